@@ -3,6 +3,7 @@ package ast
 import (
 	"bytes"
 	"fmt"
+	"strings"
 
 	"github.com/javier-varez/monkey_interpreter/token"
 )
@@ -28,9 +29,64 @@ type Error interface {
 	Span() token.Span
 }
 
+const UNDERLINE = "\x1b[4m"
+const UNDERLINE_RESET = "\x1b[24m"
+const RED = "\x1b[31m"
+const RESET_COLOR = "\x1b[0m"
+
+// FormatContextualError renders the source lines covered by the span with the
+// offending code underlined, followed by the error message. Span columns are
+// counted in runes.
+func FormatContextualError(span token.Span, msg string) string {
+	var buffer bytes.Buffer
+
+	startLine := span.Start.Line
+	endLine := span.End.Line
+	lines := strings.Split(*span.Text, "\n")
+
+	for lineIdx := startLine; lineIdx <= endLine && lineIdx < len(lines); lineIdx++ {
+		line := []rune(lines[lineIdx])
+		start := 0
+		if lineIdx == startLine {
+			start = clampColumn(span.Start.Column, line)
+		}
+		end := len(line)
+		if lineIdx == endLine {
+			end = clampColumn(span.End.Column, line)
+		}
+		if end < start {
+			end = start
+		}
+
+		buffer.WriteString(string(line[:start]))
+		if lineIdx == startLine {
+			buffer.WriteString(UNDERLINE)
+		}
+		buffer.WriteString(string(line[start:end]))
+		if lineIdx == endLine {
+			buffer.WriteString(UNDERLINE_RESET)
+		}
+		buffer.WriteString(string(line[end:]))
+		buffer.WriteByte('\n')
+	}
+
+	buffer.WriteString(fmt.Sprintf("\t%s%s%s\n", RED, msg, RESET_COLOR))
+	return buffer.String()
+}
+
+func clampColumn(column int, line []rune) int {
+	if column > len(line) {
+		return len(line)
+	}
+	return column
+}
+
 type Program struct {
 	Statements  []Statment
 	Diagnostics []Error
+	// Comments are not part of the tree, they are kept in source order for
+	// tools that print the program back.
+	Comments []token.Token
 }
 
 func (p *Program) Span() token.Span {
@@ -52,8 +108,10 @@ func (p *Program) String() string {
 }
 
 type LetStatement struct {
-	LetToken       token.Token
-	IdentExpr      Expression
+	LetToken  token.Token
+	IdentExpr Expression
+	// Type is the optional annotation of the binding, nil when omitted.
+	Type           TypeExpr
 	AssignToken    token.Token
 	Expr           Expression
 	SemicolonToken *token.Token
@@ -79,6 +137,9 @@ func (stmt *LetStatement) String() string {
 	if stmt.IdentExpr != nil {
 		buf.WriteString(stmt.IdentExpr.String())
 	}
+	if stmt.Type != nil {
+		buf.WriteString(": " + stmt.Type.String())
+	}
 	buf.WriteString(" " + stmt.AssignToken.Literal + " ")
 	if stmt.Expr != nil {
 		buf.WriteString(stmt.Expr.String())
@@ -293,8 +354,12 @@ func (expr *IfExpr) String() string {
 type FnLiteralExpr struct {
 	FnToken token.Token
 	Args    []*IdentifierExpr
-	VarArgs bool
-	Body    *BlockStatement
+	// ArgTypes holds the annotation of each argument, or nil for arguments
+	// without one.
+	ArgTypes   []TypeExpr
+	ReturnType TypeExpr
+	VarArgs    bool
+	Body       *BlockStatement
 }
 
 func (expr *FnLiteralExpr) expressionNode() {}
@@ -310,6 +375,10 @@ func (expr *FnLiteralExpr) String() string {
 	out.WriteString("(")
 	for i, arg := range expr.Args {
 		out.WriteString(arg.String())
+		if i < len(expr.ArgTypes) && expr.ArgTypes[i] != nil {
+			out.WriteString(": ")
+			out.WriteString(expr.ArgTypes[i].String())
+		}
 		if i != len(expr.Args)-1 || expr.VarArgs {
 			out.WriteString(",")
 		}
@@ -318,6 +387,11 @@ func (expr *FnLiteralExpr) String() string {
 		out.WriteString("...")
 	}
 	out.WriteString(") ")
+	if expr.ReturnType != nil {
+		out.WriteString("-> ")
+		out.WriteString(expr.ReturnType.String())
+		out.WriteString(" ")
+	}
 	out.WriteString(expr.Body.String())
 
 	return out.String()
@@ -328,6 +402,10 @@ type CallExpr struct {
 	Lparen       token.Token
 	Args         []Expression
 	Rparen       token.Token
+
+	// Set by the parser when the call is the last thing done by the enclosing
+	// function, so that engines may reuse the caller's frame.
+	IsTailCall bool
 }
 
 func (expr *CallExpr) expressionNode() {}
@@ -393,6 +471,63 @@ func (expr *ArrayLiteralExpr) String() string {
 	return buffer.String()
 }
 
+type SetLiteralExpr struct {
+	Lbrace, Rbrace token.Token
+	Elems          []Expression
+}
+
+func (expr *SetLiteralExpr) expressionNode() {}
+
+func (expr *SetLiteralExpr) Span() token.Span {
+	return expr.Lbrace.Span.Join(expr.Rbrace.Span)
+}
+
+func (expr *SetLiteralExpr) String() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString(expr.Lbrace.Literal)
+	for i, obj := range expr.Elems {
+		buffer.WriteString(obj.String())
+		if i != len(expr.Elems)-1 {
+			buffer.WriteString(", ")
+		}
+	}
+	buffer.WriteString(expr.Rbrace.Literal)
+
+	return buffer.String()
+}
+
+type TupleLiteralExpr struct {
+	Lparen, Rparen token.Token
+	Elems          []Expression
+}
+
+func (expr *TupleLiteralExpr) expressionNode() {}
+
+func (expr *TupleLiteralExpr) Span() token.Span {
+	return expr.Lparen.Span.Join(expr.Rparen.Span)
+}
+
+func (expr *TupleLiteralExpr) String() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString(expr.Lparen.Literal)
+	for i, obj := range expr.Elems {
+		buffer.WriteString(obj.String())
+		if i != len(expr.Elems)-1 {
+			buffer.WriteString(", ")
+		}
+	}
+	// A trailing comma tells a single element tuple apart from a grouped
+	// expression
+	if len(expr.Elems) == 1 {
+		buffer.WriteString(",")
+	}
+	buffer.WriteString(expr.Rparen.Literal)
+
+	return buffer.String()
+}
+
 type IndexOperatorExpr struct {
 	ObjExpr            Expression
 	Lbracket, Rbracket token.Token
@@ -451,9 +586,16 @@ func (expr *RangeExpr) String() string {
 	return buffer.String()
 }
 
+// MapEntry is a key-value pair of a map literal.
+type MapEntry struct {
+	Key, Value Expression
+}
+
 type MapLiteralExpr struct {
 	Lbrace, Rbrace token.Token
-	Map            map[Expression]Expression
+	// Entries are kept in source order, which is also the insertion order of
+	// the map they evaluate to.
+	Entries []MapEntry
 }
 
 func (expr *MapLiteralExpr) expressionNode() {}
@@ -466,10 +608,10 @@ func (expr *MapLiteralExpr) String() string {
 	var buffer bytes.Buffer
 
 	buffer.WriteString(expr.Lbrace.Literal)
-	for k, v := range expr.Map {
-		buffer.WriteString(k.String())
+	for _, entry := range expr.Entries {
+		buffer.WriteString(entry.Key.String())
 		buffer.WriteString(" : ")
-		buffer.WriteString(v.String())
+		buffer.WriteString(entry.Value.String())
 		buffer.WriteString(", ")
 	}
 	buffer.WriteString(expr.Rbrace.Literal)
@@ -27,3 +27,18 @@ func TestString(t *testing.T) {
 		t.Fatalf("program String() is wrong: %q", program.String())
 	}
 }
+
+func TestFormatContextualError(t *testing.T) {
+	text := "let a = 1;\nlet ñandú = \"🐒\" + 1;"
+	span := token.Span{
+		Text:  &text,
+		Start: token.Location{Line: 1, Column: 12},
+		End:   token.Location{Line: 1, Column: 19},
+	}
+
+	expected := "let ñandú = " + UNDERLINE + "\"🐒\" + 1" + UNDERLINE_RESET + ";\n" +
+		"\t" + RED + "Bad operands" + RESET_COLOR + "\n"
+	if result := FormatContextualError(span, "Bad operands"); result != expected {
+		t.Fatalf("FormatContextualError() is wrong.\nwant=%q\ngot=%q", expected, result)
+	}
+}
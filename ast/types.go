@@ -0,0 +1,148 @@
+package ast
+
+import (
+	"bytes"
+
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+// TypeExpr is a type annotation. Annotations are optional and ignored at
+// runtime, they are only used by the type checker.
+type TypeExpr interface {
+	Node
+	typeNode()
+}
+
+// NamedType refers to a builtin type by name, like int or string.
+type NamedType struct {
+	Token token.Token
+}
+
+func (t *NamedType) typeNode() {}
+
+func (t *NamedType) Span() token.Span {
+	return t.Token.Span
+}
+
+func (t *NamedType) String() string {
+	return t.Token.Literal
+}
+
+// ArrayType is written as [T].
+type ArrayType struct {
+	Lbracket, Rbracket token.Token
+	Elem               TypeExpr
+}
+
+func (t *ArrayType) typeNode() {}
+
+func (t *ArrayType) Span() token.Span {
+	return t.Lbracket.Span.Join(t.Rbracket.Span)
+}
+
+func (t *ArrayType) String() string {
+	return "[" + t.Elem.String() + "]"
+}
+
+// MapType is written as {K: V}.
+type MapType struct {
+	Lbrace, Rbrace token.Token
+	Key, Value     TypeExpr
+}
+
+func (t *MapType) typeNode() {}
+
+func (t *MapType) Span() token.Span {
+	return t.Lbrace.Span.Join(t.Rbrace.Span)
+}
+
+func (t *MapType) String() string {
+	return "{" + t.Key.String() + ": " + t.Value.String() + "}"
+}
+
+// SetType is written as {T}.
+type SetType struct {
+	Lbrace, Rbrace token.Token
+	Elem           TypeExpr
+}
+
+func (t *SetType) typeNode() {}
+
+func (t *SetType) Span() token.Span {
+	return t.Lbrace.Span.Join(t.Rbrace.Span)
+}
+
+func (t *SetType) String() string {
+	return "{" + t.Elem.String() + "}"
+}
+
+// TupleType is written as (T1, T2). Like tuple literals, single element tuple
+// types need a trailing comma: (T,).
+type TupleType struct {
+	Lparen, Rparen token.Token
+	Elems          []TypeExpr
+}
+
+func (t *TupleType) typeNode() {}
+
+func (t *TupleType) Span() token.Span {
+	return t.Lparen.Span.Join(t.Rparen.Span)
+}
+
+func (t *TupleType) String() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("(")
+	for i, elem := range t.Elems {
+		buffer.WriteString(elem.String())
+		if i != len(t.Elems)-1 {
+			buffer.WriteString(", ")
+		}
+	}
+	if len(t.Elems) == 1 {
+		buffer.WriteString(",")
+	}
+	buffer.WriteString(")")
+
+	return buffer.String()
+}
+
+// FnType is written as fn(T1, T2) -> R. The return type may be omitted, in
+// which case it is unknown.
+type FnType struct {
+	FnToken, Rparen token.Token
+	Args            []TypeExpr
+	VarArgs         bool
+	Return          TypeExpr
+}
+
+func (t *FnType) typeNode() {}
+
+func (t *FnType) Span() token.Span {
+	if t.Return != nil {
+		return t.FnToken.Span.Join(t.Return.Span())
+	}
+	return t.FnToken.Span.Join(t.Rparen.Span)
+}
+
+func (t *FnType) String() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("fn(")
+	for i, arg := range t.Args {
+		buffer.WriteString(arg.String())
+		if i != len(t.Args)-1 || t.VarArgs {
+			buffer.WriteString(", ")
+		}
+	}
+	if t.VarArgs {
+		buffer.WriteString("...")
+	}
+	buffer.WriteString(")")
+	if t.Return != nil {
+		buffer.WriteString(" -> ")
+		buffer.WriteString(t.Return.String())
+	}
+
+	return buffer.String()
+}
@@ -0,0 +1,514 @@
+// Package checker implements a gradual type checker for monkey programs.
+// Bindings, arguments and return values may be annotated with types, and
+// every expression whose type can be inferred is checked against the
+// operators and annotations it is used with. Values of unknown type are never
+// reported, so programs without annotations are only rejected for mismatches
+// that would fail at runtime anyway.
+package checker
+
+import (
+	"fmt"
+
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+type typeError struct {
+	span     token.Span
+	errorMsg string
+}
+
+func (e *typeError) Error() string {
+	return e.errorMsg
+}
+
+func (e *typeError) ContextualError() string {
+	return ast.FormatContextualError(e.span, e.errorMsg)
+}
+
+func (e *typeError) Span() token.Span {
+	return e.span
+}
+
+type scope struct {
+	bindings map[string]Type
+	outer    *scope
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{bindings: map[string]Type{}, outer: outer}
+}
+
+func (s *scope) lookup(name string) (Type, bool) {
+	for current := s; current != nil; current = current.outer {
+		if t, ok := current.bindings[name]; ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+type checker struct {
+	errors []ast.Error
+	scope  *scope
+	// returnTypes holds the declared return type of the functions being
+	// checked, the innermost one last.
+	returnTypes []Type
+	// signatures caches the types of fn literals, which may be needed before
+	// checking them.
+	signatures map[*ast.FnLiteralExpr]*Fn
+}
+
+// Check type checks a program that was parsed without diagnostics, returning
+// the type errors found in it.
+func Check(program *ast.Program) []ast.Error {
+	globals := newScope(nil)
+	for name, t := range builtinTypes {
+		globals.bindings[name] = t
+	}
+
+	c := &checker{
+		scope:      newScope(globals),
+		signatures: map[*ast.FnLiteralExpr]*Fn{},
+	}
+	for _, stmt := range program.Statements {
+		c.checkStatement(stmt)
+	}
+	return c.errors
+}
+
+func (c *checker) mkError(span token.Span, msg string) {
+	c.errors = append(c.errors, &typeError{span: span, errorMsg: msg})
+}
+
+// expect reports an error if a value of type actual may not be used where
+// expected is required.
+func (c *checker) expect(span token.Span, actual, expected Type) {
+	if !Assignable(actual, expected) {
+		c.mkError(span, fmt.Sprintf("Expected type %s, got %s", expected, actual))
+	}
+}
+
+// checkExpr checks an expression against the type its value is expected to
+// have. The elements of collection literals are checked one by one, so that
+// mismatches are reported at the offending element.
+func (c *checker) checkExpr(expr ast.Expression, expected Type) Type {
+	switch expr := expr.(type) {
+	case *ast.ArrayLiteralExpr:
+		if expected, ok := expected.(*Array); ok {
+			for _, elem := range expr.Elems {
+				c.checkExpr(elem, expected.Elem)
+			}
+			return expected
+		}
+	case *ast.SetLiteralExpr:
+		if expected, ok := expected.(*Set); ok {
+			for _, elem := range expr.Elems {
+				c.checkExpr(elem, expected.Elem)
+			}
+			return expected
+		}
+	case *ast.TupleLiteralExpr:
+		if expected, ok := expected.(*Tuple); ok && len(expected.Elems) == len(expr.Elems) {
+			for i, elem := range expr.Elems {
+				c.checkExpr(elem, expected.Elems[i])
+			}
+			return expected
+		}
+	case *ast.MapLiteralExpr:
+		if expected, ok := expected.(*Map); ok {
+			for _, entry := range expr.Entries {
+				c.checkExpr(entry.Key, expected.Key)
+				c.checkExpr(entry.Value, expected.Value)
+			}
+			return expected
+		}
+	}
+
+	t := c.typeOf(expr)
+	c.expect(expr.Span(), t, expected)
+	return t
+}
+
+func (c *checker) resolveType(expr ast.TypeExpr) Type {
+	switch expr := expr.(type) {
+	case *ast.NamedType:
+		t, ok := namedTypes[expr.Token.Literal]
+		if !ok {
+			c.mkError(expr.Span(), fmt.Sprintf("Unknown type %q", expr.Token.Literal))
+			return AnyType
+		}
+		return t
+	case *ast.ArrayType:
+		return &Array{Elem: c.resolveType(expr.Elem)}
+	case *ast.MapType:
+		return &Map{Key: c.resolveType(expr.Key), Value: c.resolveType(expr.Value)}
+	case *ast.SetType:
+		return &Set{Elem: c.resolveType(expr.Elem)}
+	case *ast.TupleType:
+		elems := make([]Type, len(expr.Elems))
+		for i, elem := range expr.Elems {
+			elems[i] = c.resolveType(elem)
+		}
+		return &Tuple{Elems: elems}
+	case *ast.FnType:
+		args := make([]Type, len(expr.Args))
+		for i, arg := range expr.Args {
+			args[i] = c.resolveType(arg)
+		}
+		var ret Type = AnyType
+		if expr.Return != nil {
+			ret = c.resolveType(expr.Return)
+		}
+		return &Fn{Args: args, VarArgs: expr.VarArgs, Return: ret}
+	}
+	return AnyType
+}
+
+func (c *checker) checkStatement(stmt ast.Statment) Type {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		c.checkLetStatement(stmt)
+	case *ast.ReturnStatement:
+		if len(c.returnTypes) > 0 {
+			c.checkExpr(stmt.Expr, c.returnTypes[len(c.returnTypes)-1])
+		} else {
+			c.typeOf(stmt.Expr)
+		}
+	case *ast.ExpressionStatement:
+		return c.typeOf(stmt.Expr)
+	case *ast.BlockStatement:
+		return c.checkBlock(stmt)
+	}
+	return AnyType
+}
+
+func (c *checker) checkLetStatement(stmt *ast.LetStatement) {
+	ident, ok := stmt.IdentExpr.(*ast.IdentifierExpr)
+	if !ok {
+		return
+	}
+	name := ident.IdentToken.Literal
+
+	var declared Type
+	if stmt.Type != nil {
+		declared = c.resolveType(stmt.Type)
+	}
+
+	// Bind functions before checking their body, so that they can call
+	// themselves recursively.
+	if fnExpr, ok := stmt.Expr.(*ast.FnLiteralExpr); ok {
+		if declared != nil {
+			c.scope.bindings[name] = declared
+		} else {
+			c.scope.bindings[name] = c.fnSignature(fnExpr)
+		}
+	}
+
+	if declared != nil {
+		c.checkExpr(stmt.Expr, declared)
+		c.scope.bindings[name] = declared
+	} else {
+		c.scope.bindings[name] = c.typeOf(stmt.Expr)
+	}
+}
+
+// checkBlock checks the statements of a block, returning the type of the
+// value the block evaluates to.
+func (c *checker) checkBlock(block *ast.BlockStatement) Type {
+	var t Type = AnyType
+	for i, stmt := range block.Statements {
+		stmtType := c.checkStatement(stmt)
+		if i == len(block.Statements)-1 {
+			t = stmtType
+		}
+	}
+	return t
+}
+
+func (c *checker) fnSignature(expr *ast.FnLiteralExpr) *Fn {
+	if fn, ok := c.signatures[expr]; ok {
+		return fn
+	}
+
+	fn := &Fn{Args: make([]Type, len(expr.Args)), VarArgs: expr.VarArgs, Return: AnyType}
+	for i := range expr.Args {
+		fn.Args[i] = AnyType
+		if i < len(expr.ArgTypes) && expr.ArgTypes[i] != nil {
+			fn.Args[i] = c.resolveType(expr.ArgTypes[i])
+		}
+	}
+	if expr.ReturnType != nil {
+		fn.Return = c.resolveType(expr.ReturnType)
+	}
+	c.signatures[expr] = fn
+	return fn
+}
+
+func (c *checker) typeOf(expr ast.Expression) Type {
+	switch expr := expr.(type) {
+	case *ast.IntegerLiteralExpr:
+		return IntType
+	case *ast.BoolLiteralExpr:
+		return BoolType
+	case *ast.StringLiteralExpr:
+		return StringType
+	case *ast.IdentifierExpr:
+		if t, ok := c.scope.lookup(expr.IdentToken.Literal); ok {
+			return t
+		}
+		return AnyType
+	case *ast.PrefixExpr:
+		return c.typeOfPrefixExpr(expr)
+	case *ast.InfixExpr:
+		return c.typeOfInfixExpr(expr)
+	case *ast.IfExpr:
+		// Any value can be a condition, and is true unless it is false, null
+		// or zero
+		c.typeOf(expr.Condition)
+		consequence := c.checkBlock(expr.Consequence)
+		if expr.Alternative == nil {
+			return AnyType
+		}
+		return join(consequence, c.checkBlock(expr.Alternative))
+	case *ast.FnLiteralExpr:
+		return c.typeOfFnLiteralExpr(expr)
+	case *ast.CallExpr:
+		return c.typeOfCallExpr(expr)
+	case *ast.ArrayLiteralExpr:
+		return &Array{Elem: c.joinAll(expr.Elems)}
+	case *ast.SetLiteralExpr:
+		return &Set{Elem: c.joinAll(expr.Elems)}
+	case *ast.TupleLiteralExpr:
+		elems := make([]Type, len(expr.Elems))
+		for i, elem := range expr.Elems {
+			elems[i] = c.typeOf(elem)
+		}
+		return &Tuple{Elems: elems}
+	case *ast.MapLiteralExpr:
+		keys := make([]ast.Expression, len(expr.Entries))
+		values := make([]ast.Expression, len(expr.Entries))
+		for i, entry := range expr.Entries {
+			keys[i] = entry.Key
+			values[i] = entry.Value
+		}
+		return &Map{Key: c.joinAll(keys), Value: c.joinAll(values)}
+	case *ast.IndexOperatorExpr:
+		return c.typeOfIndexExpr(expr)
+	case *ast.RangeExpr:
+		c.expect(expr.StartExpr.Span(), c.typeOf(expr.StartExpr), IntType)
+		c.expect(expr.EndExpr.Span(), c.typeOf(expr.EndExpr), IntType)
+		return &Array{Elem: IntType}
+	}
+	return AnyType
+}
+
+// joinAll checks a list of expressions, returning the type common to all of
+// them.
+func (c *checker) joinAll(exprs []ast.Expression) Type {
+	if len(exprs) == 0 {
+		return AnyType
+	}
+
+	t := c.typeOf(exprs[0])
+	for _, expr := range exprs[1:] {
+		t = join(t, c.typeOf(expr))
+	}
+	return t
+}
+
+func (c *checker) typeOfPrefixExpr(expr *ast.PrefixExpr) Type {
+	inner := c.typeOf(expr.InnerExpr)
+
+	switch expr.OperatorToken.Type {
+	case token.BANG:
+		// Like conditions, ! negates the truthiness of any value
+		return BoolType
+	case token.MINUS:
+		c.expect(expr.InnerExpr.Span(), inner, IntType)
+		return IntType
+	}
+	return AnyType
+}
+
+func (c *checker) typeOfInfixExpr(expr *ast.InfixExpr) Type {
+	left := c.typeOf(expr.LeftExpr)
+	right := c.typeOf(expr.RightExpr)
+
+	_, leftIsSet := left.(*Set)
+	_, rightIsSet := right.(*Set)
+
+	switch expr.OperatorToken.Type {
+	case token.EQ, token.NOT_EQ:
+		// Values of different types are unequal in every engine
+		return BoolType
+	case token.PIPE, token.AMPERSAND:
+		return c.typeOfSetOperation(expr, left, right)
+	case token.MINUS:
+		if leftIsSet || rightIsSet {
+			return c.typeOfSetOperation(expr, left, right)
+		}
+		c.expect(expr.LeftExpr.Span(), left, IntType)
+		c.expect(expr.RightExpr.Span(), right, IntType)
+		return IntType
+	case token.ASTERISK, token.SLASH:
+		c.expect(expr.LeftExpr.Span(), left, IntType)
+		c.expect(expr.RightExpr.Span(), right, IntType)
+		return IntType
+	case token.LT, token.GT:
+		if isBasic(left, CharType) || isBasic(right, CharType) {
+			c.expect(expr.LeftExpr.Span(), left, CharType)
+			c.expect(expr.RightExpr.Span(), right, CharType)
+		} else {
+			c.expect(expr.LeftExpr.Span(), left, IntType)
+			c.expect(expr.RightExpr.Span(), right, IntType)
+		}
+		return BoolType
+	case token.PLUS:
+		return c.typeOfAddition(expr, left, right)
+	}
+	return AnyType
+}
+
+// typeOfAddition checks the + operator, which adds integers and concatenates
+// strings with strings or chars.
+func (c *checker) typeOfAddition(expr *ast.InfixExpr, left, right Type) Type {
+	isAddable := func(t Type) bool {
+		return isAny(t) || isBasic(t, IntType) || isBasic(t, StringType) || isBasic(t, CharType)
+	}
+
+	if !isAddable(left) {
+		c.mkError(expr.LeftExpr.Span(), fmt.Sprintf("Expected type int or string, got %s", left))
+		return AnyType
+	}
+	if !isAddable(right) {
+		c.mkError(expr.RightExpr.Span(), fmt.Sprintf("Expected type int or string, got %s", right))
+		return AnyType
+	}
+
+	if isAny(left) || isAny(right) {
+		if isBasic(left, IntType) || isBasic(right, IntType) {
+			return IntType
+		}
+		if isBasic(left, StringType) || isBasic(right, StringType) {
+			return StringType
+		}
+		return AnyType
+	}
+
+	if isBasic(left, IntType) && isBasic(right, IntType) {
+		return IntType
+	}
+	if !isBasic(left, IntType) && !isBasic(right, IntType) &&
+		(isBasic(left, StringType) || isBasic(right, StringType)) {
+		return StringType
+	}
+
+	c.mkError(expr.Span(), fmt.Sprintf("Operator + is not defined for types %s and %s", left, right))
+	return AnyType
+}
+
+func (c *checker) typeOfSetOperation(expr *ast.InfixExpr, left, right Type) Type {
+	anySet := &Set{Elem: AnyType}
+	c.expect(expr.LeftExpr.Span(), left, anySet)
+	c.expect(expr.RightExpr.Span(), right, anySet)
+
+	leftSet, leftIsSet := left.(*Set)
+	rightSet, rightIsSet := right.(*Set)
+	if leftIsSet && rightIsSet {
+		return &Set{Elem: join(leftSet.Elem, rightSet.Elem)}
+	}
+	return AnyType
+}
+
+func (c *checker) typeOfFnLiteralExpr(expr *ast.FnLiteralExpr) Type {
+	fn := c.fnSignature(expr)
+
+	c.scope = newScope(c.scope)
+	for i, arg := range expr.Args {
+		c.scope.bindings[arg.IdentToken.Literal] = fn.Args[i]
+	}
+	c.returnTypes = append(c.returnTypes, fn.Return)
+
+	bodyType := c.checkBlock(expr.Body)
+	if n := len(expr.Body.Statements); n > 0 {
+		if last, ok := expr.Body.Statements[n-1].(*ast.ExpressionStatement); ok {
+			c.expect(last.Expr.Span(), bodyType, fn.Return)
+		}
+	}
+
+	c.returnTypes = c.returnTypes[:len(c.returnTypes)-1]
+	c.scope = c.scope.outer
+
+	return fn
+}
+
+func (c *checker) typeOfCallExpr(expr *ast.CallExpr) Type {
+	callee := c.typeOf(expr.CallableExpr)
+
+	fn, ok := callee.(*Fn)
+	if !ok {
+		if !isAny(callee) {
+			c.mkError(expr.CallableExpr.Span(), fmt.Sprintf("Expression of type %s is not callable", callee))
+		}
+		for _, arg := range expr.Args {
+			c.typeOf(arg)
+		}
+		return AnyType
+	}
+
+	// Expanded var args may stand for any number of arguments, so arguments
+	// are only checked up to them.
+	expandsVarArgs := false
+	for i, arg := range expr.Args {
+		if _, ok := arg.(*ast.VarArgsLiteralExpr); ok {
+			expandsVarArgs = true
+		}
+		if i < len(fn.Args) && !expandsVarArgs {
+			c.checkExpr(arg, fn.Args[i])
+		} else {
+			c.typeOf(arg)
+		}
+	}
+
+	if !expandsVarArgs {
+		numArgs := len(expr.Args)
+		if (!fn.VarArgs && numArgs != len(fn.Args)) || (fn.VarArgs && numArgs < len(fn.Args)) {
+			c.mkError(expr.Span(), fmt.Sprintf("Expected %d arguments, got %d", len(fn.Args), numArgs))
+		}
+	}
+
+	return fn.Return
+}
+
+func (c *checker) typeOfIndexExpr(expr *ast.IndexOperatorExpr) Type {
+	obj := c.typeOf(expr.ObjExpr)
+	index := c.typeOf(expr.IndexExpr)
+
+	switch obj := obj.(type) {
+	case *Any:
+		return AnyType
+	case *Array:
+		c.expect(expr.IndexExpr.Span(), index, IntType)
+		return obj.Elem
+	case *Map:
+		c.expect(expr.IndexExpr.Span(), index, obj.Key)
+		return obj.Value
+	case *Tuple:
+		c.expect(expr.IndexExpr.Span(), index, IntType)
+		if literal, ok := expr.IndexExpr.(*ast.IntegerLiteralExpr); ok {
+			if literal.Value >= 0 && literal.Value < int64(len(obj.Elems)) {
+				return obj.Elems[literal.Value]
+			}
+		}
+		return AnyType
+	case *Basic:
+		if obj.Name == StringType.Name {
+			c.expect(expr.IndexExpr.Span(), index, IntType)
+			return CharType
+		}
+	}
+
+	c.mkError(expr.ObjExpr.Span(), fmt.Sprintf("Expression of type %s cannot be indexed", obj))
+	return AnyType
+}
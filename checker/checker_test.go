@@ -0,0 +1,150 @@
+package checker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/lexer"
+	"github.com/javier-varez/monkey_interpreter/parser"
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+func testCheck(t *testing.T, input string) []ast.Error {
+	l := lexer.New(input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(program.Diagnostics) != 0 {
+		for _, err := range program.Diagnostics {
+			t.Errorf("%s", err.ContextualError())
+		}
+		t.Fatalf("Unrecoverable program diagnostics")
+	}
+
+	return Check(program)
+}
+
+func TestWellTypedPrograms(t *testing.T) {
+	tests := []string{
+		`let x: int = 1; let y: int = x * 2 + 1;`,
+		`let s: string = "a" + "b"; let c: char = s[0]; let t: string = s + c;`,
+		`let add = fn(a: int, b: int) -> int { a + b }; let r: int = add(1, 2);`,
+		`let fact = fn(n: int) -> int { if (n < 2) { return 1; } n * fact(n - 1) }; fact(5)`,
+		`let first: fn([int]) -> int = fn(arr: [int]) -> int { arr[0] }; first([1, 2])`,
+		`let m: {string: [int]} = {"a": [1], "b": []}; let v: [int] = m["a"];`,
+		`let s: {int} = {1, 2} | {3}; let d: {int} = s - {1};`,
+		`let p: (int, string) = (1, "a"); let n: int = p[0]; let str: string = p[1];`,
+		`let f = fn(a, b) { a + b }; f(1, 2) + f("a", "b")`,
+		`let g: fn(any) -> any = fn(x) { x }; g(1)`,
+		`let r: [int] = 1..10; len(r) + 1`,
+		`let v = fn(a: int, ...) { toArray(...) }; v(1, 2, 3)`,
+		`let w = fn(...) { puts(...) }; w(1, "a")`,
+		`[1, "a"] == [2, "b"]`,
+		`let e: null = puts("hello");`,
+		`let k: [any] = keys({"a": 1});`,
+		`let x = 5; if (x) { puts("t") }; puts(!x);`,
+		`let n: int = 0; let b: bool = !n; if (n) { 1 } else { 2 }`,
+		`if ("a") { !"a" }`,
+		`let n: int = 1; let s: string = "a"; let b: bool = n == s;`,
+	}
+
+	for _, input := range tests {
+		for _, err := range testCheck(t, input) {
+			t.Errorf("Unexpected type error in %q:\n%s", input, err.ContextualError())
+		}
+	}
+}
+
+func TestTypeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`let x: int = "a";`, []string{"Expected type int, got string"}},
+		{`let x: [int] = [1, "a"];`, []string{"Expected type int, got string"}},
+		{`let x: {string: int} = {"a": 1, 2: 3};`, []string{"Expected type string, got int"}},
+		{`let f = fn(a: foo) { a }; f(1)`, []string{"Unknown type \"foo\""}},
+		{`let x = 1 + true;`, []string{"Expected type int or string, got bool"}},
+		{`let x = 1 + "a";`, []string{"Operator + is not defined for types int and string"}},
+		{`let x = "a" * 2;`, []string{"Expected type int, got string"}},
+		{`-"a"`, []string{"Expected type int, got string"}},
+		{`let f = fn(a: int) -> int { a }; f("a")`, []string{"Expected type int, got string"}},
+		{`let f = fn(a: int) -> int { a }; f(1, 2)`, []string{"Expected 1 arguments, got 2"}},
+		{`let f = fn(a: int) -> string { a }`, []string{"Expected type string, got int"}},
+		{`let f = fn(a: int) -> string { return a; }`, []string{"Expected type string, got int"}},
+		{`let f = fn(a: [int]) { a["x"] }`, []string{"Expected type int, got string"}},
+		{`let m = {"a": 1}; m[1]`, []string{"Expected type string, got int"}},
+		{`let x = 1; x(2)`, []string{"Expression of type int is not callable"}},
+		{`let x = true; x[0]`, []string{"Expression of type bool cannot be indexed"}},
+		{`{1} | [2]`, []string{"Expected type {any}, got [int]"}},
+		{`let x: integer = 1`, []string{"Unknown type \"integer\""}},
+		{`let x: int = len("a") + "a"`, []string{"Operator + is not defined for types int and string"}},
+		{`let f = fn(n: int) -> int { n }; let s: string = f(1);`, []string{"Expected type string, got int"}},
+		{`let a = "a"; let b: int = a; let c: bool = a;`, []string{"Expected type int, got string", "Expected type bool, got string"}},
+	}
+
+	for _, tt := range tests {
+		errors := testCheck(t, tt.input)
+		if len(errors) != len(tt.expected) {
+			t.Errorf("Unexpected number of type errors in %q. Expected %d, got %d: %v", tt.input, len(tt.expected), len(errors), errors)
+			continue
+		}
+
+		for i, err := range errors {
+			if err.Error() != tt.expected[i] {
+				t.Errorf("Unexpected type error in %q. Expected %q, got %q", tt.input, tt.expected[i], err.Error())
+			}
+		}
+	}
+}
+
+func TestTypeErrorSpans(t *testing.T) {
+	mkSpan := func(start, end int) token.Span {
+		return token.Span{
+			Start: token.Location{Line: 0, Column: start},
+			End:   token.Location{Line: 0, Column: end},
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected token.Span
+	}{
+		{`let x: int = "a";`, mkSpan(13, 16)},
+		{`let f = fn(a: int) { a }; f(true)`, mkSpan(28, 32)},
+		{`1 + "a"`, mkSpan(0, 7)},
+	}
+
+	for _, tt := range tests {
+		errors := testCheck(t, tt.input)
+		if len(errors) != 1 {
+			t.Errorf("Expected a single type error in %q, got %d", tt.input, len(errors))
+			continue
+		}
+
+		span := errors[0].Span()
+		if span.Start != tt.expected.Start || span.End != tt.expected.End {
+			t.Errorf("Unexpected span in %q. Expected %v, got %v", tt.input, tt.expected, span)
+		}
+	}
+}
+
+func TestSamplesTypeCheck(t *testing.T) {
+	samples, err := filepath.Glob("../samples/*.monkey")
+	if err != nil || len(samples) == 0 {
+		t.Fatalf("Unable to find samples: %v", err)
+	}
+
+	for _, sample := range samples {
+		txt, err := os.ReadFile(sample)
+		if err != nil {
+			t.Fatalf("Unable to read sample %s: %v", sample, err)
+		}
+
+		for _, err := range testCheck(t, string(txt)) {
+			t.Errorf("Unexpected type error in %s:\n%s", sample, err.ContextualError())
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package checker
+
+import (
+	"bytes"
+)
+
+// Type is the static type of an expression. Checking is gradual: the Any type
+// stands for values whose type is not known, and is compatible with every
+// other type.
+type Type interface {
+	String() string
+}
+
+type Any struct{}
+
+func (t *Any) String() string {
+	return "any"
+}
+
+// Basic is a scalar type, like int or string.
+type Basic struct {
+	Name string
+}
+
+func (t *Basic) String() string {
+	return t.Name
+}
+
+type Array struct {
+	Elem Type
+}
+
+func (t *Array) String() string {
+	return "[" + t.Elem.String() + "]"
+}
+
+type Map struct {
+	Key, Value Type
+}
+
+func (t *Map) String() string {
+	return "{" + t.Key.String() + ": " + t.Value.String() + "}"
+}
+
+type Set struct {
+	Elem Type
+}
+
+func (t *Set) String() string {
+	return "{" + t.Elem.String() + "}"
+}
+
+type Tuple struct {
+	Elems []Type
+}
+
+func (t *Tuple) String() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("(")
+	for i, elem := range t.Elems {
+		buffer.WriteString(elem.String())
+		if i != len(t.Elems)-1 {
+			buffer.WriteString(", ")
+		}
+	}
+	if len(t.Elems) == 1 {
+		buffer.WriteString(",")
+	}
+	buffer.WriteString(")")
+
+	return buffer.String()
+}
+
+type Fn struct {
+	Args    []Type
+	VarArgs bool
+	Return  Type
+}
+
+func (t *Fn) String() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("fn(")
+	for i, arg := range t.Args {
+		buffer.WriteString(arg.String())
+		if i != len(t.Args)-1 || t.VarArgs {
+			buffer.WriteString(", ")
+		}
+	}
+	if t.VarArgs {
+		buffer.WriteString("...")
+	}
+	buffer.WriteString(") -> ")
+	buffer.WriteString(t.Return.String())
+
+	return buffer.String()
+}
+
+var (
+	AnyType    = &Any{}
+	IntType    = &Basic{Name: "int"}
+	BoolType   = &Basic{Name: "bool"}
+	StringType = &Basic{Name: "string"}
+	CharType   = &Basic{Name: "char"}
+	NullType   = &Basic{Name: "null"}
+)
+
+// namedTypes are the types that annotations may refer to by name.
+var namedTypes = map[string]Type{
+	"any":    AnyType,
+	"int":    IntType,
+	"bool":   BoolType,
+	"string": StringType,
+	"char":   CharType,
+	"null":   NullType,
+}
+
+// builtinTypes are the signatures of the builtin functions. Arguments that
+// accept several kinds of objects are typed as any.
+var builtinTypes = map[string]Type{
+	"len":      &Fn{Args: []Type{AnyType}, Return: IntType},
+	"first":    &Fn{Args: []Type{AnyType}, Return: AnyType},
+	"last":     &Fn{Args: []Type{AnyType}, Return: AnyType},
+	"rest":     &Fn{Args: []Type{AnyType}, Return: AnyType},
+	"push":     &Fn{Args: []Type{AnyType, AnyType}, Return: AnyType},
+	"puts":     &Fn{VarArgs: true, Return: NullType},
+	"toArray":  &Fn{Args: []Type{AnyType}, Return: &Array{Elem: AnyType}},
+	"contains": &Fn{Args: []Type{AnyType, AnyType}, Return: BoolType},
+	"keys":     &Fn{Args: []Type{AnyType}, Return: &Array{Elem: AnyType}},
+	"values":   &Fn{Args: []Type{AnyType}, Return: &Array{Elem: AnyType}},
+	"delete":   &Fn{Args: []Type{AnyType, AnyType}, Return: AnyType},
+}
+
+func isAny(t Type) bool {
+	_, ok := t.(*Any)
+	return ok
+}
+
+func isBasic(t Type, basic *Basic) bool {
+	other, ok := t.(*Basic)
+	return ok && other.Name == basic.Name
+}
+
+// Assignable reports whether a value of type from may be used where a value
+// of type to is expected.
+func Assignable(from, to Type) bool {
+	if isAny(from) || isAny(to) {
+		return true
+	}
+
+	switch to := to.(type) {
+	case *Basic:
+		return isBasic(from, to)
+	case *Array:
+		from, ok := from.(*Array)
+		return ok && Assignable(from.Elem, to.Elem)
+	case *Map:
+		from, ok := from.(*Map)
+		return ok && Assignable(from.Key, to.Key) && Assignable(from.Value, to.Value)
+	case *Set:
+		from, ok := from.(*Set)
+		return ok && Assignable(from.Elem, to.Elem)
+	case *Tuple:
+		from, ok := from.(*Tuple)
+		if !ok || len(from.Elems) != len(to.Elems) {
+			return false
+		}
+		for i := range to.Elems {
+			if !Assignable(from.Elems[i], to.Elems[i]) {
+				return false
+			}
+		}
+		return true
+	case *Fn:
+		from, ok := from.(*Fn)
+		if !ok || from.VarArgs != to.VarArgs || len(from.Args) != len(to.Args) {
+			return false
+		}
+		// Arguments flow in the opposite direction of the function value
+		for i := range to.Args {
+			if !Assignable(to.Args[i], from.Args[i]) {
+				return false
+			}
+		}
+		return Assignable(from.Return, to.Return)
+	}
+
+	return false
+}
+
+// join returns the type of a value that may be either of the given types.
+func join(a, b Type) Type {
+	if a.String() == b.String() {
+		return a
+	}
+	return AnyType
+}
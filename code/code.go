@@ -6,6 +6,11 @@ import (
 	"fmt"
 )
 
+// VERSION identifies the set of opcodes and the widths of their operands. It
+// must change whenever they do, so that serialized bytecode compiled for other
+// opcodes is rejected.
+const VERSION = 5
+
 type Instructions []byte
 
 type Opcode byte
@@ -41,6 +46,36 @@ const (
 	OpClosure
 	OpGetFree
 	OpRange
+	OpTailCall
+	OpSet
+	OpTuple
+	OpUnion
+	OpIntersect
+	OpConstantWide
+	OpSetLocalWide
+	OpGetLocalWide
+	OpClosureWide
+	OpGetFreeWide
+	OpCallWide
+	OpTailCallWide
+
+	// Superinstructions do the work of a sequence of instructions that is
+	// common in hot code, saving the dispatch of the ones they replace. The
+	// optimizer emits them.
+
+	// OpGetLocalAddConstant pushes the sum of a local and a constant, like
+	// OpGetLocal, OpConstant and OpAdd
+	OpGetLocalAddConstant
+	// OpJumpNotGreaterThan, OpJumpNotEqual and OpJumpEqual compare the two
+	// values on top of the stack and jump to their operand unless the first
+	// is greater, equal or not equal to the second, like a comparison followed
+	// by OpJumpNotTruthy
+	OpJumpNotGreaterThan
+	OpJumpNotEqual
+	OpJumpEqual
+	// OpCallGlobal calls a global with the arguments on top of the stack, like
+	// OpGetGlobal and OpCall
+	OpCallGlobal
 )
 
 type Definition struct {
@@ -70,7 +105,7 @@ var definitions = map[Opcode]*Definition{
 	OpArray:         {Name: "OpArray", OperandWidths: []int{2}},
 	OpIndex:         {Name: "OpIndex"},
 	OpHash:          {Name: "OpHash", OperandWidths: []int{2}},
-	OpCall:          {Name: "OpCall"},
+	OpCall:          {Name: "OpCall", OperandWidths: []int{1}},
 	OpReturnValue:   {Name: "OpReturnValue"},
 	OpReturn:        {Name: "OpReturn"},
 	OpSetLocal:      {Name: "OpSetLocal", OperandWidths: []int{1}},
@@ -79,6 +114,37 @@ var definitions = map[Opcode]*Definition{
 	OpClosure:       {Name: "OpClosure", OperandWidths: []int{2, 1}},
 	OpGetFree:       {Name: "OpGetFree", OperandWidths: []int{1}},
 	OpRange:         {Name: "OpRange", OperandWidths: []int{}},
+	OpTailCall:      {Name: "OpTailCall", OperandWidths: []int{1}},
+	OpSet:           {Name: "OpSet", OperandWidths: []int{2}},
+	OpTuple:         {Name: "OpTuple", OperandWidths: []int{2}},
+	OpUnion:         {Name: "OpUnion"},
+	OpIntersect:     {Name: "OpIntersect"},
+	OpConstantWide:  {Name: "OpConstantWide", OperandWidths: []int{4}},
+	OpSetLocalWide:  {Name: "OpSetLocalWide", OperandWidths: []int{2}},
+	OpGetLocalWide:  {Name: "OpGetLocalWide", OperandWidths: []int{2}},
+	OpClosureWide:   {Name: "OpClosureWide", OperandWidths: []int{4, 2}},
+	OpGetFreeWide:   {Name: "OpGetFreeWide", OperandWidths: []int{2}},
+	OpCallWide:      {Name: "OpCallWide", OperandWidths: []int{2}},
+	OpTailCallWide:  {Name: "OpTailCallWide", OperandWidths: []int{2}},
+
+	OpGetLocalAddConstant: {Name: "OpGetLocalAddConstant", OperandWidths: []int{1, 2}},
+	OpJumpNotGreaterThan:  {Name: "OpJumpNotGreaterThan", OperandWidths: []int{2}},
+	OpJumpNotEqual:        {Name: "OpJumpNotEqual", OperandWidths: []int{2}},
+	OpJumpEqual:           {Name: "OpJumpEqual", OperandWidths: []int{2}},
+	OpCallGlobal:          {Name: "OpCallGlobal", OperandWidths: []int{2, 1}},
+}
+
+// wideVariants maps opcodes to the ones taking the same operands with more
+// bytes, used when the operands do not fit. Builtins are not in the list, as
+// there are far fewer than what a byte can address.
+var wideVariants = map[Opcode]Opcode{
+	OpConstant: OpConstantWide,
+	OpSetLocal: OpSetLocalWide,
+	OpGetLocal: OpGetLocalWide,
+	OpClosure:  OpClosureWide,
+	OpGetFree:  OpGetFreeWide,
+	OpCall:     OpCallWide,
+	OpTailCall: OpTailCallWide,
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -88,6 +154,41 @@ func Lookup(op byte) (*Definition, error) {
 	return nil, fmt.Errorf("Unknown opcode %d", op)
 }
 
+// Wide returns the variant of an opcode with wider operands, if there is one.
+func Wide(op Opcode) (Opcode, bool) {
+	wide, ok := wideVariants[op]
+	return wide, ok
+}
+
+// IsJump tells whether an opcode may continue at the target in its first
+// operand instead of the next instruction.
+func IsJump(op Opcode) bool {
+	switch op {
+	case OpJump, OpJumpNotTruthy, OpJumpNotGreaterThan, OpJumpNotEqual, OpJumpEqual:
+		return true
+	}
+	return false
+}
+
+// Fits tells whether the operands fit in the widths of the opcode, as Make
+// panics otherwise.
+func Fits(op Opcode, operands ...int) bool {
+	def, ok := definitions[op]
+	if !ok {
+		return false
+	}
+	for i, o := range operands {
+		if i >= len(def.OperandWidths) || o < 0 || uint64(o) > maxOperand(def.OperandWidths[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func maxOperand(width int) uint64 {
+	return 1<<(8*width) - 1
+}
+
 func Make(opcode Opcode, operands ...int) []byte {
 	params, ok := definitions[opcode]
 	if !ok {
@@ -102,9 +203,9 @@ func Make(opcode Opcode, operands ...int) []byte {
 	instruction := make([]byte, instructionLen)
 	instruction[0] = byte(opcode)
 
-	assertInBounds := func(value int, maxBound int) {
-		if value > maxBound {
-			panic(fmt.Sprintf("Value %d is out of bounds (max=%d)", value, maxBound))
+	assertInBounds := func(value int, width int) {
+		if value < 0 || uint64(value) > maxOperand(width) {
+			panic(fmt.Sprintf("Value %d is out of bounds (max=%d)", value, maxOperand(width)))
 		}
 	}
 
@@ -113,11 +214,14 @@ func Make(opcode Opcode, operands ...int) []byte {
 		width := params.OperandWidths[i]
 		switch width {
 		case 1:
-			assertInBounds(o, 0xFF)
+			assertInBounds(o, width)
 			instruction[offset] = byte(o)
 		case 2:
-			assertInBounds(o, 0xFFFF)
+			assertInBounds(o, width)
 			binary.BigEndian.PutUint16(instruction[offset:offset+2], uint16(o))
+		case 4:
+			assertInBounds(o, width)
+			binary.BigEndian.PutUint32(instruction[offset:offset+4], uint32(o))
 		}
 		offset += width
 	}
@@ -155,6 +259,8 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 			operands[i] = int(ReadUint8(ins[offset : offset+1]))
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset : offset+2]))
+		case 4:
+			operands[i] = int(ReadUint32(ins[offset : offset+4]))
 		}
 		offset += width
 	}
@@ -162,6 +268,39 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	return operands, offset
 }
 
+// Instruction is an opcode and its operands, starting at Offset.
+type Instruction struct {
+	Offset   int
+	Opcode   Opcode
+	Operands []int
+}
+
+// Decode splits the instructions, checking that every opcode is defined and
+// that its operands, with the widths of its definition, are not truncated.
+func (ins Instructions) Decode() ([]Instruction, error) {
+	result := []Instruction{}
+	offset := 0
+	for offset < len(ins) {
+		def, err := Lookup(ins[offset])
+		if err != nil {
+			return nil, fmt.Errorf("%w at offset %d", err, offset)
+		}
+
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if offset+1+width > len(ins) {
+			return nil, fmt.Errorf("Truncated operands of %s at offset %d", def.Name, offset)
+		}
+
+		operands, read := ReadOperands(def, ins[offset+1:])
+		result = append(result, Instruction{Offset: offset, Opcode: Opcode(ins[offset]), Operands: operands})
+		offset += 1 + read
+	}
+	return result, nil
+}
+
 func ReadUint8(ins Instructions) byte {
 	return ins[0]
 }
@@ -170,6 +309,10 @@ func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
 
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
+
 func fmtInstruction(def *Definition, operands []int) string {
 	operandCount := len(def.OperandWidths)
 
@@ -1,6 +1,9 @@
 package code
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+)
 
 func TestMake(t *testing.T) {
 	tests := []struct {
@@ -29,7 +32,7 @@ func TestMake(t *testing.T) {
 		{OpArray, []int{2}, Instructions{byte(OpArray), 0, 2}},
 		{OpIndex, []int{}, Instructions{byte(OpIndex)}},
 		{OpHash, []int{2}, Instructions{byte(OpHash), 0, 2}},
-		{OpCall, []int{}, Instructions{byte(OpCall)}},
+		{OpCall, []int{3}, Instructions{byte(OpCall), 3}},
 		{OpReturn, []int{}, Instructions{byte(OpReturn)}},
 		{OpReturnValue, []int{}, Instructions{byte(OpReturnValue)}},
 		{OpGetLocal, []int{254}, Instructions{byte(OpGetLocal), 254}},
@@ -38,6 +41,14 @@ func TestMake(t *testing.T) {
 		{OpClosure, []int{254, 3}, Instructions{byte(OpClosure), 0, 254, 3}},
 		{OpGetFree, []int{254}, Instructions{byte(OpGetFree), 254}},
 		{OpRange, []int{}, Instructions{byte(OpRange)}},
+		{OpConstantWide, []int{65536}, Instructions{byte(OpConstantWide), 0, 1, 0, 0}},
+		{OpGetLocalWide, []int{256}, Instructions{byte(OpGetLocalWide), 1, 0}},
+		{OpClosureWide, []int{65536, 256}, Instructions{byte(OpClosureWide), 0, 1, 0, 0, 1, 0}},
+		{OpGetLocalAddConstant, []int{3, 258}, Instructions{byte(OpGetLocalAddConstant), 3, 1, 2}},
+		{OpJumpNotGreaterThan, []int{126}, Instructions{byte(OpJumpNotGreaterThan), 0, 126}},
+		{OpCallGlobal, []int{258, 2}, Instructions{byte(OpCallGlobal), 1, 2, 2}},
+		{OpCallWide, []int{300}, Instructions{byte(OpCallWide), 1, 44}},
+		{OpTailCallWide, []int{300}, Instructions{byte(OpTailCallWide), 1, 44}},
 	}
 
 	for _, tt := range tests {
@@ -80,7 +91,7 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpArray, 2),
 		Make(OpIndex),
 		Make(OpHash, 3),
-		Make(OpCall),
+		Make(OpCall, 3),
 		Make(OpReturn),
 		Make(OpReturnValue),
 		Make(OpGetLocal, 254),
@@ -114,15 +125,15 @@ func TestInstructionsString(t *testing.T) {
 0034 OpArray 2
 0037 OpIndex
 0038 OpHash 3
-0041 OpCall
-0042 OpReturn
-0043 OpReturnValue
-0044 OpGetLocal 254
-0046 OpSetLocal 254
-0048 OpGetBuiltin 254
-0050 OpClosure 254 3
-0054 OpGetFree 3
-0056 OpRange
+0041 OpCall 3
+0043 OpReturn
+0044 OpReturnValue
+0045 OpGetLocal 254
+0047 OpSetLocal 254
+0049 OpGetBuiltin 254
+0051 OpClosure 254 3
+0055 OpGetFree 3
+0057 OpRange
 `
 	concatted := Instructions{}
 	for _, ins := range instructions {
@@ -144,6 +155,7 @@ func TestReadOperands(t *testing.T) {
 	}{
 		{OpConstant, []int{65535}, 2},
 		{OpClosure, []int{65535, 3}, 3},
+		{OpClosureWide, []int{1 << 20, 300}, 6},
 	}
 
 	for _, tt := range tests {
@@ -166,3 +178,70 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestDecode(t *testing.T) {
+	valid := Instructions{}
+	for _, ins := range []Instructions{Make(OpConstant, 2), Make(OpClosure, 3, 1), Make(OpPop)} {
+		valid = append(valid, ins...)
+	}
+
+	decoded, err := valid.Decode()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := []Instruction{
+		{Offset: 0, Opcode: OpConstant, Operands: []int{2}},
+		{Offset: 3, Opcode: OpClosure, Operands: []int{3, 1}},
+		{Offset: 7, Opcode: OpPop, Operands: []int{}},
+	}
+	if len(decoded) != len(expected) {
+		t.Fatalf("Expected %d instructions, got %d", len(expected), len(decoded))
+	}
+	for i, ins := range expected {
+		actual := decoded[i]
+		if actual.Offset != ins.Offset || actual.Opcode != ins.Opcode || fmt.Sprint(actual.Operands) != fmt.Sprint(ins.Operands) {
+			t.Errorf("Expected instruction %+v, got %+v", ins, actual)
+		}
+	}
+
+	tests := []struct {
+		ins           Instructions
+		expectedError string
+	}{
+		{Instructions{byte(OpPop), 255}, "Unknown opcode 255 at offset 1"},
+		{Instructions{byte(OpPop), byte(OpClosure), 0, 1}, "Truncated operands of OpClosure at offset 1"},
+	}
+	for _, tt := range tests {
+		if _, err := tt.ins.Decode(); err == nil || err.Error() != tt.expectedError {
+			t.Errorf("Expected error %q, got %v", tt.expectedError, err)
+		}
+	}
+}
+
+func TestWide(t *testing.T) {
+	tests := []struct {
+		op           Opcode
+		operands     []int
+		expectedOp   Opcode
+		expectedFits bool
+	}{
+		{OpGetLocal, []int{255}, OpGetLocal, true},
+		{OpGetLocal, []int{256}, OpGetLocalWide, true},
+		{OpConstant, []int{1 << 16}, OpConstantWide, true},
+		{OpClosure, []int{3, 256}, OpClosureWide, true},
+		{OpGetFree, []int{1 << 16}, OpGetFreeWide, false},
+		{OpArray, []int{1 << 16}, OpArray, false},
+	}
+
+	for _, tt := range tests {
+		op := tt.op
+		if !Fits(op, tt.operands...) {
+			if wide, ok := Wide(op); ok {
+				op = wide
+			}
+		}
+		if op != tt.expectedOp || Fits(op, tt.operands...) != tt.expectedFits {
+			t.Errorf("Expected %v to be encoded with opcode %d (fits: %t), got %d (fits: %t)", tt.operands, tt.expectedOp, tt.expectedFits, op, Fits(op, tt.operands...))
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package code
+
+import (
+	"sort"
+
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+// DebugInfo relates the instructions of a function to the program they were
+// compiled from. The VM uses it to locate runtime errors, and debuggers to
+// stop at statements and show variables.
+type DebugInfo struct {
+	// SourceMap holds the span of the node each instruction was compiled
+	// from, sorted by offset.
+	SourceMap []SourceMapEntry
+	// Statements are sorted by their start offset. Nested statements come
+	// after the statement that contains them.
+	Statements []StatementInfo
+	// Locals holds the name of each local slot of the function. Slots of
+	// names that were defined again are left empty.
+	Locals []string
+	// Free holds the name of each free variable captured by the function.
+	Free []string
+}
+
+type SourceMapEntry struct {
+	Offset int
+	Span   token.Span
+}
+
+// StatementInfo is the range of instructions [Start, End) compiled from a
+// statement.
+type StatementInfo struct {
+	Start int
+	End   int
+	Span  token.Span
+}
+
+// StatementAt returns the statement whose first instruction is at offset.
+func (d *DebugInfo) StatementAt(offset int) (StatementInfo, bool) {
+	var result StatementInfo
+	found := false
+	for _, stmt := range d.Statements {
+		if stmt.Start == offset {
+			result = stmt
+			found = true
+		}
+	}
+	return result, found
+}
+
+// StatementContaining returns the innermost statement that contains the
+// instruction at offset.
+func (d *DebugInfo) StatementContaining(offset int) (StatementInfo, bool) {
+	var result StatementInfo
+	found := false
+	for _, stmt := range d.Statements {
+		if stmt.Start <= offset && offset < stmt.End {
+			result = stmt
+			found = true
+		}
+	}
+	return result, found
+}
+
+// SpanAt returns the span of the instruction at offset.
+func (d *DebugInfo) SpanAt(offset int) (token.Span, bool) {
+	idx := sort.Search(len(d.SourceMap), func(i int) bool {
+		return d.SourceMap[i].Offset >= offset
+	})
+	if idx == len(d.SourceMap) || d.SourceMap[idx].Offset != offset {
+		return token.Span{}, false
+	}
+	return d.SourceMap[idx].Span, true
+}
@@ -2,7 +2,6 @@ package compiler
 
 import (
 	"fmt"
-	"sort"
 
 	"github.com/javier-varez/monkey_interpreter/ast"
 	"github.com/javier-varez/monkey_interpreter/code"
@@ -12,11 +11,35 @@ import (
 
 const INTERNAL_VARARGS = "__monkey_internal_varargs_symbol__"
 
+// compileError is returned for errors in the compiled program, as opposed to
+// internal errors of the compiler. It implements ast.Error.
+type compileError struct {
+	span     token.Span
+	errorMsg string
+}
+
+func (e *compileError) Error() string {
+	return e.errorMsg
+}
+
+func (e *compileError) ContextualError() string {
+	return ast.FormatContextualError(e.span, e.errorMsg)
+}
+
+func (e *compileError) Span() token.Span {
+	return e.span
+}
+
 type CompilationScope struct {
 	instructions code.Instructions
 
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+
+	// statements compiled in the scope and the span of each instruction,
+	// for the debug info
+	statements []code.StatementInfo
+	sourceMap  []code.SourceMapEntry
 }
 
 type Compiler struct {
@@ -25,6 +48,19 @@ type Compiler struct {
 
 	scopes   []CompilationScope
 	curScope int
+
+	// span of the node being compiled, where emitted instructions are located
+	span token.Span
+	// functionName is the binding of the function literal about to be
+	// compiled, if any
+	functionName string
+	// constantIndex holds the constants that can be shared, see constantKey
+	constantIndex map[string][]int
+	// limitError is the first instruction whose operands could not be
+	// encoded, reported once the statement that emitted it is compiled
+	limitError error
+
+	optimize bool
 }
 
 type EmittedInstruction struct {
@@ -43,12 +79,20 @@ func New() *Compiler {
 
 func NewWithState(constants []object.Object, symbolTable *SymbolTable) *Compiler {
 	mainScope := CompilationScope{}
-	return &Compiler{
+	c := &Compiler{
 		constants:   constants,
 		symbolTable: symbolTable,
 
-		scopes: []CompilationScope{mainScope},
+		scopes:        []CompilationScope{mainScope},
+		constantIndex: map[string][]int{},
+	}
+
+	for i, constant := range constants {
+		if key, ok := constantKey(constant); ok {
+			c.constantIndex[key] = append(c.constantIndex[key], i)
+		}
 	}
+	return c
 }
 
 func (c *Compiler) currentInstructions() code.Instructions {
@@ -56,15 +100,23 @@ func (c *Compiler) currentInstructions() code.Instructions {
 }
 
 func (c *Compiler) Compile(untypedNode ast.Node) error {
+	outerSpan := c.span
+	c.span = untypedNode.Span()
+	defer func() { c.span = outerSpan }()
+
 	switch node := untypedNode.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
-			err := c.Compile(s)
+			err := c.compileStatement(s)
 			if err != nil {
 				return err
 			}
 		}
 
+		if c.optimize {
+			c.optimizeScope(true)
+		}
+
 	case *ast.ExpressionStatement:
 		err := c.Compile(node.Expr)
 		if err != nil {
@@ -74,6 +126,11 @@ func (c *Compiler) Compile(untypedNode ast.Node) error {
 		return nil
 
 	case *ast.InfixExpr:
+		if value, ok := constantValue(node); ok && c.optimize {
+			c.emitConstant(value)
+			return nil
+		}
+
 		if node.OperatorToken.Type == token.LT {
 			err := c.Compile(node.RightExpr)
 			if err != nil {
@@ -114,6 +171,10 @@ func (c *Compiler) Compile(untypedNode ast.Node) error {
 			c.emit(code.OpEqual)
 		case token.NOT_EQ:
 			c.emit(code.OpNotEqual)
+		case token.PIPE:
+			c.emit(code.OpUnion)
+		case token.AMPERSAND:
+			c.emit(code.OpIntersect)
 		default:
 			return fmt.Errorf("Unhandled infix operator %s", node.OperatorToken.Type)
 		}
@@ -121,7 +182,7 @@ func (c *Compiler) Compile(untypedNode ast.Node) error {
 		return nil
 
 	case *ast.IntegerLiteralExpr:
-		integer := &object.Integer{Value: node.Value}
+		integer := object.NewInt64(node.Value)
 		c.emit(code.OpConstant, c.addConstant(integer))
 
 	case *ast.BoolLiteralExpr:
@@ -132,6 +193,11 @@ func (c *Compiler) Compile(untypedNode ast.Node) error {
 		}
 
 	case *ast.PrefixExpr:
+		if value, ok := constantValue(node); ok && c.optimize {
+			c.emitConstant(value)
+			return nil
+		}
+
 		err := c.Compile(node.InnerExpr)
 		if err != nil {
 			return err
@@ -147,6 +213,10 @@ func (c *Compiler) Compile(untypedNode ast.Node) error {
 		}
 
 	case *ast.IfExpr:
+		if condition, ok := constantValue(node.Condition); ok && c.optimize {
+			return c.compileConstantIf(node, condition)
+		}
+
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
@@ -154,27 +224,19 @@ func (c *Compiler) Compile(untypedNode ast.Node) error {
 
 		notTrutyInst := c.emit(code.OpJumpNotTruthy, 1234)
 
-		err = c.Compile(node.Consequence)
+		err = c.compileBranch(node.Consequence)
 		if err != nil {
 			return err
 		}
 
-		if c.lastInstructionIsPop() {
-			c.removeLastPop()
-		}
-
 		endTruthyJumpPos := c.emit(code.OpJump, 1234)
 		c.changeOperand(notTrutyInst, len(c.currentInstructions()))
 
 		if node.Alternative != nil {
-			err = c.Compile(node.Alternative)
+			err = c.compileBranch(node.Alternative)
 			if err != nil {
 				return err
 			}
-
-			if c.lastInstructionIsPop() {
-				c.removeLastPop()
-			}
 		} else {
 			c.emit(code.OpNull)
 		}
@@ -182,19 +244,24 @@ func (c *Compiler) Compile(untypedNode ast.Node) error {
 		c.changeOperand(endTruthyJumpPos, len(c.currentInstructions()))
 	case *ast.BlockStatement:
 		for _, s := range node.Statements {
-			err := c.Compile(s)
+			err := c.compileStatement(s)
 			if err != nil {
 				return err
 			}
 		}
 
 	case *ast.LetStatement:
+		name := node.IdentExpr.(*ast.IdentifierExpr).IdentToken.Literal
+		if _, ok := node.Expr.(*ast.FnLiteralExpr); ok {
+			c.functionName = name
+		}
+
 		err := c.Compile(node.Expr)
 		if err != nil {
 			return err
 		}
 
-		sym := c.symbolTable.Define(node.IdentExpr.(*ast.IdentifierExpr).IdentToken.Literal)
+		sym := c.symbolTable.Define(name)
 		if sym.Scope == LocalScope {
 			c.emit(code.OpSetLocal, sym.Index)
 		} else {
@@ -204,7 +271,7 @@ func (c *Compiler) Compile(untypedNode ast.Node) error {
 	case *ast.VarArgsLiteralExpr:
 		sym, ok := c.symbolTable.Resolve(INTERNAL_VARARGS)
 		if !ok {
-			return fmt.Errorf("Unknown identifier %s", INTERNAL_VARARGS)
+			return &compileError{span: node.Span(), errorMsg: "`...` used outside of a function with var args"}
 		}
 
 		c.loadSymbol(sym)
@@ -212,7 +279,7 @@ func (c *Compiler) Compile(untypedNode ast.Node) error {
 	case *ast.IdentifierExpr:
 		sym, ok := c.symbolTable.Resolve(node.IdentToken.Literal)
 		if !ok {
-			return fmt.Errorf("Unknown identifier %s", node.IdentToken.Literal)
+			return &compileError{span: node.Span(), errorMsg: fmt.Sprintf("Unknown identifier %s", node.IdentToken.Literal)}
 		}
 
 		c.loadSymbol(sym)
@@ -230,6 +297,24 @@ func (c *Compiler) Compile(untypedNode ast.Node) error {
 		}
 		c.emit(code.OpArray, len(node.Elems))
 
+	case *ast.SetLiteralExpr:
+		for _, elemExpr := range node.Elems {
+			err := c.Compile(elemExpr)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpSet, len(node.Elems))
+
+	case *ast.TupleLiteralExpr:
+		for _, elemExpr := range node.Elems {
+			err := c.Compile(elemExpr)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpTuple, len(node.Elems))
+
 	case *ast.IndexOperatorExpr:
 		err := c.Compile(node.ObjExpr)
 		if err != nil {
@@ -244,28 +329,24 @@ func (c *Compiler) Compile(untypedNode ast.Node) error {
 		c.emit(code.OpIndex)
 
 	case *ast.MapLiteralExpr:
-		keys := []ast.Expression{}
-		for k := range node.Map {
-			keys = append(keys, k)
-		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, k := range keys {
-			err := c.Compile(k)
+		for _, entry := range node.Entries {
+			err := c.Compile(entry.Key)
 			if err != nil {
 				return err
 			}
 
-			err = c.Compile(node.Map[k])
+			err = c.Compile(entry.Value)
 			if err != nil {
 				return err
 			}
 		}
-		c.emit(code.OpHash, len(node.Map))
+		c.emit(code.OpHash, len(node.Entries))
 
 	case *ast.FnLiteralExpr:
+		// Functions defined inside of this one are not named after its binding
+		name := c.functionName
+		c.functionName = ""
+
 		c.enterScope()
 		// Define arguments
 		for _, arg := range node.Args {
@@ -288,6 +369,10 @@ func (c *Compiler) Compile(untypedNode ast.Node) error {
 			c.emit(code.OpReturn)
 		}
 
+		if c.optimize {
+			c.optimizeScope(false)
+		}
+		debug := c.debugInfo()
 		insts, numLocals, freeSymbols := c.exitScope()
 		numFreeSymbols := len(freeSymbols)
 
@@ -296,10 +381,12 @@ func (c *Compiler) Compile(untypedNode ast.Node) error {
 		}
 
 		c.emit(code.OpClosure, c.addConstant(&object.CompiledFunction{
+			Name:         name,
 			Instructions: insts,
 			NumLocals:    numLocals,
 			NumArgs:      len(node.Args),
 			VarArgs:      node.VarArgs,
+			Debug:        debug,
 		}), numFreeSymbols)
 
 	case *ast.ReturnStatement:
@@ -317,14 +404,16 @@ func (c *Compiler) Compile(untypedNode ast.Node) error {
 			}
 		}
 
-		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: int64(len(node.Args))}))
-
 		err := c.Compile(node.CallableExpr)
 		if err != nil {
 			return err
 		}
 
-		c.emit(code.OpCall)
+		if node.IsTailCall {
+			c.emit(code.OpTailCall, len(node.Args))
+		} else {
+			c.emit(code.OpCall, len(node.Args))
+		}
 
 	case *ast.RangeExpr:
 		if err := c.Compile(node.StartExpr); err != nil {
@@ -344,6 +433,46 @@ func (c *Compiler) Compile(untypedNode ast.Node) error {
 	return nil
 }
 
+// compileStatement compiles a statement of a program or block, recording the
+// instructions compiled from it.
+func (c *Compiler) compileStatement(stmt ast.Statment) error {
+	idx := len(c.scopes[c.curScope].statements)
+	c.scopes[c.curScope].statements = append(c.scopes[c.curScope].statements, code.StatementInfo{
+		Start: len(c.currentInstructions()),
+		Span:  stmt.Span(),
+	})
+
+	if err := c.Compile(stmt); err != nil {
+		return err
+	}
+	if c.limitError != nil {
+		return c.limitError
+	}
+
+	c.scopes[c.curScope].statements[idx].End = len(c.currentInstructions())
+	return nil
+}
+
+// compileBranch compiles a block of an if expression, leaving its value on
+// the stack. Blocks that do not end in an expression evaluate to null.
+func (c *Compiler) compileBranch(block *ast.BlockStatement) error {
+	start := len(c.currentInstructions())
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+
+	inBlock := c.scopes[c.curScope].lastInstruction.Position >= start
+	switch {
+	case inBlock && c.lastInstructionIsPop():
+		c.removeLastPop()
+	case inBlock && c.lastInstructionIsReturnValue():
+		// Execution never reaches the end of the block
+	default:
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
 func (c *Compiler) lastInstructionIsPop() bool {
 	return c.scopes[c.curScope].lastInstruction.Opcode == code.OpPop
 }
@@ -358,6 +487,16 @@ func (c *Compiler) removeLastPop() {
 	}
 	c.scopes[c.curScope].instructions = c.scopes[c.curScope].instructions[:c.scopes[c.curScope].lastInstruction.Position]
 	c.scopes[c.curScope].lastInstruction = c.scopes[c.curScope].previousInstruction
+
+	// The pop no longer belongs to the statements that ended with it
+	end := len(c.scopes[c.curScope].instructions)
+	for i := range c.scopes[c.curScope].statements {
+		if c.scopes[c.curScope].statements[i].End > end {
+			c.scopes[c.curScope].statements[i].End = end
+		}
+	}
+	sourceMap := c.scopes[c.curScope].sourceMap
+	c.scopes[c.curScope].sourceMap = sourceMap[:len(sourceMap)-1]
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstr []byte) {
@@ -368,21 +507,77 @@ func (c *Compiler) replaceInstruction(pos int, newInstr []byte) {
 
 func (c *Compiler) changeOperand(opPos int, operand int) {
 	opcode := code.Opcode(c.scopes[c.curScope].instructions[opPos])
+	if !code.Fits(opcode, operand) {
+		c.exceedLimit(opcode)
+		return
+	}
 	instrs := code.Make(opcode, operand)
 	c.replaceInstruction(opPos, instrs)
 }
 
 /// Operands is a list of operand offsets to the constants of the compiler
+// Opcodes with a wide variant switch to it when their operands do not fit.
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	if !code.Fits(op, operands...) {
+		if wide, ok := code.Wide(op); ok && code.Fits(wide, operands...) {
+			op = wide
+		} else {
+			// Keep the size of the instruction for the following ones
+			c.exceedLimit(op)
+			operands = nil
+		}
+	}
+
 	inst := code.Make(op, operands...)
 	pos := c.addInstruction(inst)
 	c.setLastInstruction(op, pos)
+	c.scopes[c.curScope].sourceMap = append(c.scopes[c.curScope].sourceMap, code.SourceMapEntry{Offset: pos, Span: c.span})
 	return pos
 }
 
+// exceedLimit records that the operands of an instruction do not fit in the
+// bytecode.
+func (c *Compiler) exceedLimit(op code.Opcode) {
+	if c.limitError != nil {
+		return
+	}
+
+	var msg string
+	switch op {
+	case code.OpGetGlobal, code.OpSetGlobal:
+		msg = fmt.Sprintf("Programs cannot define more than %d globals", 1<<16)
+	case code.OpArray, code.OpSet, code.OpTuple:
+		msg = fmt.Sprintf("Literals cannot have more than %d elements", 1<<16-1)
+	case code.OpHash:
+		msg = fmt.Sprintf("Map literals cannot have more than %d entries", 1<<16-1)
+	case code.OpCall, code.OpTailCall:
+		msg = fmt.Sprintf("Calls cannot take more than %d arguments", 1<<16-1)
+	case code.OpJump, code.OpJumpNotTruthy:
+		msg = fmt.Sprintf("Conditions cannot jump past offset %d of a function", 1<<16-1)
+	default:
+		def, _ := code.Lookup(byte(op))
+		msg = fmt.Sprintf("The operands of %s exceed the limits of the bytecode", def.Name)
+	}
+	c.limitError = &compileError{span: c.span, errorMsg: msg}
+}
+
+// addConstant returns the index of a constant equal to obj, adding obj to
+// the constants if there is none.
 func (c *Compiler) addConstant(obj object.Object) int {
+	key, ok := constantKey(obj)
+	if ok {
+		for _, idx := range c.constantIndex[key] {
+			if sameConstant(c.constants[idx], obj) {
+				return idx
+			}
+		}
+	}
+
 	off := len(c.constants)
 	c.constants = append(c.constants, obj)
+	if ok {
+		c.constantIndex[key] = append(c.constantIndex[key], off)
+	}
 	return off
 }
 
@@ -420,6 +615,28 @@ func (c *Compiler) exitScope() (code.Instructions, int, []Symbol) {
 	return insts, numLocals, freeSymbols
 }
 
+// debugInfo describes the current scope, it must be called before exiting it.
+func (c *Compiler) debugInfo() *code.DebugInfo {
+	locals := c.symbolTable.Names()
+	for i, name := range locals {
+		if name == INTERNAL_VARARGS {
+			locals[i] = "..."
+		}
+	}
+
+	free := []string{}
+	for _, sym := range c.symbolTable.FreeSymbols {
+		free = append(free, sym.Name)
+	}
+
+	return &code.DebugInfo{
+		SourceMap:  c.scopes[c.curScope].sourceMap,
+		Statements: c.scopes[c.curScope].statements,
+		Locals:     locals,
+		Free:       free,
+	}
+}
+
 func (c *Compiler) loadSymbol(sym Symbol) {
 	switch sym.Scope {
 	case LocalScope:
@@ -437,10 +654,20 @@ func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Debug: &code.DebugInfo{
+			SourceMap:  c.scopes[c.curScope].sourceMap,
+			Statements: c.scopes[c.curScope].statements,
+		},
+		Globals: c.symbolTable.Names(),
 	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+
+	// Debug relates the main instructions to the source
+	Debug *code.DebugInfo
+	// Globals holds the name of each global slot
+	Globals []string
 }
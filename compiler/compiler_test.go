@@ -2,6 +2,7 @@ package compiler
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/javier-varez/monkey_interpreter/ast"
@@ -303,9 +304,37 @@ func TestCompiler(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             `{1, 2} | {3} & {4}`,
+			expectedConstants: []interface{}{1, 2, 3, 4},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSet, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSet, 1),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpSet, 1),
+				code.Make(code.OpIntersect),
+				code.Make(code.OpUnion),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `(1, 2)[0]`,
+			expectedConstants: []interface{}{1, 2, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpTuple, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             `[1, 2 + 3, 4][3]`,
-			expectedConstants: []interface{}{1, 2, 3, 4, 3},
+			expectedConstants: []interface{}{1, 2, 3, 4},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
@@ -313,7 +342,7 @@ func TestCompiler(t *testing.T) {
 				code.Make(code.OpAdd),
 				code.Make(code.OpConstant, 3),
 				code.Make(code.OpArray, 3),
-				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 2),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
 			},
@@ -356,6 +385,18 @@ func TestCompiler(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             `{ "b": 1, "a": 2 }`,
+			expectedConstants: []interface{}{"b", 1, "a", 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input: `fn() { return 5 + 10 }`,
 			expectedConstants: []interface{}{
@@ -422,17 +463,15 @@ func TestCompiler(t *testing.T) {
 		{
 			input: `fn() { 24 }()`,
 			expectedConstants: []interface{}{
-				0,
 				24,
 				[]code.Instructions{
-					code.Make(code.OpConstant, 1),
+					code.Make(code.OpConstant, 0),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpClosure, 2, 0),
-				code.Make(code.OpCall),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpCall, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -444,14 +483,12 @@ func TestCompiler(t *testing.T) {
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpReturnValue),
 				},
-				0,
 			},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpConstant, 2),
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpCall),
+				code.Make(code.OpCall, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -534,16 +571,14 @@ func TestCompiler(t *testing.T) {
 				},
 				1,
 				2,
-				2,
 			},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpClosure, 0, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpCall),
+				code.Make(code.OpCall, 2),
 				code.Make(code.OpPop),
 			},
 		},
@@ -552,37 +587,53 @@ func TestCompiler(t *testing.T) {
 			expectedConstants: []interface {
 			}{
 				1,
-				1,
-				2,
 			},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpArray, 0),
-				code.Make(code.OpConstant, 0),
 				code.Make(code.OpGetBuiltin, 0),
-				code.Make(code.OpCall),
+				code.Make(code.OpCall, 1),
 				code.Make(code.OpPop),
 				code.Make(code.OpArray, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpGetBuiltin, 4),
-				code.Make(code.OpCall),
+				code.Make(code.OpCall, 2),
 				code.Make(code.OpPop),
 			},
 		},
 		{
 			input: `fn(...) { toArray(...) }`,
 			expectedConstants: []interface{}{
-				1,
 				[]code.Instructions{
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpConstant, 0),
 					code.Make(code.OpGetBuiltin, 6),
-					code.Make(code.OpCall),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(a) { if (a) { a(a) } else { return len(a); } }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpNotTruthy, 14),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpJump, 21),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetBuiltin, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -828,3 +879,157 @@ func TestCompilerScopes(t *testing.T) {
 			last.Opcode, code.OpMul)
 	}
 }
+
+func TestCompilerErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`puts(a)`, "Unknown identifier a"},
+		{`let f = fn(n) { f(n) };`, "Unknown identifier f"},
+		{`fn(a) { puts(...) }`, "`...` used outside of a function with var args"},
+		{"puts(" + strings.Repeat("1, ", 1<<16-1) + "1)", "Calls cannot take more than 65535 arguments"},
+		{"[" + strings.Repeat("1, ", 1<<16-1) + "1]", "Literals cannot have more than 65535 elements"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+		err := New().Compile(program)
+		if err == nil {
+			t.Errorf("Expected a compilation error in %q", tt.input)
+			continue
+		}
+
+		astErr, ok := err.(ast.Error)
+		if !ok {
+			t.Errorf("Compilation error in %q does not carry a span: %v", tt.input, err)
+			continue
+		}
+
+		if astErr.Error() != tt.expected {
+			t.Errorf("Unexpected error in %q. Expected %q, got %q", tt.input, tt.expected, astErr.Error())
+		}
+	}
+}
+
+func TestConstantInterning(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedConstants int
+	}{
+		{`1; "a"; 1; "a"; [1, "a"]`, 2},
+		{`let f = fn(x) { x + 1 }; f(1); f(1)`, 2},
+		// Equal functions in different places keep their own debug info
+		{`let f = fn() { 1 }; let g = fn() { 1 };`, 3},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("Compilation error: %s", err)
+		}
+		if constants := compiler.Bytecode().Constants; len(constants) != tt.expectedConstants {
+			t.Errorf("Expected %d constants for %q, got %d", tt.expectedConstants, tt.input, len(constants))
+		}
+	}
+
+	// Lines compiled again, like in the REPL, reuse the existing constants
+	input := `let f = fn(x) { x + "s" }; f("a")`
+	symbolTable := NewSymbolTable()
+	constants := []object.Object{}
+	for i := 0; i < 2; i++ {
+		compiler := NewWithState(constants, symbolTable)
+		if err := compiler.Compile(parse(input)); err != nil {
+			t.Fatalf("Compilation error: %s", err)
+		}
+		constants = compiler.Bytecode().Constants
+	}
+	if len(constants) != 3 {
+		t.Errorf("Expected 3 constants after compiling %q twice, got %d", input, len(constants))
+	}
+}
+
+func TestDebugInfo(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x, ...) {
+	let y = x + a;
+	fn() { y }
+};
+f(2)`
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("Compilation error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	fns := []*object.CompiledFunction{}
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			fns = append(fns, fn)
+		}
+	}
+	if len(fns) != 2 {
+		t.Fatalf("Expected 2 compiled functions, got %d", len(fns))
+	}
+
+	tests := []struct {
+		debug          *code.DebugInfo
+		instructions   code.Instructions
+		expectedLines  []int
+		expectedLocals []string
+		expectedFree   []string
+	}{
+		{bytecode.Debug, bytecode.Instructions, []int{0, 1, 5}, nil, nil},
+		{fns[1].Debug, fns[1].Instructions, []int{2, 3}, []string{"x", "...", "y"}, []string{}},
+		{fns[0].Debug, fns[0].Instructions, []int{3}, []string{}, []string{"y"}},
+	}
+
+	for i, tt := range tests {
+		lines := []int{}
+		for j, stmt := range tt.debug.Statements {
+			lines = append(lines, stmt.Span.Start.Line)
+
+			if stmt.Start >= stmt.End || stmt.End > len(tt.instructions) {
+				t.Errorf("Function %d has an invalid statement range [%d, %d)", i, stmt.Start, stmt.End)
+			}
+			if j > 0 && tt.debug.Statements[j-1].End != stmt.Start {
+				t.Errorf("Function %d has a gap between statements %d and %d", i, j-1, j)
+			}
+		}
+
+		// Every instruction is located, in order
+		offset := 0
+		for _, entry := range tt.debug.SourceMap {
+			if entry.Offset != offset || entry.Span.Text == nil {
+				t.Errorf("Function %d has an invalid source map entry %+v at offset %d", i, entry, offset)
+				break
+			}
+			def, err := code.Lookup(tt.instructions[offset])
+			if err != nil {
+				t.Fatalf("Invalid instruction: %s", err)
+			}
+			_, read := code.ReadOperands(def, tt.instructions[offset+1:])
+			offset += 1 + read
+		}
+		if offset != len(tt.instructions) {
+			t.Errorf("Function %d has unlocated instructions from offset %d", i, offset)
+		}
+
+		if fmt.Sprint(lines) != fmt.Sprint(tt.expectedLines) {
+			t.Errorf("Function %d has wrong statement lines. Expected %v, got %v", i, tt.expectedLines, lines)
+		}
+		if fmt.Sprint(tt.debug.Locals) != fmt.Sprint(tt.expectedLocals) {
+			t.Errorf("Function %d has wrong locals. Expected %v, got %v", i, tt.expectedLocals, tt.debug.Locals)
+		}
+		if fmt.Sprint(tt.debug.Free) != fmt.Sprint(tt.expectedFree) {
+			t.Errorf("Function %d has wrong free variables. Expected %v, got %v", i, tt.expectedFree, tt.debug.Free)
+		}
+	}
+
+	if fmt.Sprint(bytecode.Globals) != "[a f]" {
+		t.Errorf("Unexpected globals %v", bytecode.Globals)
+	}
+}
@@ -0,0 +1,41 @@
+package compiler
+
+import (
+	"reflect"
+	"strconv"
+
+	"github.com/javier-varez/monkey_interpreter/object"
+)
+
+// constantKey returns a key shared by the constants that may be equal, for
+// the kinds of constants that are interned: integers, strings and functions.
+// Constants are immutable, so every instruction pushing an equal value can
+// use the same one.
+func constantKey(obj object.Object) (string, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return string(obj.Type()) + " " + strconv.FormatInt(obj.Value, 10), true
+	case *object.BigInt:
+		return string(obj.Type()) + " " + obj.Value.String(), true
+	case *object.String:
+		return string(obj.Type()) + " " + obj.Value, true
+	case *object.CompiledFunction:
+		return string(obj.Type()) + " " + obj.Name + " " + string(obj.Instructions), true
+	}
+	return "", false
+}
+
+// sameConstant tells whether two constants with the same key are equal.
+// Functions must have the same debug info too, so that errors are still
+// located in the right place.
+func sameConstant(lhs, rhs object.Object) bool {
+	lhsFn, ok := lhs.(*object.CompiledFunction)
+	if !ok {
+		return object.Equals(lhs, rhs)
+	}
+	rhsFn := rhs.(*object.CompiledFunction)
+	return lhsFn.NumLocals == rhsFn.NumLocals &&
+		lhsFn.NumArgs == rhsFn.NumArgs &&
+		lhsFn.VarArgs == rhsFn.VarArgs &&
+		reflect.DeepEqual(lhsFn.Debug, rhsFn.Debug)
+}
@@ -0,0 +1,178 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/javier-varez/monkey_interpreter/code"
+	"github.com/javier-varez/monkey_interpreter/object"
+)
+
+// Disassemble lists the instructions of the main program and of every
+// function in the constant pool. Operands are annotated with the constants,
+// variables and builtins they refer to, jump targets are replaced by labels
+// and, if the bytecode has debug info, the first line of each statement is
+// shown before its instructions.
+func Disassemble(bytecode *Bytecode) string {
+	var out bytes.Buffer
+
+	main := &object.CompiledFunction{Name: "main", Instructions: bytecode.Instructions, Debug: bytecode.Debug}
+	d := &disassembler{out: &out, bytecode: bytecode}
+	fmt.Fprintf(&out, "main:\n")
+	d.function(main)
+
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&out, "\nconstant %d, %s:\n", i, describeFunction(fn))
+		d.function(fn)
+	}
+
+	return out.String()
+}
+
+func describeFunction(fn *object.CompiledFunction) string {
+	name := fn.Name
+	if name == "" {
+		name = object.ANONYMOUS_FUNCTION
+	}
+	varArgs := ""
+	if fn.VarArgs {
+		varArgs = " and varargs"
+	}
+	return fmt.Sprintf("fn %s with %d args%s, %d locals", name, fn.NumArgs, varArgs, fn.NumLocals)
+}
+
+type disassembler struct {
+	out      *bytes.Buffer
+	bytecode *Bytecode
+
+	fn     *object.CompiledFunction
+	labels map[int]string
+}
+
+func (d *disassembler) function(fn *object.CompiledFunction) {
+	d.fn = fn
+	d.labels = jumpLabels(fn.Instructions)
+
+	lastLine := -1
+	ins := fn.Instructions
+	for offset := 0; offset < len(ins); {
+		if label, ok := d.labels[offset]; ok {
+			fmt.Fprintf(d.out, "%s:\n", label)
+		}
+		if fn.Debug != nil {
+			if stmt, ok := fn.Debug.StatementAt(offset); ok && stmt.Span.Text != nil && stmt.Span.Start.Line != lastLine {
+				lastLine = stmt.Span.Start.Line
+				line := strings.Split(*stmt.Span.Text, "\n")[lastLine]
+				fmt.Fprintf(d.out, "\t; %d: %s\n", lastLine+1, strings.TrimSpace(line))
+			}
+		}
+
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			fmt.Fprintf(d.out, "\t%04d ERROR: %s\n", offset, err)
+			offset++
+			continue
+		}
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+
+		text := def.Name
+		for _, operand := range operands {
+			text += fmt.Sprintf(" %d", operand)
+		}
+		if annotation := d.annotate(code.Opcode(ins[offset]), operands); annotation != "" {
+			fmt.Fprintf(d.out, "\t%04d %-24s ; %s\n", offset, text, annotation)
+		} else {
+			fmt.Fprintf(d.out, "\t%04d %s\n", offset, text)
+		}
+		offset += 1 + read
+	}
+
+	if label, ok := d.labels[len(ins)]; ok {
+		fmt.Fprintf(d.out, "%s:\n", label)
+	}
+}
+
+// jumpLabels names the targets of the jumps of a function in the order they
+// appear.
+func jumpLabels(ins code.Instructions) map[int]string {
+	targets := []int{}
+	for offset := 0; offset < len(ins); {
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			offset++
+			continue
+		}
+		operands, read := code.ReadOperands(def, ins[offset+1:])
+		if code.IsJump(code.Opcode(ins[offset])) {
+			targets = append(targets, operands[0])
+		}
+		offset += 1 + read
+	}
+	sort.Ints(targets)
+
+	labels := map[int]string{}
+	for _, target := range targets {
+		if _, ok := labels[target]; !ok {
+			labels[target] = fmt.Sprintf("L%d", len(labels))
+		}
+	}
+	return labels
+}
+
+// annotate describes what the operands of an instruction refer to.
+func (d *disassembler) annotate(op code.Opcode, operands []int) string {
+	switch op {
+	case code.OpConstant, code.OpClosure, code.OpConstantWide, code.OpClosureWide:
+		if operands[0] >= len(d.bytecode.Constants) {
+			return "unknown constant"
+		}
+		constant := d.bytecode.Constants[operands[0]]
+		switch constant := constant.(type) {
+		case *object.CompiledFunction:
+			return describeFunction(constant)
+		case *object.String:
+			return fmt.Sprintf("%q", constant.Value)
+		}
+		return constant.Inspect()
+	case code.OpJump, code.OpJumpNotTruthy, code.OpJumpNotGreaterThan, code.OpJumpNotEqual, code.OpJumpEqual:
+		return d.labels[operands[0]]
+	case code.OpGetGlobal, code.OpSetGlobal, code.OpCallGlobal:
+		return nameAt(d.bytecode.Globals, operands[0])
+	case code.OpGetLocalAddConstant:
+		local := ""
+		if d.fn.Debug != nil {
+			local = nameAt(d.fn.Debug.Locals, operands[0])
+		}
+		constant := "unknown constant"
+		if operands[1] < len(d.bytecode.Constants) {
+			constant = d.bytecode.Constants[operands[1]].Inspect()
+		}
+		return local + " + " + constant
+	case code.OpGetLocal, code.OpSetLocal, code.OpGetLocalWide, code.OpSetLocalWide:
+		if d.fn.Debug != nil {
+			return nameAt(d.fn.Debug.Locals, operands[0])
+		}
+	case code.OpGetFree, code.OpGetFreeWide:
+		if d.fn.Debug != nil {
+			return nameAt(d.fn.Debug.Free, operands[0])
+		}
+	case code.OpGetBuiltin:
+		if operands[0] < len(object.Builtins) {
+			return object.Builtins[operands[0]].Name
+		}
+	}
+	return ""
+}
+
+func nameAt(names []string, idx int) string {
+	if idx < len(names) {
+		return names[idx]
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x) {
+	let y = if (x > a) { x } else { "a" };
+	fn() { len(y) }
+};
+f(2)();`
+
+	expected := `main:
+	; 1: let a = 1;
+	0000 OpConstant 0             ; 1
+	0003 OpSetGlobal 0            ; a
+	; 2: let f = fn(x) {
+	0006 OpClosure 3 0            ; fn f with 1 args, 2 locals
+	0010 OpSetGlobal 1            ; f
+	; 6: f(2)();
+	0013 OpConstant 4             ; 2
+	0016 OpGetGlobal 1            ; f
+	0019 OpCall 1
+	0021 OpCall 0
+	0023 OpPop
+
+constant 2, fn <anonymous> with 0 args, 0 locals:
+	; 4: fn() { len(y) }
+	0000 OpGetFree 0              ; y
+	0002 OpGetBuiltin 0           ; len
+	0004 OpTailCall 1
+	0006 OpReturnValue
+
+constant 3, fn f with 1 args, 2 locals:
+	; 3: let y = if (x > a) { x } else { "a" };
+	0000 OpGetLocal 0             ; x
+	0002 OpGetGlobal 0            ; a
+	0005 OpGreaterThan
+	0006 OpJumpNotTruthy 14       ; L0
+	0009 OpGetLocal 0             ; x
+	0011 OpJump 17                ; L1
+L0:
+	0014 OpConstant 1             ; "a"
+L1:
+	0017 OpSetLocal 1             ; y
+	; 4: fn() { len(y) }
+	0019 OpGetLocal 1             ; y
+	0021 OpClosure 2 1            ; fn <anonymous> with 0 args, 0 locals
+	0025 OpReturnValue
+`
+
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("Compilation error: %s", err)
+	}
+
+	actual := Disassemble(compiler.Bytecode())
+	if actual != expected {
+		t.Errorf("Unexpected disassembly.\nExpected:\n%s\nGot:\n%s", expected, actual)
+	}
+
+	// Without debug info there are no source lines nor local names
+	stripped := roundTrip(t, compiler.Bytecode(), false)
+	actual = Disassemble(stripped)
+	if strings.Contains(actual, "; 1: let a = 1;") || strings.Contains(actual, "OpGetLocal 0             ; x") {
+		t.Errorf("Unexpected debug info in disassembly:\n%s", actual)
+	}
+	if !strings.Contains(actual, "0011 OpJump 17                ; L1") {
+		t.Errorf("Expected labels without debug info:\n%s", actual)
+	}
+}
@@ -0,0 +1,356 @@
+package compiler
+
+import (
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/code"
+	"github.com/javier-varez/monkey_interpreter/object"
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+// EnableOptimizations makes the compiler fold constant expressions, leave out
+// the branches of conditions known at compile time, simplify the emitted
+// instructions and fuse common sequences of them into superinstructions.
+func (c *Compiler) EnableOptimizations() {
+	c.optimize = true
+}
+
+// constantValue evaluates expressions made only of literals, returning false
+// for anything else, including the operations that fail at runtime.
+func constantValue(expr ast.Expression) (object.Object, bool) {
+	switch expr := expr.(type) {
+	case *ast.IntegerLiteralExpr:
+		return object.NewInt64(expr.Value), true
+	case *ast.BoolLiteralExpr:
+		return object.NativeBool(expr.Value), true
+	case *ast.StringLiteralExpr:
+		return &object.String{Value: expr.Value}, true
+
+	case *ast.PrefixExpr:
+		inner, ok := constantValue(expr.InnerExpr)
+		if !ok {
+			return nil, false
+		}
+		switch {
+		case expr.OperatorToken.Type == token.MINUS && object.IsInteger(inner):
+			return object.NegateInteger(inner), true
+		case expr.OperatorToken.Type == token.BANG && (object.IsInteger(inner) || inner.Type() == object.BOOLEAN_OBJ):
+			return object.NativeBool(!isTruthy(inner)), true
+		}
+
+	case *ast.InfixExpr:
+		lhs, ok := constantValue(expr.LeftExpr)
+		if !ok {
+			return nil, false
+		}
+		rhs, ok := constantValue(expr.RightExpr)
+		if !ok {
+			return nil, false
+		}
+		return foldInfix(expr.OperatorToken.Type, lhs, rhs)
+	}
+	return nil, false
+}
+
+func foldInfix(operator token.TokenType, lhs, rhs object.Object) (object.Object, bool) {
+	if object.IsInteger(lhs) && object.IsInteger(rhs) {
+		switch operator {
+		case token.PLUS:
+			return object.AddIntegers(lhs, rhs), true
+		case token.MINUS:
+			return object.SubIntegers(lhs, rhs), true
+		case token.ASTERISK:
+			return object.MulIntegers(lhs, rhs), true
+		case token.SLASH:
+			// Divisions by zero are left for the runtime to report
+			result, err := object.DivIntegers(lhs, rhs)
+			return result, err == nil
+		case token.LT:
+			return object.NativeBool(object.CompareIntegers(lhs, rhs) < 0), true
+		case token.GT:
+			return object.NativeBool(object.CompareIntegers(lhs, rhs) > 0), true
+		}
+	}
+
+	if lhs.Type() == object.STRING_OBJ && rhs.Type() == object.STRING_OBJ && operator == token.PLUS {
+		return object.Concat(lhs, rhs)
+	}
+
+	if lhs.Type() == rhs.Type() {
+		switch operator {
+		case token.EQ:
+			return object.NativeBool(object.Equals(lhs, rhs)), true
+		case token.NOT_EQ:
+			return object.NativeBool(!object.Equals(lhs, rhs)), true
+		}
+	}
+	return nil, false
+}
+
+// isTruthy tells whether a constant makes conditions pass, like the VM does.
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return obj.Value != 0
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	}
+	return true
+}
+
+// emitConstant emits the instruction that pushes a folded constant.
+func (c *Compiler) emitConstant(obj object.Object) {
+	if boolean, ok := obj.(*object.Boolean); ok {
+		if boolean.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+		return
+	}
+	c.emit(code.OpConstant, c.addConstant(obj))
+}
+
+// compileConstantIf compiles the branch of an if expression whose condition
+// is known at compile time.
+func (c *Compiler) compileConstantIf(node *ast.IfExpr, condition object.Object) error {
+	var branch *ast.BlockStatement
+	if isTruthy(condition) {
+		branch = node.Consequence
+	} else if node.Alternative != nil {
+		branch = node.Alternative
+	} else {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	return c.compileBranch(branch)
+}
+
+// pushesValue tells whether an instruction only pushes a value, so that it
+// can be removed along with an OpPop that follows it.
+func pushesValue(ins code.Instruction) bool {
+	switch ins.Opcode {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree,
+		code.OpConstantWide, code.OpGetLocalWide, code.OpGetFreeWide:
+		return true
+	case code.OpClosure, code.OpClosureWide:
+		return ins.Operands[1] == 0
+	}
+	return false
+}
+
+// optimizeScope simplifies the instructions of the current scope once it is
+// compiled. Jumps to unconditional jumps go straight to their final target,
+// and values that are pushed only to be popped are not pushed at all.
+func (c *Compiler) optimizeScope(isMain bool) {
+	scope := &c.scopes[c.curScope]
+	instructions, err := scope.instructions.Decode()
+	if err != nil {
+		panic(err)
+	}
+
+	index := map[int]int{}
+	for i, ins := range instructions {
+		index[ins.Offset] = i
+	}
+
+	// Collapse jump chains, giving up on cycles
+	for _, ins := range instructions {
+		if !code.IsJump(ins.Opcode) {
+			continue
+		}
+		// Operands are shared with the instruction in the slice
+		target := ins.Operands[0]
+		for hops := 0; hops < len(instructions); hops++ {
+			i, ok := index[target]
+			if !ok || instructions[i].Opcode != code.OpJump || instructions[i].Operands[0] == target {
+				break
+			}
+			target = instructions[i].Operands[0]
+		}
+		ins.Operands[0] = target
+	}
+
+	// The last OpPop of the main program is kept, as its value is the result
+	// of the program
+	result := -1
+	for i, ins := range instructions {
+		if isMain && ins.Opcode == code.OpPop {
+			result = i
+		}
+	}
+
+	removed := make([]bool, len(instructions))
+	for changed := true; changed; {
+		changed = false
+
+		targets := map[int]bool{}
+		for i, ins := range instructions {
+			if !removed[i] && code.IsJump(ins.Opcode) {
+				targets[ins.Operands[0]] = true
+			}
+		}
+
+		previous := -1
+		for i, ins := range instructions {
+			if removed[i] {
+				continue
+			}
+			if ins.Opcode == code.OpPop && i != result && previous != -1 && pushesValue(instructions[previous]) && !targets[ins.Offset] {
+				removed[previous] = true
+				removed[i] = true
+				changed = true
+				previous = -1
+				continue
+			}
+			previous = i
+		}
+	}
+
+	c.fuse(instructions, removed)
+	c.relocate(instructions, removed)
+}
+
+// fusedJumps maps comparisons to the superinstructions that replace them
+// when they are followed by OpJumpNotTruthy.
+var fusedJumps = map[code.Opcode]code.Opcode{
+	code.OpGreaterThan: code.OpJumpNotGreaterThan,
+	code.OpEqual:       code.OpJumpNotEqual,
+	code.OpNotEqual:    code.OpJumpEqual,
+}
+
+// fuse replaces sequences of instructions with superinstructions. The first
+// instruction of a sequence is rewritten and the others are removed, so none
+// of them but the first may be the target of a jump. The superinstruction is
+// located at the source of the instruction that may fail.
+func (c *Compiler) fuse(instructions []code.Instruction, removed []bool) {
+	live := []int{}
+	targets := map[int]bool{}
+	for i, ins := range instructions {
+		if removed[i] {
+			continue
+		}
+		live = append(live, i)
+		if code.IsJump(ins.Opcode) {
+			targets[ins.Operands[0]] = true
+		}
+	}
+
+	// matches tells whether the live instructions starting at k have the
+	// given opcodes, and only the first one is a jump target
+	matches := func(k int, ops ...code.Opcode) bool {
+		if k+len(ops) > len(live) {
+			return false
+		}
+		for j, op := range ops {
+			ins := instructions[live[k+j]]
+			if ins.Opcode != op || (j > 0 && targets[ins.Offset]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for k := 0; k < len(live); k++ {
+		first := &instructions[live[k]]
+		switch {
+		case matches(k, code.OpGetLocal, code.OpConstant, code.OpAdd):
+			constant := instructions[live[k+1]].Operands[0]
+			add := instructions[live[k+2]]
+			*first = code.Instruction{Offset: first.Offset, Opcode: code.OpGetLocalAddConstant, Operands: []int{first.Operands[0], constant}}
+			c.moveSpan(add.Offset, first.Offset)
+			removed[live[k+1]], removed[live[k+2]] = true, true
+			k += 2
+
+		case matches(k, first.Opcode, code.OpJumpNotTruthy) && fusedJumps[first.Opcode] != 0:
+			target := instructions[live[k+1]].Operands[0]
+			*first = code.Instruction{Offset: first.Offset, Opcode: fusedJumps[first.Opcode], Operands: []int{target}}
+			removed[live[k+1]] = true
+			k++
+
+		case matches(k, code.OpGetGlobal, code.OpCall):
+			call := instructions[live[k+1]]
+			*first = code.Instruction{Offset: first.Offset, Opcode: code.OpCallGlobal, Operands: []int{first.Operands[0], call.Operands[0]}}
+			c.moveSpan(call.Offset, first.Offset)
+			removed[live[k+1]] = true
+			k++
+		}
+	}
+}
+
+// moveSpan locates the instruction at offset to at the source of the one at
+// offset from.
+func (c *Compiler) moveSpan(from, to int) {
+	sourceMap := c.scopes[c.curScope].sourceMap
+	var span *token.Span
+	for i := range sourceMap {
+		if sourceMap[i].Offset == from {
+			span = &sourceMap[i].Span
+		}
+	}
+	if span == nil {
+		return
+	}
+	for i := range sourceMap {
+		if sourceMap[i].Offset == to {
+			sourceMap[i].Span = *span
+		}
+	}
+}
+
+// relocate replaces the instructions of the current scope, leaving out the
+// removed ones. Jump targets and debug info are moved to the new offsets.
+func (c *Compiler) relocate(instructions []code.Instruction, removed []bool) {
+	scope := &c.scopes[c.curScope]
+
+	// offsets maps old offsets to new ones, removed instructions are mapped
+	// to the instruction that follows them
+	offsets := map[int]int{}
+	positions := map[int]int{}
+	offset := 0
+	for i, ins := range instructions {
+		offsets[ins.Offset] = offset
+		positions[ins.Offset] = i
+		if !removed[i] {
+			offset += len(code.Make(ins.Opcode, ins.Operands...))
+		}
+	}
+	offsets[len(scope.instructions)] = offset
+
+	result := code.Instructions{}
+	scope.lastInstruction = EmittedInstruction{}
+	scope.previousInstruction = EmittedInstruction{}
+	for i, ins := range instructions {
+		if removed[i] {
+			continue
+		}
+		if code.IsJump(ins.Opcode) {
+			ins.Operands[0] = offsets[ins.Operands[0]]
+		}
+		scope.previousInstruction = scope.lastInstruction
+		scope.lastInstruction = EmittedInstruction{Opcode: ins.Opcode, Position: len(result)}
+		result = append(result, code.Make(ins.Opcode, ins.Operands...)...)
+	}
+	scope.instructions = result
+
+	sourceMap := []code.SourceMapEntry{}
+	for _, entry := range scope.sourceMap {
+		if i, ok := positions[entry.Offset]; ok && !removed[i] {
+			sourceMap = append(sourceMap, code.SourceMapEntry{Offset: offsets[entry.Offset], Span: entry.Span})
+		}
+	}
+	scope.sourceMap = sourceMap
+
+	statements := []code.StatementInfo{}
+	for _, stmt := range scope.statements {
+		stmt.Start = offsets[stmt.Start]
+		stmt.End = offsets[stmt.End]
+		if stmt.Start != stmt.End {
+			statements = append(statements, stmt)
+		}
+	}
+	scope.statements = statements
+}
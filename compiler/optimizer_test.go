@@ -0,0 +1,251 @@
+package compiler
+
+import "testing"
+
+func TestOptimizations(t *testing.T) {
+	tests := []struct {
+		input    string
+		before   string
+		expected string
+	}{
+		{
+			// Constant folding and dead branches
+			"if (1 > 2) { 10 } else { 20 }; 1 - 2 * 3",
+			`main:
+	; 1: if (1 > 2) { 10 } else { 20 }; 1 - 2 * 3
+	0000 OpConstant 0             ; 1
+	0003 OpConstant 1             ; 2
+	0006 OpGreaterThan
+	0007 OpJumpNotTruthy 16       ; L0
+	0010 OpConstant 2             ; 10
+	0013 OpJump 19                ; L1
+L0:
+	0016 OpConstant 3             ; 20
+L1:
+	0019 OpPop
+	0020 OpConstant 0             ; 1
+	0023 OpConstant 1             ; 2
+	0026 OpConstant 4             ; 3
+	0029 OpMul
+	0030 OpSub
+	0031 OpPop
+`,
+			`main:
+	; 1: if (1 > 2) { 10 } else { 20 }; 1 - 2 * 3
+	0000 OpConstant 1             ; -5
+	0003 OpPop
+`,
+		},
+		{
+			// Jump chains
+			"let x = 10; if (x > 1) { if (x > 2) { 1 } else { 2 } } else { 3 }",
+			`main:
+	; 1: let x = 10; if (x > 1) { if (x > 2) { 1 } else { 2 } } else { 3 }
+	0000 OpConstant 0             ; 10
+	0003 OpSetGlobal 0            ; x
+	0006 OpGetGlobal 0            ; x
+	0009 OpConstant 1             ; 1
+	0012 OpGreaterThan
+	0013 OpJumpNotTruthy 38       ; L2
+	0016 OpGetGlobal 0            ; x
+	0019 OpConstant 2             ; 2
+	0022 OpGreaterThan
+	0023 OpJumpNotTruthy 32       ; L0
+	0026 OpConstant 1             ; 1
+	0029 OpJump 35                ; L1
+L0:
+	0032 OpConstant 2             ; 2
+L1:
+	0035 OpJump 41                ; L3
+L2:
+	0038 OpConstant 3             ; 3
+L3:
+	0041 OpPop
+`,
+			`main:
+	; 1: let x = 10; if (x > 1) { if (x > 2) { 1 } else { 2 } } else { 3 }
+	0000 OpConstant 0             ; 10
+	0003 OpSetGlobal 0            ; x
+	0006 OpGetGlobal 0            ; x
+	0009 OpConstant 1             ; 1
+	0012 OpJumpNotGreaterThan 36  ; L1
+	0015 OpGetGlobal 0            ; x
+	0018 OpConstant 2             ; 2
+	0021 OpJumpNotGreaterThan 30  ; L0
+	0024 OpConstant 1             ; 1
+	0027 OpJump 39                ; L2
+L0:
+	0030 OpConstant 2             ; 2
+	0033 OpJump 39                ; L2
+L1:
+	0036 OpConstant 3             ; 3
+L2:
+	0039 OpPop
+`,
+		},
+		{
+			// Redundant pops, keeping the result of the program
+			`let x = 1; x; x + 1; "a"; -x`,
+			`main:
+	; 1: let x = 1; x; x + 1; "a"; -x
+	0000 OpConstant 0             ; 1
+	0003 OpSetGlobal 0            ; x
+	0006 OpGetGlobal 0            ; x
+	0009 OpPop
+	0010 OpGetGlobal 0            ; x
+	0013 OpConstant 0             ; 1
+	0016 OpAdd
+	0017 OpPop
+	0018 OpConstant 1             ; "a"
+	0021 OpPop
+	0022 OpGetGlobal 0            ; x
+	0025 OpMinus
+	0026 OpPop
+`,
+			`main:
+	; 1: let x = 1; x; x + 1; "a"; -x
+	0000 OpConstant 0             ; 1
+	0003 OpSetGlobal 0            ; x
+	0006 OpGetGlobal 0            ; x
+	0009 OpConstant 0             ; 1
+	0012 OpAdd
+	0013 OpPop
+	0014 OpGetGlobal 0            ; x
+	0017 OpMinus
+	0018 OpPop
+`,
+		},
+		{
+			// Functions are optimized too, and divisions by zero are kept
+			"let f = fn(a) { if (!true) { 1 } else { a; a } }; -(2 - 5) / 0",
+			`main:
+	; 1: let f = fn(a) { if (!true) { 1 } else { a; a } }; -(2 - 5) / 0
+	0000 OpClosure 1 0            ; fn f with 1 args, 1 locals
+	0004 OpSetGlobal 0            ; f
+	0007 OpConstant 2             ; 2
+	0010 OpConstant 3             ; 5
+	0013 OpSub
+	0014 OpMinus
+	0015 OpConstant 4             ; 0
+	0018 OpDiv
+	0019 OpPop
+
+constant 1, fn f with 1 args, 1 locals:
+	; 1: let f = fn(a) { if (!true) { 1 } else { a; a } }; -(2 - 5) / 0
+	0000 OpTrue
+	0001 OpBang
+	0002 OpJumpNotTruthy 11       ; L0
+	0005 OpConstant 0             ; 1
+	0008 OpJump 16                ; L1
+L0:
+	0011 OpGetLocal 0             ; a
+	0013 OpPop
+	0014 OpGetLocal 0             ; a
+L1:
+	0016 OpReturnValue
+`,
+			`main:
+	; 1: let f = fn(a) { if (!true) { 1 } else { a; a } }; -(2 - 5) / 0
+	0000 OpClosure 0 0            ; fn f with 1 args, 1 locals
+	0004 OpSetGlobal 0            ; f
+	0007 OpConstant 1             ; 3
+	0010 OpConstant 2             ; 0
+	0013 OpDiv
+	0014 OpPop
+
+constant 0, fn f with 1 args, 1 locals:
+	; 1: let f = fn(a) { if (!true) { 1 } else { a; a } }; -(2 - 5) / 0
+	0000 OpGetLocal 0             ; a
+	0002 OpReturnValue
+`,
+		},
+		{
+			// Superinstructions
+			"let g = fn(x) { x }; let f = fn(n) { if (n != 3) { g(n + 1) * 2 } else { n } }; f(0)",
+			`main:
+	; 1: let g = fn(x) { x }; let f = fn(n) { if (n != 3) { g(n + 1) * 2 } else { n } }; f(0)
+	0000 OpClosure 0 0            ; fn g with 1 args, 1 locals
+	0004 OpSetGlobal 0            ; g
+	0007 OpClosure 4 0            ; fn f with 1 args, 1 locals
+	0011 OpSetGlobal 1            ; f
+	0014 OpConstant 5             ; 0
+	0017 OpGetGlobal 1            ; f
+	0020 OpCall 1
+	0022 OpPop
+
+constant 0, fn g with 1 args, 1 locals:
+	; 1: let g = fn(x) { x }; let f = fn(n) { if (n != 3) { g(n + 1) * 2 } else { n } }; f(0)
+	0000 OpGetLocal 0             ; x
+	0002 OpReturnValue
+
+constant 4, fn f with 1 args, 1 locals:
+	; 1: let g = fn(x) { x }; let f = fn(n) { if (n != 3) { g(n + 1) * 2 } else { n } }; f(0)
+	0000 OpGetLocal 0             ; n
+	0002 OpConstant 1             ; 3
+	0005 OpNotEqual
+	0006 OpJumpNotTruthy 27       ; L0
+	0009 OpGetLocal 0             ; n
+	0011 OpConstant 2             ; 1
+	0014 OpAdd
+	0015 OpGetGlobal 0            ; g
+	0018 OpCall 1
+	0020 OpConstant 3             ; 2
+	0023 OpMul
+	0024 OpJump 29                ; L1
+L0:
+	0027 OpGetLocal 0             ; n
+L1:
+	0029 OpReturnValue
+`,
+			`main:
+	; 1: let g = fn(x) { x }; let f = fn(n) { if (n != 3) { g(n + 1) * 2 } else { n } }; f(0)
+	0000 OpClosure 0 0            ; fn g with 1 args, 1 locals
+	0004 OpSetGlobal 0            ; g
+	0007 OpClosure 4 0            ; fn f with 1 args, 1 locals
+	0011 OpSetGlobal 1            ; f
+	0014 OpConstant 5             ; 0
+	0017 OpCallGlobal 1 1         ; f
+	0021 OpPop
+
+constant 0, fn g with 1 args, 1 locals:
+	; 1: let g = fn(x) { x }; let f = fn(n) { if (n != 3) { g(n + 1) * 2 } else { n } }; f(0)
+	0000 OpGetLocal 0             ; x
+	0002 OpReturnValue
+
+constant 4, fn f with 1 args, 1 locals:
+	; 1: let g = fn(x) { x }; let f = fn(n) { if (n != 3) { g(n + 1) * 2 } else { n } }; f(0)
+	0000 OpGetLocal 0             ; n
+	0002 OpConstant 1             ; 3
+	0005 OpJumpEqual 23           ; L0
+	0008 OpGetLocalAddConstant 0 2 ; n + 1
+	0012 OpCallGlobal 0 1         ; g
+	0016 OpConstant 3             ; 2
+	0019 OpMul
+	0020 OpJump 25                ; L1
+L0:
+	0023 OpGetLocal 0             ; n
+L1:
+	0025 OpReturnValue
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("Compilation error: %s", err)
+		}
+		if actual := Disassemble(compiler.Bytecode()); actual != tt.before {
+			t.Errorf("Unexpected disassembly of %q without optimizations.\nExpected:\n%s\nGot:\n%s", tt.input, tt.before, actual)
+		}
+
+		compiler = New()
+		compiler.EnableOptimizations()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("Compilation error: %s", err)
+		}
+		if actual := Disassemble(compiler.Bytecode()); actual != tt.expected {
+			t.Errorf("Unexpected disassembly of %q with optimizations.\nExpected:\n%s\nGot:\n%s", tt.input, tt.expected, actual)
+		}
+	}
+}
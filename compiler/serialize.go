@@ -0,0 +1,480 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/javier-varez/monkey_interpreter/code"
+	"github.com/javier-varez/monkey_interpreter/object"
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+// Bytecode files start with MAGIC, followed by FORMAT_VERSION and
+// code.VERSION. The rest of the file is made of unsigned varints, with signed
+// varints for integer values and length-prefixed strings and byte slices:
+//
+//	flags          HAS_DEBUG_INFO if the file has debug info
+//	sources        count and text of the programs spans refer to, with debug info only
+//	globals        count and name of each global slot, with debug info only
+//	instructions   of the main program
+//	debug info     of the main program, with debug info only
+//	constants      count and each constant, starting with its tag
+//
+// Spans are written as the index of their source plus one, or zero if they
+// have none, followed by their start and end locations.
+const MAGIC = "MBC\x00"
+
+// FORMAT_VERSION identifies the layout of bytecode files.
+const FORMAT_VERSION = 1
+
+// HAS_DEBUG_INFO is set in the flags of files that keep the source map,
+// statements and variable names of the program.
+const HAS_DEBUG_INFO = 1 << 0
+
+const (
+	integerTag byte = iota
+	bigIntTag
+	booleanTag
+	nullTag
+	stringTag
+	charTag
+	arrayTag
+	tupleTag
+	setTag
+	hashMapTag
+	compiledFunctionTag
+)
+
+// Encode writes the bytecode in the binary file format. Debug info is only
+// written if withDebugInfo is set, so that runtime errors of the decoded
+// program can be located.
+func (b *Bytecode) Encode(w io.Writer, withDebugInfo bool) error {
+	body := &encoder{withDebugInfo: withDebugInfo && b.Debug != nil, sources: map[*string]int{}}
+	if body.withDebugInfo {
+		body.strings(b.Globals)
+	}
+	body.bytes(b.Instructions)
+	if body.withDebugInfo {
+		body.debugInfo(b.Debug)
+	}
+	if err := body.objects(b.Constants); err != nil {
+		return err
+	}
+
+	// Sources are only known once every span was written, but they are
+	// decoded before them
+	header := &encoder{}
+	header.buffer.WriteString(MAGIC)
+	header.uint(FORMAT_VERSION)
+	header.uint(code.VERSION)
+	if body.withDebugInfo {
+		header.uint(HAS_DEBUG_INFO)
+		header.uint(uint64(len(body.sourceTexts)))
+		for _, text := range body.sourceTexts {
+			header.string(*text)
+		}
+	} else {
+		header.uint(0)
+	}
+
+	if _, err := w.Write(header.buffer.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(body.buffer.Bytes())
+	return err
+}
+
+type encoder struct {
+	buffer        bytes.Buffer
+	withDebugInfo bool
+
+	// sources maps the text of each span to its index in sourceTexts
+	sources     map[*string]int
+	sourceTexts []*string
+}
+
+func (e *encoder) uint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.buffer.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (e *encoder) int(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.buffer.Write(buf[:binary.PutVarint(buf[:], v)])
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint(uint64(len(b)))
+	e.buffer.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+func (e *encoder) strings(s []string) {
+	e.uint(uint64(len(s)))
+	for _, str := range s {
+		e.string(str)
+	}
+}
+
+func (e *encoder) span(span token.Span) {
+	if span.Text == nil {
+		e.uint(0)
+	} else {
+		idx, ok := e.sources[span.Text]
+		if !ok {
+			idx = len(e.sourceTexts)
+			e.sources[span.Text] = idx
+			e.sourceTexts = append(e.sourceTexts, span.Text)
+		}
+		e.uint(uint64(idx + 1))
+	}
+	e.uint(uint64(span.Start.Line))
+	e.uint(uint64(span.Start.Column))
+	e.uint(uint64(span.End.Line))
+	e.uint(uint64(span.End.Column))
+}
+
+func (e *encoder) debugInfo(debug *code.DebugInfo) {
+	e.uint(uint64(len(debug.SourceMap)))
+	for _, entry := range debug.SourceMap {
+		e.uint(uint64(entry.Offset))
+		e.span(entry.Span)
+	}
+	e.uint(uint64(len(debug.Statements)))
+	for _, stmt := range debug.Statements {
+		e.uint(uint64(stmt.Start))
+		e.uint(uint64(stmt.End))
+		e.span(stmt.Span)
+	}
+	e.strings(debug.Locals)
+	e.strings(debug.Free)
+}
+
+func (e *encoder) objects(objs []object.Object) error {
+	e.uint(uint64(len(objs)))
+	for _, obj := range objs {
+		if err := e.object(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) object(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.buffer.WriteByte(integerTag)
+		e.int(obj.Value)
+	case *object.BigInt:
+		e.buffer.WriteByte(bigIntTag)
+		e.int(int64(obj.Value.Sign()))
+		e.bytes(obj.Value.Bytes())
+	case *object.Boolean:
+		e.buffer.WriteByte(booleanTag)
+		if obj.Value {
+			e.uint(1)
+		} else {
+			e.uint(0)
+		}
+	case *object.Null:
+		e.buffer.WriteByte(nullTag)
+	case *object.String:
+		e.buffer.WriteByte(stringTag)
+		e.string(obj.Value)
+	case *object.Char:
+		e.buffer.WriteByte(charTag)
+		e.int(int64(obj.Value))
+	case *object.Array:
+		e.buffer.WriteByte(arrayTag)
+		return e.objects(obj.Elems)
+	case *object.Tuple:
+		e.buffer.WriteByte(tupleTag)
+		return e.objects(obj.Elems)
+	case *object.Set:
+		e.buffer.WriteByte(setTag)
+		return e.objects(obj.Elems())
+	case *object.HashMap:
+		e.buffer.WriteByte(hashMapTag)
+		e.uint(uint64(obj.Len()))
+		for _, entry := range obj.Entries() {
+			if err := e.object(entry.Key); err != nil {
+				return err
+			}
+			if err := e.object(entry.Value); err != nil {
+				return err
+			}
+		}
+	case *object.CompiledFunction:
+		e.buffer.WriteByte(compiledFunctionTag)
+		e.string(obj.Name)
+		e.bytes(obj.Instructions)
+		e.uint(uint64(obj.NumLocals))
+		e.uint(uint64(obj.NumArgs))
+		if obj.VarArgs {
+			e.uint(1)
+		} else {
+			e.uint(0)
+		}
+		if e.withDebugInfo && obj.Debug != nil {
+			e.uint(1)
+			e.debugInfo(obj.Debug)
+		} else {
+			e.uint(0)
+		}
+	default:
+		return fmt.Errorf("Cannot serialize constant of type %s", obj.Type())
+	}
+	return nil
+}
+
+// Decode reads bytecode in the binary file format. Files written for other
+// versions of the format or of the opcodes are rejected.
+func Decode(r io.Reader) (*Bytecode, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(content, []byte(MAGIC)) {
+		return nil, fmt.Errorf("Not a bytecode file")
+	}
+	d := &decoder{content: content[len(MAGIC):]}
+
+	if version := d.uint(); d.err == nil && version != FORMAT_VERSION {
+		return nil, fmt.Errorf("Unsupported bytecode format version %d, expected %d", version, FORMAT_VERSION)
+	}
+	if version := d.uint(); d.err == nil && version != code.VERSION {
+		return nil, fmt.Errorf("Bytecode was compiled for opcode version %d, expected %d", version, code.VERSION)
+	}
+
+	d.withDebugInfo = d.uint()&HAS_DEBUG_INFO != 0
+	bytecode := &Bytecode{}
+	if d.withDebugInfo {
+		count := d.count()
+		for i := 0; i < count; i++ {
+			text := d.string()
+			d.sources = append(d.sources, &text)
+		}
+		bytecode.Globals = d.strings()
+	}
+
+	bytecode.Instructions = d.bytes()
+	if d.withDebugInfo {
+		bytecode.Debug = d.debugInfo()
+	}
+	bytecode.Constants = d.objects()
+
+	if d.err == nil && len(d.content) != 0 {
+		d.fail("%d unexpected bytes at the end", len(d.content))
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return bytecode, nil
+}
+
+// decoder reads the values of a bytecode file. The first error is kept and
+// makes every following read return zero values.
+type decoder struct {
+	content       []byte
+	withDebugInfo bool
+	sources       []*string
+	err           error
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("Invalid bytecode file: "+format, args...)
+	}
+	d.content = nil
+}
+
+func (d *decoder) uint() uint64 {
+	v, n := binary.Uvarint(d.content)
+	if n <= 0 {
+		d.fail("truncated or malformed integer")
+		return 0
+	}
+	d.content = d.content[n:]
+	return v
+}
+
+func (d *decoder) int() int64 {
+	v, n := binary.Varint(d.content)
+	if n <= 0 {
+		d.fail("truncated or malformed integer")
+		return 0
+	}
+	d.content = d.content[n:]
+	return v
+}
+
+// count reads the length of a sequence. Every element takes at least one
+// byte, which bounds the lengths of valid files.
+func (d *decoder) count() int {
+	v := d.uint()
+	if v > uint64(len(d.content)) {
+		d.fail("length %d exceeds the file", v)
+		return 0
+	}
+	return int(v)
+}
+
+func (d *decoder) byte() byte {
+	if len(d.content) == 0 {
+		d.fail("unexpected end of file")
+		return 0
+	}
+	b := d.content[0]
+	d.content = d.content[1:]
+	return b
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	b := append([]byte{}, d.content[:n]...)
+	d.content = d.content[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) strings() []string {
+	count := d.count()
+	result := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		result = append(result, d.string())
+	}
+	return result
+}
+
+func (d *decoder) span() token.Span {
+	var span token.Span
+	if source := d.uint(); source != 0 {
+		if source > uint64(len(d.sources)) {
+			d.fail("unknown source %d", source)
+			return span
+		}
+		span.Text = d.sources[source-1]
+	}
+	span.Start.Line = int(d.uint())
+	span.Start.Column = int(d.uint())
+	span.End.Line = int(d.uint())
+	span.End.Column = int(d.uint())
+	return span
+}
+
+func (d *decoder) debugInfo() *code.DebugInfo {
+	debug := &code.DebugInfo{}
+
+	count := d.count()
+	for i := 0; i < count; i++ {
+		offset := int(d.uint())
+		debug.SourceMap = append(debug.SourceMap, code.SourceMapEntry{Offset: offset, Span: d.span()})
+	}
+	count = d.count()
+	for i := 0; i < count; i++ {
+		start := int(d.uint())
+		end := int(d.uint())
+		debug.Statements = append(debug.Statements, code.StatementInfo{Start: start, End: end, Span: d.span()})
+	}
+	debug.Locals = d.strings()
+	debug.Free = d.strings()
+	return debug
+}
+
+func (d *decoder) objects() []object.Object {
+	count := d.count()
+	result := make([]object.Object, 0, count)
+	for i := 0; i < count; i++ {
+		result = append(result, d.object())
+	}
+	return result
+}
+
+func (d *decoder) hashable() object.Hashable {
+	obj := d.object()
+	if d.err != nil {
+		return nil
+	}
+	key, ok := obj.(object.Hashable)
+	if !ok {
+		d.fail("unhashable key of type %s", obj.Type())
+		return nil
+	}
+	return key
+}
+
+func (d *decoder) object() object.Object {
+	switch tag := d.byte(); tag {
+	case integerTag:
+		return object.NewInt64(d.int())
+	case bigIntTag:
+		sign := d.int()
+		value := new(big.Int).SetBytes(d.bytes())
+		if sign < 0 {
+			value.Neg(value)
+		}
+		return &object.BigInt{Value: value}
+	case booleanTag:
+		return object.NativeBool(d.uint() != 0)
+	case nullTag:
+		return object.NULL
+	case stringTag:
+		return &object.String{Value: d.string()}
+	case charTag:
+		return &object.Char{Value: rune(d.int())}
+	case arrayTag:
+		return &object.Array{Elems: d.objects()}
+	case tupleTag:
+		return &object.Tuple{Elems: d.objects()}
+	case setTag:
+		set := object.NewSet()
+		count := d.count()
+		for i := 0; i < count && d.err == nil; i++ {
+			if elem := d.hashable(); elem != nil {
+				set.Add(elem)
+			}
+		}
+		return set
+	case hashMapTag:
+		hashMap := object.NewHashMap()
+		count := d.count()
+		for i := 0; i < count && d.err == nil; i++ {
+			key := d.hashable()
+			value := d.object()
+			if key != nil {
+				hashMap.Set(key, value)
+			}
+		}
+		return hashMap
+	case compiledFunctionTag:
+		fn := &object.CompiledFunction{
+			Name:         d.string(),
+			Instructions: d.bytes(),
+			NumLocals:    int(d.uint()),
+			NumArgs:      int(d.uint()),
+			VarArgs:      d.uint() != 0,
+		}
+		if d.uint() != 0 {
+			if !d.withDebugInfo {
+				d.fail("debug info in a file without it")
+			}
+			fn.Debug = d.debugInfo()
+		}
+		return fn
+	default:
+		if d.err == nil {
+			d.fail("unknown constant tag %d", tag)
+		}
+		return object.NULL
+	}
+}
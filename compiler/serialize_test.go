@@ -0,0 +1,157 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/javier-varez/monkey_interpreter/code"
+	"github.com/javier-varez/monkey_interpreter/object"
+)
+
+func compileForEncoding(t *testing.T, input string) *Bytecode {
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("Compilation error: %s", err)
+	}
+	return compiler.Bytecode()
+}
+
+func roundTrip(t *testing.T, bytecode *Bytecode, withDebugInfo bool) *Bytecode {
+	var buffer bytes.Buffer
+	if err := bytecode.Encode(&buffer, withDebugInfo); err != nil {
+		t.Fatalf("Unable to encode: %s", err)
+	}
+	decoded, err := Decode(&buffer)
+	if err != nil {
+		t.Fatalf("Unable to decode: %s", err)
+	}
+	return decoded
+}
+
+func TestEncodeCompiledProgram(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x, ...) {
+	let y = x + a;
+	fn() { y + "s" }
+};
+f(2)`
+	bytecode := compileForEncoding(t, input)
+
+	decoded := roundTrip(t, bytecode, true)
+	if !reflect.DeepEqual(decoded.Instructions, bytecode.Instructions) ||
+		!reflect.DeepEqual(decoded.Constants, bytecode.Constants) ||
+		!reflect.DeepEqual(decoded.Globals, bytecode.Globals) {
+		t.Errorf("Decoded bytecode differs.\nExpected %+v\ngot %+v", bytecode, decoded)
+	}
+	// The main program has no variables of its own
+	if !reflect.DeepEqual(decoded.Debug.SourceMap, bytecode.Debug.SourceMap) ||
+		!reflect.DeepEqual(decoded.Debug.Statements, bytecode.Debug.Statements) {
+		t.Errorf("Decoded debug info differs.\nExpected %+v\ngot %+v", bytecode.Debug, decoded.Debug)
+	}
+
+	// All spans point to a single copy of the source
+	text := decoded.Debug.Statements[0].Span.Text
+	if *text != input {
+		t.Fatalf("Unexpected source %q", *text)
+	}
+	for _, constant := range decoded.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			for _, entry := range fn.Debug.SourceMap {
+				if entry.Span.Text != text {
+					t.Errorf("Span of %s refers to another source", fn.Name)
+				}
+			}
+		}
+	}
+
+	stripped := roundTrip(t, bytecode, false)
+	if stripped.Debug != nil || stripped.Globals != nil {
+		t.Errorf("Expected no debug info, got %+v", stripped)
+	}
+	if !bytes.Equal(stripped.Instructions, bytecode.Instructions) {
+		t.Errorf("Unexpected instructions.\nExpected %s\ngot %s", bytecode.Instructions, stripped.Instructions)
+	}
+	for i, constant := range stripped.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			original := bytecode.Constants[i].(*object.CompiledFunction)
+			if fn.Debug != nil || !reflect.DeepEqual(fn.Instructions, original.Instructions) ||
+				fn.Name != original.Name || fn.NumLocals != original.NumLocals ||
+				fn.NumArgs != original.NumArgs || fn.VarArgs != original.VarArgs {
+				t.Errorf("Unexpected function %+v, expected %+v without debug info", fn, original)
+			}
+		}
+	}
+}
+
+func TestEncodeConstants(t *testing.T) {
+	hashMap := object.NewHashMap()
+	hashMap.Set(&object.String{Value: "key"}, &object.Array{Elems: []object.Object{&object.Null{}}})
+	hashMap.Set(&object.Integer{Value: -3}, &object.Boolean{Value: true})
+	set := object.NewSet()
+	set.Add(&object.Char{Value: 'ñ'})
+	set.Add(&object.Tuple{Elems: []object.Object{&object.Integer{Value: 1}, &object.Boolean{Value: false}}})
+	bigInt, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+
+	constants := []object.Object{
+		&object.Integer{Value: 1 << 40},
+		&object.BigInt{Value: bigInt},
+		&object.String{Value: "text"},
+		hashMap,
+		set,
+	}
+	decoded := roundTrip(t, &Bytecode{Constants: constants}, true)
+
+	if len(decoded.Constants) != len(constants) {
+		t.Fatalf("Expected %d constants, got %d", len(constants), len(decoded.Constants))
+	}
+	for i, expected := range constants {
+		actual := decoded.Constants[i]
+		if actual.Type() != expected.Type() || actual.Inspect() != expected.Inspect() {
+			t.Errorf("Expected constant %s, got %s", expected.Inspect(), actual.Inspect())
+		}
+	}
+
+	var buffer bytes.Buffer
+	bytecode := &Bytecode{Constants: []object.Object{&object.Closure{Fn: &object.CompiledFunction{}}}}
+	if err := bytecode.Encode(&buffer, true); err == nil {
+		t.Errorf("Expected closures not to be serializable")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := compileForEncoding(t, `let f = fn(x) { x * 2 }; f(3)`).Encode(&buffer, true); err != nil {
+		t.Fatalf("Unable to encode: %s", err)
+	}
+	valid := buffer.Bytes()
+
+	header := func(formatVersion, opcodeVersion int) []byte {
+		e := &encoder{}
+		e.buffer.WriteString(MAGIC)
+		e.uint(uint64(formatVersion))
+		e.uint(uint64(opcodeVersion))
+		return e.buffer.Bytes()
+	}
+
+	tests := []struct {
+		content       []byte
+		expectedError string
+	}{
+		{[]byte("let a = 1;"), "Not a bytecode file"},
+		{header(FORMAT_VERSION+1, code.VERSION), fmt.Sprintf("Unsupported bytecode format version %d, expected %d", FORMAT_VERSION+1, FORMAT_VERSION)},
+		{header(FORMAT_VERSION, code.VERSION+1), fmt.Sprintf("Bytecode was compiled for opcode version %d, expected %d", code.VERSION+1, code.VERSION)},
+		{valid[:len(valid)-3], "Invalid bytecode file"},
+		{append(append([]byte{}, valid...), 0), "Invalid bytecode file: 1 unexpected bytes at the end"},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.content))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.expectedError) {
+			t.Errorf("Expected error %q, got %v", tt.expectedError, err)
+		}
+	}
+}
@@ -44,6 +44,18 @@ func (st *SymbolTable) Define(name string) Symbol {
 	return st.store[name]
 }
 
+// Names returns the name of each symbol defined in the table, by index. The
+// slots of names that were defined again are left empty.
+func (st *SymbolTable) Names() []string {
+	names := make([]string, st.NumDefinitions)
+	for name, sym := range st.store {
+		if sym.Scope == st.scope() {
+			names[sym.Index] = name
+		}
+	}
+	return names
+}
+
 func (st *SymbolTable) DefineBuiltin(index int, name string) {
 	st.store[name] = Symbol{Name: name, Scope: BuiltinScope, Index: index}
 }
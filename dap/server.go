@@ -0,0 +1,442 @@
+// Package dap implements a Debug Adapter Protocol server for Monkey, so that
+// editors can debug programs running in the VM. The adapter speaks the
+// protocol over any reader and writer, usually the standard input and output
+// of the `monkey dap` command.
+//
+// Programs run in the same goroutine that serves requests, so they can only
+// be stopped at breakpoints or after a step, never paused while running.
+package dap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/checker"
+	"github.com/javier-varez/monkey_interpreter/compiler"
+	"github.com/javier-varez/monkey_interpreter/lexer"
+	"github.com/javier-varez/monkey_interpreter/object"
+	"github.com/javier-varez/monkey_interpreter/parser"
+	"github.com/javier-varez/monkey_interpreter/resolver"
+	"github.com/javier-varez/monkey_interpreter/token"
+	"github.com/javier-varez/monkey_interpreter/vm"
+)
+
+// THREAD_ID is the only thread of the programs.
+const THREAD_ID = 1
+
+// GLOBALS_REFERENCE is the variables reference of the globals. The locals and
+// free variables of each frame come after it.
+const GLOBALS_REFERENCE = 1
+
+type handler func(s *Server, args json.RawMessage) (interface{}, error)
+
+var handlers = map[string]handler{
+	"initialize":        (*Server).initialize,
+	"launch":            (*Server).launch,
+	"setBreakpoints":    (*Server).setBreakpoints,
+	"configurationDone": (*Server).configurationDone,
+	"threads":           (*Server).threads,
+	"stackTrace":        (*Server).stackTrace,
+	"scopes":            (*Server).scopes,
+	"variables":         (*Server).variables,
+	"continue":          resume((*vm.Debugger).Continue),
+	"next":              resume((*vm.Debugger).StepOver),
+	"stepIn":            resume((*vm.Debugger).StepIn),
+	"stepOut":           resume((*vm.Debugger).StepOut),
+	"evaluate":          (*Server).evaluate,
+}
+
+type Server struct {
+	conn *conn
+	seq  int
+
+	linesStartAt1   bool
+	columnsStartAt1 bool
+
+	program     string
+	debugger    *vm.Debugger
+	stopOnEntry bool
+	configured  bool
+	running     bool
+
+	// afterResponse runs once the response to the current request is sent,
+	// for the events that must follow it
+	afterResponse func() error
+}
+
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{conn: newConn(r, w), linesStartAt1: true, columnsStartAt1: true}
+}
+
+// Run serves requests until the client disconnects or closes the connection.
+// The output of the program is forwarded to the client meanwhile.
+func (s *Server) Run() error {
+	previousOutput := object.Output
+	object.Output = &outputWriter{server: s}
+	defer func() { object.Output = previousOutput }()
+
+	for {
+		content, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			return fmt.Errorf("Invalid request: %w", err)
+		}
+
+		if req.Command == "disconnect" {
+			return s.reply(&req, nil, nil)
+		}
+
+		if err := s.handle(&req); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(req *request) error {
+	h, ok := handlers[req.Command]
+	if !ok {
+		return s.reply(req, nil, fmt.Errorf("Unknown command %s", req.Command))
+	}
+
+	s.afterResponse = nil
+	body, err := h(s, req.Arguments)
+	if err := s.reply(req, body, err); err != nil {
+		return err
+	}
+
+	if err == nil && s.afterResponse != nil {
+		return s.afterResponse()
+	}
+	return nil
+}
+
+func (s *Server) reply(req *request, body interface{}, err error) error {
+	s.seq++
+	resp := &response{Seq: s.seq, Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+	return s.conn.write(resp)
+}
+
+func (s *Server) sendEvent(name string, body interface{}) error {
+	s.seq++
+	return s.conn.write(&event{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
+
+// outputWriter forwards the output of the program as output events.
+type outputWriter struct {
+	server *Server
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	if err := w.server.sendEvent("output", &OutputEventBody{Category: "stdout", Output: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *Server) initialize(args json.RawMessage) (interface{}, error) {
+	var a InitializeArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	if a.LinesStartAt1 != nil {
+		s.linesStartAt1 = *a.LinesStartAt1
+	}
+	if a.ColumnsStartAt1 != nil {
+		s.columnsStartAt1 = *a.ColumnsStartAt1
+	}
+
+	return &Capabilities{
+		SupportsConfigurationDoneRequest: true,
+		SupportsEvaluateForHovers:        true,
+	}, nil
+}
+
+// launch compiles the program, which starts running once the client is done
+// setting breakpoints.
+func (s *Server) launch(args json.RawMessage) (interface{}, error) {
+	var a LaunchArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	if s.debugger != nil {
+		return nil, fmt.Errorf("A program has already been launched")
+	}
+
+	bytecode, err := compileFile(a.Program)
+	if err != nil {
+		return nil, err
+	}
+
+	s.program = a.Program
+	s.debugger = vm.NewDebugger(bytecode)
+	s.stopOnEntry = a.StopOnEntry
+
+	s.afterResponse = func() error {
+		if err := s.sendEvent("initialized", nil); err != nil {
+			return err
+		}
+		if s.configured {
+			return s.start()
+		}
+		return nil
+	}
+	return nil, nil
+}
+
+// compileFile runs the same passes as the run command over a file, returning
+// the diagnostics as a single error.
+func compileFile(path string) (*compiler.Bytecode, error) {
+	txt, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	program := parser.New(lexer.New(string(txt))).ParseProgram()
+	if len(program.Diagnostics) == 0 {
+		program.Diagnostics = resolver.Resolve(program)
+	}
+	if len(program.Diagnostics) == 0 {
+		program.Diagnostics = checker.Check(program)
+	}
+	if len(program.Diagnostics) != 0 {
+		return nil, diagnosticsError(program.Diagnostics)
+	}
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		return nil, err
+	}
+	return c.Bytecode(), nil
+}
+
+func diagnosticsError(diagnostics []ast.Error) error {
+	messages := []string{}
+	for _, diag := range diagnostics {
+		messages = append(messages, diag.ContextualError())
+	}
+	return fmt.Errorf("%s", strings.Join(messages, "\n"))
+}
+
+func (s *Server) configurationDone(args json.RawMessage) (interface{}, error) {
+	s.configured = true
+	if s.debugger != nil {
+		s.afterResponse = s.start
+	}
+	return nil, nil
+}
+
+func (s *Server) start() error {
+	s.running = true
+	if s.stopOnEntry {
+		// The first statement starts at the first instruction
+		reason, err := s.debugger.StepIn()
+		if reason == vm.StopStep {
+			reason = "entry"
+		}
+		return s.stopped(reason, err)
+	}
+	return s.stopped(s.debugger.Continue())
+}
+
+// resume returns a handler running the program with the given debugger
+// method, which reports where the program stopped once the response is sent.
+func resume(run func(*vm.Debugger) (vm.StopReason, error)) handler {
+	return func(s *Server, args json.RawMessage) (interface{}, error) {
+		if !s.running {
+			return nil, fmt.Errorf("The program is not running")
+		}
+		s.afterResponse = func() error {
+			return s.stopped(run(s.debugger))
+		}
+		return &ContinueResponseBody{AllThreadsContinued: true}, nil
+	}
+}
+
+// stopped sends the events for a program that stopped for the given reason
+// or failed with an error.
+func (s *Server) stopped(reason vm.StopReason, err error) error {
+	if err == nil && reason != vm.StopExited {
+		return s.sendEvent("stopped", &StoppedEventBody{Reason: string(reason), ThreadID: THREAD_ID, AllThreadsStopped: true})
+	}
+
+	s.running = false
+	exitCode := 0
+	if err != nil {
+		exitCode = 1
+		msg := err.Error()
+		if astErr, ok := err.(ast.Error); ok {
+			msg = astErr.ContextualError()
+		}
+		if tracer, ok := err.(object.StackTracer); ok {
+			msg += object.FormatStackTrace(tracer.StackTrace())
+		}
+		if err := s.sendEvent("output", &OutputEventBody{Category: "stderr", Output: msg + "\n"}); err != nil {
+			return err
+		}
+	}
+	if err := s.sendEvent("exited", &ExitedEventBody{ExitCode: exitCode}); err != nil {
+		return err
+	}
+	return s.sendEvent("terminated", nil)
+}
+
+func (s *Server) setBreakpoints(args json.RawMessage) (interface{}, error) {
+	var a SetBreakpointsArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	if s.debugger == nil {
+		return nil, fmt.Errorf("No program has been launched")
+	}
+
+	lines := []int{}
+	for _, bp := range a.Breakpoints {
+		lines = append(lines, bp.Line-s.lineBase())
+	}
+
+	result := []Breakpoint{}
+	for i, verified := range s.debugger.SetBreakpoints(lines) {
+		result = append(result, Breakpoint{Verified: verified, Line: a.Breakpoints[i].Line})
+	}
+	return &SetBreakpointsResponseBody{Breakpoints: result}, nil
+}
+
+func (s *Server) threads(args json.RawMessage) (interface{}, error) {
+	return &ThreadsResponseBody{Threads: []Thread{{ID: THREAD_ID, Name: "main"}}}, nil
+}
+
+func (s *Server) stackTrace(args json.RawMessage) (interface{}, error) {
+	if !s.running {
+		return nil, fmt.Errorf("The program is not running")
+	}
+
+	source := Source{Name: filepath.Base(s.program), Path: s.program}
+	frames := []StackFrame{}
+	for i, frame := range s.debugger.StackFrames() {
+		start, end := s.toPosition(frame.Span.Start), s.toPosition(frame.Span.End)
+		frames = append(frames, StackFrame{
+			ID:        i,
+			Name:      frame.Name,
+			Source:    source,
+			Line:      start.Line,
+			Column:    start.Column,
+			EndLine:   end.Line,
+			EndColumn: end.Column,
+		})
+	}
+	return &StackTraceResponseBody{StackFrames: frames, TotalFrames: len(frames)}, nil
+}
+
+func (s *Server) lineBase() int {
+	if s.linesStartAt1 {
+		return 1
+	}
+	return 0
+}
+
+func (s *Server) toPosition(loc token.Location) token.Location {
+	column := loc.Column
+	if s.columnsStartAt1 {
+		column++
+	}
+	return token.Location{Line: loc.Line + s.lineBase(), Column: column}
+}
+
+// Each frame has two variables references after the globals, one for its
+// locals and one for its free variables.
+func localsReference(frame int) int {
+	return GLOBALS_REFERENCE + 1 + 2*frame
+}
+
+func freeReference(frame int) int {
+	return localsReference(frame) + 1
+}
+
+func (s *Server) scopes(args json.RawMessage) (interface{}, error) {
+	var a FrameArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	if !s.running {
+		return nil, fmt.Errorf("The program is not running")
+	}
+
+	return &ScopesResponseBody{Scopes: []Scope{
+		{Name: "Locals", VariablesReference: localsReference(a.FrameID)},
+		{Name: "Free variables", VariablesReference: freeReference(a.FrameID)},
+		{Name: "Globals", VariablesReference: GLOBALS_REFERENCE},
+	}}, nil
+}
+
+func (s *Server) variables(args json.RawMessage) (interface{}, error) {
+	var a VariablesArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	if !s.running {
+		return nil, fmt.Errorf("The program is not running")
+	}
+
+	var variables []vm.Variable
+	if a.VariablesReference == GLOBALS_REFERENCE {
+		variables = s.debugger.Globals()
+	} else {
+		frames := s.debugger.StackFrames()
+		frame := (a.VariablesReference - GLOBALS_REFERENCE - 1) / 2
+		if frame < 0 || frame >= len(frames) {
+			return nil, fmt.Errorf("Invalid variables reference %d", a.VariablesReference)
+		}
+
+		if a.VariablesReference == localsReference(frame) {
+			variables = frames[frame].Locals
+		} else {
+			variables = frames[frame].Free
+		}
+	}
+
+	result := []Variable{}
+	for _, variable := range variables {
+		result = append(result, Variable{
+			Name:  variable.Name,
+			Value: variable.Value.Inspect(),
+			Type:  string(variable.Value.Type()),
+		})
+	}
+	return &VariablesResponseBody{Variables: result}, nil
+}
+
+func (s *Server) evaluate(args json.RawMessage) (interface{}, error) {
+	var a EvaluateArguments
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	if !s.running {
+		return nil, fmt.Errorf("The program is not running")
+	}
+
+	program := parser.New(lexer.New(a.Expression)).ParseProgram()
+	if len(program.Diagnostics) != 0 {
+		return nil, diagnosticsError(program.Diagnostics)
+	}
+
+	result, err := s.debugger.Evaluate(a.FrameID, program)
+	if err != nil {
+		return nil, err
+	}
+	return &EvaluateResponseBody{Result: result.Inspect(), Type: string(result.Type())}, nil
+}
@@ -0,0 +1,354 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testClient talks to a server running in the same process, through pipes.
+type testClient struct {
+	t      *testing.T
+	conn   *conn
+	seq    int
+	events []incoming
+	done   chan error
+}
+
+type incoming struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Command    string          `json:"command"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func newTestClient(t *testing.T) *testClient {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	client := &testClient{
+		t:    t,
+		conn: &conn{reader: textproto.NewReader(bufio.NewReader(clientReader)), writer: clientWriter},
+		done: make(chan error),
+	}
+
+	go func() {
+		err := NewServer(serverReader, serverWriter).Run()
+		serverWriter.Close()
+		client.done <- err
+	}()
+
+	t.Cleanup(func() {
+		clientWriter.Close()
+		if err := <-client.done; err != nil {
+			t.Errorf("Server failed: %v", err)
+		}
+	})
+
+	if resp := client.call("initialize", map[string]interface{}{"clientID": "test"}, nil); !resp.Success {
+		t.Fatalf("Unable to initialize: %s", resp.Message)
+	}
+	return client
+}
+
+// receive reads the next message from the server.
+func (c *testClient) receive() *incoming {
+	content, err := c.conn.read()
+	if err != nil {
+		c.t.Fatalf("Unable to read message: %v", err)
+	}
+
+	var msg incoming
+	if err := json.Unmarshal(content, &msg); err != nil {
+		c.t.Fatalf("Invalid message %s: %v", content, err)
+	}
+	return &msg
+}
+
+// call sends a request and decodes the body of its response into body.
+// Events received meanwhile are kept.
+func (c *testClient) call(command string, args interface{}, body interface{}) *incoming {
+	c.seq++
+	req := map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args}
+	if err := c.conn.write(req); err != nil {
+		c.t.Fatalf("Unable to send request: %v", err)
+	}
+
+	for {
+		msg := c.receive()
+		if msg.Type == "event" {
+			c.events = append(c.events, *msg)
+			continue
+		}
+
+		if msg.RequestSeq != c.seq || msg.Command != command {
+			c.t.Fatalf("Unexpected response to %s (%d): %+v", command, c.seq, msg)
+		}
+		if msg.Success && body != nil {
+			if err := json.Unmarshal(msg.Body, body); err != nil {
+				c.t.Fatalf("Invalid body %s: %v", msg.Body, err)
+			}
+		}
+		return msg
+	}
+}
+
+// waitEvent returns the next event with the given name. Other events are
+// kept for later.
+func (c *testClient) waitEvent(name string, body interface{}) {
+	var msg *incoming
+	for i := range c.events {
+		if c.events[i].Event == name {
+			msg = &c.events[i]
+			c.events = append(c.events[:i:i], c.events[i+1:]...)
+			break
+		}
+	}
+
+	for msg == nil {
+		received := c.receive()
+		if received.Type != "event" {
+			c.t.Fatalf("Unexpected message while waiting for %s: %+v", name, received)
+		}
+		if received.Event == name {
+			msg = received
+		} else {
+			c.events = append(c.events, *received)
+		}
+	}
+
+	if body != nil {
+		if err := json.Unmarshal(msg.Body, body); err != nil {
+			c.t.Fatalf("Invalid event body %s: %v", msg.Body, err)
+		}
+	}
+}
+
+// stopped waits for the program to stop and returns the reason and the line
+// of the innermost frame.
+func (c *testClient) stopped() (string, int) {
+	var event StoppedEventBody
+	c.waitEvent("stopped", &event)
+
+	var trace StackTraceResponseBody
+	if resp := c.call("stackTrace", map[string]interface{}{"threadId": THREAD_ID}, &trace); !resp.Success {
+		c.t.Fatalf("Unable to get the stack trace: %s", resp.Message)
+	}
+	return event.Reason, trace.StackFrames[0].Line
+}
+
+func (c *testClient) variables(reference int) map[string]string {
+	var body VariablesResponseBody
+	if resp := c.call("variables", &VariablesArguments{VariablesReference: reference}, &body); !resp.Success {
+		c.t.Fatalf("Unable to get variables: %s", resp.Message)
+	}
+
+	result := map[string]string{}
+	for _, v := range body.Variables {
+		result[v.Name] = v.Value
+	}
+	return result
+}
+
+func writeProgram(t *testing.T, text string) string {
+	path := filepath.Join(t.TempDir(), "test.monkey")
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatalf("Unable to write program: %v", err)
+	}
+	return path
+}
+
+// launch starts debugging a program with breakpoints on the given lines.
+func (c *testClient) launch(text string, stopOnEntry bool, lines ...int) []Breakpoint {
+	path := writeProgram(c.t, text)
+	if resp := c.call("launch", &LaunchArguments{Program: path, StopOnEntry: stopOnEntry}, nil); !resp.Success {
+		c.t.Fatalf("Unable to launch: %s", resp.Message)
+	}
+	c.waitEvent("initialized", nil)
+
+	breakpoints := []SourceBreakpoint{}
+	for _, line := range lines {
+		breakpoints = append(breakpoints, SourceBreakpoint{Line: line})
+	}
+	var body SetBreakpointsResponseBody
+	args := &SetBreakpointsArguments{Source: Source{Path: path}, Breakpoints: breakpoints}
+	if resp := c.call("setBreakpoints", args, &body); !resp.Success {
+		c.t.Fatalf("Unable to set breakpoints: %s", resp.Message)
+	}
+
+	if resp := c.call("configurationDone", nil, nil); !resp.Success {
+		c.t.Fatalf("Configuration failed: %s", resp.Message)
+	}
+	return body.Breakpoints
+}
+
+const testProgram = `let add = fn(a, b) {
+	let sum = a + b;
+	sum
+};
+let x = add(1, 2);
+puts(x);
+let adder = fn(a) { fn(b) { a + b } };
+adder(x)(4);
+`
+
+func TestStepping(t *testing.T) {
+	c := newTestClient(t)
+	c.launch(testProgram, true)
+
+	tests := []struct {
+		command        string
+		expectedReason string
+		expectedLine   int
+	}{
+		{"", "entry", 1},
+		{"next", "step", 5},
+		{"stepIn", "step", 2},
+		{"next", "step", 3},
+		{"stepOut", "step", 5},
+		{"next", "step", 6},
+		{"next", "step", 7},
+	}
+
+	for _, tt := range tests {
+		if tt.command != "" {
+			var body ContinueResponseBody
+			if resp := c.call(tt.command, map[string]interface{}{"threadId": THREAD_ID}, &body); !resp.Success {
+				t.Fatalf("%s failed: %s", tt.command, resp.Message)
+			}
+		}
+
+		reason, line := c.stopped()
+		if reason != tt.expectedReason || line != tt.expectedLine {
+			t.Fatalf("After %q expected to stop at line %d (%s), got line %d (%s)", tt.command, tt.expectedLine, tt.expectedReason, line, reason)
+		}
+	}
+
+	// The output of the program is forwarded
+	var output OutputEventBody
+	c.waitEvent("output", &output)
+	if output.Category != "stdout" || output.Output != "3" {
+		t.Errorf("Unexpected output: %+v", output)
+	}
+
+	c.call("continue", map[string]interface{}{"threadId": THREAD_ID}, nil)
+	var exited ExitedEventBody
+	c.waitEvent("exited", &exited)
+	if exited.ExitCode != 0 {
+		t.Errorf("Unexpected exit code %d", exited.ExitCode)
+	}
+	c.waitEvent("terminated", nil)
+
+	if resp := c.call("next", map[string]interface{}{"threadId": THREAD_ID}, nil); resp.Success {
+		t.Errorf("Expected stepping a terminated program to fail")
+	}
+	c.call("disconnect", nil, nil)
+}
+
+func TestBreakpointsAndVariables(t *testing.T) {
+	c := newTestClient(t)
+	breakpoints := c.launch(testProgram, false, 3, 4, 7)
+
+	expected := []Breakpoint{{Verified: true, Line: 3}, {Verified: false, Line: 4}, {Verified: true, Line: 7}}
+	if len(breakpoints) != len(expected) {
+		t.Fatalf("Unexpected breakpoints %+v", breakpoints)
+	}
+	for i := range expected {
+		if breakpoints[i] != expected[i] {
+			t.Errorf("Unexpected breakpoint. Expected %+v, got %+v", expected[i], breakpoints[i])
+		}
+	}
+
+	reason, line := c.stopped()
+	if reason != "breakpoint" || line != 3 {
+		t.Fatalf("Expected to stop at the breakpoint on line 3, got line %d (%s)", line, reason)
+	}
+
+	var scopes ScopesResponseBody
+	c.call("scopes", &FrameArguments{FrameID: 0}, &scopes)
+	if len(scopes.Scopes) != 3 {
+		t.Fatalf("Unexpected scopes %+v", scopes)
+	}
+
+	tests := []struct {
+		reference int
+		expected  map[string]string
+	}{
+		{scopes.Scopes[0].VariablesReference, map[string]string{"a": "1", "b": "2", "sum": "3"}},
+		{scopes.Scopes[1].VariablesReference, map[string]string{}},
+		{scopes.Scopes[2].VariablesReference, map[string]string{"add": "Closure"}},
+	}
+	for _, tt := range tests {
+		vars := c.variables(tt.reference)
+		if len(vars) != len(tt.expected) {
+			t.Errorf("Unexpected variables. Expected %v, got %v", tt.expected, vars)
+			continue
+		}
+		for name, value := range tt.expected {
+			actual, ok := vars[name]
+			if !ok || (value != "Closure" && actual != value) {
+				t.Errorf("Unexpected variables. Expected %v, got %v", tt.expected, vars)
+			}
+		}
+	}
+
+	var eval EvaluateResponseBody
+	if resp := c.call("evaluate", &EvaluateArguments{Expression: "sum * 10", FrameID: 0, Context: "watch"}, &eval); !resp.Success {
+		t.Fatalf("Unable to evaluate: %s", resp.Message)
+	}
+	if eval.Result != "30" || eval.Type != "INTEGER" {
+		t.Errorf("Unexpected evaluation %+v", eval)
+	}
+	if resp := c.call("evaluate", &EvaluateArguments{Expression: "missing", FrameID: 0}, nil); resp.Success || resp.Message != "Unknown identifier missing" {
+		t.Errorf("Expected an evaluation error, got %+v", resp)
+	}
+
+	// Line 7 has statements in the main program, adder and the inner closure
+	for i := 0; i < 3; i++ {
+		c.call("continue", map[string]interface{}{"threadId": THREAD_ID}, nil)
+		reason, line = c.stopped()
+		if reason != "breakpoint" || line != 7 {
+			t.Fatalf("Expected to stop at the breakpoint on line 7, got line %d (%s)", line, reason)
+		}
+	}
+	c.call("scopes", &FrameArguments{FrameID: 0}, &scopes)
+	if vars := c.variables(scopes.Scopes[1].VariablesReference); len(vars) != 1 || vars["a"] != "3" {
+		t.Errorf("Unexpected free variables %v", vars)
+	}
+
+	c.call("continue", map[string]interface{}{"threadId": THREAD_ID}, nil)
+	c.waitEvent("terminated", nil)
+}
+
+func TestLaunchErrors(t *testing.T) {
+	c := newTestClient(t)
+
+	if resp := c.call("launch", &LaunchArguments{Program: writeProgram(t, "puts(y)")}, nil); resp.Success {
+		t.Errorf("Expected launching an invalid program to fail")
+	}
+	if resp := c.call("unknown", nil, nil); resp.Success || resp.Message != "Unknown command unknown" {
+		t.Errorf("Expected an unknown command error, got %+v", resp)
+	}
+
+	// Runtime errors terminate the program
+	c.launch("let a = 0;\n1 / a;\n", false)
+	var output OutputEventBody
+	c.waitEvent("output", &output)
+	if output.Category != "stderr" {
+		t.Errorf("Expected the error in stderr, got %+v", output)
+	}
+	var exited ExitedEventBody
+	c.waitEvent("exited", &exited)
+	if exited.ExitCode != 1 {
+		t.Errorf("Unexpected exit code %d", exited.ExitCode)
+	}
+	c.waitEvent("terminated", nil)
+}
@@ -24,102 +24,76 @@ func init() {
 }
 
 func evalAdd(leftObject, rightObject object.Object) object.Object {
-	if leftObject.Type() == object.INTEGER_OBJ && rightObject.Type() == object.INTEGER_OBJ {
-		left := leftObject.(*object.Integer)
-		right := rightObject.(*object.Integer)
-		return &object.Integer{Value: left.Value + right.Value}
-	} else if leftObject.Type() == object.STRING_OBJ && rightObject.Type() == object.STRING_OBJ {
-		left := leftObject.(*object.String)
-		right := rightObject.(*object.String)
-		return &object.String{Value: left.Value + right.Value}
+	if object.IsInteger(leftObject) && object.IsInteger(rightObject) {
+		return object.AddIntegers(leftObject, rightObject)
+	} else if result, ok := object.Concat(leftObject, rightObject); ok {
+		return result
 	}
 
 	panic("Invalid types for call to evalAdd")
 }
 
 func evalSub(leftObject, rightObject object.Object) object.Object {
-	left := leftObject.(*object.Integer)
-	right := rightObject.(*object.Integer)
-
-	return &object.Integer{
-		Value: left.Value - right.Value,
-	}
+	return object.SubIntegers(leftObject, rightObject)
 }
 
 func evalMul(leftObject, rightObject object.Object) object.Object {
-	left := leftObject.(*object.Integer)
-	right := rightObject.(*object.Integer)
-
-	return &object.Integer{
-		Value: left.Value * right.Value,
-	}
+	return object.MulIntegers(leftObject, rightObject)
 }
 
-func evalDiv(leftObject, rightObject object.Object) object.Object {
-	left := leftObject.(*object.Integer)
-	right := rightObject.(*object.Integer)
-
-	return &object.Integer{
-		Value: left.Value / right.Value,
+func evalDiv(expr *ast.InfixExpr, leftObject, rightObject object.Object) object.Object {
+	result, err := object.DivIntegers(leftObject, rightObject)
+	if err != nil {
+		return mkError(expr.Span(), err.Error())
 	}
+	return result
 }
 
 func evalEq(leftObject, rightObject object.Object) object.Object {
-	result := false
-	if leftObject.Type() == object.INTEGER_OBJ && rightObject.Type() == object.INTEGER_OBJ {
-		left := leftObject.(*object.Integer)
-		right := rightObject.(*object.Integer)
-		result = left.Value == right.Value
-	} else if leftObject.Type() == object.BOOLEAN_OBJ && rightObject.Type() == object.BOOLEAN_OBJ {
-		left := leftObject.(*object.Boolean)
-		right := rightObject.(*object.Boolean)
-		result = left.Value == right.Value
-	} else if leftObject.Type() == object.STRING_OBJ && rightObject.Type() == object.STRING_OBJ {
-		left := leftObject.(*object.String)
-		right := rightObject.(*object.String)
-		result = left.Value == right.Value
-	} else {
-		panic("Unsupported operands.")
-	}
-
-	return &object.Boolean{Value: result}
+	return object.NativeBool(object.Equals(leftObject, rightObject))
 }
 
 func evalNeq(leftObject, rightObject object.Object) object.Object {
-	result := false
-	if leftObject.Type() == object.INTEGER_OBJ && rightObject.Type() == object.INTEGER_OBJ {
-		left := leftObject.(*object.Integer)
-		right := rightObject.(*object.Integer)
-		result = left.Value != right.Value
-	} else if leftObject.Type() == object.BOOLEAN_OBJ && rightObject.Type() == object.BOOLEAN_OBJ {
-		left := leftObject.(*object.Boolean)
-		right := rightObject.(*object.Boolean)
-		result = left.Value != right.Value
-	} else if leftObject.Type() == object.STRING_OBJ && rightObject.Type() == object.STRING_OBJ {
-		left := leftObject.(*object.String)
-		right := rightObject.(*object.String)
-		result = left.Value != right.Value
-	} else {
-		panic("Unsupported operands.")
-	}
-
-	return &object.Boolean{Value: result}
+	return object.NativeBool(!object.Equals(leftObject, rightObject))
 }
 
 func evalLess(leftObject, rightObject object.Object) object.Object {
-	left := leftObject.(*object.Integer)
-	right := rightObject.(*object.Integer)
-	result := left.Value < right.Value
-
-	return &object.Boolean{Value: result}
+	if leftObject.Type() == object.CHAR_OBJ {
+		result := leftObject.(*object.Char).Value < rightObject.(*object.Char).Value
+		return object.NativeBool(result)
+	}
+	result := object.CompareIntegers(leftObject, rightObject) < 0
+	return object.NativeBool(result)
 }
 
 func evalGreater(leftObject, rightObject object.Object) object.Object {
-	left := leftObject.(*object.Integer)
-	right := rightObject.(*object.Integer)
-	result := left.Value > right.Value
+	if leftObject.Type() == object.CHAR_OBJ {
+		result := leftObject.(*object.Char).Value > rightObject.(*object.Char).Value
+		return object.NativeBool(result)
+	}
+	result := object.CompareIntegers(leftObject, rightObject) > 0
+	return object.NativeBool(result)
+}
 
-	return &object.Boolean{Value: result}
+// haveSameType is true when both objects have the same type, considering
+// Integer and BigInt objects to be of the same type.
+func haveSameType(leftObject, rightObject object.Object) bool {
+	if object.IsInteger(leftObject) && object.IsInteger(rightObject) {
+		return true
+	}
+	return leftObject.Type() == rightObject.Type()
+}
+
+func evalSetInfixExpr(expr *ast.InfixExpr, left, right *object.Set) object.Object {
+	switch expr.OperatorToken.Type {
+	case token.PIPE:
+		return left.Union(right)
+	case token.AMPERSAND:
+		return left.Intersect(right)
+	case token.MINUS:
+		return left.Difference(right)
+	}
+	return mkError(expr.Span(), fmt.Sprintf("Operator %q is not supported on sets", expr.OperatorToken.Literal))
 }
 
 func evalInfixExpr(expr *ast.InfixExpr, env *object.Environment) object.Object {
@@ -133,49 +107,53 @@ func evalInfixExpr(expr *ast.InfixExpr, env *object.Environment) object.Object {
 		return right
 	}
 
+	isEqualityOp := expr.OperatorToken.Type == token.EQ || expr.OperatorToken.Type == token.NOT_EQ
+	if left.Type() == object.SET_OBJ && right.Type() == object.SET_OBJ && !isEqualityOp {
+		return evalSetInfixExpr(expr, left.(*object.Set), right.(*object.Set))
+	}
+
 	switch expr.OperatorToken.Type {
+	case token.PIPE:
+		fallthrough
+	case token.AMPERSAND:
+		if left.Type() != object.SET_OBJ {
+			return mkError(expr.LeftExpr.Span(), "Expression does not evaluate to a set object")
+		}
+		return mkError(expr.RightExpr.Span(), "Expression does not evaluate to a set object")
 	case token.PLUS:
-		if left.Type() != object.INTEGER_OBJ && left.Type() != object.STRING_OBJ {
+		if !object.IsInteger(left) && left.Type() != object.STRING_OBJ && left.Type() != object.CHAR_OBJ {
 			return mkError(expr.LeftExpr.Span(), "Expression does not evaluate to an integer or string object")
 		}
 
-		if right.Type() != object.INTEGER_OBJ && right.Type() != object.STRING_OBJ {
+		if !object.IsInteger(right) && right.Type() != object.STRING_OBJ && right.Type() != object.CHAR_OBJ {
 			return mkError(expr.RightExpr.Span(), "Expression does not evaluate to an integer or string object")
 		}
 
-		if right.Type() != left.Type() {
+		if _, ok := object.Concat(left, right); !ok && !(object.IsInteger(left) && object.IsInteger(right)) {
 			return mkError(expr.Span(), "Left and right arguments to the infix operator do not have the same type")
 		}
+	case token.LT:
+		fallthrough
+	case token.GT:
+		if left.Type() == object.CHAR_OBJ && right.Type() == object.CHAR_OBJ {
+			break
+		}
+		fallthrough
 	case token.MINUS:
 		fallthrough
 	case token.ASTERISK:
 		fallthrough
 	case token.SLASH:
-		fallthrough
-	case token.LT:
-		fallthrough
-	case token.GT:
-		if left.Type() != object.INTEGER_OBJ {
+		if !object.IsInteger(left) {
 			return mkError(expr.LeftExpr.Span(), "Expression does not evaluate to an integer object")
 		}
 
-		if right.Type() != object.INTEGER_OBJ {
+		if !object.IsInteger(right) {
 			return mkError(expr.RightExpr.Span(), "Expression does not evaluate to an integer object")
 		}
-	case token.EQ:
-		fallthrough
-	case token.NOT_EQ:
-		if left.Type() != object.INTEGER_OBJ && left.Type() != object.BOOLEAN_OBJ && left.Type() != object.STRING_OBJ {
-			return mkError(expr.LeftExpr.Span(), "Expression does not evaluate to an integer, boolean or string object")
-		}
-
-		if right.Type() != object.INTEGER_OBJ && right.Type() != object.BOOLEAN_OBJ && right.Type() != object.STRING_OBJ {
-			return mkError(expr.RightExpr.Span(), "Expression does not evaluate to an integer, boolean or string object")
-		}
-
-		if right.Type() != left.Type() {
-			return mkError(expr.Span(), "Left and right arguments to the infix operator do not have the same type")
-		}
+	case token.EQ, token.NOT_EQ:
+		// Any values can be compared, and values of different types are never
+		// equal, like in the VM
 	default:
 		log.Fatalf("Unsupported infix operator: %v", expr.OperatorToken)
 	}
@@ -188,7 +166,7 @@ func evalInfixExpr(expr *ast.InfixExpr, env *object.Environment) object.Object {
 	case token.ASTERISK:
 		return evalMul(left, right)
 	case token.SLASH:
-		return evalDiv(left, right)
+		return evalDiv(expr, left, right)
 	case token.EQ:
 		return evalEq(left, right)
 	case token.NOT_EQ:
@@ -206,12 +184,11 @@ func evalInfixExpr(expr *ast.InfixExpr, env *object.Environment) object.Object {
 
 func evalBang(obj object.Object) object.Object {
 	boolObj := obj.(*object.Boolean)
-	return &object.Boolean{Value: !boolObj.Value}
+	return object.NativeBool(!boolObj.Value)
 }
 
 func evalMinus(obj object.Object) object.Object {
-	intObj := obj.(*object.Integer)
-	return &object.Integer{Value: -intObj.Value}
+	return object.NegateInteger(obj)
 }
 
 func evalPrefixExpr(expr *ast.PrefixExpr, env *object.Environment) object.Object {
@@ -227,7 +204,7 @@ func evalPrefixExpr(expr *ast.PrefixExpr, env *object.Environment) object.Object
 		}
 		return evalBang(innerResult)
 	case token.MINUS:
-		if innerResult.Type() != object.INTEGER_OBJ {
+		if !object.IsInteger(innerResult) {
 			return mkError(expr.Span(), fmt.Sprintf("%q requires an integer argument", token.MINUS))
 		}
 		return evalMinus(innerResult)
@@ -257,7 +234,7 @@ func evalIfExpr(expr *ast.IfExpr, env *object.Environment) object.Object {
 		return Eval(expr.Alternative, env)
 	}
 
-	return &object.Null{}
+	return object.NULL
 }
 
 func evalBlockStatement(stmt *ast.BlockStatement, env *object.Environment) object.Object {
@@ -295,7 +272,7 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 }
 
 func evalReturnStatement(stmt *ast.ReturnStatement, env *object.Environment) object.Object {
-	var result object.Object = &object.Null{}
+	var result object.Object = object.NULL
 	if stmt.Expr != nil {
 		result = Eval(stmt.Expr, env)
 		if result.Type() == object.ERROR_VALUE_OBJ {
@@ -312,6 +289,13 @@ func evalLetStatement(stmt *ast.LetStatement, env *object.Environment) object.Ob
 		return obj
 	}
 
+	// Name the functions after their binding for stack traces
+	if fn, ok := obj.(*object.Function); ok && fn.Name == "" {
+		if _, ok := stmt.Expr.(*ast.FnLiteralExpr); ok {
+			fn.Name = stmt.IdentExpr.(*ast.IdentifierExpr).IdentToken.Literal
+		}
+	}
+
 	return env.Set(stmt.IdentExpr.(*ast.IdentifierExpr).IdentToken.Literal, obj)
 }
 
@@ -350,18 +334,17 @@ func evalCallBuiltin(builtin *object.Builtin, expr *ast.CallExpr, env *object.En
 
 	res := builtin.Function(expr.Span(), args...)
 	if res == nil {
-		res = &object.Null{}
+		res = object.NULL
 	}
 	return res
 }
 
-func evalCallFnObject(fnObj *object.Function, expr *ast.CallExpr, env *object.Environment) object.Object {
-	// Eval args
+func evalCallArgs(fnObj *object.Function, expr *ast.CallExpr, env *object.Environment) ([]object.Object, *object.Error) {
 	var args []object.Object
 	for _, arg := range expr.Args {
 		res := Eval(arg, env)
 		if res.Type() == object.ERROR_VALUE_OBJ {
-			return res
+			return nil, res.(*object.Error)
 		}
 
 		if res.Type() == object.VAR_ARGS_OBJ {
@@ -374,31 +357,62 @@ func evalCallFnObject(fnObj *object.Function, expr *ast.CallExpr, env *object.En
 	}
 
 	if !fnObj.VarArgs && len(fnObj.Args) != len(args) {
-		return mkError(expr.Span(), fmt.Sprintf("Callable takes %d arguments, but %d were supplied", len(fnObj.Args), len(args)))
+		return nil, mkError(expr.Span(), fmt.Sprintf("Callable takes %d arguments, but %d were supplied", len(fnObj.Args), len(args)))
 	}
 
 	if fnObj.VarArgs && len(fnObj.Args) > len(args) {
-		return mkError(expr.Span(), fmt.Sprintf("Callable takes at least %d arguments, but only %d were supplied", len(fnObj.Args), len(args)))
+		return nil, mkError(expr.Span(), fmt.Sprintf("Callable takes at least %d arguments, but only %d were supplied", len(fnObj.Args), len(args)))
 	}
 
-	// Bind args to new environment
-	newEnv := object.NewEnclosedEnvironment(fnObj.Env)
-	for i := range fnObj.Args {
-		newEnv.Set(fnObj.Args[i].IdentToken.Literal, args[i])
+	return args, nil
+}
+
+func evalCallFnObject(fnObj *object.Function, expr *ast.CallExpr, env *object.Environment) object.Object {
+	args, err := evalCallArgs(fnObj, expr, env)
+	if err != nil {
+		return err
 	}
-	if fnObj.VarArgs {
-		varArgs := args[len(fnObj.Args):]
-		newEnv.SetVarArgs(varArgs)
+
+	if expr.IsTailCall {
+		// Let the caller of the enclosing function run the call instead.
+		return &object.TailCall{Fn: fnObj, Args: args}
 	}
 
-	result := Eval(fnObj.Body, newEnv)
+	// Trampoline over the tail calls of the function, so that tail-recursive
+	// loops run in constant stack space.
+	for {
+		// Bind args to new environment
+		newEnv := object.NewEnclosedEnvironment(fnObj.Env)
+		for i := range fnObj.Args {
+			newEnv.Set(fnObj.Args[i].IdentToken.Literal, args[i])
+		}
+		if fnObj.VarArgs {
+			varArgs := args[len(fnObj.Args):]
+			newEnv.SetVarArgs(varArgs)
+		}
+
+		result := Eval(fnObj.Body, newEnv)
 
-	// Unwrap return so that it does not cross the boundary of the function
-	if result.Type() == object.RETURN_VALUE_OBJ {
-		returnObject := result.(*object.Return)
-		result = returnObject.Value
+		// Unwrap return so that it does not cross the boundary of the function
+		if result.Type() == object.RETURN_VALUE_OBJ {
+			returnObject := result.(*object.Return)
+			result = returnObject.Value
+		}
+
+		// Record the call in errors that leave the function. Calls replaced
+		// by tail calls are reported at the site of the original call.
+		if errObj, ok := result.(*object.Error); ok {
+			errObj.Stack = append(errObj.Stack, object.StackFrame{Function: fnObj.Name, CallSite: expr.Span()})
+			return errObj
+		}
+
+		tailCall, ok := result.(*object.TailCall)
+		if !ok {
+			return result
+		}
+		fnObj = tailCall.Fn
+		args = tailCall.Args
 	}
-	return result
 }
 
 func evalCallExpr(expr *ast.CallExpr, env *object.Environment) object.Object {
@@ -437,6 +451,43 @@ func evalArrayLiteralExpr(expr *ast.ArrayLiteralExpr, env *object.Environment) o
 	return result
 }
 
+func evalSetLiteralExpr(expr *ast.SetLiteralExpr, env *object.Environment) object.Object {
+	result := object.NewSet()
+
+	for _, inner := range expr.Elems {
+		innerEval := Eval(inner, env)
+		if innerEval.Type() == object.ERROR_VALUE_OBJ {
+			return innerEval
+		}
+
+		hashable, ok := innerEval.(object.Hashable)
+		if !ok {
+			return mkError(inner.Span(), "Expression is not hashable")
+		}
+
+		result.Add(hashable)
+	}
+
+	return result
+}
+
+func evalTupleLiteralExpr(expr *ast.TupleLiteralExpr, env *object.Environment) object.Object {
+	result := &object.Tuple{
+		Elems: []object.Object{},
+	}
+
+	for _, inner := range expr.Elems {
+		innerEval := Eval(inner, env)
+		if innerEval.Type() == object.ERROR_VALUE_OBJ {
+			return innerEval
+		}
+
+		result.Elems = append(result.Elems, innerEval)
+	}
+
+	return result
+}
+
 func evalIndexOperatorExpr(expr *ast.IndexOperatorExpr, env *object.Environment) object.Object {
 	indexedObj := Eval(expr.ObjExpr, env)
 	if indexedObj.Type() == object.ERROR_VALUE_OBJ {
@@ -461,23 +512,45 @@ func evalIndexOperatorExpr(expr *ast.IndexOperatorExpr, env *object.Environment)
 		}
 
 		return (*elems)[indexValue]
+	} else if indexedObj.Type() == object.STRING_OBJ {
+		if indexObj.Type() != object.INTEGER_OBJ {
+			return mkError(expr.IndexExpr.Span(), "Expression must evaluate to an integer object")
+		}
+
+		indexValue := indexObj.(*object.Integer).Value
+		strObj := indexedObj.(*object.String)
+
+		ch, ok := strObj.CharAt(indexValue)
+		if !ok {
+			return mkError(expr.IndexExpr.Span(), fmt.Sprintf("Index %d exceeds length of the string (%d)", indexValue, strObj.Len()))
+		}
+
+		return ch
+	} else if indexedObj.Type() == object.TUPLE_OBJ {
+		if indexObj.Type() != object.INTEGER_OBJ {
+			return mkError(expr.IndexExpr.Span(), "Expression must evaluate to an integer object")
+		}
+
+		indexValue := indexObj.(*object.Integer).Value
+		elems := indexedObj.(*object.Tuple).Elems
+
+		if indexValue < 0 || indexValue >= int64(len(elems)) {
+			return mkError(expr.IndexExpr.Span(), fmt.Sprintf("Index %d exceeds length of the tuple (%d)", indexValue, len(elems)))
+		}
+
+		return elems[indexValue]
 	} else if indexedObj.Type() == object.MAP_OBJ {
 		hashable, ok := indexObj.(object.Hashable)
 		if !ok {
 			return mkError(expr.IndexExpr.Span(), "Expression must evaluate to a hashable object")
 		}
 
-		hashKey := hashable.HashKey()
 		mapObj := indexedObj.(*object.HashMap)
-		value, ok := mapObj.Elems[hashKey]
+		value, ok := mapObj.Get(hashable)
 		if !ok {
 			return mkError(expr.Span(), fmt.Sprintf("Key %q not found", indexObj.Inspect()))
 		}
-
-		if value.Key.Inspect() != indexObj.Inspect() {
-			return mkError(expr.Span(), fmt.Sprintf("Key %q not found", indexObj.Inspect()))
-		}
-		return value.Value
+		return value
 	}
 
 	return mkError(expr.ObjExpr.Span(), "Expression must evaluate to an array or map object")
@@ -516,7 +589,7 @@ func evalRangeExpr(node *ast.RangeExpr, env *object.Environment) object.Object {
 
 	curValue := startIntObj.Value
 	for curValue != endIntObj.Value {
-		arrayObj.Elems = append(arrayObj.Elems, &object.Integer{Value: curValue})
+		arrayObj.Elems = append(arrayObj.Elems, object.NewInt64(curValue))
 		curValue = curValue + incr
 	}
 
@@ -524,33 +597,25 @@ func evalRangeExpr(node *ast.RangeExpr, env *object.Environment) object.Object {
 }
 
 func evalMapLiteralExpr(node *ast.MapLiteralExpr, env *object.Environment) object.Object {
-	mapObj := &object.HashMap{
-		Elems: map[object.HashKey]object.HashEntry{},
-	}
+	mapObj := object.NewHashMap()
 
-	for kExpr, vExpr := range node.Map {
-		kObj := Eval(kExpr, env)
+	for _, entry := range node.Entries {
+		kObj := Eval(entry.Key, env)
 		if kObj.Type() == object.ERROR_VALUE_OBJ {
 			return kObj
 		}
 
 		hashableK, ok := kObj.(object.Hashable)
 		if !ok {
-			return mkError(kExpr.Span(), "Expression is not hashable")
+			return mkError(entry.Key.Span(), "Expression is not hashable")
 		}
-		hashKey := hashableK.HashKey()
 
-		vObj := Eval(vExpr, env)
+		vObj := Eval(entry.Value, env)
 		if vObj.Type() == object.ERROR_VALUE_OBJ {
 			return vObj
 		}
 
-		hashEntry := object.HashEntry{
-			Key:   kObj,
-			Value: vObj,
-		}
-
-		mapObj.Elems[hashKey] = hashEntry
+		mapObj.Set(hashableK, vObj)
 	}
 
 	return mapObj
@@ -565,10 +630,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expr, env)
 
 	case *ast.IntegerLiteralExpr:
-		return &object.Integer{Value: node.Value}
+		return object.NewInt64(node.Value)
 
 	case *ast.BoolLiteralExpr:
-		return &object.Boolean{Value: node.Value}
+		return object.NativeBool(node.Value)
 
 	case *ast.PrefixExpr:
 		return evalPrefixExpr(node, env)
@@ -615,6 +680,12 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.MapLiteralExpr:
 		return evalMapLiteralExpr(node, env)
 
+	case *ast.SetLiteralExpr:
+		return evalSetLiteralExpr(node, env)
+
+	case *ast.TupleLiteralExpr:
+		return evalTupleLiteralExpr(node, env)
+
 	default:
 		log.Fatalf("Unimplemented evaluation of node type: %T\n", node)
 	}
@@ -1,7 +1,9 @@
 package evaluator
 
 import (
-	"hash/fnv"
+	"fmt"
+	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/javier-varez/monkey_interpreter/ast"
@@ -25,6 +27,34 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testBigIntObject(t *testing.T, obj object.Object, expected *big.Int) bool {
+	bigIntRes, ok := obj.(*object.BigInt)
+	if !ok {
+		t.Errorf("Result is not a big integer object: %v", obj)
+		return false
+	}
+
+	if bigIntRes.Value.Cmp(expected) != 0 {
+		t.Errorf("Unexpected value: expected %s, got %s", expected, bigIntRes.Value)
+		return false
+	}
+	return true
+}
+
+func testCharObject(t *testing.T, obj object.Object, expected rune) bool {
+	charRes, ok := obj.(*object.Char)
+	if !ok {
+		t.Errorf("Result is not a char object: %v", obj)
+		return false
+	}
+
+	if charRes.Value != expected {
+		t.Errorf("Unexpected value: expected %q, got %q", expected, charRes.Value)
+		return false
+	}
+	return true
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	boolRes, ok := obj.(*object.Boolean)
 	if !ok {
@@ -81,32 +111,19 @@ func testMapObject(t *testing.T, obj object.Object, expected map[string]interfac
 		return false
 	}
 
-	if len(mapObj.Elems) != len(expected) {
-		t.Errorf("Map length does not match. Expected %d, got %d", len(expected), len(mapObj.Elems))
+	if mapObj.Len() != len(expected) {
+		t.Errorf("Map length does not match. Expected %d, got %d", len(expected), mapObj.Len())
 		return false
 	}
 
 	for expectedK, expectedV := range expected {
-		h := fnv.New64()
-		h.Write([]byte(expectedK))
-		hash := h.Sum64()
-
-		hashKey := object.HashKey{
-			Type: object.STRING_OBJ,
-			Hash: hash,
-		}
-
-		v, ok := mapObj.Elems[hashKey]
+		v, ok := mapObj.Get(&object.String{Value: expectedK})
 		if !ok {
 			t.Errorf("Map does not contain entry for the expected key: %s", expectedK)
 			return false
 		}
 
-		if !testObject(t, v.Key, expectedK) {
-			return false
-		}
-
-		if !testObject(t, v.Value, expectedV) {
+		if !testObject(t, v, expectedV) {
 			return false
 		}
 	}
@@ -130,10 +147,14 @@ func testObject(t *testing.T, obj object.Object, inner interface{}) bool {
 		return testIntegerObject(t, obj, int64(inner))
 	case int64:
 		return testIntegerObject(t, obj, inner)
+	case *big.Int:
+		return testBigIntObject(t, obj, inner)
 	case bool:
 		return testBooleanObject(t, obj, inner)
 	case string:
 		return testStringObject(t, obj, inner)
+	case rune:
+		return testCharObject(t, obj, inner)
 	case []interface{}:
 		return testArrayObject(t, obj, inner)
 	case map[string]interface{}:
@@ -194,6 +215,46 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalBigIntegerExpression(t *testing.T) {
+	mustParseBigInt := func(s string) *big.Int {
+		value, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			t.Fatalf("Invalid big integer %q", s)
+		}
+		return value
+	}
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"9223372036854775807 + 1", mustParseBigInt("9223372036854775808")},
+		{"9223372036854775807 + 1 - 1", int64(9223372036854775807)},
+		{"-9223372036854775807 - 2", mustParseBigInt("-9223372036854775809")},
+		{"4611686018427387904 * 2", mustParseBigInt("9223372036854775808")},
+		{"4611686018427387904 * 4 / 4", int64(4611686018427387904)},
+		{"(-9223372036854775807 - 1) / -1", mustParseBigInt("9223372036854775808")},
+		{"-(-9223372036854775807 - 1)", mustParseBigInt("9223372036854775808")},
+		{"9223372036854775807 * 9223372036854775807", mustParseBigInt("85070591730234615847396907784232501249")},
+		{"9223372036854775807 + 1 > 9223372036854775807", true},
+		{"9223372036854775807 < 9223372036854775807 + 1", true},
+		{"9223372036854775807 + 1 == 9223372036854775806 + 2", true},
+		{"9223372036854775807 + 1 != 9223372036854775807", true},
+		{"{9223372036854775807 + 1: 1}[9223372036854775806 + 2]", 1},
+		{
+			`let wrap = fn(self) { fn(...) { self(self, ...) } };
+			 let fib = wrap(fn(self, n, a, b) { if (n == 0) { a } else { self(self, n - 1, b, a + b) } });
+			 fib(100, 0, 1)`,
+			mustParseBigInt("354224848179261915075"),
+		},
+	}
+
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		testObject(t, result, tt.expected)
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input  string
@@ -272,6 +333,10 @@ func TestEvalInfixBoolOperators(t *testing.T) {
 		{"true == false", false},
 		{"true != true", false},
 		{"true != false", true},
+		// Values of different types are never equal
+		{`10 == "str"`, false},
+		{`"str" == 10`, false},
+		{`10 != "str"`, true},
 		// {`"Hi!" == "Hi!"`, true},
 		// {`"Hi!" == "Hi!a"`, false},
 		// {`"Hi!" != "Hi!"`, false},
@@ -354,21 +419,27 @@ func TestEvalRuntimeErrors(t *testing.T) {
 		{"if (true + 10) {}", mkSpan(4, 8), "Expression does not evaluate to an integer or string object"},
 		{`let a = "str" + 10`, mkSpan(8, 18), "Left and right arguments to the infix operator do not have the same type"},
 		{`let a = 10 + "str"`, mkSpan(8, 18), "Left and right arguments to the infix operator do not have the same type"},
-		{`let a = 10 == "str"`, mkSpan(8, 19), "Left and right arguments to the infix operator do not have the same type"},
-		{`let a = "str" == 10`, mkSpan(8, 19), "Left and right arguments to the infix operator do not have the same type"},
 		{"if (!10) {}", mkSpan(4, 7), "\"!\" requires a boolean argument"},
 		{"-true", mkSpan(0, 5), "\"-\" requires an integer argument"},
 		{"if (10) {}", mkSpan(4, 6), "Condition must evaluate to a boolean object"},
 		{"foobar", mkSpan(0, 6), "Identifier not found"},
-		{"len(3)", mkSpan(0, 6), "\"len\" builtin takes a single string or array argument"},
-		{`len("", "")`, mkSpan(0, 11), "\"len\" builtin takes a single string or array argument"},
+		{"1 / 0", mkSpan(0, 5), "Division by zero"},
+		{`"ñandú"[5]`, mkSpan(8, 9), "Index 5 exceeds length of the string (5)"},
+		{`(1, 2)[2]`, mkSpan(7, 8), "Index 2 exceeds length of the tuple (2)"},
+		{`{{1: 2}, 2}`, mkSpan(1, 7), "Expression is not hashable"},
+		{`{1} | 2`, mkSpan(6, 7), "Expression does not evaluate to a set object"},
+		{`1 & {2}`, mkSpan(0, 1), "Expression does not evaluate to a set object"},
+		{`{1} * {2}`, mkSpan(0, 9), "Operator \"*\" is not supported on sets"},
+		{`"ñandú"[0] + "ñandú"[1]`, mkSpan(0, 23), "Left and right arguments to the infix operator do not have the same type"},
+		{"len(3)", mkSpan(0, 6), "\"len\" builtin takes a single string, array, tuple or set argument"},
+		{`len("", "")`, mkSpan(0, 11), "\"len\" builtin takes a single string, array, tuple or set argument"},
 		{`let a = [123, 123]; a[2]`, mkSpan(22, 23), "Index 2 exceeds length of the array (2)"},
 		{`first([])`, mkSpan(0, 9), "Array is empty"},
 		{`last([])`, mkSpan(0, 8), "Array is empty"},
 		{`rest([])`, mkSpan(0, 8), "Array is empty"},
-		{`first(1)`, mkSpan(0, 8), "\"first\" builtin takes a single array argument"},
-		{`last(1)`, mkSpan(0, 7), "\"last\" builtin takes a single array argument"},
-		{`rest(1)`, mkSpan(0, 7), "\"rest\" builtin takes a single array argument"},
+		{`first(1)`, mkSpan(0, 8), "\"first\" builtin takes a single string or array argument"},
+		{`last(1)`, mkSpan(0, 7), "\"last\" builtin takes a single string or array argument"},
+		{`rest(1)`, mkSpan(0, 7), "\"rest\" builtin takes a single string or array argument"},
 		{`push(3)`, mkSpan(0, 7), "\"push\" builtin takes an array argument and a new object to push"},
 		{`let myFn = fn(a, ...) {}; myFn()`, mkSpan(26, 32), "Callable takes at least 1 arguments, but only 0 were supplied"},
 		{`let myFn = fn(a, ...) { fn(a, b, ...){}(a,...) }; myFn(3)`, mkSpan(24, 46), "Callable takes at least 2 arguments, but only 1 were supplied"},
@@ -450,6 +521,25 @@ func TestEvalCallExpressions(t *testing.T) {
 		{"let x = 100; let y = 100; let add = fn(x, y) { return x + y; }; add(3, add(4, 3));", 10},
 		{"let x = 100; let y = 100; let add = fn(x, y) { return x + y; }; add(3, add(4, 3)); x + y", 200},
 		{"let x = 100; let add = fn(a) { return a + x; }; let x = 200; add(1)", 101},
+		{"let add = fn(a: int, b: [int]) -> int { a + b[0] }; let x: int = add(1, [2]); x", 3},
+	}
+
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		testObject(t, result, tt.expected)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	wrap := "let wrap = fn(self) { fn(...) { self(self, ...) } };"
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{wrap + "let count = wrap(fn(self, n, acc) { if (n == 0) { return acc; } self(self, n - 1, acc + 1) }); count(100000, 0)", 100000},
+		{wrap + "let count = wrap(fn(self, n, acc) { if (n == 0) { acc } else { return self(self, n - 1, acc + 2); } }); count(100000, 0)", 200000},
+		{wrap + "let even = wrap(fn(self, n) { if (n == 0) { true } else { !self(self, n - 1) } }); even(10)", true},
+		{"let f = fn(a) { len(a) }; f([1, 2, 3])", 3},
 	}
 
 	for _, tt := range tests {
@@ -498,6 +588,141 @@ func TestLenBuiltin(t *testing.T) {
 		{`let a = ["", ""]; let b = len; b(a)`, 2},
 		{`let a = [""]; let b = len; b(a)`, 1},
 		{`let a = []; let b = len; b(a)`, 0},
+		{`len("ñandú 🐒")`, 7},
+	}
+
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		testObject(t, result, tt.expected)
+	}
+}
+
+func TestUnicodeStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"ñandú"[0]`, 'ñ'},
+		{`"ñandú"[4]`, 'ú'},
+		{`first("🐒 monkey")`, '🐒'},
+		{`last("ñandú")`, 'ú'},
+		{`rest("ñandú")`, "andú"},
+		{`"ñandú"[0] + "andú"`, "ñandú"},
+		{`"ñand" + "ñandú"[4]`, "ñandú"},
+		{`"ñandú"[4] == last("ñandú")`, true},
+		{`"ñandú"[0] != "ñandú"[1]`, true},
+		{`"abc"[0] < "abc"[1]`, true},
+		{`"abc"[2] > "abc"[1]`, true},
+		{`{"ñandú"[0]: 1}[first("ñandú")]`, 1},
+		{
+			`let wrap = fn(self) { fn(...) { self(self, ...) } };
+			 let reverse = wrap(fn(self, s, acc) { if (len(s) == 0) { acc } else { self(self, rest(s), first(s) + acc) } });
+			 reverse("ñandú 🐒", "")`,
+			"🐒 údnañ",
+		},
+	}
+
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		testObject(t, result, tt.expected)
+	}
+}
+
+func TestSetsAndTuples(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`len({1, 2, 3})`, 3},
+		{`len({1, 2, 2, 1})`, 2},
+		{`len({1, 2, 3} | {3, 4})`, 4},
+		{`len({1, 2, 3} & {3, 4})`, 1},
+		{`len({1, 2, 3} - {3, 4})`, 2},
+		{`contains({1, "two", (3, 4)}, "two")`, true},
+		{`contains({1, "two", (3, 4)}, (3, 4))`, true},
+		{`contains({1, "two", (3, 4)}, (4, 3))`, false},
+		{`contains({1, 2, 3} & {3, 4}, 3)`, true},
+		{`contains({1, 2, 3} - {3, 4}, 3)`, false},
+		{`let a = {1, 2}; let b = {2, 3}; contains(a | b, 1) == contains(b | a, 1)`, true},
+		{`len((1, "two", [3]))`, 3},
+		{`(1, "two", [3])[1]`, "two"},
+		{`let t = (1,); t[0]`, 1},
+		{`len(())`, 0},
+		{`{(1, 2): "a", (2, 1): "b"}[(2, 1)]`, "b"},
+		{`let point = fn(x, y) { (x, y) }; {point(1, 2): "a"}[(1, 2)]`, "a"},
+	}
+
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		testObject(t, result, tt.expected)
+	}
+}
+
+func TestStructuralEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`[1, 2, [3, "four"]] == [1, 2, [3, "four"]]`, true},
+		{`[1, 2, [3, "four"]] == [1, 2, [3, "five"]]`, false},
+		{`[1, 2] != [1, 2, 3]`, true},
+		{`[] == []`, true},
+		{`{"a": [1, 2], "b": {1: 2}} == {"b": {1: 2}, "a": [1, 2]}`, true},
+		{`{"a": [1, 2]} == {"a": [2, 1]}`, false},
+		{`{"a": 1} != {"a": 1, "b": 2}`, true},
+		{`if (false) { 1 } == if (false) { 2 }`, true},
+		{`(1, [2]) == (1, [2])`, true},
+		{`{1, 2, 3} == {3, 2, 1}`, true},
+		{`{1, 2, 3} == {1, 2}`, false},
+		{`9223372036854775807 + 1 == 9223372036854775806 + 2`, true},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
+		{`{[1, 2]: "a", [2, 1]: "b"}[[2, 1]]`, "b"},
+		{`let key = [1, [2, 3]]; {key: "a"}[[1, [2, 3]]]`, "a"},
+		{`contains({[1, 2]: "a"}, [1, 2])`, true},
+		{`contains({[1, 2]: "a"}, [1, 3])`, false},
+		{`contains({[1], [1], [2]}, [1])`, true},
+		{`len({[1], [1], [2]})`, 2},
+		// Maps and sets hash the same whatever their order
+		{`let a = [{"a": 1, "b": 2}]; let b = [{"b": 2, "a": 1}]; a == b`, true},
+		{`let a = [{"a": 1, "b": 2}]; let b = [{"b": 2, "a": 1}]; contains({a}, b)`, true},
+		{`let a = [{"a": 1, "b": 2}]; let b = [{"b": 2, "a": 1}]; {a: 1}[b]`, 1},
+		{`let a = ({1, 2}, 3); let b = ({2, 1}, 3); {a: "x"}[b]`, "x"},
+		{`1 == "a"`, false},
+		{`let eq = fn(a, b) { a == b }; eq(1, "a")`, false},
+		{`[1] != (1)`, true},
+		{`"a"[0] == "a"`, false},
+	}
+
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		testObject(t, result, tt.expected)
+	}
+}
+
+func TestMapBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`len(keys({"a": 1, "b": 2, "c": 3}))`, 3},
+		{`keys({"a": 1})`, []interface{}{"a"}},
+		{`values({"a": 1})`, []interface{}{1}},
+		{`keys({})`, []interface{}{}},
+		{`let m = {"a": 1, "b": 2}; contains({1, 2}, values(m)[0])`, true},
+		{`let m = {"a": 1, "b": 2}; let n = delete(m, "a"); contains(n, "a")`, false},
+		{`let m = {"a": 1, "b": 2}; let n = delete(m, "a"); contains(m, "a")`, true},
+		{`len(keys(delete({"a": 1, "b": 2}, "c")))`, 2},
+		{`delete({"a": 1, "b": 2}, "a") == {"b": 2}`, true},
+		{`let f = fn() { 1 }; let g = fn() { 1 }; let m = {[f]: "f", [g]: "g"}; len(keys(m))`, 2},
+		{`let f = fn() { 1 }; let g = fn() { 1 }; let m = {[f]: "f", [g]: "g"}; m[[g]]`, "g"},
+		{`let f = fn() { 1 }; let g = fn() { 1 }; let m = {[f]: "f", [g]: "g"}; delete(m, [f])[[g]]`, "g"},
+		{`keys({"b": 1, "a": 2, "c": 3})`, []interface{}{"b", "a", "c"}},
+		{`values({"b": 1, "a": 2, "c": 3})`, []interface{}{1, 2, 3}},
+		{`keys(delete({"b": 1, "a": 2, "c": 3}, "a"))`, []interface{}{"b", "c"}},
+		{`{"a": 1, "a": 2}["a"]`, 2},
+		{`keys({"b": 1, "a": 2, "b": 3})`, []interface{}{"b", "a"}},
+		{`let m = {"a": 1, "b": 2}; m[keys(m)[1]] == values(m)[1]`, true},
 	}
 
 	for _, tt := range tests {
@@ -609,3 +834,46 @@ func TestHashMapLiteral(t *testing.T) {
 		testObject(t, result, tt.expected)
 	}
 }
+
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedFunctions []string
+		expectedCallSites []string
+	}{
+		{
+			"let inner = fn(x) { -x };\nlet outer = fn(x) { let y = inner(x); y };\nlet apply = fn(f, x) { f(x) + 1 };\napply(outer, \"a\")",
+			[]string{"inner", "outer", "apply"},
+			[]string{"inner(x)", "f(x)", "apply(outer, \"a\")"},
+		},
+		{
+			"let call = fn(f) { f() + 1 };\ncall(fn() { 1 / 0 })",
+			[]string{"", "call"},
+			[]string{"f()", "call(fn() { 1 / 0 })"},
+		},
+		{"1 / 0", []string{}, []string{}},
+	}
+
+	for _, tt := range tests {
+		errObj, ok := testEval(tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("Expected an error for %q", tt.input)
+			continue
+		}
+
+		functions := []string{}
+		callSites := []string{}
+		for _, frame := range errObj.Stack {
+			functions = append(functions, frame.Function)
+			line := []rune(strings.Split(*frame.CallSite.Text, "\n")[frame.CallSite.Start.Line])
+			callSites = append(callSites, string(line[frame.CallSite.Start.Column:frame.CallSite.End.Column]))
+		}
+
+		if fmt.Sprintf("%q", functions) != fmt.Sprintf("%q", tt.expectedFunctions) {
+			t.Errorf("Wrong functions for %q. Expected %q, got %q", tt.input, tt.expectedFunctions, functions)
+		}
+		if fmt.Sprintf("%q", callSites) != fmt.Sprintf("%q", tt.expectedCallSites) {
+			t.Errorf("Wrong call sites for %q. Expected %q, got %q", tt.input, tt.expectedCallSites, callSites)
+		}
+	}
+}
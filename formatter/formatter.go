@@ -0,0 +1,480 @@
+// Package formatter prints programs back in a canonical style, keeping their
+// comments. Unlike ast.Node.String(), which prints a single line with every
+// infix expression in parenthesis, the output is meant to be read and edited.
+//
+// The style is fixed: blocks are indented with four spaces, let and return
+// statements end with a semicolon, binary operators are surrounded by spaces
+// and lists that do not fit in MAX_WIDTH columns are broken into one element
+// per line. Blank lines between statements are kept, collapsed into one.
+package formatter
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/lexer"
+	"github.com/javier-varez/monkey_interpreter/parser"
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+const (
+	MAX_WIDTH = 80
+	INDENT    = "    "
+)
+
+// Source parses and formats a program. The diagnostics of the parser are
+// returned if the source can't be parsed.
+func Source(src string) (string, []ast.Error) {
+	program := parser.New(lexer.New(src)).ParseProgram()
+	if len(program.Diagnostics) != 0 {
+		return "", program.Diagnostics
+	}
+	return Format(program), nil
+}
+
+// Format prints a program that was parsed without diagnostics.
+func Format(program *ast.Program) string {
+	p := &printer{
+		leading:  map[ast.Statment][]token.Token{},
+		trailing: map[ast.Statment]token.Token{},
+		closing:  map[*ast.BlockStatement][]token.Token{},
+		printed:  map[printedKey]string{},
+	}
+	p.attachComments(program)
+	return p.statements(program.Statements, 0, p.eof)
+}
+
+// printer holds the comments of the program attached to the statements they
+// are printed next to.
+type printer struct {
+	// leading comments are printed in the lines before a statement. Comments
+	// found within a statement, but not in any of its blocks, are moved there.
+	leading map[ast.Statment][]token.Token
+	// trailing comments follow a statement in its last line.
+	trailing map[ast.Statment]token.Token
+	// closing comments follow the last statement of a block.
+	closing map[*ast.BlockStatement][]token.Token
+	// eof comments follow the last statement of the program.
+	eof []token.Token
+	// printed caches the expressions already printed. Lists print their items
+	// twice, which would take exponential time with nested lists otherwise.
+	printed map[printedKey]string
+}
+
+type printedKey struct {
+	expr        ast.Expression
+	indent, col int
+}
+
+func before(a, b token.Location) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+func (p *printer) attachComments(program *ast.Program) {
+	blocks := []*ast.BlockStatement{}
+	for _, stmt := range program.Statements {
+		blocks = collectStatementBlocks(stmt, blocks)
+	}
+
+	for _, comment := range program.Comments {
+		pos := comment.Span.Start
+
+		// Blocks are collected in source order, so the last one holding the
+		// comment is the innermost
+		var block *ast.BlockStatement
+		stmts := program.Statements
+		for _, b := range blocks {
+			if before(b.Lbrace.Span.Start, pos) && before(pos, b.Rbrace.Span.Start) {
+				block = b
+				stmts = b.Statements
+			}
+		}
+
+		p.attachComment(comment, stmts, block)
+	}
+}
+
+func (p *printer) attachComment(comment token.Token, stmts []ast.Statment, block *ast.BlockStatement) {
+	pos := comment.Span.Start
+
+	var previous ast.Statment
+	for _, stmt := range stmts {
+		span := stmt.Span()
+		if before(pos, span.Start) {
+			if previous != nil && previous.Span().End.Line == pos.Line {
+				p.trailing[previous] = comment
+			} else {
+				p.leading[stmt] = append(p.leading[stmt], comment)
+			}
+			return
+		}
+
+		if before(pos, span.End) {
+			p.leading[stmt] = append(p.leading[stmt], comment)
+			return
+		}
+		previous = stmt
+	}
+
+	if previous != nil && previous.Span().End.Line == pos.Line {
+		p.trailing[previous] = comment
+	} else if block != nil {
+		p.closing[block] = append(p.closing[block], comment)
+	} else {
+		p.eof = append(p.eof, comment)
+	}
+}
+
+func collectStatementBlocks(stmt ast.Statment, blocks []*ast.BlockStatement) []*ast.BlockStatement {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return collectBlocks(stmt.Expr, blocks)
+	case *ast.ReturnStatement:
+		return collectBlocks(stmt.Expr, blocks)
+	case *ast.ExpressionStatement:
+		return collectBlocks(stmt.Expr, blocks)
+	}
+	return blocks
+}
+
+func collectBlockStatements(block *ast.BlockStatement, blocks []*ast.BlockStatement) []*ast.BlockStatement {
+	blocks = append(blocks, block)
+	for _, stmt := range block.Statements {
+		blocks = collectStatementBlocks(stmt, blocks)
+	}
+	return blocks
+}
+
+func collectBlocks(expr ast.Expression, blocks []*ast.BlockStatement) []*ast.BlockStatement {
+	switch expr := expr.(type) {
+	case *ast.PrefixExpr:
+		return collectBlocks(expr.InnerExpr, blocks)
+	case *ast.InfixExpr:
+		return collectBlocks(expr.RightExpr, collectBlocks(expr.LeftExpr, blocks))
+	case *ast.RangeExpr:
+		return collectBlocks(expr.EndExpr, collectBlocks(expr.StartExpr, blocks))
+	case *ast.IfExpr:
+		blocks = collectBlockStatements(expr.Consequence, collectBlocks(expr.Condition, blocks))
+		if expr.Alternative != nil {
+			blocks = collectBlockStatements(expr.Alternative, blocks)
+		}
+	case *ast.FnLiteralExpr:
+		return collectBlockStatements(expr.Body, blocks)
+	case *ast.CallExpr:
+		blocks = collectBlocks(expr.CallableExpr, blocks)
+		for _, arg := range expr.Args {
+			blocks = collectBlocks(arg, blocks)
+		}
+	case *ast.IndexOperatorExpr:
+		return collectBlocks(expr.IndexExpr, collectBlocks(expr.ObjExpr, blocks))
+	case *ast.ArrayLiteralExpr:
+		for _, elem := range expr.Elems {
+			blocks = collectBlocks(elem, blocks)
+		}
+	case *ast.SetLiteralExpr:
+		for _, elem := range expr.Elems {
+			blocks = collectBlocks(elem, blocks)
+		}
+	case *ast.TupleLiteralExpr:
+		for _, elem := range expr.Elems {
+			blocks = collectBlocks(elem, blocks)
+		}
+	case *ast.MapLiteralExpr:
+		for _, entry := range expr.Entries {
+			blocks = collectBlocks(entry.Value, collectBlocks(entry.Key, blocks))
+		}
+	}
+	return blocks
+}
+
+// statements prints a list of statements, one per line, followed by the
+// closing comments of the list.
+func (p *printer) statements(stmts []ast.Statment, indent int, closing []token.Token) string {
+	var buffer bytes.Buffer
+	prefix := strings.Repeat(INDENT, indent)
+
+	texts := make([]string, len(stmts))
+	for i, stmt := range stmts {
+		texts[i] = p.statement(stmt, indent)
+	}
+
+	// Blank lines of the source are kept, but never at the start of a list
+	lastLine := -1
+	writeLine := func(line int, text string) {
+		if lastLine >= 0 && line > lastLine+1 {
+			buffer.WriteByte('\n')
+		}
+		buffer.WriteString(prefix)
+		buffer.WriteString(text)
+		buffer.WriteByte('\n')
+	}
+
+	for i, stmt := range stmts {
+		span := stmt.Span()
+
+		for _, comment := range p.leading[stmt] {
+			line := comment.Span.Start.Line
+			if line > span.Start.Line {
+				line = span.Start.Line
+			}
+			writeLine(line, comment.Literal)
+			lastLine = line
+		}
+
+		text := texts[i]
+		if _, ok := stmt.(*ast.ExpressionStatement); ok && i != len(stmts)-1 && needsSemicolon(text, texts[i+1]) {
+			text += ";"
+		}
+		if comment, ok := p.trailing[stmt]; ok {
+			text += " " + comment.Literal
+		}
+		writeLine(span.Start.Line, text)
+		lastLine = span.End.Line
+	}
+
+	for _, comment := range closing {
+		writeLine(comment.Span.Start.Line, comment.Literal)
+		lastLine = comment.Span.Start.Line
+	}
+
+	return buffer.String()
+}
+
+// needsSemicolon reports whether an expression statement must be terminated
+// before the next statement. Expressions ending in a block are only
+// terminated when the next statement would otherwise continue them, like in
+// a call or index expression.
+func needsSemicolon(text, next string) bool {
+	if !strings.HasSuffix(text, "}") {
+		return true
+	}
+	return strings.HasPrefix(next, "(") || strings.HasPrefix(next, "[") || strings.HasPrefix(next, "-")
+}
+
+func (p *printer) statement(stmt ast.Statment, indent int) string {
+	col := len(INDENT) * indent
+
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		text := "let " + stmt.IdentExpr.String()
+		if stmt.Type != nil {
+			text += ": " + stmt.Type.String()
+		}
+		text += " = "
+		return text + p.expression(stmt.Expr, indent, col+len(text)) + ";"
+	case *ast.ReturnStatement:
+		text := "return "
+		return text + p.expression(stmt.Expr, indent, col+len(text)) + ";"
+	case *ast.ExpressionStatement:
+		return p.expression(stmt.Expr, indent, col)
+	case *ast.BlockStatement:
+		return p.block(stmt, indent)
+	}
+	return stmt.String()
+}
+
+// block prints a block across several lines, unless it was written in a
+// single line and holds at most one statement without comments.
+func (p *printer) block(block *ast.BlockStatement, indent int) string {
+	if len(block.Statements) == 0 && len(p.closing[block]) == 0 {
+		return "{}"
+	}
+
+	if block.Lbrace.Span.Start.Line == block.Rbrace.Span.Start.Line &&
+		len(block.Statements) == 1 && len(p.closing[block]) == 0 {
+		stmt := block.Statements[0]
+		_, hasTrailing := p.trailing[stmt]
+		text := p.statement(stmt, indent)
+		if len(p.leading[stmt]) == 0 && !hasTrailing && !strings.Contains(text, "\n") {
+			return "{ " + text + " }"
+		}
+	}
+
+	return "{\n" + p.statements(block.Statements, indent+1, p.closing[block]) + strings.Repeat(INDENT, indent) + "}"
+}
+
+// advance returns the column reached after printing text from col.
+func advance(col int, text string) int {
+	if idx := strings.LastIndexByte(text, '\n'); idx >= 0 {
+		return utf8.RuneCountInString(text[idx+1:])
+	}
+	return col + utf8.RuneCountInString(text)
+}
+
+// fits reports whether every line of text, printed from col, fits in
+// MAX_WIDTH columns.
+func fits(col int, text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		if col+utf8.RuneCountInString(line) > MAX_WIDTH {
+			return false
+		}
+		col = 0
+	}
+	return true
+}
+
+const primaryPrecedence = parser.ARRAY_IDX + 1
+
+func precedence(expr ast.Expression) int {
+	switch expr := expr.(type) {
+	case *ast.InfixExpr:
+		return parser.Precedence(expr.OperatorToken.Type)
+	case *ast.RangeExpr:
+		return parser.RANGE
+	case *ast.PrefixExpr:
+		return parser.PREFIX
+	}
+	return primaryPrecedence
+}
+
+// operand prints expr in parenthesis if its precedence is lower than min.
+func (p *printer) operand(expr ast.Expression, min, indent, col int) string {
+	if precedence(expr) < min {
+		return "(" + p.expression(expr, indent, col+1) + ")"
+	}
+	return p.expression(expr, indent, col)
+}
+
+func (p *printer) expression(expr ast.Expression, indent, col int) string {
+	key := printedKey{expr, indent, col}
+	if text, ok := p.printed[key]; ok {
+		return text
+	}
+	text := p.printExpression(expr, indent, col)
+	p.printed[key] = text
+	return text
+}
+
+func (p *printer) printExpression(expr ast.Expression, indent, col int) string {
+	switch expr := expr.(type) {
+	case *ast.IdentifierExpr:
+		return expr.IdentToken.Literal
+	case *ast.IntegerLiteralExpr:
+		return expr.IntToken.Literal
+	case *ast.BoolLiteralExpr:
+		return expr.Token.Literal
+	case *ast.StringLiteralExpr:
+		return expr.StringLitToken.Literal
+	case *ast.VarArgsLiteralExpr:
+		return expr.Token.Literal
+	case *ast.PrefixExpr:
+		op := expr.OperatorToken.Literal
+		return op + p.operand(expr.InnerExpr, parser.PREFIX, indent, col+len(op))
+	case *ast.InfixExpr:
+		prec := precedence(expr)
+		left := p.operand(expr.LeftExpr, prec, indent, col)
+		op := " " + expr.OperatorToken.Literal + " "
+		// Infix operators are left associative
+		return left + op + p.operand(expr.RightExpr, prec+1, indent, advance(col, left+op))
+	case *ast.RangeExpr:
+		left := p.operand(expr.StartExpr, parser.RANGE, indent, col)
+		op := expr.DotsToken.Literal
+		return left + op + p.operand(expr.EndExpr, parser.RANGE+1, indent, advance(col, left+op))
+	case *ast.IfExpr:
+		text := "if ("
+		text += p.expression(expr.Condition, indent, col+len(text)) + ") "
+		text += p.block(expr.Consequence, indent)
+		if expr.Alternative != nil {
+			text += " else " + p.block(expr.Alternative, indent)
+		}
+		return text
+	case *ast.FnLiteralExpr:
+		return p.fnLiteral(expr, indent)
+	case *ast.CallExpr:
+		callee := p.operand(expr.CallableExpr, parser.CALL, indent, col)
+		return callee + p.list("(", ")", len(expr.Args), false, indent, advance(col, callee), func(i, indent, col int) string {
+			return p.expression(expr.Args[i], indent, col)
+		})
+	case *ast.IndexOperatorExpr:
+		obj := p.operand(expr.ObjExpr, parser.CALL, indent, col)
+		return obj + "[" + p.expression(expr.IndexExpr, indent, advance(col, obj)+1) + "]"
+	case *ast.ArrayLiteralExpr:
+		return p.elements("[", "]", expr.Elems, false, indent, col)
+	case *ast.SetLiteralExpr:
+		return p.elements("{", "}", expr.Elems, false, indent, col)
+	case *ast.TupleLiteralExpr:
+		return p.elements("(", ")", expr.Elems, len(expr.Elems) == 1, indent, col)
+	case *ast.MapLiteralExpr:
+		return p.list("{", "}", len(expr.Entries), false, indent, col, func(i, indent, col int) string {
+			key := p.expression(expr.Entries[i].Key, indent, col) + ": "
+			return key + p.expression(expr.Entries[i].Value, indent, advance(col, key))
+		})
+	}
+	return expr.String()
+}
+
+func (p *printer) fnLiteral(expr *ast.FnLiteralExpr, indent int) string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("fn(")
+	for i, arg := range expr.Args {
+		buffer.WriteString(arg.IdentToken.Literal)
+		if expr.ArgTypes[i] != nil {
+			buffer.WriteString(": ")
+			buffer.WriteString(expr.ArgTypes[i].String())
+		}
+		if i != len(expr.Args)-1 || expr.VarArgs {
+			buffer.WriteString(", ")
+		}
+	}
+	if expr.VarArgs {
+		buffer.WriteString("...")
+	}
+	buffer.WriteString(") ")
+	if expr.ReturnType != nil {
+		buffer.WriteString("-> ")
+		buffer.WriteString(expr.ReturnType.String())
+		buffer.WriteString(" ")
+	}
+	buffer.WriteString(p.block(expr.Body, indent))
+
+	return buffer.String()
+}
+
+func (p *printer) elements(open, close string, elems []ast.Expression, trailingComma bool, indent, col int) string {
+	return p.list(open, close, len(elems), trailingComma, indent, col, func(i, indent, col int) string {
+		return p.expression(elems[i], indent, col)
+	})
+}
+
+// list prints the items of a call, collection literal or tuple in a single
+// line if they fit. Only the last item may span several lines there, so that
+// no items are left after the end of a multi-line one. Otherwise each item
+// goes in its own line, followed by a comma.
+func (p *printer) list(open, close string, count int, trailingComma bool, indent, col int, item func(i, indent, col int) string) string {
+	var flat bytes.Buffer
+	flat.WriteString(open)
+	multiline := false
+	for i := 0; i < count && !multiline; i++ {
+		text := item(i, indent, advance(col, flat.String()))
+		flat.WriteString(text)
+		if i != count-1 {
+			multiline = strings.Contains(text, "\n")
+			flat.WriteString(", ")
+		}
+	}
+	if trailingComma {
+		flat.WriteString(",")
+	}
+	flat.WriteString(close)
+
+	if count == 0 || (!multiline && fits(col, flat.String())) {
+		return flat.String()
+	}
+
+	var wrapped bytes.Buffer
+	prefix := strings.Repeat(INDENT, indent+1)
+	wrapped.WriteString(open)
+	wrapped.WriteByte('\n')
+	for i := 0; i < count; i++ {
+		wrapped.WriteString(prefix)
+		wrapped.WriteString(item(i, indent+1, len(prefix)))
+		wrapped.WriteString(",\n")
+	}
+	wrapped.WriteString(strings.Repeat(INDENT, indent))
+	wrapped.WriteString(close)
+
+	return wrapped.String()
+}
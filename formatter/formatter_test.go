@@ -0,0 +1,143 @@
+package formatter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/javier-varez/monkey_interpreter/lexer"
+	"github.com/javier-varez/monkey_interpreter/parser"
+)
+
+var formatTests = []struct {
+	input    string
+	expected string
+}{
+	{"let a=1", "let a = 1;\n"},
+	{"let x:[int]=[1,2,3];x", "let x: [int] = [1, 2, 3];\nx\n"},
+	{"puts(1)\nputs(2)", "puts(1);\nputs(2)\n"},
+	{"return -(1+2)*3", "return -(1 + 2) * 3;\n"},
+	{"(1 + 2) * 3 - (4 - 5) - 6", "(1 + 2) * 3 - (4 - 5) - 6\n"},
+	{"1 + 2 * 3 == 7", "1 + 2 * 3 == 7\n"},
+	{"(1..2)[0] + len(1..n+1)", "(1..2)[0] + len(1..n + 1)\n"},
+	{"(-f)(1); -f(1)", "(-f)(1);\n-f(1)\n"},
+	{"let t = ((1,), (1, 2), ())", "let t = ((1,), (1, 2), ());\n"},
+	{"let m = {\"a\": 1, \"b\": {1, 2}}", "let m = {\"a\": 1, \"b\": {1, 2}};\n"},
+	{"let f = fn(a:int,b,...)->int{a}", "let f = fn(a: int, b, ...) -> int { a };\n"},
+	{"let f = fn(a) {\na\n}", "let f = fn(a) {\n    a\n};\n"},
+	{"if (a) { 1 } else { 2 }", "if (a) { 1 } else { 2 }\n"},
+	{"if (a) { 1 };\n(1, 2)", "if (a) { 1 };\n(1, 2)\n"},
+	{"if (a) { 1 }\nputs(2)", "if (a) { 1 }\nputs(2)\n"},
+	{"let a = 1;\n\n\n\nlet b = 2;", "let a = 1;\n\nlet b = 2;\n"},
+	{
+		"let f = fn() {\n\n  let a = 1;   // one\n\n  // two\n  a\n  // end\n}",
+		"let f = fn() {\n    let a = 1; // one\n\n    // two\n    a\n    // end\n};\n",
+	},
+	{"// only a comment", "// only a comment\n"},
+	{"let a = [\n  1, // one\n  2];", "// one\nlet a = [1, 2];\n"},
+	{
+		"let long = [aaaaaaaaaaaaaaaa, bbbbbbbbbbbbbbbbbbbbb, cccccccccccccccccccc, dddddddddddddddd]",
+		"let long = [\n    aaaaaaaaaaaaaaaa,\n    bbbbbbbbbbbbbbbbbbbbb,\n    cccccccccccccccccccc,\n    dddddddddddddddd,\n];\n",
+	},
+	{
+		"callable(\"a long string argument\", \"another even longer string argument\", fn(x) { x })",
+		"callable(\n    \"a long string argument\",\n    \"another even longer string argument\",\n    fn(x) { x },\n)\n",
+	},
+	{
+		"map(arr, fn(x) {\nx * 2\n})",
+		"map(arr, fn(x) {\n    x * 2\n})\n",
+	},
+	{
+		"outer(inner(longArgumentNumberOne, longArgumentNumberTwo, longArgumentNumberThree, c), x, y)",
+		"outer(\n    inner(\n        longArgumentNumberOne,\n        longArgumentNumberTwo,\n        longArgumentNumberThree,\n        c,\n    ),\n    x,\n    y,\n)\n",
+	},
+}
+
+func TestFormat(t *testing.T) {
+	for _, tt := range formatTests {
+		output, errors := Source(tt.input)
+		if len(errors) != 0 {
+			t.Fatalf("Unexpected diagnostics formatting %q: %v", tt.input, errors)
+		}
+
+		if output != tt.expected {
+			t.Errorf("Unexpected output formatting %q.\nExpected:\n%s\nGot:\n%s", tt.input, tt.expected, output)
+		}
+	}
+}
+
+// testRoundTrip checks that formatting is idempotent and that the formatted
+// program parses to the same tree as the original one.
+func testRoundTrip(t *testing.T, name, input string) {
+	output, errors := Source(input)
+	if len(errors) != 0 {
+		t.Fatalf("Unexpected diagnostics formatting %s: %v", name, errors)
+	}
+
+	again, errors := Source(output)
+	if len(errors) != 0 {
+		t.Fatalf("Formatted %s does not parse: %v\n%s", name, errors, output)
+	}
+	if again != output {
+		t.Errorf("Formatting %s is not idempotent.\nFirst:\n%s\nSecond:\n%s", name, output, again)
+	}
+
+	// Semicolons are normalized, so they are ignored in the comparison
+	original := parser.New(lexer.New(input)).ParseProgram()
+	formatted := parser.New(lexer.New(output)).ParseProgram()
+	if len(original.Statements) != len(formatted.Statements) {
+		t.Fatalf("Formatting %s changed the number of statements.\n%s", name, output)
+	}
+	for i := range original.Statements {
+		expected := strings.ReplaceAll(original.Statements[i].String(), ";", "")
+		got := strings.ReplaceAll(formatted.Statements[i].String(), ";", "")
+		if expected != got {
+			t.Errorf("Formatting %s changed the program.\nExpected: %s\nGot: %s", name, expected, got)
+		}
+	}
+}
+
+func TestFormatNestedLists(t *testing.T) {
+	input := "x"
+	for i := 0; i < 12; i++ {
+		input = "call(" + input + ", trailingArgumentNumberOne, trailingArgumentNumberTwo)"
+	}
+
+	output, errors := Source(input)
+	if len(errors) != 0 {
+		t.Fatalf("Unexpected diagnostics: %v", errors)
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if len(line) > MAX_WIDTH {
+			t.Errorf("Line is longer than %d columns: %q", MAX_WIDTH, line)
+		}
+	}
+	testRoundTrip(t, "nested lists", input)
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	for _, tt := range formatTests {
+		testRoundTrip(t, tt.input, tt.input)
+	}
+}
+
+func TestSamplesFormatted(t *testing.T) {
+	samples, err := filepath.Glob("../samples/*.monkey")
+	if err != nil || len(samples) == 0 {
+		t.Fatalf("Unable to find samples: %v", err)
+	}
+
+	for _, sample := range samples {
+		txt, err := os.ReadFile(sample)
+		if err != nil {
+			t.Fatalf("Unable to read sample %s: %v", sample, err)
+		}
+
+		testRoundTrip(t, sample, string(txt))
+
+		if output, _ := Source(string(txt)); output != string(txt) {
+			t.Errorf("Sample %s is not formatted, run `monkey fmt --write %s`", sample, sample)
+		}
+	}
+}
@@ -1,34 +1,47 @@
 package lexer
 
 import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"github.com/javier-varez/monkey_interpreter/token"
 )
 
 type Lexer struct {
-	input          string
-	position       int  // current position in input (points to current char)
-	ch             byte // current char under examination
-	currentLine    int  // Keeps track of the current line
-	lineByteOffset int  // Keeps track of the offset to the start of the line in input
+	input        string
+	position     int  // current position in input (points to current char)
+	readPosition int  // position of the next char in input
+	ch           rune // current char under examination
+	currentLine  int  // Keeps track of the current line
+	column       int  // Column of the current char in runes, relative to the start of the line
+	comments     []token.Token
 }
 
 func New(input string) *Lexer {
 	l := &Lexer{input: input}
-	l.ch = l.input[l.position]
+	l.decodeChar()
 	return l
 }
 
-func (l *Lexer) readChar() {
-	next := l.position + 1
-	if next >= len(l.input) {
+func (l *Lexer) decodeChar() {
+	if l.position >= len(l.input) {
 		l.ch = 0
-	} else {
-		l.ch = l.input[next]
+		l.readPosition = l.position + 1
+		return
 	}
-	l.position = next
+	ch, width := utf8.DecodeRuneInString(l.input[l.position:])
+	l.ch = ch
+	l.readPosition = l.position + width
+}
+
+func (l *Lexer) readChar() {
+	l.position = l.readPosition
+	l.column += 1
+	l.decodeChar()
 }
 
-func newToken(tokenType token.TokenType, literal byte, line, col int, text *string) token.Token {
+func newToken(tokenType token.TokenType, literal rune, line, col int, text *string) token.Token {
 	return token.Token{
 		Type:    tokenType,
 		Literal: string(literal),
@@ -49,75 +62,90 @@ func (l *Lexer) NextToken() token.Token {
 		if l.peekChar(1) == '=' {
 			tok.Type = token.EQ
 			ch := l.ch
-			position := l.position
+			column := l.column
 			l.readChar()
 			tok.Literal = string(ch) + string(l.ch)
 			tok.Span = token.Span{
 				Text:  &l.input,
-				Start: token.Location{Line: l.currentLine, Column: position - l.lineByteOffset},
-				End:   token.Location{Line: l.currentLine, Column: position + 2 - l.lineByteOffset},
+				Start: token.Location{Line: l.currentLine, Column: column},
+				End:   token.Location{Line: l.currentLine, Column: column + 2},
 			}
 		} else {
-			tok = newToken(token.ASSIGN, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+			tok = newToken(token.ASSIGN, l.ch, l.currentLine, l.column, &l.input)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+		tok = newToken(token.PLUS, l.ch, l.currentLine, l.column, &l.input)
 	case '(':
-		tok = newToken(token.LPAREN, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+		tok = newToken(token.LPAREN, l.ch, l.currentLine, l.column, &l.input)
 	case ')':
-		tok = newToken(token.RPAREN, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+		tok = newToken(token.RPAREN, l.ch, l.currentLine, l.column, &l.input)
 	case '{':
-		tok = newToken(token.LBRACE, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+		tok = newToken(token.LBRACE, l.ch, l.currentLine, l.column, &l.input)
 	case '}':
-		tok = newToken(token.RBRACE, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+		tok = newToken(token.RBRACE, l.ch, l.currentLine, l.column, &l.input)
 	case '[':
-		tok = newToken(token.LBRACKET, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+		tok = newToken(token.LBRACKET, l.ch, l.currentLine, l.column, &l.input)
 	case ']':
-		tok = newToken(token.RBRACKET, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+		tok = newToken(token.RBRACKET, l.ch, l.currentLine, l.column, &l.input)
 	case ':':
-		tok = newToken(token.COLON, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+		tok = newToken(token.COLON, l.ch, l.currentLine, l.column, &l.input)
 	case ';':
-		tok = newToken(token.SEMICOLON, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+		tok = newToken(token.SEMICOLON, l.ch, l.currentLine, l.column, &l.input)
 	case ',':
-		tok = newToken(token.COMMA, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+		tok = newToken(token.COMMA, l.ch, l.currentLine, l.column, &l.input)
 	case '!':
 		if l.peekChar(1) == '=' {
 			tok.Type = token.NOT_EQ
 			ch := l.ch
-			position := l.position
+			column := l.column
 			l.readChar()
 			tok.Literal = string(ch) + string(l.ch)
 			tok.Span = token.Span{
 				Text:  &l.input,
-				Start: token.Location{Line: l.currentLine, Column: position - l.lineByteOffset},
-				End:   token.Location{Line: l.currentLine, Column: position + 2 - l.lineByteOffset},
+				Start: token.Location{Line: l.currentLine, Column: column},
+				End:   token.Location{Line: l.currentLine, Column: column + 2},
 			}
 		} else {
-			tok = newToken(token.BANG, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+			tok = newToken(token.BANG, l.ch, l.currentLine, l.column, &l.input)
 		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+		if l.peekChar(1) == '>' {
+			tok.Type = token.ARROW
+			ch := l.ch
+			column := l.column
+			l.readChar()
+			tok.Literal = string(ch) + string(l.ch)
+			tok.Span = token.Span{
+				Text:  &l.input,
+				Start: token.Location{Line: l.currentLine, Column: column},
+				End:   token.Location{Line: l.currentLine, Column: column + 2},
+			}
+		} else {
+			tok = newToken(token.MINUS, l.ch, l.currentLine, l.column, &l.input)
+		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+		tok = newToken(token.ASTERISK, l.ch, l.currentLine, l.column, &l.input)
 	case '/':
-		tok = newToken(token.SLASH, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+		tok = newToken(token.SLASH, l.ch, l.currentLine, l.column, &l.input)
 	case '>':
-		tok = newToken(token.GT, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+		tok = newToken(token.GT, l.ch, l.currentLine, l.column, &l.input)
 	case '<':
-		tok = newToken(token.LT, l.ch, l.currentLine, l.position-l.lineByteOffset, &l.input)
+		tok = newToken(token.LT, l.ch, l.currentLine, l.column, &l.input)
+	case '|':
+		tok = newToken(token.PIPE, l.ch, l.currentLine, l.column, &l.input)
+	case '&':
+		tok = newToken(token.AMPERSAND, l.ch, l.currentLine, l.column, &l.input)
 	case '.':
 		return l.readDots()
 	case '"':
-		tok.Literal, tok.Span = l.readString()
-		tok.Type = token.STRING
-		return tok
+		return l.readString()
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
 		tok.Span = token.Span{
 			Text:  &l.input,
-			Start: token.Location{Line: l.currentLine, Column: l.position - l.lineByteOffset},
-			End:   token.Location{Line: l.currentLine, Column: l.position - l.lineByteOffset},
+			Start: token.Location{Line: l.currentLine, Column: l.column},
+			End:   token.Location{Line: l.currentLine, Column: l.column},
 		}
 	default:
 		if isLetter(l.ch) {
@@ -143,8 +171,8 @@ func (l *Lexer) illegalToken() token.Token {
 		Literal: string(l.ch),
 		Span: token.Span{
 			Text:  &l.input,
-			Start: token.Location{Line: l.currentLine, Column: l.position - l.lineByteOffset},
-			End:   token.Location{Line: l.currentLine, Column: l.position + 1 - l.lineByteOffset},
+			Start: token.Location{Line: l.currentLine, Column: l.column},
+			End:   token.Location{Line: l.currentLine, Column: l.column + 1},
 		},
 	}
 }
@@ -152,44 +180,63 @@ func (l *Lexer) illegalToken() token.Token {
 func (l *Lexer) readIdentifier() (string, token.Span) {
 	line := l.currentLine
 	startPos := l.position
+	startColumn := l.column
 	for isLetter(l.ch) {
 		l.readChar()
 	}
 	return l.input[startPos:l.position], token.Span{
 		Text:  &l.input,
-		Start: token.Location{Line: line, Column: startPos - l.lineByteOffset},
-		End:   token.Location{Line: line, Column: l.position - l.lineByteOffset},
+		Start: token.Location{Line: line, Column: startColumn},
+		End:   token.Location{Line: line, Column: l.column},
 	}
 }
 
 func (l *Lexer) readNumber() (string, token.Span) {
 	line := l.currentLine
 	startPos := l.position
+	startColumn := l.column
 	for isDigit(l.ch) {
 		l.readChar()
 	}
 	return l.input[startPos:l.position], token.Span{
 		Text:  &l.input,
-		Start: token.Location{Line: line, Column: startPos - l.lineByteOffset},
-		End:   token.Location{Line: line, Column: l.position - l.lineByteOffset},
+		Start: token.Location{Line: line, Column: startColumn},
+		End:   token.Location{Line: line, Column: l.column},
 	}
 }
 
-func (l *Lexer) readString() (string, token.Span) {
+func (l *Lexer) readString() token.Token {
 	line := l.currentLine
 	startPos := l.position
+	startColumn := l.column
 	// Skip the initial "
 	l.readChar()
 	for l.ch != '"' {
+		if l.ch == 0 && l.position >= len(l.input) {
+			return token.Token{
+				Type:    token.ILLEGAL,
+				Literal: l.input[startPos:],
+				Span: token.Span{
+					Text:  &l.input,
+					Start: token.Location{Line: line, Column: startColumn},
+					End:   token.Location{Line: l.currentLine, Column: l.column},
+				},
+			}
+		}
+		l.skipNewline()
 		l.readChar()
 	}
 
 	// Skip the last "
 	l.readChar()
-	return l.input[startPos:l.position], token.Span{
-		Text:  &l.input,
-		Start: token.Location{Line: line, Column: startPos - l.lineByteOffset},
-		End:   token.Location{Line: line, Column: l.position - l.lineByteOffset},
+	return token.Token{
+		Type:    token.STRING,
+		Literal: l.input[startPos:l.position],
+		Span: token.Span{
+			Text:  &l.input,
+			Start: token.Location{Line: line, Column: startColumn},
+			End:   token.Location{Line: l.currentLine, Column: l.column},
+		},
 	}
 }
 
@@ -199,10 +246,13 @@ func (l *Lexer) readDots() token.Token {
 	thirdDot := l.peekChar(2)
 
 	if firstDot != '.' || secondDot != '.' {
-		return l.illegalToken()
+		// Skip the lone dot, or the lexer would return it forever
+		tok := l.illegalToken()
+		l.readChar()
+		return tok
 	}
 
-	startPos := l.position
+	startColumn := l.column
 	literal := string(firstDot) + string(secondDot)
 	tokType := token.TWO_DOTS
 
@@ -220,35 +270,74 @@ func (l *Lexer) readDots() token.Token {
 		Literal: literal,
 		Span: token.Span{
 			Text:  &l.input,
-			Start: token.Location{Line: l.currentLine, Column: startPos - l.lineByteOffset},
-			End:   token.Location{Line: l.currentLine, Column: l.position - l.lineByteOffset},
+			Start: token.Location{Line: l.currentLine, Column: startColumn},
+			End:   token.Location{Line: l.currentLine, Column: l.column},
 		},
 	}
 }
 
 func (l *Lexer) skipWhitespace() {
-	for l.ch == '\n' || l.ch == '\r' || l.ch == ' ' || l.ch == '\t' {
-		if l.ch == '\n' {
-			l.currentLine += 1
-			l.lineByteOffset = l.position + 1
+	for {
+		if l.ch == '\n' || l.ch == '\r' || l.ch == ' ' || l.ch == '\t' {
+			l.skipNewline()
+			l.readChar()
+		} else if l.ch == '/' && l.peekChar(1) == '/' {
+			l.readComment()
+		} else {
+			return
 		}
+	}
+}
+
+// readComment skips a comment running from "//" to the end of the line. The
+// line break is left for skipWhitespace.
+func (l *Lexer) readComment() {
+	startPos := l.position
+	startColumn := l.column
+	for l.ch != '\n' && !(l.ch == 0 && l.position >= len(l.input)) {
 		l.readChar()
 	}
+
+	literal := strings.TrimRight(l.input[startPos:l.position], "\r")
+	l.comments = append(l.comments, token.Token{
+		Type:    token.COMMENT,
+		Literal: literal,
+		Span: token.Span{
+			Text:  &l.input,
+			Start: token.Location{Line: l.currentLine, Column: startColumn},
+			End:   token.Location{Line: l.currentLine, Column: startColumn + utf8.RuneCountInString(literal)},
+		},
+	})
+}
+
+// Comments returns the comments found so far, in the order they appear.
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
+// skipNewline starts a new line if the current char is a line break. The
+// column of the next char is then 0.
+func (l *Lexer) skipNewline() {
+	if l.ch == '\n' {
+		l.currentLine += 1
+		l.column = -1
+	}
 }
 
-func (l *Lexer) peekChar(offset int) byte {
+// peekChar returns the byte at the given offset from the current char. It is
+// only used to look for ASCII chars, so byte offsets are enough.
+func (l *Lexer) peekChar(offset int) rune {
 	next := l.position + offset
 	if next >= len(l.input) {
 		return 0
 	}
-	return l.input[next]
+	return rune(l.input[next])
 }
 
-func isLetter(ch byte) bool {
-	return (ch >= 'a' && ch <= 'z') ||
-		(ch >= 'A' && ch <= 'Z') || (ch == '_')
+func isLetter(ch rune) bool {
+	return unicode.IsLetter(ch) || (ch == '_')
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return ch >= '0' && ch <= '9'
 }
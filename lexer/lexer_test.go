@@ -45,6 +45,8 @@ if (5 < 10) {
 fn(...) { a(...) }
 1..2
 :
+| &
+-> - >
 `
 
 	tests := []token.Token{
@@ -143,7 +145,12 @@ fn(...) { a(...) }
 		{Type: token.TWO_DOTS, Literal: "..", Span: newSpan(23, 1, 2)},
 		{Type: token.INT, Literal: "2", Span: newSpan(23, 3, 1)},
 		{Type: token.COLON, Literal: ":", Span: newSpan(24, 0, 1)},
-		{Type: token.EOF, Literal: ``, Span: newSpan(25, 0, 0)},
+		{Type: token.PIPE, Literal: "|", Span: newSpan(25, 0, 1)},
+		{Type: token.AMPERSAND, Literal: "&", Span: newSpan(25, 2, 1)},
+		{Type: token.ARROW, Literal: "->", Span: newSpan(26, 0, 2)},
+		{Type: token.MINUS, Literal: "-", Span: newSpan(26, 3, 1)},
+		{Type: token.GT, Literal: ">", Span: newSpan(26, 5, 1)},
+		{Type: token.EOF, Literal: ``, Span: newSpan(27, 0, 0)},
 	}
 
 	l := New(input)
@@ -165,3 +172,117 @@ fn(...) { a(...) }
 		}
 	}
 }
+
+func TestUnicodeInput(t *testing.T) {
+	input := `let café = "ñandú 🐒";
+"multi
+line" + café
+"unterminated`
+
+	tests := []token.Token{
+		{Type: token.LET, Literal: "let", Span: newSpan(0, 0, 3)},
+		{Type: token.IDENT, Literal: "café", Span: newSpan(0, 4, 4)},
+		{Type: token.ASSIGN, Literal: "=", Span: newSpan(0, 9, 1)},
+		{Type: token.STRING, Literal: `"ñandú 🐒"`, Span: newSpan(0, 11, 9)},
+		{Type: token.SEMICOLON, Literal: ";", Span: newSpan(0, 20, 1)},
+		{Type: token.STRING, Literal: "\"multi\nline\"", Span: token.Span{
+			Start: token.Location{Line: 1, Column: 0},
+			End:   token.Location{Line: 2, Column: 5},
+		}},
+		{Type: token.PLUS, Literal: "+", Span: newSpan(2, 6, 1)},
+		{Type: token.IDENT, Literal: "café", Span: newSpan(2, 8, 4)},
+		{Type: token.ILLEGAL, Literal: `"unterminated`, Span: newSpan(3, 0, 13)},
+		{Type: token.EOF, Literal: ``, Span: newSpan(3, 13, 0)},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.Literal {
+			t.Fatalf("tests[%d] - tokenliteral wrong. expected=%v, got=%v", i, tt, tok)
+		}
+		if tok.Type != tt.Type {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%v, got=%v", i, tt, tok)
+		}
+		if tok.Span.Start != tt.Span.Start {
+			t.Fatalf("tests[%d] - tokenspan wrong. expected=%v, got=%v", i, tt, tok)
+		}
+
+		if tok.Span.End != tt.Span.End {
+			t.Fatalf("tests[%d] - tokenspan wrong. expected=%v, got=%v", i, tt, tok)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading comment
+let a = 4 / 2; // trailing "comment"
+"not // a comment" // ñandú`
+
+	tests := []token.Token{
+		{Type: token.LET, Literal: "let", Span: newSpan(1, 0, 3)},
+		{Type: token.IDENT, Literal: "a", Span: newSpan(1, 4, 1)},
+		{Type: token.ASSIGN, Literal: "=", Span: newSpan(1, 6, 1)},
+		{Type: token.INT, Literal: "4", Span: newSpan(1, 8, 1)},
+		{Type: token.SLASH, Literal: "/", Span: newSpan(1, 10, 1)},
+		{Type: token.INT, Literal: "2", Span: newSpan(1, 12, 1)},
+		{Type: token.SEMICOLON, Literal: ";", Span: newSpan(1, 13, 1)},
+		{Type: token.STRING, Literal: `"not // a comment"`, Span: newSpan(2, 0, 18)},
+		{Type: token.EOF, Literal: ``, Span: newSpan(2, 27, 0)},
+	}
+
+	expectedComments := []token.Token{
+		{Type: token.COMMENT, Literal: "// leading comment", Span: newSpan(0, 0, 18)},
+		{Type: token.COMMENT, Literal: `// trailing "comment"`, Span: newSpan(1, 15, 21)},
+		{Type: token.COMMENT, Literal: "// ñandú", Span: newSpan(2, 19, 8)},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.Literal || tok.Type != tt.Type {
+			t.Fatalf("tests[%d] - token wrong. expected=%v, got=%v", i, tt, tok)
+		}
+		if tok.Span.Start != tt.Span.Start || tok.Span.End != tt.Span.End {
+			t.Fatalf("tests[%d] - tokenspan wrong. expected=%v, got=%v", i, tt, tok)
+		}
+	}
+
+	comments := l.Comments()
+	if len(comments) != len(expectedComments) {
+		t.Fatalf("Unexpected number of comments. expected=%d, got=%d", len(expectedComments), len(comments))
+	}
+
+	for i, tt := range expectedComments {
+		comment := comments[i]
+		if comment.Literal != tt.Literal || comment.Type != tt.Type {
+			t.Errorf("comments[%d] - wrong comment. expected=%v, got=%v", i, tt, comment)
+		}
+		if comment.Span.Start != tt.Span.Start || comment.Span.End != tt.Span.End {
+			t.Errorf("comments[%d] - comment span wrong. expected=%v, got=%v", i, tt, comment)
+		}
+	}
+}
+
+func TestLoneDot(t *testing.T) {
+	tests := []token.Token{
+		{Type: token.INT, Literal: "2", Span: newSpan(0, 0, 1)},
+		{Type: token.ILLEGAL, Literal: ".", Span: newSpan(0, 1, 1)},
+		{Type: token.IDENT, Literal: "x", Span: newSpan(0, 3, 1)},
+		{Type: token.EOF, Literal: ``, Span: newSpan(0, 4, 0)},
+	}
+
+	l := New("2. x")
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.Type || tok.Literal != tt.Literal {
+			t.Fatalf("tests[%d] - token wrong. expected=%v, got=%v", i, tt, tok)
+		}
+		if tok.Span.Start != tt.Span.Start || tok.Span.End != tt.Span.End {
+			t.Fatalf("tests[%d] - tokenspan wrong. expected=%v, got=%v", i, tt, tok)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package linter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const (
+	UNUSED_LET         = "unused-let"
+	SHADOWED_NAME      = "shadowed-name"
+	UNREACHABLE_CODE   = "unreachable-code"
+	CONSTANT_CONDITION = "constant-condition"
+	SELF_COMPARISON    = "self-comparison"
+	BUILTIN_ARITY      = "builtin-arity"
+)
+
+// Rules lists the names of all the rules implemented by the linter.
+var Rules = []string{
+	UNUSED_LET,
+	SHADOWED_NAME,
+	UNREACHABLE_CODE,
+	CONSTANT_CONDITION,
+	SELF_COMPARISON,
+	BUILTIN_ARITY,
+}
+
+// Config selects the rules that are enabled. Rules missing from the map are
+// enabled. It is read from a JSON file like:
+//
+//	{ "rules": { "shadowed-name": false } }
+type Config struct {
+	Rules map[string]bool `json:"rules"`
+}
+
+// DefaultConfig returns a configuration with every rule enabled.
+func DefaultConfig() *Config {
+	return &Config{Rules: map[string]bool{}}
+}
+
+// LoadConfig reads the configuration stored in the given file.
+func LoadConfig(path string) (*Config, error) {
+	txt, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := DefaultConfig()
+	if err := json.Unmarshal(txt, config); err != nil {
+		return nil, fmt.Errorf("Invalid lint configuration %s: %w", path, err)
+	}
+
+	for rule := range config.Rules {
+		if !isRule(rule) {
+			return nil, fmt.Errorf("Unknown lint rule %q in %s", rule, path)
+		}
+	}
+
+	return config, nil
+}
+
+// Enabled reports whether the given rule should run.
+func (c *Config) Enabled(rule string) bool {
+	enabled, ok := c.Rules[rule]
+	return !ok || enabled
+}
+
+func isRule(name string) bool {
+	for _, rule := range Rules {
+		if rule == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,307 @@
+// Package linter reports code that is valid but most likely not what its
+// author intended, like unused bindings or comparisons of a value with itself.
+//
+// Names are bound following the same rules as the resolver: a let statement
+// binds its name after evaluating its value and blocks do not introduce a
+// scope.
+package linter
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/object"
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+// Diagnostic is a finding of one of the lint rules. It implements ast.Error.
+type Diagnostic struct {
+	Rule    string
+	Message string
+	span    token.Span
+}
+
+func (d *Diagnostic) Error() string {
+	return d.Message
+}
+
+func (d *Diagnostic) ContextualError() string {
+	return ast.FormatContextualError(d.span, fmt.Sprintf("%s [%s]", d.Message, d.Rule))
+}
+
+func (d *Diagnostic) Span() token.Span {
+	return d.span
+}
+
+type jsonLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// MarshalJSON encodes the diagnostic for tools. Lines and columns are
+// counted from 1, as most editors do.
+func (d *Diagnostic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Rule    string       `json:"rule"`
+		Message string       `json:"message"`
+		Start   jsonLocation `json:"start"`
+		End     jsonLocation `json:"end"`
+	}{
+		Rule:    d.Rule,
+		Message: d.Message,
+		Start:   jsonLocation{Line: d.span.Start.Line + 1, Column: d.span.Start.Column + 1},
+		End:     jsonLocation{Line: d.span.End.Line + 1, Column: d.span.End.Column + 1},
+	})
+}
+
+type binding struct {
+	name string
+	span token.Span
+	used bool
+	// arity is only meaningful for builtins, which have no span.
+	builtin bool
+	arity   int
+}
+
+type scope struct {
+	bindings map[string]*binding
+	outer    *scope
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{bindings: map[string]*binding{}, outer: outer}
+}
+
+func (s *scope) lookup(name string) *binding {
+	for current := s; current != nil; current = current.outer {
+		if b, ok := current.bindings[name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+type linter struct {
+	config      *Config
+	diagnostics []*Diagnostic
+	scope       *scope
+	lets        []*binding
+}
+
+// Lint runs the rules enabled in config over a program that was parsed
+// without diagnostics. The findings are sorted by their location.
+func Lint(program *ast.Program, config *Config) []*Diagnostic {
+	builtins := newScope(nil)
+	for _, builtin := range object.Builtins {
+		builtins.bindings[builtin.Name] = &binding{
+			name:    builtin.Name,
+			builtin: true,
+			arity:   builtin.Arity,
+		}
+	}
+
+	l := &linter{config: config, diagnostics: []*Diagnostic{}, scope: newScope(builtins)}
+	l.lintStatements(program.Statements)
+
+	for _, b := range l.lets {
+		if !b.used && !strings.HasPrefix(b.name, "_") {
+			l.report(UNUSED_LET, b.span, fmt.Sprintf("Binding %s is never used", b.name))
+		}
+	}
+
+	sort.SliceStable(l.diagnostics, func(i, j int) bool {
+		a, b := l.diagnostics[i].span.Start, l.diagnostics[j].span.Start
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+	return l.diagnostics
+}
+
+func (l *linter) report(rule string, span token.Span, msg string) {
+	if l.config.Enabled(rule) {
+		l.diagnostics = append(l.diagnostics, &Diagnostic{Rule: rule, Message: msg, span: span})
+	}
+}
+
+func (l *linter) define(ident *ast.IdentifierExpr, isLet bool) {
+	name := ident.IdentToken.Literal
+	if _, ok := l.scope.bindings[name]; !ok {
+		if shadowed := l.scope.outer.lookup(name); shadowed != nil {
+			if shadowed.builtin {
+				l.report(SHADOWED_NAME, ident.Span(), fmt.Sprintf("Binding %s shadows a builtin", name))
+			} else {
+				l.report(SHADOWED_NAME, ident.Span(), fmt.Sprintf("Binding %s shadows a binding of an outer scope", name))
+			}
+		}
+	}
+
+	b := &binding{name: name, span: ident.Span()}
+	l.scope.bindings[name] = b
+	// Only let bindings are reported when unused, parameters are often
+	// required by the caller.
+	if isLet {
+		l.lets = append(l.lets, b)
+	}
+}
+
+func (l *linter) lintStatements(stmts []ast.Statment) {
+	for i, stmt := range stmts {
+		l.lintStatement(stmt)
+
+		if _, ok := stmt.(*ast.ReturnStatement); ok && i != len(stmts)-1 {
+			span := stmts[i+1].Span().Join(stmts[len(stmts)-1].Span())
+			l.report(UNREACHABLE_CODE, span, "Unreachable code after return statement")
+			l.lintStatements(stmts[i+1:])
+			return
+		}
+	}
+}
+
+func (l *linter) lintStatement(stmt ast.Statment) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		l.lintExpression(stmt.Expr)
+		if ident, ok := stmt.IdentExpr.(*ast.IdentifierExpr); ok {
+			l.define(ident, true)
+		}
+	case *ast.ReturnStatement:
+		l.lintExpression(stmt.Expr)
+	case *ast.ExpressionStatement:
+		l.lintExpression(stmt.Expr)
+	case *ast.BlockStatement:
+		l.lintStatements(stmt.Statements)
+	}
+}
+
+func (l *linter) lintExpressions(exprs []ast.Expression) {
+	for _, expr := range exprs {
+		l.lintExpression(expr)
+	}
+}
+
+func (l *linter) lintExpression(expr ast.Expression) {
+	switch expr := expr.(type) {
+	case *ast.IdentifierExpr:
+		if b := l.scope.lookup(expr.IdentToken.Literal); b != nil {
+			b.used = true
+		}
+	case *ast.PrefixExpr:
+		l.lintExpression(expr.InnerExpr)
+	case *ast.InfixExpr:
+		l.lintSelfComparison(expr)
+		l.lintExpression(expr.LeftExpr)
+		l.lintExpression(expr.RightExpr)
+	case *ast.IfExpr:
+		if isConstant(expr.Condition) {
+			l.report(CONSTANT_CONDITION, expr.Condition.Span(), "Condition of if expression is constant")
+		}
+		l.lintExpression(expr.Condition)
+		l.lintStatements(expr.Consequence.Statements)
+		if expr.Alternative != nil {
+			l.lintStatements(expr.Alternative.Statements)
+		}
+	case *ast.FnLiteralExpr:
+		l.scope = newScope(l.scope)
+		for _, arg := range expr.Args {
+			l.define(arg, false)
+		}
+		l.lintStatements(expr.Body.Statements)
+		l.scope = l.scope.outer
+	case *ast.CallExpr:
+		l.lintBuiltinArity(expr)
+		l.lintExpression(expr.CallableExpr)
+		l.lintExpressions(expr.Args)
+	case *ast.ArrayLiteralExpr:
+		l.lintExpressions(expr.Elems)
+	case *ast.SetLiteralExpr:
+		l.lintExpressions(expr.Elems)
+	case *ast.TupleLiteralExpr:
+		l.lintExpressions(expr.Elems)
+	case *ast.MapLiteralExpr:
+		for _, entry := range expr.Entries {
+			l.lintExpression(entry.Key)
+			l.lintExpression(entry.Value)
+		}
+	case *ast.IndexOperatorExpr:
+		l.lintExpression(expr.ObjExpr)
+		l.lintExpression(expr.IndexExpr)
+	case *ast.RangeExpr:
+		l.lintExpression(expr.StartExpr)
+		l.lintExpression(expr.EndExpr)
+	}
+}
+
+func (l *linter) lintSelfComparison(expr *ast.InfixExpr) {
+	var result bool
+	switch expr.OperatorToken.Type {
+	case token.EQ:
+		result = true
+	case token.NOT_EQ, token.LT, token.GT:
+		result = false
+	default:
+		return
+	}
+
+	if !isPure(expr.LeftExpr) || expr.LeftExpr.String() != expr.RightExpr.String() {
+		return
+	}
+
+	msg := fmt.Sprintf("Comparison of %s with itself is always %t", expr.LeftExpr.String(), result)
+	l.report(SELF_COMPARISON, expr.Span(), msg)
+}
+
+func (l *linter) lintBuiltinArity(expr *ast.CallExpr) {
+	ident, ok := expr.CallableExpr.(*ast.IdentifierExpr)
+	if !ok {
+		return
+	}
+
+	b := l.scope.lookup(ident.IdentToken.Literal)
+	if b == nil || !b.builtin || b.arity == object.VARIADIC {
+		return
+	}
+
+	// The number of arguments expanded from var args is not known
+	for _, arg := range expr.Args {
+		if _, ok := arg.(*ast.VarArgsLiteralExpr); ok {
+			return
+		}
+	}
+
+	if len(expr.Args) != b.arity {
+		msg := fmt.Sprintf("Builtin %s expects %d arguments, got %d", b.name, b.arity, len(expr.Args))
+		l.report(BUILTIN_ARITY, expr.Span(), msg)
+	}
+}
+
+// isConstant reports whether the value of an expression is known without
+// running the program.
+func isConstant(expr ast.Expression) bool {
+	switch expr := expr.(type) {
+	case *ast.IntegerLiteralExpr, *ast.BoolLiteralExpr, *ast.StringLiteralExpr:
+		return true
+	case *ast.PrefixExpr:
+		return isConstant(expr.InnerExpr)
+	case *ast.InfixExpr:
+		return isConstant(expr.LeftExpr) && isConstant(expr.RightExpr)
+	}
+	return false
+}
+
+// isPure reports whether evaluating an expression twice is guaranteed to
+// produce the same value, which excludes function calls.
+func isPure(expr ast.Expression) bool {
+	switch expr := expr.(type) {
+	case *ast.IdentifierExpr, *ast.IntegerLiteralExpr, *ast.BoolLiteralExpr, *ast.StringLiteralExpr:
+		return true
+	case *ast.PrefixExpr:
+		return isPure(expr.InnerExpr)
+	case *ast.InfixExpr:
+		return isPure(expr.LeftExpr) && isPure(expr.RightExpr)
+	case *ast.IndexOperatorExpr:
+		return isPure(expr.ObjExpr) && isPure(expr.IndexExpr)
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package linter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/lexer"
+	"github.com/javier-varez/monkey_interpreter/parser"
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(program.Diagnostics) != 0 {
+		for _, err := range program.Diagnostics {
+			t.Errorf("%s", err.ContextualError())
+		}
+		t.Fatalf("Unrecoverable program diagnostics")
+	}
+	return program
+}
+
+func TestLintRules(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`let a = 1; puts(a)`, []string{}},
+		{`let a = 1;`, []string{"Binding a is never used"}},
+		{`let _a = 1;`, []string{}},
+		{`let a = 1; let a = 2; puts(a)`, []string{"Binding a is never used"}},
+		{`let a = 1; let a = a + 1; puts(a)`, []string{}},
+		{`let f = fn(x) { let y = 2; x }; f(1)`, []string{"Binding y is never used"}},
+		{`let f = fn(unused) { 1 }; f(1)`, []string{}},
+		{`let x = 1; let f = fn(x) { x }; f(x)`, []string{"Binding x shadows a binding of an outer scope"}},
+		{`let f = fn() { let len = 1; len }; f()`, []string{"Binding len shadows a builtin"}},
+		{`let f = fn(a) { if (a) { return 1; puts(a); } 2 }; f(true)`, []string{"Unreachable code after return statement"}},
+		{`let f = fn(a) { return a; }; f(1)`, []string{}},
+		{`if (true) { 1 }`, []string{"Condition of if expression is constant"}},
+		{`if (1 < 2) { 1 }`, []string{"Condition of if expression is constant"}},
+		{`let a = 1; if (a < 2) { 1 }`, []string{}},
+		{`let a = 1; a == a`, []string{"Comparison of a with itself is always true"}},
+		{`let a = [1]; a[0] != a[0]`, []string{"Comparison of a[0] with itself is always false"}},
+		{`let f = fn() { 1 }; f() == f()`, []string{}},
+		{`len([1], 2)`, []string{"Builtin len expects 1 arguments, got 2"}},
+		{`push([1])`, []string{"Builtin push expects 2 arguments, got 1"}},
+		{`puts(1, 2, 3)`, []string{}},
+		{`let f = fn(...) { len(...) }; f(1)`, []string{}},
+		{`let len = fn(a, b) { a }; len(1, 2)`, []string{"Binding len shadows a builtin"}},
+	}
+
+	for _, tt := range tests {
+		diagnostics := Lint(parse(t, tt.input), DefaultConfig())
+		if len(diagnostics) != len(tt.expected) {
+			t.Errorf("Unexpected number of diagnostics in %q. Expected %d, got %d: %v", tt.input, len(tt.expected), len(diagnostics), diagnostics)
+			continue
+		}
+
+		for i, diag := range diagnostics {
+			if diag.Error() != tt.expected[i] {
+				t.Errorf("Unexpected diagnostic in %q. Expected %q, got %q", tt.input, tt.expected[i], diag.Error())
+			}
+		}
+	}
+}
+
+func TestLintDiagnosticOrder(t *testing.T) {
+	input := `let a = 1; let b = 2; puts(b == b)`
+	expected := []string{UNUSED_LET, SELF_COMPARISON}
+
+	diagnostics := Lint(parse(t, input), DefaultConfig())
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("Unexpected number of diagnostics. Expected %d, got %d", len(expected), len(diagnostics))
+	}
+
+	for i, diag := range diagnostics {
+		if diag.Rule != expected[i] {
+			t.Errorf("Unexpected rule for diagnostic %d. Expected %s, got %s", i, expected[i], diag.Rule)
+		}
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "lint.json")
+	if err := os.WriteFile(path, []byte(`{"rules": {"unused-let": false}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+
+	if config.Enabled(UNUSED_LET) || !config.Enabled(SHADOWED_NAME) {
+		t.Errorf("Unexpected enabled rules: %v", config.Rules)
+	}
+
+	diagnostics := Lint(parse(t, `let a = 1; if (true) { 1 }`), config)
+	if len(diagnostics) != 1 || diagnostics[0].Rule != CONSTANT_CONDITION {
+		t.Errorf("Unexpected diagnostics: %v", diagnostics)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"rules": {"no-such-rule": false}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadConfig(path); err == nil {
+		t.Errorf("Expected an error for an unknown rule")
+	}
+}
+
+func TestDiagnosticJSON(t *testing.T) {
+	diagnostics := Lint(parse(t, "let a = 1;\nlet b = 2; puts(a)"), DefaultConfig())
+	if len(diagnostics) != 1 {
+		t.Fatalf("Expected a single diagnostic, got %v", diagnostics)
+	}
+
+	span := diagnostics[0].Span()
+	expectedSpan := token.Span{
+		Start: token.Location{Line: 1, Column: 4},
+		End:   token.Location{Line: 1, Column: 5},
+	}
+	if span.Start != expectedSpan.Start || span.End != expectedSpan.End {
+		t.Errorf("Unexpected span. Expected %v, got %v", expectedSpan, span)
+	}
+
+	encoded, err := json.Marshal(diagnostics)
+	if err != nil {
+		t.Fatalf("Unexpected error encoding diagnostics: %v", err)
+	}
+
+	expected := `[{"rule":"unused-let","message":"Binding b is never used","start":{"line":2,"column":5},"end":{"line":2,"column":6}}]`
+	if string(encoded) != expected {
+		t.Errorf("Unexpected JSON. Expected %s, got %s", expected, encoded)
+	}
+}
@@ -0,0 +1,76 @@
+package lsp
+
+import (
+	"strings"
+	"unicode/utf16"
+
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/lexer"
+	"github.com/javier-varez/monkey_interpreter/parser"
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+// document is an open text document and the result of analyzing it.
+type document struct {
+	uri     string
+	text    string
+	lines   []string
+	program *ast.Program
+	index   *index
+}
+
+func newDocument(uri, text string) *document {
+	doc := &document{
+		uri:   uri,
+		text:  text,
+		lines: strings.Split(text, "\n"),
+	}
+	doc.program = parser.New(lexer.New(text)).ParseProgram()
+	doc.index = newIndex(doc, doc.program)
+	return doc
+}
+
+// toPosition converts a location of a token, with columns counted in runes,
+// to an LSP position, with characters counted in UTF-16 code units.
+func (d *document) toPosition(loc token.Location) Position {
+	if loc.Line >= len(d.lines) {
+		return Position{Line: loc.Line, Character: loc.Column}
+	}
+
+	line := []rune(d.lines[loc.Line])
+	if loc.Column < len(line) {
+		line = line[:loc.Column]
+	}
+	return Position{Line: loc.Line, Character: len(utf16.Encode(line))}
+}
+
+func (d *document) toRange(span token.Span) Range {
+	return Range{Start: d.toPosition(span.Start), End: d.toPosition(span.End)}
+}
+
+// toLocation is the inverse of toPosition.
+func (d *document) toLocation(pos Position) token.Location {
+	if pos.Line >= len(d.lines) {
+		return token.Location{Line: pos.Line, Column: pos.Character}
+	}
+
+	column := 0
+	units := 0
+	for _, r := range d.lines[pos.Line] {
+		if units >= pos.Character {
+			break
+		}
+		units += utf16.RuneLen(r)
+		column++
+	}
+	return token.Location{Line: pos.Line, Column: column}
+}
+
+// fullRange is the range covering the whole document.
+func (d *document) fullRange() Range {
+	last := len(d.lines) - 1
+	return Range{
+		Start: Position{Line: 0, Character: 0},
+		End:   Position{Line: last, Character: len(utf16.Encode([]rune(d.lines[last])))},
+	}
+}
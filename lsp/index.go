@@ -0,0 +1,237 @@
+package lsp
+
+import (
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/compiler"
+	"github.com/javier-varez/monkey_interpreter/object"
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+type definitionKind int
+
+const (
+	letDefinition definitionKind = iota
+	paramDefinition
+	builtinDefinition
+)
+
+type definition struct {
+	name string
+	kind definitionKind
+	// span is the identifier that defines the name. Builtins have none.
+	span token.Span
+	// annotation is the type of the definition, if it was annotated.
+	annotation ast.TypeExpr
+}
+
+// reference is an identifier of the program, including the ones that
+// define names, with the symbol the compiler resolves it to.
+type reference struct {
+	span       token.Span
+	symbol     compiler.Symbol
+	definition *definition
+}
+
+// index maps the identifiers of a program to their definitions. Names are
+// resolved with the symbol tables of the compiler, so that hovers show where
+// the VM stores each value.
+type index struct {
+	references []*reference
+	symbols    []DocumentSymbol
+}
+
+type indexScope struct {
+	table       *compiler.SymbolTable
+	definitions map[string]*definition
+	outer       *indexScope
+}
+
+func (s *indexScope) lookup(name string) *definition {
+	for current := s; current != nil; current = current.outer {
+		if def, ok := current.definitions[name]; ok {
+			return def
+		}
+	}
+	return nil
+}
+
+type indexer struct {
+	index *index
+	scope *indexScope
+	doc   *document
+}
+
+// newIndex indexes a program. Programs with parse errors are indexed as far
+// as possible, skipping the statements that could not be parsed.
+func newIndex(doc *document, program *ast.Program) *index {
+	table := compiler.NewSymbolTable()
+	definitions := map[string]*definition{}
+	for i, builtin := range object.Builtins {
+		table.DefineBuiltin(i, builtin.Name)
+		definitions[builtin.Name] = &definition{name: builtin.Name, kind: builtinDefinition}
+	}
+
+	ix := &indexer{
+		index: &index{},
+		scope: &indexScope{table: table, definitions: definitions},
+		doc:   doc,
+	}
+	ix.index.symbols = ix.statements(program.Statements)
+	return ix.index
+}
+
+// lookup returns the reference at the given location.
+func (ix *index) lookup(loc token.Location) *reference {
+	for _, ref := range ix.references {
+		if !before(loc, ref.span.Start) && !before(ref.span.End, loc) {
+			return ref
+		}
+	}
+	return nil
+}
+
+func before(a, b token.Location) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+func (ix *indexer) define(ident *ast.IdentifierExpr, kind definitionKind, annotation ast.TypeExpr) {
+	name := ident.IdentToken.Literal
+	def := &definition{name: name, kind: kind, span: ident.Span(), annotation: annotation}
+	ix.scope.definitions[name] = def
+
+	symbol := ix.scope.table.Define(name)
+	ix.index.references = append(ix.index.references, &reference{
+		span:       ident.Span(),
+		symbol:     symbol,
+		definition: def,
+	})
+}
+
+// statements indexes a list of statements, returning the document symbols of
+// the let statements in it.
+func (ix *indexer) statements(stmts []ast.Statment) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	for _, stmt := range stmts {
+		symbols = append(symbols, ix.statement(stmt)...)
+	}
+	return symbols
+}
+
+func (ix *indexer) statement(stmt ast.Statment) []DocumentSymbol {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		if stmt == nil {
+			return nil
+		}
+
+		children := ix.expression(stmt.Expr)
+		ident, ok := stmt.IdentExpr.(*ast.IdentifierExpr)
+		if !ok {
+			return children
+		}
+		ix.define(ident, letDefinition, stmt.Type)
+
+		kind := SymbolKindVariable
+		if _, ok := stmt.Expr.(*ast.FnLiteralExpr); ok {
+			kind = SymbolKindFunction
+		}
+
+		span := stmt.LetToken.Span.Join(ident.Span())
+		if stmt.Expr != nil {
+			span = span.Join(stmt.Expr.Span())
+		}
+		return []DocumentSymbol{{
+			Name:           ident.IdentToken.Literal,
+			Kind:           kind,
+			Range:          ix.doc.toRange(span),
+			SelectionRange: ix.doc.toRange(ident.Span()),
+			Children:       children,
+		}}
+	case *ast.ReturnStatement:
+		if stmt != nil {
+			return ix.expression(stmt.Expr)
+		}
+	case *ast.ExpressionStatement:
+		if stmt != nil {
+			return ix.expression(stmt.Expr)
+		}
+	}
+	return nil
+}
+
+func (ix *indexer) expressions(exprs []ast.Expression) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	for _, expr := range exprs {
+		symbols = append(symbols, ix.expression(expr)...)
+	}
+	return symbols
+}
+
+// expression indexes the identifiers of an expression, returning the
+// document symbols of the functions defined in it.
+func (ix *indexer) expression(expr ast.Expression) []DocumentSymbol {
+	switch expr := expr.(type) {
+	case *ast.IdentifierExpr:
+		name := expr.IdentToken.Literal
+		if symbol, ok := ix.scope.table.Resolve(name); ok {
+			ix.index.references = append(ix.index.references, &reference{
+				span:       expr.Span(),
+				symbol:     symbol,
+				definition: ix.scope.lookup(name),
+			})
+		}
+	case *ast.PrefixExpr:
+		return ix.expression(expr.InnerExpr)
+	case *ast.InfixExpr:
+		return ix.expressions([]ast.Expression{expr.LeftExpr, expr.RightExpr})
+	case *ast.RangeExpr:
+		return ix.expressions([]ast.Expression{expr.StartExpr, expr.EndExpr})
+	case *ast.IfExpr:
+		symbols := ix.expression(expr.Condition)
+		if expr.Consequence != nil {
+			symbols = append(symbols, ix.statements(expr.Consequence.Statements)...)
+		}
+		if expr.Alternative != nil {
+			symbols = append(symbols, ix.statements(expr.Alternative.Statements)...)
+		}
+		return symbols
+	case *ast.FnLiteralExpr:
+		return ix.fnLiteral(expr)
+	case *ast.CallExpr:
+		return append(ix.expression(expr.CallableExpr), ix.expressions(expr.Args)...)
+	case *ast.IndexOperatorExpr:
+		return ix.expressions([]ast.Expression{expr.ObjExpr, expr.IndexExpr})
+	case *ast.ArrayLiteralExpr:
+		return ix.expressions(expr.Elems)
+	case *ast.SetLiteralExpr:
+		return ix.expressions(expr.Elems)
+	case *ast.TupleLiteralExpr:
+		return ix.expressions(expr.Elems)
+	case *ast.MapLiteralExpr:
+		symbols := []DocumentSymbol{}
+		for _, entry := range expr.Entries {
+			symbols = append(symbols, ix.expressions([]ast.Expression{entry.Key, entry.Value})...)
+		}
+		return symbols
+	}
+	return nil
+}
+
+func (ix *indexer) fnLiteral(expr *ast.FnLiteralExpr) []DocumentSymbol {
+	ix.scope = &indexScope{
+		table:       compiler.NewEnclosedSymbolTable(ix.scope.table),
+		definitions: map[string]*definition{},
+		outer:       ix.scope,
+	}
+
+	for i, arg := range expr.Args {
+		ix.define(arg, paramDefinition, expr.ArgTypes[i])
+	}
+	if expr.VarArgs {
+		ix.scope.table.Define(compiler.INTERNAL_VARARGS)
+	}
+
+	symbols := ix.statements(expr.Body.Statements)
+	ix.scope = ix.scope.outer
+	return symbols
+}
@@ -0,0 +1,340 @@
+// Package lsp implements a Language Server Protocol server for Monkey, so that
+// editors can show diagnostics, hovers and symbols of the programs being
+// edited. The server speaks JSON-RPC over any reader and writer, usually the
+// standard input and output of the `monkey lsp` command.
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/checker"
+	"github.com/javier-varez/monkey_interpreter/compiler"
+	"github.com/javier-varez/monkey_interpreter/formatter"
+	"github.com/javier-varez/monkey_interpreter/linter"
+	"github.com/javier-varez/monkey_interpreter/object"
+	"github.com/javier-varez/monkey_interpreter/resolver"
+)
+
+type handler func(s *Server, params json.RawMessage) (interface{}, error)
+
+var handlers = map[string]handler{
+	"initialize":                  (*Server).initialize,
+	"initialized":                 (*Server).ignore,
+	"shutdown":                    (*Server).shutdown,
+	"textDocument/didOpen":        (*Server).didOpen,
+	"textDocument/didChange":      (*Server).didChange,
+	"textDocument/didClose":       (*Server).didClose,
+	"textDocument/didSave":        (*Server).ignore,
+	"textDocument/hover":          (*Server).hover,
+	"textDocument/definition":     (*Server).definition,
+	"textDocument/completion":     (*Server).completion,
+	"textDocument/documentSymbol": (*Server).documentSymbol,
+	"textDocument/formatting":     (*Server).formatting,
+}
+
+type Server struct {
+	conn       *conn
+	documents  map[string]*document
+	isShutdown bool
+}
+
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{conn: newConn(r, w), documents: map[string]*document{}}
+}
+
+// Run serves requests until the client sends the exit notification or
+// closes the connection.
+func (s *Server) Run() error {
+	for {
+		content, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			if err := s.conn.write(&errorResponse{JSONRPC: "2.0", Error: &responseError{Code: parseErrorCode, Message: err.Error()}}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if req.Method == "exit" {
+			return nil
+		}
+
+		if err := s.handle(&req); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(req *request) error {
+	h, ok := handlers[req.Method]
+
+	// Notifications get no response, even if they fail
+	if req.ID == nil {
+		if ok {
+			h(s, req.Params)
+		}
+		return nil
+	}
+
+	if !ok {
+		return s.reply(req, nil, &responseError{Code: methodNotFoundCode, Message: fmt.Sprintf("Unknown method %s", req.Method)})
+	}
+	if s.isShutdown {
+		return s.reply(req, nil, &responseError{Code: invalidRequestCode, Message: "The server is shutting down"})
+	}
+
+	result, err := h(s, req.Params)
+	return s.reply(req, result, err)
+}
+
+func (s *Server) reply(req *request, result interface{}, err error) error {
+	if err != nil {
+		respErr, ok := err.(*responseError)
+		if !ok {
+			respErr = &responseError{Code: invalidParamsCode, Message: err.Error()}
+		}
+		return s.conn.write(&errorResponse{JSONRPC: "2.0", ID: req.ID, Error: respErr})
+	}
+	return s.conn.write(&response{JSONRPC: "2.0", ID: req.ID, Result: result})
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	return s.conn.write(&notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) ignore(params json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+func (s *Server) initialize(params json.RawMessage) (interface{}, error) {
+	return &InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync:           SyncFull,
+			HoverProvider:              true,
+			DefinitionProvider:         true,
+			CompletionProvider:         struct{}{},
+			DocumentSymbolProvider:     true,
+			DocumentFormattingProvider: true,
+		},
+		ServerInfo: ServerInfo{Name: "monkey"},
+	}, nil
+}
+
+func (s *Server) shutdown(params json.RawMessage) (interface{}, error) {
+	s.isShutdown = true
+	return nil, nil
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	doc, ok := s.documents[uri]
+	if !ok {
+		return nil, fmt.Errorf("Unknown document %s", uri)
+	}
+	return doc, nil
+}
+
+func (s *Server) didOpen(params json.RawMessage) (interface{}, error) {
+	var p DidOpenTextDocumentParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	return nil, s.update(p.TextDocument.URI, p.TextDocument.Text)
+}
+
+func (s *Server) didChange(params json.RawMessage) (interface{}, error) {
+	var p DidChangeTextDocumentParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	if len(p.ContentChanges) == 0 {
+		return nil, nil
+	}
+	return nil, s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+}
+
+func (s *Server) didClose(params json.RawMessage) (interface{}, error) {
+	var p DidCloseTextDocumentParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	delete(s.documents, p.TextDocument.URI)
+	return nil, s.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []Diagnostic{}})
+}
+
+// update analyzes the new text of a document and publishes its diagnostics.
+func (s *Server) update(uri, text string) error {
+	doc := newDocument(uri, text)
+	s.documents[uri] = doc
+
+	return s.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: diagnostics(doc),
+	})
+}
+
+// diagnostics runs the same passes as the run command, followed by the
+// linter. Each pass only runs if the previous ones found no errors.
+func diagnostics(doc *document) []Diagnostic {
+	result := []Diagnostic{}
+	addErrors := func(errors []ast.Error) {
+		for _, err := range errors {
+			result = append(result, Diagnostic{
+				Range:    doc.toRange(err.Span()),
+				Severity: SeverityError,
+				Source:   "monkey",
+				Message:  err.Error(),
+			})
+		}
+	}
+
+	addErrors(doc.program.Diagnostics)
+	if len(result) == 0 {
+		addErrors(resolver.Resolve(doc.program))
+	}
+	if len(result) == 0 {
+		addErrors(checker.Check(doc.program))
+	}
+	if len(doc.program.Diagnostics) != 0 {
+		return result
+	}
+
+	for _, diag := range linter.Lint(doc.program, linter.DefaultConfig()) {
+		result = append(result, Diagnostic{
+			Range:    doc.toRange(diag.Span()),
+			Severity: SeverityWarning,
+			Code:     diag.Rule,
+			Source:   "monkey-lint",
+			Message:  diag.Message,
+		})
+	}
+	return result
+}
+
+func (s *Server) hover(params json.RawMessage) (interface{}, error) {
+	var p TextDocumentPositionParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := doc.index.lookup(doc.toLocation(p.Position))
+	if ref == nil || ref.definition == nil {
+		return nil, nil
+	}
+
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: hoverText(ref)},
+		Range:    doc.toRange(ref.span),
+	}, nil
+}
+
+// hoverText describes the definition of a name and the symbol it resolves
+// to, like:
+//
+//	let x: int
+//	Global scope, index 0
+func hoverText(ref *reference) string {
+	var buffer strings.Builder
+	def := ref.definition
+
+	buffer.WriteString("```monkey\n")
+	switch def.kind {
+	case letDefinition:
+		buffer.WriteString("let ")
+	case builtinDefinition:
+		buffer.WriteString("builtin ")
+	}
+	buffer.WriteString(def.name)
+	if def.annotation != nil {
+		buffer.WriteString(": ")
+		buffer.WriteString(def.annotation.String())
+	}
+	if def.kind == paramDefinition {
+		buffer.WriteString(" (parameter)")
+	}
+	buffer.WriteString("\n```\n")
+
+	buffer.WriteString(fmt.Sprintf("%s, index %d", ref.symbol.Scope, ref.symbol.Index))
+	if ref.symbol.Scope == compiler.FreeScope {
+		buffer.WriteString(", captured by the closure")
+	}
+	return buffer.String()
+}
+
+func (s *Server) definition(params json.RawMessage) (interface{}, error) {
+	var p TextDocumentPositionParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := doc.index.lookup(doc.toLocation(p.Position))
+	if ref == nil || ref.definition == nil || ref.definition.kind == builtinDefinition {
+		return nil, nil
+	}
+
+	return &Location{URI: doc.uri, Range: doc.toRange(ref.definition.span)}, nil
+}
+
+func (s *Server) completion(params json.RawMessage) (interface{}, error) {
+	items := []CompletionItem{}
+	for _, builtin := range object.Builtins {
+		detail := fmt.Sprintf("builtin taking %d arguments", builtin.Arity)
+		if builtin.Arity == object.VARIADIC {
+			detail = "builtin taking any number of arguments"
+		}
+		items = append(items, CompletionItem{Label: builtin.Name, Kind: CompletionKindFunction, Detail: detail})
+	}
+	return items, nil
+}
+
+func (s *Server) documentSymbol(params json.RawMessage) (interface{}, error) {
+	var p DocumentSymbolParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	return doc.index.symbols, nil
+}
+
+func (s *Server) formatting(params json.RawMessage) (interface{}, error) {
+	var p DocumentFormattingParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	// Programs with errors are left as they are
+	if len(doc.program.Diagnostics) != 0 {
+		return nil, nil
+	}
+
+	formatted := formatter.Format(doc.program)
+	if formatted == doc.text {
+		return []TextEdit{}, nil
+	}
+	return []TextEdit{{Range: doc.fullRange(), NewText: formatted}}, nil
+}
@@ -0,0 +1,390 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// testClient talks to a server running in the same process, through pipes.
+type testClient struct {
+	t             *testing.T
+	conn          *conn
+	nextID        int
+	notifications []notification
+	done          chan error
+}
+
+type incoming struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+}
+
+func newTestClient(t *testing.T) *testClient {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	client := &testClient{
+		t:    t,
+		conn: &conn{reader: textproto.NewReader(bufio.NewReader(clientReader)), writer: clientWriter},
+		done: make(chan error),
+	}
+
+	go func() {
+		err := NewServer(serverReader, serverWriter).Run()
+		serverWriter.Close()
+		client.done <- err
+	}()
+
+	t.Cleanup(func() {
+		clientWriter.Close()
+		if err := <-client.done; err != nil {
+			t.Errorf("Server failed: %v", err)
+		}
+	})
+
+	client.call("initialize", map[string]interface{}{}, nil)
+	client.notify("initialized", map[string]interface{}{})
+	return client
+}
+
+func (c *testClient) notify(method string, params interface{}) {
+	if err := c.conn.write(&notification{JSONRPC: "2.0", Method: method, Params: params}); err != nil {
+		c.t.Fatalf("Unable to send notification: %v", err)
+	}
+}
+
+// receive reads the next message from the server.
+func (c *testClient) receive() *incoming {
+	content, err := c.conn.read()
+	if err != nil {
+		c.t.Fatalf("Unable to read message: %v", err)
+	}
+
+	var msg incoming
+	if err := json.Unmarshal(content, &msg); err != nil {
+		c.t.Fatalf("Invalid message %s: %v", content, err)
+	}
+	return &msg
+}
+
+// call sends a request and decodes its result into result. Notifications
+// received meanwhile are kept.
+func (c *testClient) call(method string, params interface{}, result interface{}) *responseError {
+	c.nextID++
+	id := c.nextID
+	req := map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params}
+	if err := c.conn.write(req); err != nil {
+		c.t.Fatalf("Unable to send request: %v", err)
+	}
+
+	for {
+		msg := c.receive()
+		if msg.ID == nil {
+			c.notifications = append(c.notifications, notification{Method: msg.Method, Params: msg.Params})
+			continue
+		}
+
+		if *msg.ID != id {
+			c.t.Fatalf("Unexpected response id. Expected %d, got %d", id, *msg.ID)
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				c.t.Fatalf("Invalid result %s: %v", msg.Result, err)
+			}
+		}
+		return nil
+	}
+}
+
+// diagnostics opens or changes a document and returns the diagnostics
+// published for it.
+func (c *testClient) diagnostics(method string, params interface{}) []Diagnostic {
+	c.notify(method, params)
+
+	msg := c.receive()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("Expected diagnostics, got %s", msg.Method)
+	}
+
+	var published PublishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &published); err != nil {
+		c.t.Fatalf("Invalid diagnostics %s: %v", msg.Params, err)
+	}
+	return published.Diagnostics
+}
+
+const testURI = "file:///test.monkey"
+
+func (c *testClient) open(text string) []Diagnostic {
+	return c.diagnostics("textDocument/didOpen", &DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: testURI, LanguageID: "monkey", Version: 1, Text: text},
+	})
+}
+
+func position(line, character int) *TextDocumentPositionParams {
+	return &TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: testURI},
+		Position:     Position{Line: line, Character: character},
+	}
+}
+
+func mkRange(line, start, end int) Range {
+	return Range{Start: Position{Line: line, Character: start}, End: Position{Line: line, Character: end}}
+}
+
+func TestInitialize(t *testing.T) {
+	c := newTestClient(t)
+
+	var result InitializeResult
+	if err := c.call("initialize", map[string]interface{}{}, &result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	caps := result.Capabilities
+	if caps.TextDocumentSync != SyncFull || !caps.HoverProvider || !caps.DefinitionProvider ||
+		!caps.DocumentSymbolProvider || !caps.DocumentFormattingProvider {
+		t.Errorf("Unexpected capabilities: %+v", caps)
+	}
+
+	if err := c.call("textDocument/unknown", map[string]interface{}{}, nil); err == nil || err.Code != methodNotFoundCode {
+		t.Errorf("Expected a method not found error, got %v", err)
+	}
+
+	if err := c.call("shutdown", nil, nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	c.notify("exit", nil)
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := newTestClient(t)
+
+	tests := []struct {
+		text     string
+		expected []Diagnostic
+	}{
+		{"let a = 1;\nputs(a)", []Diagnostic{}},
+		{"let a = ;", []Diagnostic{{Range: mkRange(0, 8, 9), Severity: SeverityError, Source: "monkey", Message: "Invalid token"}}},
+		{"puts(b)", []Diagnostic{{Range: mkRange(0, 5, 6), Severity: SeverityError, Source: "monkey", Message: "Unknown identifier b"}}},
+		{"let x: int = \"a\"; x", []Diagnostic{{Range: mkRange(0, 13, 16), Severity: SeverityError, Source: "monkey", Message: "Expected type int, got string"}}},
+		{"let a = 1;", []Diagnostic{{Range: mkRange(0, 4, 5), Severity: SeverityWarning, Code: "unused-let", Source: "monkey-lint", Message: "Binding a is never used"}}},
+		// Columns are counted in UTF-16 code units
+		{"puts(\"🐒\", b)", []Diagnostic{{Range: mkRange(0, 11, 12), Severity: SeverityError, Source: "monkey", Message: "Unknown identifier b"}}},
+	}
+
+	for i, tt := range tests {
+		var diagnostics []Diagnostic
+		if i == 0 {
+			diagnostics = c.open(tt.text)
+		} else {
+			diagnostics = c.diagnostics("textDocument/didChange", &DidChangeTextDocumentParams{
+				TextDocument:   VersionedTextDocumentIdentifier{URI: testURI, Version: i + 1},
+				ContentChanges: []TextDocumentContentChangeEvent{{Text: tt.text}},
+			})
+		}
+
+		if len(diagnostics) != len(tt.expected) {
+			t.Errorf("Unexpected diagnostics for %q. Expected %v, got %v", tt.text, tt.expected, diagnostics)
+			continue
+		}
+		for j := range diagnostics {
+			if diagnostics[j] != tt.expected[j] {
+				t.Errorf("Unexpected diagnostic for %q. Expected %v, got %v", tt.text, tt.expected[j], diagnostics[j])
+			}
+		}
+	}
+
+	diagnostics := c.diagnostics("textDocument/didClose", &DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: testURI}})
+	if len(diagnostics) != 0 {
+		t.Errorf("Expected diagnostics to be cleared, got %v", diagnostics)
+	}
+}
+
+// Editors send documents while they are being typed, which must not stop the
+// server from answering
+func TestIncompleteDocuments(t *testing.T) {
+	c := newTestClient(t)
+
+	tests := []struct {
+		text     string
+		expected Diagnostic
+	}{
+		{"let f = fn(a) {\n", Diagnostic{Range: mkRange(1, 0, 0), Severity: SeverityError, Source: "monkey", Message: "Expected } delimiter to close block"}},
+		{"let x = [1, 2", Diagnostic{Range: mkRange(0, 13, 13), Severity: SeverityError, Source: "monkey", Message: "Expected ] delimiter to close array literal"}},
+		{"let g = fn(.", Diagnostic{Range: mkRange(0, 11, 12), Severity: SeverityError, Source: "monkey", Message: "Parameters to an fn literal must be identifier expressions or \"...\""}},
+	}
+
+	for _, tt := range tests {
+		diagnostics := c.open(tt.text)
+		found := false
+		for _, diagnostic := range diagnostics {
+			found = found || diagnostic == tt.expected
+		}
+		if !found {
+			t.Errorf("Expected diagnostic %v for %q, got %v", tt.expected, tt.text, diagnostics)
+		}
+	}
+
+	if err := c.call("shutdown", nil, nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	c.notify("exit", nil)
+}
+
+const testProgram = `let total: int = 10;
+let add = fn(a, b: int) {
+    let sum = a + b;
+    fn() { sum + total }
+};
+puts(add(1, 2)(), len("🐒"), total)`
+
+func TestHover(t *testing.T) {
+	c := newTestClient(t)
+	c.open(testProgram)
+
+	tests := []struct {
+		position *TextDocumentPositionParams
+		expected string
+		span     Range
+	}{
+		{position(0, 5), "```monkey\nlet total: int\n```\nGlobal scope, index 0", mkRange(0, 4, 9)},
+		{position(1, 13), "```monkey\na (parameter)\n```\nLocal scope, index 0", mkRange(1, 13, 14)},
+		{position(2, 18), "```monkey\nb: int (parameter)\n```\nLocal scope, index 1", mkRange(2, 18, 19)},
+		{position(3, 11), "```monkey\nlet sum\n```\nFree scope, index 0, captured by the closure", mkRange(3, 11, 14)},
+		{position(3, 20), "```monkey\nlet total: int\n```\nGlobal scope, index 0", mkRange(3, 17, 22)},
+		{position(5, 2), "```monkey\nbuiltin puts\n```\nBuiltin scope, index 5", mkRange(5, 0, 4)},
+		{position(5, 31), "```monkey\nlet total: int\n```\nGlobal scope, index 0", mkRange(5, 29, 34)},
+	}
+
+	for _, tt := range tests {
+		var hover Hover
+		if err := c.call("textDocument/hover", tt.position, &hover); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if hover.Contents.Value != tt.expected {
+			t.Errorf("Unexpected hover at %v. Expected %q, got %q", tt.position.Position, tt.expected, hover.Contents.Value)
+		}
+		if hover.Range != tt.span {
+			t.Errorf("Unexpected hover range at %v. Expected %v, got %v", tt.position.Position, tt.span, hover.Range)
+		}
+	}
+
+	var hover *Hover
+	if err := c.call("textDocument/hover", position(0, 0), &hover); err != nil || hover != nil {
+		t.Errorf("Expected no hover on keywords, got %v, %v", hover, err)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	c := newTestClient(t)
+	c.open(testProgram)
+
+	tests := []struct {
+		position *TextDocumentPositionParams
+		expected *Range
+	}{
+		{position(2, 14), &Range{Start: Position{1, 13}, End: Position{1, 14}}},
+		{position(3, 12), &Range{Start: Position{2, 8}, End: Position{2, 11}}},
+		{position(5, 6), &Range{Start: Position{1, 4}, End: Position{1, 7}}},
+		{position(5, 32), &Range{Start: Position{0, 4}, End: Position{0, 9}}},
+		{position(5, 20), nil},
+	}
+
+	for _, tt := range tests {
+		var location *Location
+		if err := c.call("textDocument/definition", tt.position, &location); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if tt.expected == nil {
+			if location != nil {
+				t.Errorf("Expected no definition at %v, got %v", tt.position.Position, location)
+			}
+			continue
+		}
+
+		if location == nil || location.URI != testURI || location.Range != *tt.expected {
+			t.Errorf("Unexpected definition at %v. Expected %v, got %v", tt.position.Position, tt.expected, location)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	c := newTestClient(t)
+	c.open(testProgram)
+
+	var items []CompletionItem
+	if err := c.call("textDocument/completion", position(5, 0), &items); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	details := map[string]string{}
+	for _, item := range items {
+		details[item.Label] = item.Detail
+	}
+
+	if details["len"] != "builtin taking 1 arguments" || details["puts"] != "builtin taking any number of arguments" {
+		t.Errorf("Unexpected completion items: %v", items)
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c := newTestClient(t)
+	c.open(testProgram)
+
+	var symbols []DocumentSymbol
+	params := &DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: testURI}}
+	if err := c.call("textDocument/documentSymbol", params, &symbols); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(symbols) != 2 {
+		t.Fatalf("Expected 2 symbols, got %v", symbols)
+	}
+
+	if symbols[0].Name != "total" || symbols[0].Kind != SymbolKindVariable || symbols[0].SelectionRange != mkRange(0, 4, 9) {
+		t.Errorf("Unexpected symbol: %+v", symbols[0])
+	}
+
+	add := symbols[1]
+	if add.Name != "add" || add.Kind != SymbolKindFunction || add.Range.Start != (Position{1, 0}) || add.Range.End != (Position{4, 1}) {
+		t.Errorf("Unexpected symbol: %+v", add)
+	}
+	if len(add.Children) != 1 || add.Children[0].Name != "sum" {
+		t.Errorf("Unexpected children of add: %+v", add.Children)
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	c := newTestClient(t)
+	c.open("let a=1\nputs( a )")
+
+	var edits []TextEdit
+	params := &DocumentFormattingParams{TextDocument: TextDocumentIdentifier{URI: testURI}}
+	if err := c.call("textDocument/formatting", params, &edits); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := TextEdit{Range: mkRange(0, 0, 0), NewText: "let a = 1;\nputs(a)\n"}
+	expected.Range.End = Position{Line: 1, Character: 9}
+	if len(edits) != 1 || edits[0] != expected {
+		t.Errorf("Unexpected edits. Expected %v, got %v", expected, edits)
+	}
+
+	if err := c.call("textDocument/formatting", &DocumentFormattingParams{TextDocument: TextDocumentIdentifier{URI: "file:///missing"}}, nil); err == nil {
+		t.Errorf("Expected an error formatting an unknown document")
+	} else if !strings.Contains(err.Message, "Unknown document") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -1,16 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/checker"
 	"github.com/javier-varez/monkey_interpreter/compiler"
+	"github.com/javier-varez/monkey_interpreter/dap"
 	"github.com/javier-varez/monkey_interpreter/evaluator"
+	"github.com/javier-varez/monkey_interpreter/formatter"
 	"github.com/javier-varez/monkey_interpreter/lexer"
+	"github.com/javier-varez/monkey_interpreter/linter"
+	"github.com/javier-varez/monkey_interpreter/lsp"
 	"github.com/javier-varez/monkey_interpreter/object"
 	"github.com/javier-varez/monkey_interpreter/parser"
+	"github.com/javier-varez/monkey_interpreter/regvm"
 	"github.com/javier-varez/monkey_interpreter/repl"
+	"github.com/javier-varez/monkey_interpreter/resolver"
 	"github.com/javier-varez/monkey_interpreter/transpiler"
 	"github.com/javier-varez/monkey_interpreter/vm"
 	"github.com/spf13/cobra"
@@ -35,24 +48,111 @@ var compileCmd cobra.Command = cobra.Command{
 	Run:  compileFile,
 }
 
+var checkCmd cobra.Command = cobra.Command{
+	Use:  "check filename",
+	Args: cobra.ExactArgs(1),
+	Run:  checkFile,
+}
+
+var lintCmd cobra.Command = cobra.Command{
+	Use:  "lint filename",
+	Args: cobra.ExactArgs(1),
+	Run:  lintFile,
+}
+
+var fmtCmd cobra.Command = cobra.Command{
+	Use:  "fmt filename...",
+	Args: cobra.MinimumNArgs(1),
+	Run:  formatFiles,
+}
+
+var buildCmd cobra.Command = cobra.Command{
+	Use:   "build filename",
+	Short: "Compiles a program to a bytecode file that the exec command runs",
+	Args:  cobra.ExactArgs(1),
+	Run:   buildFile,
+}
+
+var execCmd cobra.Command = cobra.Command{
+	Use:   "exec filename",
+	Short: "Runs a bytecode file in the VM",
+	Args:  cobra.ExactArgs(1),
+	Run:   execFile,
+}
+
+var disasmCmd cobra.Command = cobra.Command{
+	Use:   "disasm filename",
+	Short: "Prints the bytecode of a program, annotated with its source",
+	Args:  cobra.ExactArgs(1),
+	Run:   disasmFile,
+}
+
+var lspCmd cobra.Command = cobra.Command{
+	Use:   "lsp",
+	Short: "Runs a language server over the standard input and output",
+	Args:  cobra.NoArgs,
+	Run:   runLsp,
+}
+
+var dapCmd cobra.Command = cobra.Command{
+	Use:   "dap",
+	Short: "Runs a debug adapter for the VM over the standard input and output",
+	Args:  cobra.NoArgs,
+	Run:   runDap,
+}
+
+// DEFAULT_LINT_CONFIG is read by the lint command if it exists and no other
+// configuration is given.
+const DEFAULT_LINT_CONFIG = ".monkeylint.json"
+
+// BYTECODE_EXTENSION replaces the extension of built programs if no output
+// file is given.
+const BYTECODE_EXTENSION = ".mbc"
+
 var useVm bool
+var engine string
+var lintJson bool
+var lintConfig string
+var fmtWrite bool
+var fmtCheck bool
+var optimize bool
+var buildOutput string
+var buildStrip bool
 
 func init() {
 	replCmd.Flags().BoolVar(&useVm, "vm", false, "Instructs to use the VM instead of the interpreter")
-	runCmd.Flags().BoolVar(&useVm, "vm", false, "Instructs to use the VM instead of the interpreter")
+	runCmd.Flags().BoolVar(&useVm, "vm", false, "Instructs to use the VM instead of the interpreter, like --engine=vm")
+	runCmd.Flags().StringVar(&engine, "engine", "interpreter", "Engine running the program: interpreter, vm or regvm")
+	runCmd.Flags().BoolVarP(&optimize, "optimize", "O", false, "Optimizes the bytecode run by the VM")
 	rootCmd.AddCommand(&replCmd)
 	rootCmd.AddCommand(&runCmd)
 	rootCmd.AddCommand(&compileCmd)
+	lintCmd.Flags().BoolVar(&lintJson, "json", false, "Prints the diagnostics as JSON")
+	lintCmd.Flags().StringVar(&lintConfig, "config", DEFAULT_LINT_CONFIG, "JSON file enabling or disabling lint rules")
+	rootCmd.AddCommand(&checkCmd)
+	rootCmd.AddCommand(&lintCmd)
+	fmtCmd.Flags().BoolVar(&fmtWrite, "write", false, "Writes the formatted code back to the files instead of printing it")
+	fmtCmd.Flags().BoolVar(&fmtCheck, "check", false, "Lists the files that are not formatted and fails if there are any")
+	rootCmd.AddCommand(&fmtCmd)
+	buildCmd.Flags().StringVarP(&buildOutput, "output", "o", "", "Bytecode file to write, the program with the "+BYTECODE_EXTENSION+" extension by default")
+	buildCmd.Flags().BoolVarP(&optimize, "optimize", "O", false, "Optimizes the bytecode")
+	buildCmd.Flags().BoolVar(&buildStrip, "strip", false, "Leaves the source map out, so runtime errors are not located")
+	rootCmd.AddCommand(&buildCmd)
+	rootCmd.AddCommand(&execCmd)
+	disasmCmd.Flags().BoolVarP(&optimize, "optimize", "O", false, "Optimizes the bytecode")
+	rootCmd.AddCommand(&disasmCmd)
+	rootCmd.AddCommand(&lspCmd)
+	rootCmd.AddCommand(&dapCmd)
 }
 
 func runRepl(c *cobra.Command, args []string) {
 	repl.Start(useVm)
 }
 
-func runFile(c *cobra.Command, args []string) {
-	fmt.Println("running file", args[0])
-
-	txt, err := os.ReadFile(args[0])
+// parseFile parses, resolves and type checks a source file. Diagnostics are printed
+// and nil is returned if there are any.
+func parseFile(filename string) *ast.Program {
+	txt, err := os.ReadFile(filename)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -61,60 +161,273 @@ func runFile(c *cobra.Command, args []string) {
 	p := parser.New(lex)
 
 	program := p.ParseProgram()
+	if len(program.Diagnostics) == 0 {
+		program.Diagnostics = resolver.Resolve(program)
+	}
+	if len(program.Diagnostics) == 0 {
+		program.Diagnostics = checker.Check(program)
+	}
 
 	if len(program.Diagnostics) != 0 {
 		fmt.Print("Diagnostics:\n\n")
 		for _, diag := range program.Diagnostics {
 			fmt.Println(diag.ContextualError())
 		}
-	} else {
-		if useVm {
-			fmt.Println("Using VM")
-			c := compiler.New()
-			if err := c.Compile(program); err != nil {
-				fmt.Println("Compilation error: ", err)
-				return
-			}
+		return nil
+	}
 
-			bytecode := c.Bytecode()
-			vm := vm.New(bytecode)
-			vm.Run()
-		} else {
-			fmt.Println("Using interpreter")
-			env := object.NewEnvironment()
-			result := evaluator.Eval(program, env)
-			if result != nil {
-				if result.Type() == object.ERROR_VALUE_OBJ {
-					err := result.(*object.Error)
-					fmt.Printf("%s\n", err.ContextualError())
-				}
+	return program
+}
+
+func runFile(c *cobra.Command, args []string) {
+	fmt.Println("running file", args[0])
+
+	program := parseFile(args[0])
+	if program == nil {
+		return
+	}
+
+	if useVm && !c.Flags().Changed("engine") {
+		engine = "vm"
+	}
+
+	switch engine {
+	case "vm":
+		fmt.Println("Using VM")
+		c := newCompiler()
+		if err := c.Compile(program); err != nil {
+			fmt.Println("Compilation error: ", err)
+			return
+		}
+
+		runBytecode(c.Bytecode())
+	case "regvm":
+		fmt.Println("Using register VM")
+		c := regvm.NewCompiler()
+		if err := c.Compile(program); err != nil {
+			fmt.Println("Compilation error: ", err)
+			return
+		}
+
+		printRuntimeError(regvm.New(c.Program()).Run())
+	case "interpreter":
+		fmt.Println("Using interpreter")
+		env := object.NewEnvironment()
+		result := evaluator.Eval(program, env)
+		if result != nil {
+			if result.Type() == object.ERROR_VALUE_OBJ {
+				err := result.(*object.Error)
+				fmt.Print(err.ContextualError())
+				fmt.Print(object.FormatStackTrace(err.Stack))
 			}
 		}
+	default:
+		fmt.Printf("Unknown engine %q, expected interpreter, vm or regvm\n", engine)
+		os.Exit(1)
+	}
+}
+
+// newCompiler returns a compiler that optimizes the bytecode if requested.
+func newCompiler() *compiler.Compiler {
+	c := compiler.New()
+	if optimize {
+		c.EnableOptimizations()
+	}
+	return c
+}
+
+// runBytecode verifies a compiled program and runs it in the VM, printing
+// runtime errors. The VM trusts its input, so even the bytecode of the
+// compiler is verified, in case of bugs in the compiler.
+func runBytecode(bytecode *compiler.Bytecode) {
+	if err := vm.Verify(bytecode); err != nil {
+		log.Fatal(err)
+	}
+	printRuntimeError(vm.New(bytecode).Run())
+}
+
+// printRuntimeError prints the error of a VM run, if any, along with its
+// stack trace.
+func printRuntimeError(err error) {
+	if astErr, ok := err.(ast.Error); ok {
+		fmt.Print(astErr.ContextualError())
+	} else if err != nil {
+		fmt.Println("Runtime error: ", err)
+	}
+	if tracer, ok := err.(object.StackTracer); ok {
+		fmt.Print(object.FormatStackTrace(tracer.StackTrace()))
 	}
 }
 
+func buildFile(c *cobra.Command, args []string) {
+	program := parseFile(args[0])
+	if program == nil {
+		os.Exit(1)
+	}
+
+	comp := newCompiler()
+	if err := comp.Compile(program); err != nil {
+		fmt.Println("Compilation error: ", err)
+		os.Exit(1)
+	}
+
+	output := buildOutput
+	if output == "" {
+		output = strings.TrimSuffix(args[0], filepath.Ext(args[0])) + BYTECODE_EXTENSION
+	}
+
+	var buffer bytes.Buffer
+	if err := comp.Bytecode().Encode(&buffer, !buildStrip); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(output, buffer.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func execFile(c *cobra.Command, args []string) {
+	file, err := os.Open(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	bytecode, err := compiler.Decode(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	runBytecode(bytecode)
+}
+
+func disasmFile(c *cobra.Command, args []string) {
+	program := parseFile(args[0])
+	if program == nil {
+		os.Exit(1)
+	}
+
+	comp := newCompiler()
+	if err := comp.Compile(program); err != nil {
+		fmt.Println("Compilation error: ", err)
+		os.Exit(1)
+	}
+	fmt.Print(compiler.Disassemble(comp.Bytecode()))
+}
+
 func compileFile(c *cobra.Command, args []string) {
 	fmt.Println("compiling file", args[0])
 
-	txt, err := os.ReadFile(args[0])
+	program := parseFile(args[0])
+	if program == nil {
+		return
+	}
+
+	transpiledCode := transpiler.Transpile(program)
+	runOut := transpiler.Compile(transpiledCode)
+	fmt.Println(runOut)
+}
+
+func checkFile(c *cobra.Command, args []string) {
+	if parseFile(args[0]) == nil {
+		os.Exit(1)
+	}
+	fmt.Println("No type errors found")
+}
+
+func loadLintConfig(c *cobra.Command) *linter.Config {
+	config, err := linter.LoadConfig(lintConfig)
+	if errors.Is(err, os.ErrNotExist) && !c.Flags().Changed("config") {
+		return linter.DefaultConfig()
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	return config
+}
 
-	lex := lexer.New(string(txt))
-	p := parser.New(lex)
+func lintFile(c *cobra.Command, args []string) {
+	config := loadLintConfig(c)
 
-	program := p.ParseProgram()
+	txt, err := os.ReadFile(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
 
+	program := parser.New(lexer.New(string(txt))).ParseProgram()
 	if len(program.Diagnostics) != 0 {
 		fmt.Print("Diagnostics:\n\n")
 		for _, diag := range program.Diagnostics {
 			fmt.Println(diag.ContextualError())
 		}
+		os.Exit(1)
+	}
+
+	diagnostics := linter.Lint(program, config)
+	if lintJson {
+		encoded, err := json.MarshalIndent(diagnostics, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(encoded))
 	} else {
-		transpiledCode := transpiler.Transpile(program)
-		runOut := transpiler.Compile(transpiledCode)
-		fmt.Println(runOut)
+		for _, diag := range diagnostics {
+			fmt.Println(diag.ContextualError())
+		}
+	}
+
+	if len(diagnostics) != 0 {
+		os.Exit(1)
+	}
+}
+
+func formatFiles(c *cobra.Command, args []string) {
+	failed := false
+
+	for _, filename := range args {
+		txt, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		formatted, diagnostics := formatter.Source(string(txt))
+		if len(diagnostics) != 0 {
+			fmt.Printf("Diagnostics in %s:\n\n", filename)
+			for _, diag := range diagnostics {
+				fmt.Println(diag.ContextualError())
+			}
+			failed = true
+			continue
+		}
+
+		if fmtCheck {
+			if formatted != string(txt) {
+				fmt.Println(filename)
+				failed = true
+			}
+		} else if fmtWrite {
+			if formatted != string(txt) {
+				if err := os.WriteFile(filename, []byte(formatted), 0644); err != nil {
+					log.Fatal(err)
+				}
+			}
+		} else {
+			fmt.Print(formatted)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func runLsp(c *cobra.Command, args []string) {
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runDap(c *cobra.Command, args []string) {
+	if err := dap.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		log.Fatal(err)
 	}
 }
 
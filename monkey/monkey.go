@@ -0,0 +1,119 @@
+// Package monkey runs Monkey programs from Go programs, either with the
+// interpreter or the VM. Programs go through the same passes as with the run
+// command, and errors carry everything needed to show them to users.
+package monkey
+
+import (
+	"strings"
+
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/checker"
+	"github.com/javier-varez/monkey_interpreter/compiler"
+	"github.com/javier-varez/monkey_interpreter/evaluator"
+	"github.com/javier-varez/monkey_interpreter/lexer"
+	"github.com/javier-varez/monkey_interpreter/object"
+	"github.com/javier-varez/monkey_interpreter/parser"
+	"github.com/javier-varez/monkey_interpreter/resolver"
+	"github.com/javier-varez/monkey_interpreter/token"
+	"github.com/javier-varez/monkey_interpreter/vm"
+)
+
+type Engine int
+
+const (
+	Interpreter Engine = iota
+	VM
+)
+
+// Error is returned when a program has diagnostics or fails while running.
+type Error struct {
+	// Diagnostics found before running the program
+	Diagnostics []ast.Error
+
+	// Message and Span describe the runtime error, the span is empty if it
+	// is unknown
+	Message string
+	Span    token.Span
+	// Stack holds the calls that led to the runtime error, innermost first
+	Stack []object.StackFrame
+}
+
+func (e *Error) Error() string {
+	if len(e.Diagnostics) != 0 {
+		return e.Diagnostics[0].Error()
+	}
+	return e.Message
+}
+
+// Traceback renders the error for users, underlining the code that failed
+// and listing the calls that led to it.
+func (e *Error) Traceback() string {
+	if len(e.Diagnostics) != 0 {
+		messages := []string{}
+		for _, diag := range e.Diagnostics {
+			messages = append(messages, diag.ContextualError())
+		}
+		return strings.Join(messages, "\n")
+	}
+
+	if e.Span.Text == nil {
+		return e.Message + "\n" + object.FormatStackTrace(e.Stack)
+	}
+	return ast.FormatContextualError(e.Span, e.Message) + object.FormatStackTrace(e.Stack)
+}
+
+// Run runs a program, returning the value of its last expression statement.
+// Errors are always of type *Error.
+func Run(source string, engine Engine) (object.Object, error) {
+	program := parser.New(lexer.New(source)).ParseProgram()
+	if len(program.Diagnostics) == 0 {
+		program.Diagnostics = resolver.Resolve(program)
+	}
+	if len(program.Diagnostics) == 0 {
+		program.Diagnostics = checker.Check(program)
+	}
+	if len(program.Diagnostics) != 0 {
+		return nil, &Error{Diagnostics: program.Diagnostics}
+	}
+
+	if engine == Interpreter {
+		result := evaluator.Eval(program, object.NewEnvironment())
+		if err, ok := result.(*object.Error); ok {
+			return nil, &Error{Message: err.Message, Span: err.Span, Stack: err.Stack}
+		}
+		if result == nil {
+			result = vm.Null
+		}
+		return result, nil
+	}
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		if astErr, ok := err.(ast.Error); ok {
+			return nil, &Error{Diagnostics: []ast.Error{astErr}}
+		}
+		return nil, &Error{Message: err.Error()}
+	}
+
+	if err := vm.Verify(c.Bytecode()); err != nil {
+		return nil, &Error{Message: err.Error()}
+	}
+
+	machine := vm.New(c.Bytecode())
+	if err := machine.Run(); err != nil {
+		result := &Error{Message: err.Error()}
+		if astErr, ok := err.(ast.Error); ok {
+			result.Span = astErr.Span()
+		}
+		if tracer, ok := err.(object.StackTracer); ok {
+			result.Stack = tracer.StackTrace()
+		}
+		return nil, result
+	}
+
+	result := machine.LastPoppedStackElem()
+	if result == nil {
+		result = vm.Null
+	}
+	return result, nil
+}
@@ -0,0 +1,64 @@
+package monkey
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let add = fn(a, b) { a + b }; add(1, 2)", "3"},
+		{`let a = [1, 2]; push(a, 3)`, "[1, 2, 3]"},
+	}
+
+	for _, engine := range []Engine{Interpreter, VM} {
+		for _, tt := range tests {
+			result, err := Run(tt.input, engine)
+			if err != nil {
+				t.Errorf("Unexpected error for %q: %s", tt.input, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("Unexpected result for %q. Expected %s, got %s", tt.input, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedTraceback []string
+	}{
+		{"puts(b)", []string{"Unknown identifier b"}},
+		{
+			"let inner = fn(x) { x / 0 };\nlet outer = fn(x) { inner(x) + 1 };\nouter(1)",
+			[]string{
+				"x / 0",
+				"Division by zero",
+				"Stack trace, most recent call first:\n\tin inner, called at line 2, column 21\n\tin outer, called at line 3, column 1\n",
+			},
+		},
+	}
+
+	for _, engine := range []Engine{Interpreter, VM} {
+		for _, tt := range tests {
+			_, err := Run(tt.input, engine)
+			monkeyErr, ok := err.(*Error)
+			if !ok {
+				t.Errorf("Expected an error for %q, got %v", tt.input, err)
+				continue
+			}
+
+			traceback := monkeyErr.Traceback()
+			for _, expected := range tt.expectedTraceback {
+				if !strings.Contains(traceback, expected) {
+					t.Errorf("Traceback of %q does not contain %q:\n%s", tt.input, expected, traceback)
+				}
+			}
+		}
+	}
+}
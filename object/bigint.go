@@ -0,0 +1,138 @@
+package object
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/big"
+)
+
+// BigInt holds integer values that do not fit in 64 bits. Integer arithmetic
+// promotes to BigInt on overflow and demotes results back to Integer whenever
+// they fit, so every value has a single representation.
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Type() ObjectType {
+	return BIGINT_OBJ
+}
+
+func (b *BigInt) Inspect() string {
+	return b.Value.String()
+}
+
+func (b *BigInt) HashKey() HashKey {
+	h := fnv.New64()
+	if b.Value.Sign() < 0 {
+		h.Write([]byte{'-'})
+	}
+	h.Write(b.Value.Bytes())
+
+	return HashKey{
+		Type: BIGINT_OBJ,
+		Hash: h.Sum64(),
+	}
+}
+
+// IsInteger returns true for both Integer and BigInt objects.
+func IsInteger(obj Object) bool {
+	return obj.Type() == INTEGER_OBJ || obj.Type() == BIGINT_OBJ
+}
+
+// NewInteger returns an Integer if the value fits in 64 bits, or a BigInt
+// otherwise.
+func NewInteger(value *big.Int) Object {
+	if value.IsInt64() {
+		return NewInt64(value.Int64())
+	}
+	return &BigInt{Value: value}
+}
+
+func toBigInt(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	case *BigInt:
+		return obj.Value
+	}
+	panic(fmt.Sprintf("Object is not an integer: %T", obj))
+}
+
+func asInt64s(lhs, rhs Object) (int64, int64, bool) {
+	l, lok := lhs.(*Integer)
+	r, rok := rhs.(*Integer)
+	if !lok || !rok {
+		return 0, 0, false
+	}
+	return l.Value, r.Value, true
+}
+
+func AddIntegers(lhs, rhs Object) Object {
+	if l, r, ok := asInt64s(lhs, rhs); ok {
+		result := l + r
+		// Overflow happens when both operands have a different sign than the result
+		if (l^result)&(r^result) >= 0 {
+			return NewInt64(result)
+		}
+	}
+	return NewInteger(new(big.Int).Add(toBigInt(lhs), toBigInt(rhs)))
+}
+
+func SubIntegers(lhs, rhs Object) Object {
+	if l, r, ok := asInt64s(lhs, rhs); ok {
+		result := l - r
+		// Overflow happens when the operands have different signs and the
+		// result does not have the sign of the left operand
+		if (l^r)&(l^result) >= 0 {
+			return NewInt64(result)
+		}
+	}
+	return NewInteger(new(big.Int).Sub(toBigInt(lhs), toBigInt(rhs)))
+}
+
+func MulIntegers(lhs, rhs Object) Object {
+	if l, r, ok := asInt64s(lhs, rhs); ok {
+		result := l * r
+		overflow := l != 0 && (result/l != r || (l == -1 && r == math.MinInt64))
+		if !overflow {
+			return NewInt64(result)
+		}
+	}
+	return NewInteger(new(big.Int).Mul(toBigInt(lhs), toBigInt(rhs)))
+}
+
+// DivIntegers truncates the result towards zero, like integer division in Go.
+func DivIntegers(lhs, rhs Object) (Object, error) {
+	if toBigInt(rhs).Sign() == 0 {
+		return nil, fmt.Errorf("Division by zero")
+	}
+
+	if l, r, ok := asInt64s(lhs, rhs); ok {
+		if l != math.MinInt64 || r != -1 {
+			return NewInt64(l / r), nil
+		}
+	}
+	return NewInteger(new(big.Int).Quo(toBigInt(lhs), toBigInt(rhs))), nil
+}
+
+func NegateInteger(obj Object) Object {
+	if i, ok := obj.(*Integer); ok && i.Value != math.MinInt64 {
+		return NewInt64(-i.Value)
+	}
+	return NewInteger(new(big.Int).Neg(toBigInt(obj)))
+}
+
+// CompareIntegers returns -1, 0 or +1 depending on whether lhs is less, equal
+// or greater than rhs.
+func CompareIntegers(lhs, rhs Object) int {
+	if l, r, ok := asInt64s(lhs, rhs); ok {
+		if l < r {
+			return -1
+		} else if l > r {
+			return 1
+		}
+		return 0
+	}
+	return toBigInt(lhs).Cmp(toBigInt(rhs))
+}
@@ -2,6 +2,9 @@ package object
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
 
 	"github.com/javier-varez/monkey_interpreter/token"
 )
@@ -10,38 +13,59 @@ func mkError(s token.Span, msg string) *Error {
 	return &Error{Span: s, Message: msg}
 }
 
+// Output is where puts writes to. Debuggers replace it to forward the output
+// of the program to their clients.
+var Output io.Writer = os.Stdout
+
+// VARIADIC is the arity of builtins that take any number of arguments.
+const VARIADIC = -1
+
 var Builtins = []struct {
-	Name    string
+	Name string
+	// Arity is the number of arguments the builtin takes, or VARIADIC.
+	Arity   int
 	Builtin *Builtin
 }{
 	{
-		Name: "len",
+		Name:  "len",
+		Arity: 1,
 		Builtin: &Builtin{
 			Function: func(span token.Span, objects ...Object) Object {
 				if len(objects) != 1 {
-					return mkError(span, "\"len\" builtin takes a single string or array argument")
-				}
-
-				strObj, ok := objects[0].(*String)
-				if ok {
-					return &Integer{Value: int64(len(strObj.Value))}
+					return mkError(span, "\"len\" builtin takes a single string, array, tuple or set argument")
 				}
 
-				arrObj, ok := objects[0].(*Array)
-				if ok {
-					return &Integer{Value: int64(len(arrObj.Elems))}
+				switch obj := objects[0].(type) {
+				case *String:
+					return NewInt64(int64(obj.Len()))
+				case *Array:
+					return NewInt64(int64(len(obj.Elems)))
+				case *Tuple:
+					return NewInt64(int64(len(obj.Elems)))
+				case *Set:
+					return NewInt64(int64(obj.Len()))
 				}
 
-				return mkError(span, "\"len\" builtin takes a single string or array argument")
+				return mkError(span, "\"len\" builtin takes a single string, array, tuple or set argument")
 			},
 		},
 	},
 	{
-		Name: "first",
+		Name:  "first",
+		Arity: 1,
 		Builtin: &Builtin{
 			Function: func(span token.Span, objects ...Object) Object {
 				if len(objects) != 1 {
-					return mkError(span, "\"first\" builtin takes a single array argument")
+					return mkError(span, "\"first\" builtin takes a single string or array argument")
+				}
+
+				strObj, ok := objects[0].(*String)
+				if ok {
+					if strObj.Value == "" {
+						return mkError(span, "String is empty")
+					}
+					ch, _ := utf8.DecodeRuneInString(strObj.Value)
+					return &Char{Value: ch}
 				}
 
 				arrObj, ok := objects[0].(*Array)
@@ -52,16 +76,26 @@ var Builtins = []struct {
 					return arrObj.Elems[0]
 				}
 
-				return mkError(span, "\"first\" builtin takes a single array argument")
+				return mkError(span, "\"first\" builtin takes a single string or array argument")
 			},
 		},
 	},
 	{
-		Name: "last",
+		Name:  "last",
+		Arity: 1,
 		Builtin: &Builtin{
 			Function: func(span token.Span, objects ...Object) Object {
 				if len(objects) != 1 {
-					return mkError(span, "\"last\" builtin takes a single array argument")
+					return mkError(span, "\"last\" builtin takes a single string or array argument")
+				}
+
+				strObj, ok := objects[0].(*String)
+				if ok {
+					if strObj.Value == "" {
+						return mkError(span, "String is empty")
+					}
+					ch, _ := utf8.DecodeLastRuneInString(strObj.Value)
+					return &Char{Value: ch}
 				}
 
 				arrObj, ok := objects[0].(*Array)
@@ -72,16 +106,26 @@ var Builtins = []struct {
 					return arrObj.Elems[len(arrObj.Elems)-1]
 				}
 
-				return mkError(span, "\"last\" builtin takes a single array argument")
+				return mkError(span, "\"last\" builtin takes a single string or array argument")
 			},
 		},
 	},
 	{
-		Name: "rest",
+		Name:  "rest",
+		Arity: 1,
 		Builtin: &Builtin{
 			Function: func(span token.Span, objects ...Object) Object {
 				if len(objects) != 1 {
-					return mkError(span, "\"rest\" builtin takes a single array argument")
+					return mkError(span, "\"rest\" builtin takes a single string or array argument")
+				}
+
+				strObj, ok := objects[0].(*String)
+				if ok {
+					if strObj.Value == "" {
+						return mkError(span, "String is empty")
+					}
+					_, width := utf8.DecodeRuneInString(strObj.Value)
+					return &String{Value: strObj.Value[width:]}
 				}
 
 				arrObj, ok := objects[0].(*Array)
@@ -94,12 +138,13 @@ var Builtins = []struct {
 					return newArr
 				}
 
-				return mkError(span, "\"rest\" builtin takes a single array argument")
+				return mkError(span, "\"rest\" builtin takes a single string or array argument")
 			},
 		},
 	},
 	{
-		Name: "push",
+		Name:  "push",
+		Arity: 2,
 		Builtin: &Builtin{
 			Function: func(span token.Span, objects ...Object) Object {
 				if len(objects) != 2 {
@@ -121,19 +166,21 @@ var Builtins = []struct {
 		},
 	},
 	{
-		Name: "puts",
+		Name:  "puts",
+		Arity: VARIADIC,
 		Builtin: &Builtin{
 			Function: func(span token.Span, objects ...Object) Object {
 				for _, object := range objects {
-					fmt.Print(object.Inspect())
+					fmt.Fprint(Output, object.Inspect())
 				}
-				fmt.Println()
+				fmt.Fprintln(Output)
 				return nil
 			},
 		},
 	},
 	{
-		Name: "toArray",
+		Name:  "toArray",
+		Arity: 1,
 		Builtin: &Builtin{
 			Function: func(span token.Span, objects ...Object) Object {
 				if len(objects) != 1 {
@@ -150,11 +197,84 @@ var Builtins = []struct {
 		},
 	},
 	{
-		Name: "contains",
+		Name:  "contains",
+		Arity: 2,
 		Builtin: &Builtin{
 			Function: func(span token.Span, objects ...Object) Object {
 				if len(objects) != 2 {
-					return mkError(span, "\"contains\" builtin takes a HashMap argument and a key")
+					return mkError(span, "\"contains\" builtin takes a HashMap or Set argument and a key")
+				}
+
+				keyObj, ok := objects[1].(Hashable)
+				if !ok {
+					return mkError(span, "Second argument is not a hashable object")
+				}
+
+				if setObj, ok := objects[0].(*Set); ok {
+					return NativeBool(setObj.Contains(keyObj))
+				}
+
+				hashMapObj, ok := objects[0].(*HashMap)
+				if !ok {
+					return mkError(span, "First argument is not a hash map or set")
+				}
+
+				_, ok = hashMapObj.Get(keyObj)
+				return NativeBool(ok)
+			},
+		},
+	},
+	{
+		Name:  "keys",
+		Arity: 1,
+		Builtin: &Builtin{
+			Function: func(span token.Span, objects ...Object) Object {
+				if len(objects) != 1 {
+					return mkError(span, "\"keys\" builtin takes a single HashMap argument")
+				}
+
+				hashMapObj, ok := objects[0].(*HashMap)
+				if !ok {
+					return mkError(span, "\"keys\" builtin takes a single HashMap argument")
+				}
+
+				result := &Array{Elems: []Object{}}
+				for _, entry := range hashMapObj.Entries() {
+					result.Elems = append(result.Elems, entry.Key)
+				}
+				return result
+			},
+		},
+	},
+	{
+		Name:  "values",
+		Arity: 1,
+		Builtin: &Builtin{
+			Function: func(span token.Span, objects ...Object) Object {
+				if len(objects) != 1 {
+					return mkError(span, "\"values\" builtin takes a single HashMap argument")
+				}
+
+				hashMapObj, ok := objects[0].(*HashMap)
+				if !ok {
+					return mkError(span, "\"values\" builtin takes a single HashMap argument")
+				}
+
+				result := &Array{Elems: []Object{}}
+				for _, entry := range hashMapObj.Entries() {
+					result.Elems = append(result.Elems, entry.Value)
+				}
+				return result
+			},
+		},
+	},
+	{
+		Name:  "delete",
+		Arity: 2,
+		Builtin: &Builtin{
+			Function: func(span token.Span, objects ...Object) Object {
+				if len(objects) != 2 {
+					return mkError(span, "\"delete\" builtin takes a HashMap argument and a key")
 				}
 
 				hashMapObj, ok := objects[0].(*HashMap)
@@ -167,14 +287,10 @@ var Builtins = []struct {
 					return mkError(span, "Second argument is not a hashable object")
 				}
 
-				elem, ok := hashMapObj.Elems[keyObj.HashKey()]
-				if ok {
-					if elem.Key.Inspect() == keyObj.Inspect() {
-						return &Boolean{Value: true}
-					}
-				}
-
-				return &Boolean{Value: false}
+				// Maps are immutable, return a copy without the key.
+				result := hashMapObj.Copy()
+				result.Delete(keyObj)
+				return result
 			},
 		},
 	},
@@ -0,0 +1,40 @@
+package object
+
+// NULL, TRUE and FALSE are shared by all the engines, so that null and
+// booleans never need to be allocated. No other Null or Boolean objects should
+// be created.
+var (
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
+
+// NativeBool returns the shared Boolean object for value.
+func NativeBool(value bool) *Boolean {
+	if value {
+		return TRUE
+	}
+	return FALSE
+}
+
+// SMALL_INT_MIN and SMALL_INT_MAX bound the integers that are preallocated,
+// which covers most counters, indices and lengths.
+const SMALL_INT_MIN = -128
+const SMALL_INT_MAX = 1023
+
+var smallInts = func() []*Integer {
+	ints := make([]*Integer, SMALL_INT_MAX-SMALL_INT_MIN+1)
+	for i := range ints {
+		ints[i] = &Integer{Value: int64(i + SMALL_INT_MIN)}
+	}
+	return ints
+}()
+
+// NewInt64 returns an Integer holding value, which is shared with every other
+// user of the same value when it is small. Integers are therefore immutable.
+func NewInt64(value int64) *Integer {
+	if value >= SMALL_INT_MIN && value <= SMALL_INT_MAX {
+		return smallInts[value-SMALL_INT_MIN]
+	}
+	return &Integer{Value: value}
+}
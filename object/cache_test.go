@@ -0,0 +1,66 @@
+package object
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSmallIntegerCache(t *testing.T) {
+	tests := []struct {
+		value  int64
+		shared bool
+	}{
+		{0, true},
+		{1, true},
+		{SMALL_INT_MIN, true},
+		{SMALL_INT_MAX, true},
+		{SMALL_INT_MIN - 1, false},
+		{SMALL_INT_MAX + 1, false},
+		{math.MaxInt64, false},
+	}
+
+	for _, tt := range tests {
+		a, b := NewInt64(tt.value), NewInt64(tt.value)
+		if a.Value != tt.value || b.Value != tt.value {
+			t.Errorf("Unexpected values for %d: %d and %d", tt.value, a.Value, b.Value)
+		}
+		if (a == b) != tt.shared {
+			t.Errorf("Integer %d shared=%t, expected %t", tt.value, a == b, tt.shared)
+		}
+		if !Equals(a, b) || !Equals(a, &Integer{Value: tt.value}) {
+			t.Errorf("Integer %d is not equal to itself", tt.value)
+		}
+	}
+}
+
+func TestArithmeticUsesSharedIntegers(t *testing.T) {
+	quotient, _ := DivIntegers(NewInt64(100), NewInt64(10))
+	tests := []struct {
+		result   Object
+		expected int64
+	}{
+		{AddIntegers(NewInt64(2), NewInt64(3)), 5},
+		{SubIntegers(NewInt64(2), NewInt64(3)), -1},
+		{MulIntegers(NewInt64(20), NewInt64(30)), 600},
+		{quotient, 10},
+		{NegateInteger(NewInt64(7)), -7},
+	}
+
+	for _, tt := range tests {
+		if tt.result != NewInt64(tt.expected) {
+			t.Errorf("Result %s is not the shared integer %d", tt.result.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestSharedBooleans(t *testing.T) {
+	if NativeBool(true) != TRUE || NativeBool(false) != FALSE {
+		t.Errorf("NativeBool does not return the shared booleans")
+	}
+	if !Equals(TRUE, &Boolean{Value: true}) || Equals(TRUE, FALSE) {
+		t.Errorf("Shared booleans do not compare by value")
+	}
+	if !Equals(NULL, &Null{}) {
+		t.Errorf("Null is not equal to null")
+	}
+}
@@ -0,0 +1,71 @@
+package object
+
+// Equals compares two objects structurally. Composite values are equal when
+// they hold equal elements, while functions are only equal to themselves.
+func Equals(lhs, rhs Object) bool {
+	// Shared objects like small integers, booleans and null are immutable, so
+	// the same object is always equal to itself
+	if lhs == rhs {
+		return true
+	}
+
+	if IsInteger(lhs) && IsInteger(rhs) {
+		return CompareIntegers(lhs, rhs) == 0
+	}
+
+	if lhs.Type() != rhs.Type() {
+		return false
+	}
+
+	switch lhs := lhs.(type) {
+	case *Boolean:
+		return lhs.Value == rhs.(*Boolean).Value
+	case *String:
+		return lhs.Value == rhs.(*String).Value
+	case *Char:
+		return lhs.Value == rhs.(*Char).Value
+	case *Null:
+		return true
+	case *Array:
+		return elemsEqual(lhs.Elems, rhs.(*Array).Elems)
+	case *Tuple:
+		return elemsEqual(lhs.Elems, rhs.(*Tuple).Elems)
+	case *HashMap:
+		other := rhs.(*HashMap)
+		if lhs.Len() != other.Len() {
+			return false
+		}
+		for _, entry := range lhs.Entries() {
+			otherValue, ok := other.Get(entry.Key.(Hashable))
+			if !ok || !Equals(entry.Value, otherValue) {
+				return false
+			}
+		}
+		return true
+	case *Set:
+		other := rhs.(*Set)
+		if lhs.Len() != other.Len() {
+			return false
+		}
+		for _, elem := range lhs.Elems() {
+			if !other.Contains(elem.(Hashable)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return lhs == rhs
+}
+
+func elemsEqual(lhs, rhs []Object) bool {
+	if len(lhs) != len(rhs) {
+		return false
+	}
+	for i := range lhs {
+		if !Equals(lhs[i], rhs[i]) {
+			return false
+		}
+	}
+	return true
+}
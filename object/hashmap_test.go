@@ -0,0 +1,126 @@
+package object
+
+import "testing"
+
+// collidingKey is a hashable object whose hash only depends on its bucket,
+// which allows forcing collisions between otherwise different keys.
+type collidingKey struct {
+	name   string
+	bucket uint64
+}
+
+func (k *collidingKey) Type() ObjectType {
+	return STRING_OBJ
+}
+
+func (k *collidingKey) Inspect() string {
+	return k.name
+}
+
+func (k *collidingKey) HashKey() HashKey {
+	return HashKey{Type: STRING_OBJ, Hash: k.bucket}
+}
+
+func TestHashMapCollisions(t *testing.T) {
+	a := &collidingKey{name: "a"}
+	b := &collidingKey{name: "b"}
+	c := &collidingKey{name: "c"}
+	d := &collidingKey{name: "d", bucket: 1}
+
+	hashMap := NewHashMap()
+	hashMap.Set(a, &Integer{Value: 1})
+	hashMap.Set(b, &Integer{Value: 2})
+	hashMap.Set(c, &Integer{Value: 3})
+	hashMap.Set(d, &Integer{Value: 4})
+	hashMap.Set(b, &Integer{Value: 5})
+
+	if hashMap.Len() != 4 {
+		t.Fatalf("Unexpected map length. Expected 4, got %d", hashMap.Len())
+	}
+
+	tests := []struct {
+		key      Hashable
+		expected int64
+	}{
+		{a, 1},
+		{b, 5},
+		{c, 3},
+		{d, 4},
+	}
+
+	for _, tt := range tests {
+		value, ok := hashMap.Get(tt.key)
+		if !ok {
+			t.Errorf("Key %s not found", tt.key.Inspect())
+			continue
+		}
+		if value.(*Integer).Value != tt.expected {
+			t.Errorf("Unexpected value for key %s. Expected %d, got %s", tt.key.Inspect(), tt.expected, value.Inspect())
+		}
+	}
+
+	if _, ok := hashMap.Get(&collidingKey{name: "e"}); ok {
+		t.Errorf("Found key e, which was never inserted")
+	}
+
+	if !hashMap.Delete(b) {
+		t.Fatalf("Failed to delete key b")
+	}
+	if hashMap.Delete(b) {
+		t.Errorf("Deleted key b twice")
+	}
+	if hashMap.Len() != 3 {
+		t.Errorf("Unexpected map length after delete. Expected 3, got %d", hashMap.Len())
+	}
+	if _, ok := hashMap.Get(b); ok {
+		t.Errorf("Key b is still present after delete")
+	}
+	for _, key := range []Hashable{a, c, d} {
+		if _, ok := hashMap.Get(key); !ok {
+			t.Errorf("Key %s was lost when deleting b", key.Inspect())
+		}
+	}
+}
+
+func TestHashMapInsertionOrder(t *testing.T) {
+	hashMap := NewHashMap()
+	for _, k := range []string{"c", "a", "d", "b"} {
+		hashMap.Set(&String{Value: k}, &Integer{Value: 1})
+	}
+	hashMap.Set(&String{Value: "a"}, &Integer{Value: 2})
+	hashMap.Delete(&String{Value: "d"})
+
+	expected := "{c:1,a:2,b:1,}"
+	if hashMap.Inspect() != expected {
+		t.Errorf("Unexpected map representation. Expected %s, got %s", expected, hashMap.Inspect())
+	}
+}
+
+func TestSetCollisions(t *testing.T) {
+	a := &collidingKey{name: "a"}
+	b := &collidingKey{name: "b"}
+	c := &collidingKey{name: "c"}
+
+	set := NewSet()
+	set.Add(a)
+	set.Add(b)
+	set.Add(b)
+
+	if set.Len() != 2 {
+		t.Fatalf("Unexpected set length. Expected 2, got %d", set.Len())
+	}
+
+	other := NewSet()
+	other.Add(b)
+	other.Add(c)
+
+	if n := set.Union(other).Len(); n != 3 {
+		t.Errorf("Unexpected union length. Expected 3, got %d", n)
+	}
+	if n := set.Intersect(other).Len(); n != 1 {
+		t.Errorf("Unexpected intersection length. Expected 1, got %d", n)
+	}
+	if n := set.Difference(other).Len(); n != 1 {
+		t.Errorf("Unexpected difference length. Expected 1, got %d", n)
+	}
+}
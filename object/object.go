@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
-	"strings"
+	"unicode/utf8"
 
 	"github.com/javier-varez/monkey_interpreter/ast"
 	"github.com/javier-varez/monkey_interpreter/code"
@@ -15,10 +15,13 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ           = "INTEGER"
+	BIGINT_OBJ            = "BIGINT"
 	BOOLEAN_OBJ           = "BOOLEAN"
 	STRING_OBJ            = "STRING"
+	CHAR_OBJ              = "CHAR"
 	NULL_OBJ              = "NULL"
 	RETURN_VALUE_OBJ      = "RETURN_VALUE"
+	TAIL_CALL_OBJ         = "TAIL_CALL"
 	ERROR_VALUE_OBJ       = "ERROR_VALUE"
 	FUNCTION_OBJ          = "FUNCTION"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
@@ -27,6 +30,8 @@ const (
 	ARRAY_OBJ             = "ARRAY"
 	VAR_ARGS_OBJ          = "VAR_ARGS"
 	MAP_OBJ               = "MAP"
+	SET_OBJ               = "SET"
+	TUPLE_OBJ             = "TUPLE"
 )
 
 type Object interface {
@@ -70,6 +75,68 @@ func (s *String) Inspect() string {
 	return fmt.Sprintf("%s", s.Value)
 }
 
+// Char is a single unicode code point of a string.
+type Char struct {
+	Value rune
+}
+
+func (c *Char) Type() ObjectType {
+	return CHAR_OBJ
+}
+
+func (c *Char) Inspect() string {
+	return string(c.Value)
+}
+
+// CharAt returns the char at the given index of the string, counting in runes.
+func (s *String) CharAt(index int64) (*Char, bool) {
+	if index < 0 {
+		return nil, false
+	}
+	for _, ch := range s.Value {
+		if index == 0 {
+			return &Char{Value: ch}, true
+		}
+		index--
+	}
+	return nil, false
+}
+
+// Len returns the number of runes in the string.
+func (s *String) Len() int {
+	return utf8.RuneCountInString(s.Value)
+}
+
+// Concat joins strings and chars into a new string. At least one of the
+// operands must be a string.
+func Concat(lhs, rhs Object) (*String, bool) {
+	_, lhsIsString := lhs.(*String)
+	_, rhsIsString := rhs.(*String)
+	if !lhsIsString && !rhsIsString {
+		return nil, false
+	}
+
+	lhsText, ok := textOf(lhs)
+	if !ok {
+		return nil, false
+	}
+	rhsText, ok := textOf(rhs)
+	if !ok {
+		return nil, false
+	}
+	return &String{Value: lhsText + rhsText}, true
+}
+
+func textOf(obj Object) (string, bool) {
+	switch obj := obj.(type) {
+	case *String:
+		return obj.Value, true
+	case *Char:
+		return string(obj.Value), true
+	}
+	return "", false
+}
+
 type Null struct{}
 
 func (n *Null) Type() ObjectType {
@@ -92,9 +159,28 @@ func (r *Return) Inspect() string {
 	return r.Value.Inspect()
 }
 
+// TailCall is produced by the evaluator for calls in tail position. It is
+// resolved by the caller of the enclosing function, which reuses its own Go
+// stack frame to run the callee.
+type TailCall struct {
+	Fn   *Function
+	Args []Object
+}
+
+func (t *TailCall) Type() ObjectType {
+	return TAIL_CALL_OBJ
+}
+
+func (t *TailCall) Inspect() string {
+	return "<TailCall>"
+}
+
 type Error struct {
 	Message string
 	Span    token.Span
+	// Stack holds the calls that were running when the error happened,
+	// innermost first
+	Stack []StackFrame
 }
 
 func (e *Error) Type() ObjectType {
@@ -105,58 +191,13 @@ func (e *Error) Inspect() string {
 	return e.Message
 }
 
-const UNDERLINE = "\x1b[4m"
-const UNDERLINE_RESET = "\x1b[24m"
-const RED = "\x1b[31m"
-const RESET_COLOR = "\x1b[0m"
-
-func (e *Error) errLines(input string) []string {
-	lines := strings.Split(input, "\n")
-	return lines[e.Span.Start.Line : e.Span.End.Line+1]
-}
-
 func (e *Error) ContextualError() string {
-	var buffer bytes.Buffer
-
-	input := *e.Span.Text
-	startLine := e.Span.Start.Line
-	endLine := e.Span.End.Line
-
-	for lineIdx, line := range e.errLines(input) {
-		if lineIdx > startLine && lineIdx < endLine {
-			buffer.WriteString(line)
-		} else {
-			if lineIdx == startLine && lineIdx == endLine {
-				firstPart := line[:e.Span.Start.Column]
-				secondPart := line[e.Span.Start.Column:e.Span.End.Column]
-				thirdPart := line[e.Span.End.Column:]
-				buffer.WriteString(firstPart)
-				buffer.WriteString(UNDERLINE)
-				buffer.WriteString(secondPart)
-				buffer.WriteString(UNDERLINE_RESET)
-				buffer.WriteString(thirdPart)
-			} else if lineIdx == startLine {
-				firstPart := line[:e.Span.Start.Column]
-				secondPart := line[e.Span.Start.Column:e.Span.End.Column]
-				buffer.WriteString(firstPart)
-				buffer.WriteString(UNDERLINE)
-				buffer.WriteString(secondPart)
-			} else if lineIdx == endLine {
-				firstPart := line[e.Span.Start.Column:e.Span.End.Column]
-				secondPart := line[e.Span.End.Column:]
-				buffer.WriteString(firstPart)
-				buffer.WriteString(UNDERLINE_RESET)
-				buffer.WriteString(secondPart)
-			}
-		}
-		buffer.WriteByte('\n')
-	}
-
-	buffer.WriteString(fmt.Sprintf("\t%s%s%s\n", RED, e.Message, RESET_COLOR))
-	return buffer.String()
+	return ast.FormatContextualError(e.Span, e.Message)
 }
 
 type Function struct {
+	// Name is the binding the function was defined with, if any
+	Name    string
 	Args    []*ast.IdentifierExpr
 	VarArgs bool
 	Body    *ast.BlockStatement
@@ -198,10 +239,16 @@ func (f *Builtin) Inspect() string {
 }
 
 type CompiledFunction struct {
+	// Name is the binding the function was defined with, if any
+	Name         string
 	Instructions code.Instructions
 	NumLocals    int
 	NumArgs      int
 	VarArgs      bool
+
+	// Debug relates the instructions to the source, it is nil for functions
+	// compiled without it
+	Debug *code.DebugInfo
 }
 
 func (f *CompiledFunction) Type() ObjectType {
@@ -300,6 +347,13 @@ func (i *Integer) HashKey() HashKey {
 	}
 }
 
+func (a *Array) HashKey() HashKey {
+	return HashKey{
+		Type: ARRAY_OBJ,
+		Hash: hashElems(a.Elems),
+	}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64()
 	h.Write([]byte(s.Value))
@@ -311,14 +365,28 @@ func (s *String) HashKey() HashKey {
 	}
 }
 
+func (c *Char) HashKey() HashKey {
+	return HashKey{
+		Type: CHAR_OBJ,
+		Hash: uint64(c.Value),
+	}
+}
+
 type HashEntry struct {
 	Key   Object
 	Value Object
-	// TODO(ja): Deal with collisions. Implement chaining.
 }
 
+// HashMap maps hashable keys to values. Keys whose hashes collide are chained
+// in the same bucket and told apart with Equals. Entries are iterated in
+// insertion order.
 type HashMap struct {
-	Elems map[HashKey]HashEntry
+	buckets map[HashKey][]*HashEntry
+	entries []*HashEntry
+}
+
+func NewHashMap() *HashMap {
+	return &HashMap{buckets: map[HashKey][]*HashEntry{}}
 }
 
 func (a *HashMap) Type() ObjectType {
@@ -329,7 +397,7 @@ func (a *HashMap) Inspect() string {
 	var buffer bytes.Buffer
 
 	buffer.WriteString("{")
-	for _, v := range a.Elems {
+	for _, v := range a.entries {
 		buffer.WriteString(v.Key.Inspect())
 		buffer.WriteString(":")
 		buffer.WriteString(v.Value.Inspect())
@@ -339,3 +407,78 @@ func (a *HashMap) Inspect() string {
 
 	return buffer.String()
 }
+
+// Set associates value with key, replacing the value of an equal key if the
+// map already contains one. Replacing a value keeps the original position of
+// the key.
+func (a *HashMap) Set(key Hashable, value Object) {
+	hashKey := key.HashKey()
+	bucket := a.buckets[hashKey]
+	for _, entry := range bucket {
+		if Equals(entry.Key, key) {
+			entry.Value = value
+			return
+		}
+	}
+	entry := &HashEntry{Key: key, Value: value}
+	a.buckets[hashKey] = append(bucket, entry)
+	a.entries = append(a.entries, entry)
+}
+
+func (a *HashMap) Get(key Hashable) (Object, bool) {
+	for _, entry := range a.buckets[key.HashKey()] {
+		if Equals(entry.Key, key) {
+			return entry.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Delete removes key from the map, returning whether it was present.
+func (a *HashMap) Delete(key Hashable) bool {
+	hashKey := key.HashKey()
+	bucket := a.buckets[hashKey]
+	for i, entry := range bucket {
+		if !Equals(entry.Key, key) {
+			continue
+		}
+		if len(bucket) == 1 {
+			delete(a.buckets, hashKey)
+		} else {
+			rest := make([]*HashEntry, 0, len(bucket)-1)
+			rest = append(rest, bucket[:i]...)
+			a.buckets[hashKey] = append(rest, bucket[i+1:]...)
+		}
+		for j, ordered := range a.entries {
+			if ordered == entry {
+				a.entries = append(a.entries[:j], a.entries[j+1:]...)
+				break
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (a *HashMap) Len() int {
+	return len(a.entries)
+}
+
+// Entries returns the key-value pairs of the map in insertion order.
+func (a *HashMap) Entries() []HashEntry {
+	entries := make([]HashEntry, len(a.entries))
+	for i, entry := range a.entries {
+		entries[i] = *entry
+	}
+	return entries
+}
+
+// Copy returns a shallow copy of the map that can be modified without
+// affecting the original.
+func (a *HashMap) Copy() *HashMap {
+	result := NewHashMap()
+	for _, entry := range a.Entries() {
+		result.Set(entry.Key.(Hashable), entry.Value)
+	}
+	return result
+}
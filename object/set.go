@@ -0,0 +1,171 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// Set is an unordered collection of unique hashable objects. Elements are
+// stored as the keys of a HashMap, so colliding hashes are chained as well.
+type Set struct {
+	elems *HashMap
+}
+
+func NewSet() *Set {
+	return &Set{elems: NewHashMap()}
+}
+
+func (s *Set) Type() ObjectType {
+	return SET_OBJ
+}
+
+// Inspect lists the elements of the set sorted by their representation, so
+// that equal sets always look the same.
+func (s *Set) Inspect() string {
+	elems := []string{}
+	for _, elem := range s.Elems() {
+		elems = append(elems, elem.Inspect())
+	}
+	sort.Strings(elems)
+
+	var buffer bytes.Buffer
+
+	buffer.WriteString("{")
+	for i, elem := range elems {
+		buffer.WriteString(elem)
+		if i != len(elems)-1 {
+			buffer.WriteString(", ")
+		}
+	}
+	buffer.WriteString("}")
+
+	return buffer.String()
+}
+
+func (s *Set) Add(elem Hashable) {
+	s.elems.Set(elem, elem)
+}
+
+func (s *Set) Contains(elem Hashable) bool {
+	_, ok := s.elems.Get(elem)
+	return ok
+}
+
+func (s *Set) Len() int {
+	return s.elems.Len()
+}
+
+// Elems returns the elements of the set in no particular order.
+func (s *Set) Elems() []Object {
+	entries := s.elems.Entries()
+	elems := make([]Object, len(entries))
+	for i, entry := range entries {
+		elems[i] = entry.Key
+	}
+	return elems
+}
+
+func (s *Set) Union(other *Set) *Set {
+	result := NewSet()
+	for _, elem := range s.Elems() {
+		result.Add(elem.(Hashable))
+	}
+	for _, elem := range other.Elems() {
+		result.Add(elem.(Hashable))
+	}
+	return result
+}
+
+func (s *Set) Intersect(other *Set) *Set {
+	result := NewSet()
+	for _, elem := range s.Elems() {
+		if other.Contains(elem.(Hashable)) {
+			result.Add(elem.(Hashable))
+		}
+	}
+	return result
+}
+
+func (s *Set) Difference(other *Set) *Set {
+	result := NewSet()
+	for _, elem := range s.Elems() {
+		if !other.Contains(elem.(Hashable)) {
+			result.Add(elem.(Hashable))
+		}
+	}
+	return result
+}
+
+// Tuple is an immutable sequence of objects. Unlike arrays, tuples are
+// hashable and may be used as keys of maps and elements of sets.
+type Tuple struct {
+	Elems []Object
+}
+
+func (t *Tuple) Type() ObjectType {
+	return TUPLE_OBJ
+}
+
+func (t *Tuple) Inspect() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("(")
+	for i, obj := range t.Elems {
+		buffer.WriteString(obj.Inspect())
+		if i != len(t.Elems)-1 {
+			buffer.WriteString(", ")
+		}
+	}
+	if len(t.Elems) == 1 {
+		buffer.WriteString(",")
+	}
+	buffer.WriteString(")")
+
+	return buffer.String()
+}
+
+func (t *Tuple) HashKey() HashKey {
+	return HashKey{
+		Type: TUPLE_OBJ,
+		Hash: hashElems(t.Elems),
+	}
+}
+
+// hashElems hashes the contents of a sequence of objects, so that equal
+// sequences hash the same.
+func hashElems(elems []Object) uint64 {
+	h := fnv.New64()
+	for _, elem := range elems {
+		fmt.Fprintf(h, "%s:%x;", elem.Type(), hashContent(elem))
+	}
+	return h.Sum64()
+}
+
+// hashContent hashes an object consistently with Equals. Maps and sets are
+// equal regardless of their order, so the hashes of their entries are
+// combined with a sum. Other objects that are not hashable contribute with
+// their representation.
+func hashContent(obj Object) uint64 {
+	switch obj := obj.(type) {
+	case Hashable:
+		return obj.HashKey().Hash
+	case *HashMap:
+		var sum uint64
+		for _, entry := range obj.Entries() {
+			sum += hashElems([]Object{entry.Key, entry.Value})
+		}
+		return sum
+	case *Set:
+		var sum uint64
+		for _, elem := range obj.Elems() {
+			sum += hashElems([]Object{elem})
+		}
+		return sum
+	}
+
+	h := fnv.New64()
+	h.Write([]byte(obj.Inspect()))
+	return h.Sum64()
+}
@@ -0,0 +1,61 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+// ANONYMOUS_FUNCTION names the functions that were not bound with let.
+const ANONYMOUS_FUNCTION = "<anonymous>"
+
+// StackFrame is a call that was running when a runtime error happened.
+type StackFrame struct {
+	// Function is the name of the called function, empty if it has none
+	Function string
+	// CallSite is the call expression
+	CallSite token.Span
+}
+
+// StackTracer is implemented by the errors of running programs, returning the
+// calls that led to them, innermost first.
+type StackTracer interface {
+	StackTrace() []StackFrame
+}
+
+func (e *Error) StackTrace() []StackFrame {
+	return e.Stack
+}
+
+func (f StackFrame) String() string {
+	name := f.Function
+	if name == "" {
+		name = ANONYMOUS_FUNCTION
+	}
+	return fmt.Sprintf("in %s, called at line %d, column %d", name, f.CallSite.Start.Line+1, f.CallSite.Start.Column+1)
+}
+
+// FormatStackTrace renders the calls that led to a runtime error, innermost
+// first. Repeated frames of recursive calls are collapsed.
+func FormatStackTrace(stack []StackFrame) string {
+	if len(stack) == 0 {
+		return ""
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("Stack trace, most recent call first:\n")
+	for i := 0; i < len(stack); {
+		buffer.WriteString(fmt.Sprintf("\t%s\n", stack[i]))
+
+		repeated := 0
+		for i+1+repeated < len(stack) && stack[i+1+repeated] == stack[i] {
+			repeated++
+		}
+		if repeated > 0 {
+			buffer.WriteString(fmt.Sprintf("\t... repeated %d more times\n", repeated))
+		}
+		i += 1 + repeated
+	}
+	return buffer.String()
+}
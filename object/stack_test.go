@@ -0,0 +1,40 @@
+package object
+
+import (
+	"testing"
+
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+func TestFormatStackTrace(t *testing.T) {
+	text := "f(1)\ng(2)"
+	f := StackFrame{Function: "f", CallSite: token.Span{Start: token.Location{Line: 0, Column: 0}, Text: &text}}
+	anonymous := StackFrame{CallSite: token.Span{Start: token.Location{Line: 1, Column: 2}, Text: &text}}
+
+	tests := []struct {
+		stack    []StackFrame
+		expected string
+	}{
+		{[]StackFrame{}, ""},
+		{
+			[]StackFrame{anonymous, f},
+			"Stack trace, most recent call first:\n" +
+				"\tin <anonymous>, called at line 2, column 3\n" +
+				"\tin f, called at line 1, column 1\n",
+		},
+		{
+			[]StackFrame{f, f, f, anonymous, f},
+			"Stack trace, most recent call first:\n" +
+				"\tin f, called at line 1, column 1\n" +
+				"\t... repeated 2 more times\n" +
+				"\tin <anonymous>, called at line 2, column 3\n" +
+				"\tin f, called at line 1, column 1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		if actual := FormatStackTrace(tt.stack); actual != tt.expected {
+			t.Errorf("Unexpected stack trace. Expected %q, got %q", tt.expected, actual)
+		}
+	}
+}
@@ -1,8 +1,6 @@
 package parser
 
 import (
-	"bytes"
-	"fmt"
 	"log"
 	"strconv"
 	"strings"
@@ -19,25 +17,27 @@ const (
 	RANGE       // 1..2
 	EQUALS      // == or !=
 	LESSGREATER // < or >
-	SUM         // +
-	PRODUCT     // *
+	SUM         // + or |
+	PRODUCT     // * or &
 	PREFIX      // - or !
 	CALL        // fn(x)
 	ARRAY_IDX   // array[idx]
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.ASTERISK: PRODUCT,
-	token.SLASH:    PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: ARRAY_IDX,
-	token.TWO_DOTS: RANGE,
+	token.EQ:        EQUALS,
+	token.NOT_EQ:    EQUALS,
+	token.LT:        LESSGREATER,
+	token.GT:        LESSGREATER,
+	token.PLUS:      SUM,
+	token.MINUS:     SUM,
+	token.PIPE:      SUM,
+	token.ASTERISK:  PRODUCT,
+	token.SLASH:     PRODUCT,
+	token.AMPERSAND: PRODUCT,
+	token.LPAREN:    CALL,
+	token.LBRACKET:  ARRAY_IDX,
+	token.TWO_DOTS:  RANGE,
 }
 
 type prefixParseFn func() ast.Expression
@@ -52,54 +52,8 @@ func (p *parseError) Error() string {
 	return p.errorMsg
 }
 
-func (p *parseError) errLines() []string {
-	lines := strings.Split(*p.span.Text, "\n")
-	return lines[p.span.Start.Line : p.span.End.Line+1]
-}
-
-const UNDERLINE = "\x1b[4m"
-const UNDERLINE_RESET = "\x1b[24m"
-const RED = "\x1b[31m"
-const RESET_COLOR = "\x1b[0m"
-
 func (p *parseError) ContextualError() string {
-	var buffer bytes.Buffer
-
-	startLine := p.span.Start.Line
-	endLine := p.span.End.Line
-
-	for lineIdx, line := range p.errLines() {
-		if lineIdx > startLine && lineIdx < endLine {
-			buffer.WriteString(line)
-		} else {
-			if lineIdx == startLine && lineIdx == endLine {
-				firstPart := line[:p.span.Start.Column]
-				secondPart := line[p.span.Start.Column:p.span.End.Column]
-				thirdPart := line[p.span.End.Column:]
-				buffer.WriteString(firstPart)
-				buffer.WriteString(UNDERLINE)
-				buffer.WriteString(secondPart)
-				buffer.WriteString(UNDERLINE_RESET)
-				buffer.WriteString(thirdPart)
-			} else if lineIdx == startLine {
-				firstPart := line[:p.span.Start.Column]
-				secondPart := line[p.span.Start.Column:p.span.End.Column]
-				buffer.WriteString(firstPart)
-				buffer.WriteString(UNDERLINE)
-				buffer.WriteString(secondPart)
-			} else if lineIdx == endLine {
-				firstPart := line[p.span.Start.Column:p.span.End.Column]
-				secondPart := line[p.span.End.Column:]
-				buffer.WriteString(firstPart)
-				buffer.WriteString(UNDERLINE_RESET)
-				buffer.WriteString(secondPart)
-			}
-		}
-		buffer.WriteByte('\n')
-	}
-
-	buffer.WriteString(fmt.Sprintf("\t%s%s%s\n", RED, p.errorMsg, RESET_COLOR))
-	return buffer.String()
+	return ast.FormatContextualError(p.span, p.errorMsg)
 }
 
 func (p *parseError) Span() token.Span {
@@ -144,6 +98,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.infixParseFns[token.MINUS] = p.parseInfixExpr
 	p.infixParseFns[token.ASTERISK] = p.parseInfixExpr
 	p.infixParseFns[token.SLASH] = p.parseInfixExpr
+	p.infixParseFns[token.PIPE] = p.parseInfixExpr
+	p.infixParseFns[token.AMPERSAND] = p.parseInfixExpr
 	p.infixParseFns[token.EQ] = p.parseInfixExpr
 	p.infixParseFns[token.NOT_EQ] = p.parseInfixExpr
 	p.infixParseFns[token.GT] = p.parseInfixExpr
@@ -174,6 +130,7 @@ func (p *Parser) ParseProgram() *ast.Program {
 	}
 
 	program.Diagnostics = p.errors
+	program.Comments = p.l.Comments()
 	return program
 }
 
@@ -211,10 +168,19 @@ func (p *Parser) parseBooleanLiteralExpr() ast.Expression {
 }
 
 func (p *Parser) parseGroupedExpr() ast.Expression {
+	lparen := p.curToken
 	p.nextToken()
 
+	if p.curToken.Type == token.RPAREN {
+		return &ast.TupleLiteralExpr{Lparen: lparen, Rparen: p.curToken, Elems: []ast.Expression{}}
+	}
+
 	exp := p.parseExpression(LOWEST)
 
+	if p.peekToken.Type == token.COMMA {
+		return p.parseTupleLiteralExpr(lparen, exp)
+	}
+
 	if p.peekToken.Type != token.RPAREN {
 		return nil
 	}
@@ -223,6 +189,38 @@ func (p *Parser) parseGroupedExpr() ast.Expression {
 	return exp
 }
 
+// parseTupleLiteralExpr parses the remaining elements of a tuple, once the
+// first element and the comma following it have been found.
+func (p *Parser) parseTupleLiteralExpr(lparen token.Token, first ast.Expression) ast.Expression {
+	expr := &ast.TupleLiteralExpr{
+		Lparen: lparen,
+		Elems:  []ast.Expression{first},
+	}
+
+	// Skip the comma after the first element
+	p.nextToken()
+	p.nextToken()
+
+	for p.curToken.Type != token.RPAREN {
+		if p.curToken.Type == token.EOF {
+			p.mkError(p.curToken.Span, "Expected ) delimiter to close tuple literal")
+			return nil
+		}
+
+		inner := p.parseExpression(LOWEST)
+		expr.Elems = append(expr.Elems, inner)
+
+		p.nextToken()
+		if p.curToken.Type == token.COMMA {
+			p.nextToken()
+		}
+	}
+
+	expr.Rparen = p.curToken
+
+	return expr
+}
+
 func (p *Parser) parseIfExpr() ast.Expression {
 	expr := ast.IfExpr{
 		IfToken: p.curToken,
@@ -286,7 +284,8 @@ func (p *Parser) parseFnLiteralExpr() ast.Expression {
 			return nil
 		}
 
-		if p.peekToken.Type != token.COMMA && p.peekToken.Type != token.RPAREN {
+		if p.peekToken.Type != token.COMMA && p.peekToken.Type != token.RPAREN &&
+			!(p.curToken.Type == token.IDENT && p.peekToken.Type == token.COLON) {
 			p.mkError(p.peekToken.Span, "Invalid token found in argument list of fn literal expression")
 			return nil
 		}
@@ -300,7 +299,21 @@ func (p *Parser) parseFnLiteralExpr() ast.Expression {
 			}
 		} else {
 			identExpr := p.parseIdentExpr().(*ast.IdentifierExpr)
+			var argType ast.TypeExpr
+			if p.peekToken.Type == token.COLON {
+				p.nextToken()
+				p.nextToken()
+				argType = p.parseType()
+				if argType == nil {
+					return nil
+				}
+				if p.peekToken.Type != token.COMMA && p.peekToken.Type != token.RPAREN {
+					p.mkError(p.peekToken.Span, "Invalid token found in argument list of fn literal expression")
+					return nil
+				}
+			}
 			expr.Args = append(expr.Args, identExpr)
+			expr.ArgTypes = append(expr.ArgTypes, argType)
 			p.nextToken()
 		}
 
@@ -309,6 +322,15 @@ func (p *Parser) parseFnLiteralExpr() ast.Expression {
 		}
 	}
 
+	if p.peekToken.Type == token.ARROW {
+		p.nextToken()
+		p.nextToken()
+		expr.ReturnType = p.parseType()
+		if expr.ReturnType == nil {
+			return nil
+		}
+	}
+
 	if p.peekToken.Type != token.LBRACE {
 		p.mkError(p.peekToken.Span, "Expected body of fn literal")
 		return nil
@@ -316,9 +338,58 @@ func (p *Parser) parseFnLiteralExpr() ast.Expression {
 	p.nextToken()
 
 	expr.Body = p.parseBlockStatement()
+	markTailCalls(expr.Body, true)
 	return expr
 }
 
+// markTailCalls flags the call expressions in tail position of a function
+// body. Return statements are always in tail position, while the last
+// expression of a block is only in tail position if the block itself is.
+func markTailCalls(block *ast.BlockStatement, isTail bool) {
+	for i, stmt := range block.Statements {
+		isLast := isTail && i == len(block.Statements)-1
+
+		switch stmt := stmt.(type) {
+		case *ast.ReturnStatement:
+			if stmt != nil {
+				markTailExpr(stmt.Expr)
+			}
+		case *ast.ExpressionStatement:
+			if stmt == nil {
+				continue
+			}
+
+			if isLast {
+				markTailExpr(stmt.Expr)
+			} else if ifExpr, ok := stmt.Expr.(*ast.IfExpr); ok && ifExpr != nil {
+				markIfExprTailCalls(ifExpr, false)
+			}
+		}
+	}
+}
+
+func markTailExpr(expr ast.Expression) {
+	switch expr := expr.(type) {
+	case *ast.CallExpr:
+		if expr != nil {
+			expr.IsTailCall = true
+		}
+	case *ast.IfExpr:
+		if expr != nil {
+			markIfExprTailCalls(expr, true)
+		}
+	}
+}
+
+func markIfExprTailCalls(expr *ast.IfExpr, isTail bool) {
+	if expr.Consequence != nil {
+		markTailCalls(expr.Consequence, isTail)
+	}
+	if expr.Alternative != nil {
+		markTailCalls(expr.Alternative, isTail)
+	}
+}
+
 func (p *Parser) parseStringLiteralExpr() ast.Expression {
 	return &ast.StringLiteralExpr{
 		StringLitToken: p.curToken,
@@ -332,8 +403,8 @@ func (p *Parser) parseVarArgsLiteralExpr() ast.Expression {
 
 func (p *Parser) parseMapLiteralExpr() ast.Expression {
 	expr := &ast.MapLiteralExpr{
-		Lbrace: p.curToken,
-		Map:    map[ast.Expression]ast.Expression{},
+		Lbrace:  p.curToken,
+		Entries: []ast.MapEntry{},
 	}
 
 	p.nextToken()
@@ -342,6 +413,10 @@ func (p *Parser) parseMapLiteralExpr() ast.Expression {
 		key := p.parseExpression(LOWEST)
 
 		if p.peekToken.Type != token.COLON {
+			// Braces holding elements that are not key-value pairs are a set
+			if len(expr.Entries) == 0 {
+				return p.parseSetLiteralExpr(expr.Lbrace, key)
+			}
 			p.mkError(p.peekToken.Span, "Expected colon to separate key and value")
 			return nil
 		}
@@ -349,7 +424,40 @@ func (p *Parser) parseMapLiteralExpr() ast.Expression {
 		p.nextToken()
 
 		value := p.parseExpression(LOWEST)
-		expr.Map[key] = value
+		expr.Entries = append(expr.Entries, ast.MapEntry{Key: key, Value: value})
+
+		p.nextToken()
+		if p.curToken.Type == token.COMMA {
+			p.nextToken()
+		}
+	}
+
+	expr.Rbrace = p.curToken
+
+	return expr
+}
+
+// parseSetLiteralExpr parses the remaining elements of a set, once its first
+// element has been found.
+func (p *Parser) parseSetLiteralExpr(lbrace token.Token, first ast.Expression) ast.Expression {
+	expr := &ast.SetLiteralExpr{
+		Lbrace: lbrace,
+		Elems:  []ast.Expression{first},
+	}
+
+	p.nextToken()
+	if p.curToken.Type == token.COMMA {
+		p.nextToken()
+	}
+
+	for p.curToken.Type != token.RBRACE {
+		if p.curToken.Type == token.EOF {
+			p.mkError(p.curToken.Span, "Expected } delimiter to close set literal")
+			return nil
+		}
+
+		inner := p.parseExpression(LOWEST)
+		expr.Elems = append(expr.Elems, inner)
 
 		p.nextToken()
 		if p.curToken.Type == token.COMMA {
@@ -371,6 +479,11 @@ func (p *Parser) parseArrayLiteralExpr() ast.Expression {
 	p.nextToken()
 
 	for p.curToken.Type != token.RBRACKET {
+		if p.curToken.Type == token.EOF {
+			p.mkError(p.curToken.Span, "Expected ] delimiter to close array literal")
+			return nil
+		}
+
 		inner := p.parseExpression(LOWEST)
 		expr.Elems = append(expr.Elems, inner)
 
@@ -487,6 +600,15 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt.IdentExpr = p.parseIdentExpr()
 	p.nextToken()
 
+	if p.curToken.Type == token.COLON {
+		p.nextToken()
+		stmt.Type = p.parseType()
+		if stmt.Type == nil {
+			return nil
+		}
+		p.nextToken()
+	}
+
 	if p.curToken.Type != token.ASSIGN {
 		p.mkError(p.curToken.Span, "Expected \"=\" in let statement")
 		return nil
@@ -532,6 +654,15 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	}
 
 	for p.peekToken.Type != token.RBRACE {
+		if p.peekToken.Type == token.EOF {
+			// Keep the statements parsed so far, so that tools can still
+			// inspect incomplete programs
+			p.nextToken()
+			p.mkError(p.curToken.Span, "Expected } delimiter to close block")
+			stmt.Rbrace = p.curToken
+			return stmt
+		}
+
 		p.nextToken()
 		s := p.parseStatement()
 		stmt.Statements = append(stmt.Statements, s)
@@ -589,16 +720,19 @@ func (p *Parser) parseStatement() ast.Statment {
 	}
 }
 
-func (p *Parser) peekPrecedence() int {
-	if precedence, ok := precedences[p.peekToken.Type]; ok {
+// Precedence returns the precedence of the infix operator of the given type,
+// or LOWEST if it is not an infix operator.
+func Precedence(tokenType token.TokenType) int {
+	if precedence, ok := precedences[tokenType]; ok {
 		return precedence
 	}
 	return LOWEST
 }
 
+func (p *Parser) peekPrecedence() int {
+	return Precedence(p.peekToken.Type)
+}
+
 func (p *Parser) curPrecedence() int {
-	if precedence, ok := precedences[p.curToken.Type]; ok {
-		return precedence
-	}
-	return LOWEST
+	return Precedence(p.curToken.Type)
 }
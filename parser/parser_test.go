@@ -2,6 +2,7 @@ package parser
 
 import (
 	"testing"
+	"time"
 
 	"github.com/javier-varez/monkey_interpreter/ast"
 	"github.com/javier-varez/monkey_interpreter/lexer"
@@ -266,6 +267,8 @@ func TestInfixExpressions(t *testing.T) {
 		{"5>5;", ">", 5, 5},
 		{"5== 5;", "==", 5, 5},
 		{"5 !=5 ;", "!=", 5, 5},
+		{"5 | 5;", "|", 5, 5},
+		{"5 & 5;", "&", 5, 5},
 	}
 
 	for pIdx, test := range tests {
@@ -353,6 +356,13 @@ func TestPrecedence(t *testing.T) {
 		{"a + add(b * c) + d", "((a+add((b*c)))+d)"},
 		{"add(a, b, 1, 2 * 3, 4 + 5, add(6, 7 * 8))", "add(a,b,1,(2*3),(4+5),add(6,(7*8)))"},
 		{"let a = 300;", "let a = 300;"},
+		{"a | b & c", "(a|(b&c))"},
+		{"a - b | c - d", "(((a-b)|c)-d)"},
+		{"a & b * c", "((a&b)*c)"},
+		{"(a, b + c)", "(a, (b+c))"},
+		{"(a,)", "(a,)"},
+		{"()", "()"},
+		{"{a, b | c}", "{a, (b|c)}"},
 	}
 
 	for _, test := range tests {
@@ -481,12 +491,13 @@ func testMapLiteralExpression(t *testing.T, expr ast.Expression, expectedMap map
 		return false
 	}
 
-	if len(mapExpr.Map) != len(expectedMap) {
-		t.Errorf("Map sizes do not match. Expected %d, got %d", len(expectedMap), len(mapExpr.Map))
+	if len(mapExpr.Entries) != len(expectedMap) {
+		t.Errorf("Map sizes do not match. Expected %d, got %d", len(expectedMap), len(mapExpr.Entries))
 		return false
 	}
 
-	for k, v := range mapExpr.Map {
+	for _, entry := range mapExpr.Entries {
+		k, v := entry.Key, entry.Value
 		kStr, ok := k.(*ast.StringLiteralExpr)
 		if !ok {
 			t.Errorf("Key is not a string literal %v", k)
@@ -806,6 +817,88 @@ func TestCallExpression(t *testing.T) {
 	}
 }
 
+func collectCallExprs(node ast.Node, calls map[string]*ast.CallExpr) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, stmt := range node.Statements {
+			collectCallExprs(stmt, calls)
+		}
+	case *ast.BlockStatement:
+		for _, stmt := range node.Statements {
+			collectCallExprs(stmt, calls)
+		}
+	case *ast.ExpressionStatement:
+		collectCallExprs(node.Expr, calls)
+	case *ast.ReturnStatement:
+		collectCallExprs(node.Expr, calls)
+	case *ast.LetStatement:
+		collectCallExprs(node.Expr, calls)
+	case *ast.InfixExpr:
+		collectCallExprs(node.LeftExpr, calls)
+		collectCallExprs(node.RightExpr, calls)
+	case *ast.IfExpr:
+		collectCallExprs(node.Condition, calls)
+		collectCallExprs(node.Consequence, calls)
+		if node.Alternative != nil {
+			collectCallExprs(node.Alternative, calls)
+		}
+	case *ast.FnLiteralExpr:
+		collectCallExprs(node.Body, calls)
+	case *ast.CallExpr:
+		calls[node.CallableExpr.String()] = node
+		collectCallExprs(node.CallableExpr, calls)
+		for _, arg := range node.Args {
+			collectCallExprs(arg, calls)
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input     string
+		tailCalls map[string]bool
+	}{
+		{`a()`, map[string]bool{"a": false}},
+		{`fn() { a() }`, map[string]bool{"a": true}},
+		{`fn() { a(); }`, map[string]bool{"a": true}},
+		{`fn() { a(); b() }`, map[string]bool{"a": false, "b": true}},
+		{`fn() { return a(b()); }`, map[string]bool{"a": true, "b": false}},
+		{`fn() { a() + b() }`, map[string]bool{"a": false, "b": false}},
+		{`fn() { let x = a(); x }`, map[string]bool{"a": false}},
+		{`fn() { if (a()) { b() } else { c() } }`, map[string]bool{"a": false, "b": true, "c": true}},
+		{`fn() { if (x) { b() }; c() }`, map[string]bool{"b": false, "c": true}},
+		{`fn() { if (x) { return b(); }; c() }`, map[string]bool{"b": true, "c": true}},
+		{`fn() { fn() { a() } }`, map[string]bool{"a": true}},
+		{`fn() { fn() { a() }() }`, map[string]bool{"a": true, "fn() {a()}": true}},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkDiagnostics(t, program)
+
+		calls := map[string]*ast.CallExpr{}
+		collectCallExprs(program, calls)
+
+		if len(calls) != len(tt.tailCalls) {
+			t.Fatalf("%q: unexpected number of call expressions. got=%d, want=%d", tt.input, len(calls), len(tt.tailCalls))
+		}
+
+		for name, isTailCall := range tt.tailCalls {
+			call, ok := calls[name]
+			if !ok {
+				t.Fatalf("%q: call to %q not found", tt.input, name)
+			}
+
+			if call.IsTailCall != isTailCall {
+				t.Errorf("%q: unexpected tail call flag for %q. got=%t, want=%t", tt.input, name, call.IsTailCall, isTailCall)
+			}
+		}
+	}
+}
+
 func TestEmptyCallExpression(t *testing.T) {
 	input := `add();`
 	l := lexer.New(input)
@@ -881,6 +974,83 @@ func TestArrayLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestSetLiteralExpression(t *testing.T) {
+	input := `{123, test, true}`
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	if program == nil {
+		t.Fatalf("ParseProgram() returned a nil program")
+	}
+
+	checkDiagnostics(t, program)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("Statement is not an expression: %T", program.Statements[0])
+	}
+
+	setLiteralExpr, ok := stmt.Expr.(*ast.SetLiteralExpr)
+	if !ok {
+		t.Fatalf("Expression is not a set literal: %T", stmt.Expr)
+	}
+
+	expected := []interface{}{123, "test", true}
+	if len(setLiteralExpr.Elems) != len(expected) {
+		t.Fatalf("Number of elements in set does not match. Expected %d, got %d", len(expected), len(setLiteralExpr.Elems))
+	}
+
+	for i := range setLiteralExpr.Elems {
+		if !testLiteralExpression(t, setLiteralExpr.Elems[i], expected[i]) {
+			t.Errorf("Error found in element with index: %d", i)
+		}
+	}
+}
+
+func TestTupleLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []interface{}
+	}{
+		{`(123, test, true)`, []interface{}{123, "test", true}},
+		{`(123,)`, []interface{}{123}},
+		{`()`, []interface{}{}},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		if program == nil {
+			t.Fatalf("ParseProgram() returned a nil program")
+		}
+
+		checkDiagnostics(t, program)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("Statement is not an expression: %T", program.Statements[0])
+		}
+
+		tupleLiteralExpr, ok := stmt.Expr.(*ast.TupleLiteralExpr)
+		if !ok {
+			t.Fatalf("Expression is not a tuple literal: %T", stmt.Expr)
+		}
+
+		if len(tupleLiteralExpr.Elems) != len(tt.expected) {
+			t.Fatalf("Number of elements in tuple does not match. Expected %d, got %d", len(tt.expected), len(tupleLiteralExpr.Elems))
+		}
+
+		for i := range tupleLiteralExpr.Elems {
+			if !testLiteralExpression(t, tupleLiteralExpr.Elems[i], tt.expected[i]) {
+				t.Errorf("Error found in element with index: %d", i)
+			}
+		}
+	}
+}
+
 func TestArrayIndexOperator(t *testing.T) {
 	input := `a[123]`
 	l := lexer.New(input)
@@ -982,3 +1152,97 @@ func TestMapLiteralExpression(t *testing.T) {
 		return
 	}
 }
+
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 1;", "let x: int = 1;"},
+		{"let f = fn(a: [int], b: string, ...) -> int { 1 }", "let f = fn(a: [int],b: string,...) -> int {1}"},
+		{"let f = fn(a, b: bool) { a }", "let f = fn(a,b: bool) {a}"},
+		{"let g: fn(int, ...) -> {string: (int, bool)} = 1", "let g: fn(int, ...) -> {string: (int, bool)} = 1"},
+		{"let s: {(int,)} = 1", "let s: {(int,)} = 1"},
+		{"let p: (int) = 1", "let p: int = 1"},
+		{"let h: fn() = 1", "let h: fn() = 1"},
+		{"let e: () = ()", "let e: () = ()"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkDiagnostics(t, program)
+
+		if program.String() != tt.expected {
+			t.Errorf("Unexpected program. Expected %q, got %q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestTypeAnnotationErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: = 1", "Expected a type"},
+		{"let y: [int = 1", "Expected ] delimiter to close array type"},
+		{"let z: {int, int} = 1", "Expected colon to separate key and value types"},
+		{"fn(a: int b) {}", "Invalid token found in argument list of fn literal expression"},
+		{"fn() -> {}", "Expected a type"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		if len(program.Diagnostics) == 0 {
+			t.Errorf("Expected diagnostics for %q", tt.input)
+			continue
+		}
+
+		if program.Diagnostics[0].Error() != tt.expected {
+			t.Errorf("Unexpected diagnostic for %q. Expected %q, got %q", tt.input, tt.expected, program.Diagnostics[0].Error())
+		}
+	}
+}
+
+// Editors parse documents while they are being typed, so incomplete input
+// must end with a diagnostic instead of looping forever
+func TestIncompleteInput(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn(a) {\n", "Expected } delimiter to close block"},
+		{"let x = [1, 2", "Expected ] delimiter to close array literal"},
+		{"(1, 2", "Expected ) delimiter to close tuple literal"},
+		{"{1, 2", "Expected } delimiter to close set literal"},
+		{"fn(.", "Parameters to an fn literal must be identifier expressions or \"...\""},
+		{"self(self, .", "Invalid token"},
+		{"reduce(2.", "Invalid delimiter token found in call expression argument list"},
+		{"let x = .", "Invalid token"},
+	}
+
+	for _, tt := range tests {
+		done := make(chan *ast.Program)
+		go func() {
+			done <- New(lexer.New(tt.input)).ParseProgram()
+		}()
+
+		select {
+		case program := <-done:
+			if len(program.Diagnostics) == 0 {
+				t.Errorf("Expected diagnostics for %q", tt.input)
+				continue
+			}
+			if program.Diagnostics[0].Error() != tt.expected {
+				t.Errorf("Unexpected diagnostic for %q. Expected %q, got %q", tt.input, tt.expected, program.Diagnostics[0].Error())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Parsing %q does not end", tt.input)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package parser
+
+import (
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+// parseType parses a type annotation starting at the current token. When it
+// returns, the current token is the last token of the annotation.
+func (p *Parser) parseType() ast.TypeExpr {
+	switch p.curToken.Type {
+	case token.IDENT:
+		return &ast.NamedType{Token: p.curToken}
+	case token.LBRACKET:
+		return p.parseArrayType()
+	case token.LBRACE:
+		return p.parseMapOrSetType()
+	case token.LPAREN:
+		return p.parseTupleType()
+	case token.FUNCTION:
+		return p.parseFnType()
+	}
+
+	p.mkError(p.curToken.Span, "Expected a type")
+	return nil
+}
+
+func (p *Parser) parseArrayType() ast.TypeExpr {
+	t := &ast.ArrayType{Lbracket: p.curToken}
+	p.nextToken()
+
+	t.Elem = p.parseType()
+	if t.Elem == nil {
+		return nil
+	}
+
+	if p.peekToken.Type != token.RBRACKET {
+		p.mkError(p.peekToken.Span, "Expected ] delimiter to close array type")
+		return nil
+	}
+	p.nextToken()
+	t.Rbracket = p.curToken
+
+	return t
+}
+
+func (p *Parser) parseMapOrSetType() ast.TypeExpr {
+	lbrace := p.curToken
+	p.nextToken()
+
+	elem := p.parseType()
+	if elem == nil {
+		return nil
+	}
+
+	if p.peekToken.Type == token.RBRACE {
+		p.nextToken()
+		return &ast.SetType{Lbrace: lbrace, Elem: elem, Rbrace: p.curToken}
+	}
+
+	if p.peekToken.Type != token.COLON {
+		p.mkError(p.peekToken.Span, "Expected colon to separate key and value types")
+		return nil
+	}
+	p.nextToken()
+	p.nextToken()
+
+	value := p.parseType()
+	if value == nil {
+		return nil
+	}
+
+	if p.peekToken.Type != token.RBRACE {
+		p.mkError(p.peekToken.Span, "Expected } delimiter to close map type")
+		return nil
+	}
+	p.nextToken()
+
+	return &ast.MapType{Lbrace: lbrace, Key: elem, Value: value, Rbrace: p.curToken}
+}
+
+// parseTupleType parses tuple types. A single type between parentheses
+// without a trailing comma is not a tuple, just a grouped type.
+func (p *Parser) parseTupleType() ast.TypeExpr {
+	t := &ast.TupleType{Lparen: p.curToken, Elems: []ast.TypeExpr{}}
+	p.nextToken()
+
+	isTuple := p.curToken.Type == token.RPAREN
+	for p.curToken.Type != token.RPAREN {
+		elem := p.parseType()
+		if elem == nil {
+			return nil
+		}
+		t.Elems = append(t.Elems, elem)
+		p.nextToken()
+
+		if p.curToken.Type == token.COMMA {
+			isTuple = true
+			p.nextToken()
+		} else if p.curToken.Type != token.RPAREN {
+			p.mkError(p.curToken.Span, "Expected ) delimiter to close tuple type")
+			return nil
+		}
+	}
+	t.Rparen = p.curToken
+
+	if !isTuple {
+		return t.Elems[0]
+	}
+	return t
+}
+
+func (p *Parser) parseFnType() ast.TypeExpr {
+	t := &ast.FnType{FnToken: p.curToken, Args: []ast.TypeExpr{}}
+
+	if p.peekToken.Type != token.LPAREN {
+		p.mkError(p.peekToken.Span, "fn type must be followed by argument list")
+		return nil
+	}
+	p.nextToken()
+	p.nextToken()
+
+	for p.curToken.Type != token.RPAREN {
+		if p.curToken.Type == token.THREE_DOTS {
+			t.VarArgs = true
+			p.nextToken()
+			if p.curToken.Type != token.RPAREN {
+				p.mkError(p.curToken.Span, "`...` var args must be the last argument to a function")
+				return nil
+			}
+			break
+		}
+
+		arg := p.parseType()
+		if arg == nil {
+			return nil
+		}
+		t.Args = append(t.Args, arg)
+		p.nextToken()
+
+		if p.curToken.Type == token.COMMA {
+			p.nextToken()
+		} else if p.curToken.Type != token.RPAREN {
+			p.mkError(p.curToken.Span, "Invalid token found in argument list of fn type")
+			return nil
+		}
+	}
+	t.Rparen = p.curToken
+
+	if p.peekToken.Type == token.ARROW {
+		p.nextToken()
+		p.nextToken()
+		t.Return = p.parseType()
+		if t.Return == nil {
+			return nil
+		}
+	}
+
+	return t
+}
@@ -0,0 +1,168 @@
+package regvm
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Opcode identifies the operation of a register instruction. Registers are
+// numbered from the base of the frame of the running function, where its
+// arguments come first, followed by its locals and temporary values.
+type Opcode byte
+
+const (
+	// OpLoadConstant stores constant B in register A
+	OpLoadConstant Opcode = iota
+	// OpLoadTrue, OpLoadFalse and OpLoadNull store the value in register A
+	OpLoadTrue
+	OpLoadFalse
+	OpLoadNull
+	// OpMove copies register B to register A
+	OpMove
+	// OpGetGlobal stores global B in register A
+	OpGetGlobal
+	// OpSetGlobal stores register B in global A
+	OpSetGlobal
+	// OpGetFree stores free variable B of the closure in register A
+	OpGetFree
+	// OpGetBuiltin stores builtin B in register A
+	OpGetBuiltin
+
+	// Binary operations store the result of operating registers B and C in
+	// register A
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpUnion
+	OpIntersect
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpIndex
+	OpRange
+
+	// Unary operations store the result of operating register B in register A
+	OpMinus
+	OpBang
+
+	// OpJump continues at instruction A
+	OpJump
+	// OpJumpNotTruthy continues at instruction B if register A is not truthy
+	OpJumpNotTruthy
+
+	// Collections are built in register A from the C registers starting at
+	// register B. Maps take keys and values in turns, C is the number of
+	// entries.
+	OpArray
+	OpSet
+	OpTuple
+	OpHash
+
+	// OpClosure stores in register A a closure of the function in constant B
+	// over the values of its free variables, starting at register C
+	OpClosure
+	// OpCall calls register B with the C registers after it as arguments,
+	// storing the result in register A
+	OpCall
+	// OpTailCall calls register B like OpCall, returning the result from the
+	// current function, whose frame is reused
+	OpTailCall
+	// OpReturn returns register A from the current function
+	OpReturn
+	// OpReturnNull returns null from the current function
+	OpReturnNull
+)
+
+var names = map[Opcode]string{
+	OpLoadConstant:  "OpLoadConstant",
+	OpLoadTrue:      "OpLoadTrue",
+	OpLoadFalse:     "OpLoadFalse",
+	OpLoadNull:      "OpLoadNull",
+	OpMove:          "OpMove",
+	OpGetGlobal:     "OpGetGlobal",
+	OpSetGlobal:     "OpSetGlobal",
+	OpGetFree:       "OpGetFree",
+	OpGetBuiltin:    "OpGetBuiltin",
+	OpAdd:           "OpAdd",
+	OpSub:           "OpSub",
+	OpMul:           "OpMul",
+	OpDiv:           "OpDiv",
+	OpUnion:         "OpUnion",
+	OpIntersect:     "OpIntersect",
+	OpEqual:         "OpEqual",
+	OpNotEqual:      "OpNotEqual",
+	OpGreaterThan:   "OpGreaterThan",
+	OpIndex:         "OpIndex",
+	OpRange:         "OpRange",
+	OpMinus:         "OpMinus",
+	OpBang:          "OpBang",
+	OpJump:          "OpJump",
+	OpJumpNotTruthy: "OpJumpNotTruthy",
+	OpArray:         "OpArray",
+	OpSet:           "OpSet",
+	OpTuple:         "OpTuple",
+	OpHash:          "OpHash",
+	OpClosure:       "OpClosure",
+	OpCall:          "OpCall",
+	OpTailCall:      "OpTailCall",
+	OpReturn:        "OpReturn",
+	OpReturnNull:    "OpReturnNull",
+}
+
+// operandCounts holds the number of operands of the opcodes that do not use
+// all three.
+var operandCounts = map[Opcode]int{
+	OpLoadTrue:      1,
+	OpLoadFalse:     1,
+	OpLoadNull:      1,
+	OpJump:          1,
+	OpReturn:        1,
+	OpReturnNull:    0,
+	OpLoadConstant:  2,
+	OpMove:          2,
+	OpGetGlobal:     2,
+	OpSetGlobal:     2,
+	OpGetFree:       2,
+	OpGetBuiltin:    2,
+	OpMinus:         2,
+	OpBang:          2,
+	OpJumpNotTruthy: 2,
+}
+
+// Instruction is an opcode with up to three operands, unused operands are
+// zero.
+type Instruction struct {
+	Op      Opcode
+	A, B, C int
+}
+
+func (ins Instruction) String() string {
+	name, ok := names[ins.Op]
+	if !ok {
+		return fmt.Sprintf("ERROR: unknown opcode %d", ins.Op)
+	}
+
+	count, ok := operandCounts[ins.Op]
+	if !ok {
+		count = 3
+	}
+	operands := []int{ins.A, ins.B, ins.C}[:count]
+
+	var out bytes.Buffer
+	out.WriteString(name)
+	for _, operand := range operands {
+		fmt.Fprintf(&out, " %d", operand)
+	}
+	return out.String()
+}
+
+type Instructions []Instruction
+
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+	for i, instruction := range ins {
+		fmt.Fprintf(&out, "%04d %s\n", i, instruction)
+	}
+	return out.String()
+}
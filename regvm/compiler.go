@@ -0,0 +1,578 @@
+package regvm
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/compiler"
+	"github.com/javier-varez/monkey_interpreter/object"
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+// RESULT_REGISTER holds the value of the last expression statement of the
+// main program.
+const RESULT_REGISTER = 0
+
+// compileError is returned for errors in the compiled program. It implements
+// ast.Error.
+type compileError struct {
+	span     token.Span
+	errorMsg string
+}
+
+func (e *compileError) Error() string {
+	return e.errorMsg
+}
+
+func (e *compileError) ContextualError() string {
+	return ast.FormatContextualError(e.span, e.errorMsg)
+}
+
+func (e *compileError) Span() token.Span {
+	return e.span
+}
+
+// Function is a function compiled for the register VM.
+type Function struct {
+	// Name is the binding the function was defined with, if any
+	Name         string
+	Instructions Instructions
+	// Spans locates each instruction in the source
+	Spans   []token.Span
+	NumArgs int
+	VarArgs bool
+	// NumRegisters is the size of the frame of the function
+	NumRegisters int
+	// NumFree is the number of free variables its closures capture
+	NumFree int
+}
+
+func (f *Function) Type() object.ObjectType {
+	return object.COMPILED_FUNCTION_OBJ
+}
+
+func (f *Function) Inspect() string {
+	return fmt.Sprintf("Function[%p]", f)
+}
+
+// Program is the output of the compiler, run by the VM.
+type Program struct {
+	Main      *Function
+	Constants []object.Object
+}
+
+// scope is a function being compiled. Registers are allocated like a stack:
+// temporary values are released once used, while the registers of locals are
+// held until the end of the function.
+type scope struct {
+	fn *Function
+	// locals maps the index of each local symbol to its register
+	locals []int
+	// top is the first free register and floor the first one above every
+	// local
+	top   int
+	floor int
+}
+
+// Compiler is a backend for the register VM, compiling the same programs as
+// the compiler of the stack VM.
+type Compiler struct {
+	constants     []object.Object
+	constantIndex map[string]int
+	symbolTable   *compiler.SymbolTable
+
+	scopes []*scope
+
+	// span of the node being compiled, where emitted instructions are located
+	span token.Span
+	// functionName is the binding of the function literal about to be
+	// compiled, if any
+	functionName string
+}
+
+func NewCompiler() *Compiler {
+	st := compiler.NewSymbolTable()
+	for i, builtin := range object.Builtins {
+		st.DefineBuiltin(i, builtin.Name)
+	}
+
+	main := &scope{fn: &Function{Name: "main"}}
+	main.reserve(RESULT_REGISTER + 1)
+
+	return &Compiler{
+		constantIndex: map[string]int{},
+		symbolTable:   st,
+		scopes:        []*scope{main},
+	}
+}
+
+// Compile compiles the main program.
+func (c *Compiler) Compile(program *ast.Program) error {
+	c.span = program.Span()
+	for _, stmt := range program.Statements {
+		if err := c.compileStatement(stmt); err != nil {
+			return err
+		}
+	}
+	c.emit(OpReturn, RESULT_REGISTER, 0, 0)
+	return nil
+}
+
+func (c *Compiler) Program() *Program {
+	return &Program{Main: c.scopes[0].fn, Constants: c.constants}
+}
+
+func (c *Compiler) current() *scope {
+	return c.scopes[len(c.scopes)-1]
+}
+
+func (c *Compiler) isMain() bool {
+	return len(c.scopes) == 1
+}
+
+// reserve allocates n consecutive registers, returning the first one.
+func (s *scope) reserve(n int) int {
+	first := s.top
+	s.top += n
+	if s.top > s.fn.NumRegisters {
+		s.fn.NumRegisters = s.top
+	}
+	return first
+}
+
+// release frees the registers reserved since top was mark, except the ones
+// of locals.
+func (s *scope) release(mark int) {
+	if mark < s.floor {
+		mark = s.floor
+	}
+	s.top = mark
+}
+
+// defineLocal holds a register for a local symbol until the end of the
+// function.
+func (s *scope) defineLocal(sym compiler.Symbol, register int) {
+	for len(s.locals) <= sym.Index {
+		s.locals = append(s.locals, -1)
+	}
+	s.locals[sym.Index] = register
+}
+
+// holdRegister reserves a register that is never released.
+func (s *scope) holdRegister() int {
+	register := s.reserve(1)
+	s.floor = s.top
+	return register
+}
+
+func (c *Compiler) emit(op Opcode, a, b, cc int) int {
+	fn := c.current().fn
+	fn.Instructions = append(fn.Instructions, Instruction{Op: op, A: a, B: b, C: cc})
+	fn.Spans = append(fn.Spans, c.span)
+	return len(fn.Instructions) - 1
+}
+
+// addConstant returns the index of a constant equal to obj, adding obj to the
+// constants if there is none. Only integers and strings are shared.
+func (c *Compiler) addConstant(obj object.Object) int {
+	key := ""
+	switch obj := obj.(type) {
+	case *object.Integer:
+		key = string(obj.Type()) + " " + strconv.FormatInt(obj.Value, 10)
+	case *object.String:
+		key = string(obj.Type()) + " " + obj.Value
+	}
+	if idx, ok := c.constantIndex[key]; ok && key != "" {
+		return idx
+	}
+
+	c.constants = append(c.constants, obj)
+	if key != "" {
+		c.constantIndex[key] = len(c.constants) - 1
+	}
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) compileStatement(stmt ast.Statment) error {
+	outerSpan := c.span
+	c.span = stmt.Span()
+	defer func() { c.span = outerSpan }()
+
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		if c.isMain() {
+			return c.compileExpr(stmt.Expr, RESULT_REGISTER)
+		}
+		mark := c.current().top
+		_, err := c.operand(stmt.Expr)
+		c.current().release(mark)
+		return err
+
+	case *ast.LetStatement:
+		name := stmt.IdentExpr.(*ast.IdentifierExpr).IdentToken.Literal
+		if _, ok := stmt.Expr.(*ast.FnLiteralExpr); ok {
+			c.functionName = name
+		}
+
+		if c.isMain() {
+			mark := c.current().top
+			value, err := c.operand(stmt.Expr)
+			if err != nil {
+				return err
+			}
+			sym := c.symbolTable.Define(name)
+			if sym.Index >= GLOBALS_SIZE {
+				return &compileError{span: stmt.Span(), errorMsg: fmt.Sprintf("Programs cannot define more than %d globals", GLOBALS_SIZE)}
+			}
+			c.emit(OpSetGlobal, sym.Index, value, 0)
+			c.current().release(mark)
+			return nil
+		}
+
+		// The name is not visible until the value is computed
+		register := c.current().holdRegister()
+		if err := c.compileExpr(stmt.Expr, register); err != nil {
+			return err
+		}
+		c.current().defineLocal(c.symbolTable.Define(name), register)
+		return nil
+
+	case *ast.ReturnStatement:
+		mark := c.current().top
+		value, err := c.operand(stmt.Expr)
+		if err != nil {
+			return err
+		}
+		c.emit(OpReturn, value, 0, 0)
+		c.current().release(mark)
+		return nil
+	}
+
+	return fmt.Errorf("Unhandled statement type %T", stmt)
+}
+
+// compileBlock stores the value of the last statement of a block in dest,
+// which is null if it is not an expression.
+func (c *Compiler) compileBlock(block *ast.BlockStatement, dest int) error {
+	for i, stmt := range block.Statements {
+		if exprStmt, ok := stmt.(*ast.ExpressionStatement); ok && i == len(block.Statements)-1 {
+			outerSpan := c.span
+			c.span = stmt.Span()
+			err := c.compileExpr(exprStmt.Expr, dest)
+			c.span = outerSpan
+			return err
+		}
+
+		if err := c.compileStatement(stmt); err != nil {
+			return err
+		}
+	}
+
+	c.emit(OpLoadNull, dest, 0, 0)
+	return nil
+}
+
+// operand returns a register holding the value of an expression. Locals are
+// used in place, other expressions are stored in a new register that the
+// caller must release.
+func (c *Compiler) operand(expr ast.Expression) (int, error) {
+	if ident, ok := expr.(*ast.IdentifierExpr); ok {
+		if sym, ok := c.symbolTable.Resolve(ident.IdentToken.Literal); ok && sym.Scope == compiler.LocalScope {
+			return c.current().locals[sym.Index], nil
+		}
+	}
+
+	register := c.current().reserve(1)
+	return register, c.compileExpr(expr, register)
+}
+
+// compileExpr stores the value of an expression in register dest.
+func (c *Compiler) compileExpr(expr ast.Expression, dest int) error {
+	outerSpan := c.span
+	c.span = expr.Span()
+	defer func() { c.span = outerSpan }()
+
+	s := c.current()
+	mark := s.top
+	defer s.release(mark)
+
+	switch node := expr.(type) {
+	case *ast.IntegerLiteralExpr:
+		c.emit(OpLoadConstant, dest, c.addConstant(object.NewInt64(node.Value)), 0)
+
+	case *ast.StringLiteralExpr:
+		c.emit(OpLoadConstant, dest, c.addConstant(&object.String{Value: node.Value}), 0)
+
+	case *ast.BoolLiteralExpr:
+		if node.Value {
+			c.emit(OpLoadTrue, dest, 0, 0)
+		} else {
+			c.emit(OpLoadFalse, dest, 0, 0)
+		}
+
+	case *ast.IdentifierExpr:
+		sym, ok := c.symbolTable.Resolve(node.IdentToken.Literal)
+		if !ok {
+			return &compileError{span: node.Span(), errorMsg: fmt.Sprintf("Unknown identifier %s", node.IdentToken.Literal)}
+		}
+		c.loadSymbol(sym, dest)
+
+	case *ast.VarArgsLiteralExpr:
+		sym, ok := c.symbolTable.Resolve(compiler.INTERNAL_VARARGS)
+		if !ok {
+			return &compileError{span: node.Span(), errorMsg: "`...` used outside of a function with var args"}
+		}
+		c.loadSymbol(sym, dest)
+
+	case *ast.PrefixExpr:
+		inner, err := c.operand(node.InnerExpr)
+		if err != nil {
+			return err
+		}
+
+		switch node.OperatorToken.Type {
+		case token.BANG:
+			c.emit(OpBang, dest, inner, 0)
+		case token.MINUS:
+			c.emit(OpMinus, dest, inner, 0)
+		default:
+			return fmt.Errorf("Unhandled prefix operator %s", node.OperatorToken.Type)
+		}
+
+	case *ast.InfixExpr:
+		return c.compileInfix(node, dest)
+
+	case *ast.IfExpr:
+		condition, err := c.operand(node.Condition)
+		if err != nil {
+			return err
+		}
+		jumpNotTruthy := c.emit(OpJumpNotTruthy, condition, 0, 0)
+		s.release(mark)
+
+		if err := c.compileBlock(node.Consequence, dest); err != nil {
+			return err
+		}
+		jump := c.emit(OpJump, 0, 0, 0)
+
+		s.fn.Instructions[jumpNotTruthy].B = len(s.fn.Instructions)
+		if node.Alternative != nil {
+			if err := c.compileBlock(node.Alternative, dest); err != nil {
+				return err
+			}
+		} else {
+			c.emit(OpLoadNull, dest, 0, 0)
+		}
+		s.fn.Instructions[jump].A = len(s.fn.Instructions)
+
+	case *ast.ArrayLiteralExpr:
+		return c.compileCollection(OpArray, node.Elems, dest)
+
+	case *ast.SetLiteralExpr:
+		return c.compileCollection(OpSet, node.Elems, dest)
+
+	case *ast.TupleLiteralExpr:
+		return c.compileCollection(OpTuple, node.Elems, dest)
+
+	case *ast.MapLiteralExpr:
+		first := s.reserve(2 * len(node.Entries))
+		for i, entry := range node.Entries {
+			if err := c.compileExpr(entry.Key, first+2*i); err != nil {
+				return err
+			}
+			if err := c.compileExpr(entry.Value, first+2*i+1); err != nil {
+				return err
+			}
+		}
+		c.emit(OpHash, dest, first, len(node.Entries))
+
+	case *ast.IndexOperatorExpr:
+		obj, err := c.operand(node.ObjExpr)
+		if err != nil {
+			return err
+		}
+		index, err := c.operand(node.IndexExpr)
+		if err != nil {
+			return err
+		}
+		c.emit(OpIndex, dest, obj, index)
+
+	case *ast.RangeExpr:
+		start, err := c.operand(node.StartExpr)
+		if err != nil {
+			return err
+		}
+		end, err := c.operand(node.EndExpr)
+		if err != nil {
+			return err
+		}
+		c.emit(OpRange, dest, start, end)
+
+	case *ast.FnLiteralExpr:
+		return c.compileFunction(node, dest)
+
+	case *ast.CallExpr:
+		// The callee goes right before its arguments
+		first := s.reserve(1 + len(node.Args))
+		for i, arg := range node.Args {
+			if err := c.compileExpr(arg, first+1+i); err != nil {
+				return err
+			}
+		}
+		if err := c.compileExpr(node.CallableExpr, first); err != nil {
+			return err
+		}
+
+		if node.IsTailCall && !c.isMain() {
+			c.emit(OpTailCall, dest, first, len(node.Args))
+		} else {
+			c.emit(OpCall, dest, first, len(node.Args))
+		}
+
+	default:
+		return fmt.Errorf("Unhandled node type %T", expr)
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileInfix(node *ast.InfixExpr, dest int) error {
+	// Like in the stack VM, the right side of < is evaluated first and
+	// compared the other way around
+	if node.OperatorToken.Type == token.LT {
+		rhs, err := c.operand(node.RightExpr)
+		if err != nil {
+			return err
+		}
+		lhs, err := c.operand(node.LeftExpr)
+		if err != nil {
+			return err
+		}
+		c.emit(OpGreaterThan, dest, rhs, lhs)
+		return nil
+	}
+
+	lhs, err := c.operand(node.LeftExpr)
+	if err != nil {
+		return err
+	}
+	rhs, err := c.operand(node.RightExpr)
+	if err != nil {
+		return err
+	}
+
+	var op Opcode
+	switch node.OperatorToken.Type {
+	case token.PLUS:
+		op = OpAdd
+	case token.MINUS:
+		op = OpSub
+	case token.ASTERISK:
+		op = OpMul
+	case token.SLASH:
+		op = OpDiv
+	case token.GT:
+		op = OpGreaterThan
+	case token.EQ:
+		op = OpEqual
+	case token.NOT_EQ:
+		op = OpNotEqual
+	case token.PIPE:
+		op = OpUnion
+	case token.AMPERSAND:
+		op = OpIntersect
+	default:
+		return fmt.Errorf("Unhandled infix operator %s", node.OperatorToken.Type)
+	}
+	c.emit(op, dest, lhs, rhs)
+	return nil
+}
+
+func (c *Compiler) compileCollection(op Opcode, elems []ast.Expression, dest int) error {
+	first := c.current().reserve(len(elems))
+	for i, elem := range elems {
+		if err := c.compileExpr(elem, first+i); err != nil {
+			return err
+		}
+	}
+	c.emit(op, dest, first, len(elems))
+	return nil
+}
+
+func (c *Compiler) compileFunction(node *ast.FnLiteralExpr, dest int) error {
+	// Functions defined inside of this one are not named after its binding
+	name := c.functionName
+	c.functionName = ""
+
+	fn := &Function{Name: name, NumArgs: len(node.Args), VarArgs: node.VarArgs}
+	c.scopes = append(c.scopes, &scope{fn: fn})
+	c.symbolTable = compiler.NewEnclosedSymbolTable(c.symbolTable)
+
+	// Arguments take the first registers
+	for _, arg := range node.Args {
+		c.current().defineLocal(c.symbolTable.Define(arg.IdentToken.Literal), c.current().holdRegister())
+	}
+	if node.VarArgs {
+		c.current().defineLocal(c.symbolTable.Define(compiler.INTERNAL_VARARGS), c.current().holdRegister())
+	}
+
+	if err := c.compileBody(node.Body); err != nil {
+		return err
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	fn.NumFree = len(freeSymbols)
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.symbolTable = c.symbolTable.Parent
+
+	first := c.current().reserve(len(freeSymbols))
+	for i, sym := range freeSymbols {
+		c.loadSymbol(sym, first+i)
+	}
+	c.emit(OpClosure, dest, c.addConstant(fn), first)
+	return nil
+}
+
+// compileBody compiles the statements of a function, returning the value of
+// the last one if it is an expression.
+func (c *Compiler) compileBody(body *ast.BlockStatement) error {
+	statements := body.Statements
+	for i, stmt := range statements {
+		exprStmt, ok := stmt.(*ast.ExpressionStatement)
+		if !ok || i != len(statements)-1 {
+			if err := c.compileStatement(stmt); err != nil {
+				return err
+			}
+			continue
+		}
+
+		outerSpan := c.span
+		c.span = stmt.Span()
+		value, err := c.operand(exprStmt.Expr)
+		if err != nil {
+			return err
+		}
+		c.emit(OpReturn, value, 0, 0)
+		c.span = outerSpan
+		return nil
+	}
+
+	c.emit(OpReturnNull, 0, 0, 0)
+	return nil
+}
+
+func (c *Compiler) loadSymbol(sym compiler.Symbol, dest int) {
+	switch sym.Scope {
+	case compiler.LocalScope:
+		if local := c.current().locals[sym.Index]; local != dest {
+			c.emit(OpMove, dest, local, 0)
+		}
+	case compiler.GlobalScope:
+		c.emit(OpGetGlobal, dest, sym.Index, 0)
+	case compiler.BuiltinScope:
+		c.emit(OpGetBuiltin, dest, sym.Index, 0)
+	case compiler.FreeScope:
+		c.emit(OpGetFree, dest, sym.Index, 0)
+	}
+}
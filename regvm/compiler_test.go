@@ -0,0 +1,121 @@
+package regvm
+
+import (
+	"testing"
+
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/lexer"
+	"github.com/javier-varez/monkey_interpreter/parser"
+)
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func TestCompiledInstructions(t *testing.T) {
+	tests := []struct {
+		input     string
+		main      string
+		functions []string
+	}{
+		{
+			// Temporary values reuse registers once consumed
+			"1 + 2 * 3",
+			`0000 OpLoadConstant 1 0
+0001 OpLoadConstant 3 1
+0002 OpLoadConstant 4 2
+0003 OpMul 2 3 4
+0004 OpAdd 0 1 2
+0005 OpReturn 0
+`,
+			nil,
+		},
+		{
+			// Both branches store their value in the same register
+			"let a = 1; if (a < 2) { [a, 3] } else { 4 }",
+			`0000 OpLoadConstant 1 0
+0001 OpSetGlobal 0 1
+0002 OpLoadConstant 2 1
+0003 OpGetGlobal 3 0
+0004 OpGreaterThan 1 2 3
+0005 OpJumpNotTruthy 1 10
+0006 OpGetGlobal 1 0
+0007 OpLoadConstant 2 2
+0008 OpArray 0 1 2
+0009 OpJump 11
+0010 OpLoadConstant 0 3
+0011 OpReturn 0
+`,
+			nil,
+		},
+		{
+			// Locals are used in place
+			"let add = fn(a, b) { let c = a + b; c }; add(1, 2)",
+			`0000 OpClosure 1 0 2
+0001 OpSetGlobal 0 1
+0002 OpLoadConstant 2 1
+0003 OpLoadConstant 3 2
+0004 OpGetGlobal 1 0
+0005 OpCall 0 1 2
+0006 OpReturn 0
+`,
+			[]string{
+				`0000 OpAdd 2 0 1
+0001 OpReturn 2
+`,
+			},
+		},
+		{
+			// Free variables are moved next to each other for OpClosure
+			"let adder = fn(a) { fn(b) { a + b } }; adder(1)(2)",
+			`0000 OpClosure 1 1 2
+0001 OpSetGlobal 0 1
+0002 OpLoadConstant 2 2
+0003 OpLoadConstant 4 3
+0004 OpGetGlobal 3 0
+0005 OpCall 1 3 1
+0006 OpCall 0 1 1
+0007 OpReturn 0
+`,
+			[]string{
+				`0000 OpGetFree 2 0
+0001 OpAdd 1 2 0
+0002 OpReturn 1
+`,
+				`0000 OpMove 2 0
+0001 OpClosure 1 0 2
+0002 OpReturn 1
+`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		comp := NewCompiler()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		program := comp.Program()
+
+		if program.Main.Instructions.String() != tt.main {
+			t.Errorf("Wrong instructions for %q.\nwant=\n%s\ngot=\n%s", tt.input, tt.main, program.Main.Instructions)
+		}
+
+		functions := []string{}
+		for _, constant := range program.Constants {
+			if fn, ok := constant.(*Function); ok {
+				functions = append(functions, fn.Instructions.String())
+			}
+		}
+		if len(functions) != len(tt.functions) {
+			t.Fatalf("Wrong number of functions for %q. want=%d, got=%d", tt.input, len(tt.functions), len(functions))
+		}
+		for i, fn := range functions {
+			if fn != tt.functions[i] {
+				t.Errorf("Wrong instructions of function %d for %q.\nwant=\n%s\ngot=\n%s", i, tt.input, tt.functions[i], fn)
+			}
+		}
+	}
+}
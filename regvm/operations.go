@@ -0,0 +1,183 @@
+package regvm
+
+import (
+	"fmt"
+
+	"github.com/javier-varez/monkey_interpreter/code"
+	"github.com/javier-varez/monkey_interpreter/object"
+)
+
+// The operations behave like the ones of the stack VM, down to their error
+// messages. Operators are identified by the opcodes of the stack VM.
+
+func binaryOp(op code.Opcode, lhs, rhs object.Object) (object.Object, error) {
+	if object.IsInteger(rhs) && object.IsInteger(lhs) {
+		switch op {
+		case code.OpAdd:
+			return object.AddIntegers(lhs, rhs), nil
+		case code.OpSub:
+			return object.SubIntegers(lhs, rhs), nil
+		case code.OpMul:
+			return object.MulIntegers(lhs, rhs), nil
+		case code.OpDiv:
+			return object.DivIntegers(lhs, rhs)
+		}
+		return nil, fmt.Errorf("Invalid binary operation: %v", op)
+	}
+
+	if lhs, ok := lhs.(*object.Set); ok {
+		if rhs, ok := rhs.(*object.Set); ok {
+			switch op {
+			case code.OpUnion:
+				return lhs.Union(rhs), nil
+			case code.OpIntersect:
+				return lhs.Intersect(rhs), nil
+			case code.OpSub:
+				return lhs.Difference(rhs), nil
+			}
+			return nil, fmt.Errorf("Invalid set binary operation: %v", op)
+		}
+	}
+
+	if rhs.Type() == object.STRING_OBJ || lhs.Type() == object.STRING_OBJ {
+		if op != code.OpAdd {
+			return nil, fmt.Errorf("Invalid string binary operation: %v", op)
+		}
+		if result, ok := object.Concat(lhs, rhs); ok {
+			return result, nil
+		}
+	}
+	return nil, fmt.Errorf("Invalid binary operation %d for types %T and %T", op, lhs, rhs)
+}
+
+func comparisonOp(op code.Opcode, lhs, rhs object.Object) (object.Object, error) {
+	if object.IsInteger(rhs) && object.IsInteger(lhs) {
+		comparison := object.CompareIntegers(lhs, rhs)
+		switch op {
+		case code.OpEqual:
+			return nativeBoolToBooleanObject(comparison == 0), nil
+		case code.OpNotEqual:
+			return nativeBoolToBooleanObject(comparison != 0), nil
+		case code.OpGreaterThan:
+			return nativeBoolToBooleanObject(comparison > 0), nil
+		}
+		return nil, fmt.Errorf("Invalid integer comparison operation: %v", op)
+	}
+
+	if lhs, ok := lhs.(*object.Char); ok {
+		if rhs, ok := rhs.(*object.Char); ok {
+			switch op {
+			case code.OpEqual:
+				return nativeBoolToBooleanObject(lhs.Value == rhs.Value), nil
+			case code.OpNotEqual:
+				return nativeBoolToBooleanObject(lhs.Value != rhs.Value), nil
+			case code.OpGreaterThan:
+				return nativeBoolToBooleanObject(lhs.Value > rhs.Value), nil
+			}
+			return nil, fmt.Errorf("Invalid char comparison operation: %v", op)
+		}
+	}
+
+	switch op {
+	case code.OpEqual:
+		return nativeBoolToBooleanObject(object.Equals(lhs, rhs)), nil
+	case code.OpNotEqual:
+		return nativeBoolToBooleanObject(!object.Equals(lhs, rhs)), nil
+	}
+	return nil, fmt.Errorf("Cannot apply comparison operator on types %T and %T", lhs, rhs)
+}
+
+func index(indexed, idx object.Object) (object.Object, error) {
+	switch indexed := indexed.(type) {
+	case *object.Array:
+		i, ok := idx.(*object.Integer)
+		if !ok {
+			return nil, fmt.Errorf("Index to array must be an integral. Got=%T (%+v)", idx, idx)
+		}
+		if i.Value >= 0 && i.Value < int64(len(indexed.Elems)) {
+			return indexed.Elems[i.Value], nil
+		}
+		return Null, nil
+
+	case *object.String:
+		i, ok := idx.(*object.Integer)
+		if !ok {
+			return nil, fmt.Errorf("Index to string must be an integral. Got=%T (%+v)", idx, idx)
+		}
+		if ch, ok := indexed.CharAt(i.Value); ok {
+			return ch, nil
+		}
+		return Null, nil
+
+	case *object.Tuple:
+		i, ok := idx.(*object.Integer)
+		if !ok {
+			return nil, fmt.Errorf("Index to tuple must be an integral. Got=%T (%+v)", idx, idx)
+		}
+		if i.Value >= 0 && i.Value < int64(len(indexed.Elems)) {
+			return indexed.Elems[i.Value], nil
+		}
+		return Null, nil
+
+	case *object.HashMap:
+		hashable, ok := idx.(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("Index of type %T (%+v) is not hashable", idx, idx)
+		}
+		if value, ok := indexed.Get(hashable); ok {
+			return value, nil
+		}
+		return Null, nil
+	}
+	return nil, fmt.Errorf("Cannot index object of type: %T", indexed)
+}
+
+func makeRange(startObj, endObj object.Object) (object.Object, error) {
+	if endObj.Type() != object.INTEGER_OBJ {
+		return nil, fmt.Errorf("Range start does not evaluate to an integer object: %T (%v)", endObj, endObj)
+	}
+	if startObj.Type() != object.INTEGER_OBJ {
+		return nil, fmt.Errorf("Range start does not evaluate to an integer object: %T (%v)", startObj, startObj)
+	}
+
+	start := startObj.(*object.Integer).Value
+	end := endObj.(*object.Integer).Value
+
+	incr := int64(1)
+	if start > end {
+		// Decreasing range
+		incr = -1
+	}
+
+	array := &object.Array{Elems: []object.Object{}}
+	for value := start; value != end; value += incr {
+		array.Elems = append(array.Elems, object.NewInt64(value))
+	}
+	return array, nil
+}
+
+func makeSet(elems []object.Object) (object.Object, error) {
+	// Added in reverse, like the stack VM pops them
+	set := object.NewSet()
+	for i := len(elems) - 1; i >= 0; i-- {
+		hashable, ok := elems[i].(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("Set element is not hashable")
+		}
+		set.Add(hashable)
+	}
+	return set, nil
+}
+
+// makeHash builds a map from keys and values in turns.
+func makeHash(entries []object.Object) (object.Object, error) {
+	hashMap := object.NewHashMap()
+	for i := 0; i < len(entries); i += 2 {
+		hashable, ok := entries[i].(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("Key object is not hashable")
+		}
+		hashMap.Set(hashable, entries[i+1])
+	}
+	return hashMap, nil
+}
@@ -0,0 +1,395 @@
+package regvm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/code"
+	"github.com/javier-varez/monkey_interpreter/object"
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+const GLOBALS_SIZE = 65536
+const MAX_FRAMES = 1 << 16
+
+var Null = object.NULL
+var True = object.TRUE
+var False = object.FALSE
+
+// Closure is a function of the register VM with the values of its free
+// variables.
+type Closure struct {
+	Fn          *Function
+	FreeObjects []object.Object
+}
+
+func (c *Closure) Type() object.ObjectType {
+	return object.CLOSURE_OBJ
+}
+
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// runtimeError is an error of the running program, located at the source of
+// the instruction that failed. It implements ast.Error.
+type runtimeError struct {
+	span     token.Span
+	errorMsg string
+	stack    []object.StackFrame
+}
+
+func (e *runtimeError) Error() string {
+	return e.errorMsg
+}
+
+func (e *runtimeError) ContextualError() string {
+	return ast.FormatContextualError(e.span, e.errorMsg)
+}
+
+func (e *runtimeError) Span() token.Span {
+	return e.span
+}
+
+func (e *runtimeError) StackTrace() []object.StackFrame {
+	return e.stack
+}
+
+type frame struct {
+	closure *Closure
+	// ip is the next instruction to run
+	ip int
+	// base is the register where the registers of the function start
+	base int
+	// result is the register of the caller where the result is stored
+	result int
+}
+
+// VM runs programs compiled to registers. Each call gets a window of the
+// register file, right after the one of its caller.
+type VM struct {
+	constants []object.Object
+	globals   []object.Object
+
+	registers []object.Object
+	frames    []frame
+
+	// args holds the arguments of the call being made
+	args []object.Object
+	// result is the value returned by the main program
+	result object.Object
+}
+
+func New(program *Program) *VM {
+	main := &Closure{Fn: program.Main}
+	vm := &VM{
+		constants: program.Constants,
+		globals:   make([]object.Object, GLOBALS_SIZE),
+		registers: make([]object.Object, 1024),
+		frames:    make([]frame, 0, 64),
+	}
+	vm.ensureRegisters(main.Fn.NumRegisters)
+	vm.frames = append(vm.frames, frame{closure: main})
+	return vm
+}
+
+// Result returns the value of the last expression statement of the main
+// program once it has run.
+func (vm *VM) Result() object.Object {
+	return vm.result
+}
+
+// Run runs the program until it ends or fails. Errors are located at the
+// instruction that failed and carry the calls that led to it.
+func (vm *VM) Run() error {
+	err := vm.run()
+	if err == nil {
+		return nil
+	}
+
+	top := vm.frames[len(vm.frames)-1]
+	span := top.closure.Fn.Spans[top.ip-1]
+	if span.Text == nil {
+		return err
+	}
+	return &runtimeError{span: span, errorMsg: err.Error(), stack: vm.stackTrace()}
+}
+
+// stackTrace returns the calls of the frames in the call stack, innermost
+// first. Each frame was called by the instruction its caller ran last.
+func (vm *VM) stackTrace() []object.StackFrame {
+	stack := []object.StackFrame{}
+	for i := len(vm.frames) - 1; i > 0; i-- {
+		caller := vm.frames[i-1]
+		span := caller.closure.Fn.Spans[caller.ip-1]
+		stack = append(stack, object.StackFrame{Function: vm.frames[i].closure.Fn.Name, CallSite: span})
+	}
+	return stack
+}
+
+func (vm *VM) run() error {
+	f := &vm.frames[len(vm.frames)-1]
+	ins := f.closure.Fn.Instructions
+	regs := vm.registers[f.base:]
+
+	// reload picks up the frame on top after calls and returns, which may
+	// also grow the registers
+	reload := func() {
+		f = &vm.frames[len(vm.frames)-1]
+		ins = f.closure.Fn.Instructions
+		regs = vm.registers[f.base:]
+	}
+
+	for {
+		in := ins[f.ip]
+		f.ip++
+
+		var err error
+		switch in.Op {
+		case OpLoadConstant:
+			regs[in.A] = vm.constants[in.B]
+		case OpLoadTrue:
+			regs[in.A] = True
+		case OpLoadFalse:
+			regs[in.A] = False
+		case OpLoadNull:
+			regs[in.A] = Null
+		case OpMove:
+			regs[in.A] = regs[in.B]
+		case OpGetGlobal:
+			regs[in.A] = vm.globals[in.B]
+		case OpSetGlobal:
+			vm.globals[in.A] = regs[in.B]
+		case OpGetFree:
+			regs[in.A] = f.closure.FreeObjects[in.B]
+		case OpGetBuiltin:
+			regs[in.A] = object.Builtins[in.B].Builtin
+
+		case OpAdd:
+			regs[in.A], err = binaryOp(code.OpAdd, regs[in.B], regs[in.C])
+		case OpSub:
+			regs[in.A], err = binaryOp(code.OpSub, regs[in.B], regs[in.C])
+		case OpMul:
+			regs[in.A], err = binaryOp(code.OpMul, regs[in.B], regs[in.C])
+		case OpDiv:
+			regs[in.A], err = binaryOp(code.OpDiv, regs[in.B], regs[in.C])
+		case OpUnion:
+			regs[in.A], err = binaryOp(code.OpUnion, regs[in.B], regs[in.C])
+		case OpIntersect:
+			regs[in.A], err = binaryOp(code.OpIntersect, regs[in.B], regs[in.C])
+		case OpEqual:
+			regs[in.A], err = comparisonOp(code.OpEqual, regs[in.B], regs[in.C])
+		case OpNotEqual:
+			regs[in.A], err = comparisonOp(code.OpNotEqual, regs[in.B], regs[in.C])
+		case OpGreaterThan:
+			regs[in.A], err = comparisonOp(code.OpGreaterThan, regs[in.B], regs[in.C])
+		case OpIndex:
+			regs[in.A], err = index(regs[in.B], regs[in.C])
+		case OpRange:
+			regs[in.A], err = makeRange(regs[in.B], regs[in.C])
+
+		case OpMinus:
+			if !object.IsInteger(regs[in.B]) {
+				return fmt.Errorf("Cannot apply minus operator on type %T", regs[in.B])
+			}
+			regs[in.A] = object.NegateInteger(regs[in.B])
+		case OpBang:
+			regs[in.A] = nativeBoolToBooleanObject(!isTruthy(regs[in.B]))
+
+		case OpJump:
+			f.ip = in.A
+		case OpJumpNotTruthy:
+			if !isTruthy(regs[in.A]) {
+				f.ip = in.B
+			}
+
+		case OpArray:
+			elems := make([]object.Object, in.C)
+			copy(elems, regs[in.B:in.B+in.C])
+			regs[in.A] = &object.Array{Elems: elems}
+		case OpTuple:
+			elems := make([]object.Object, in.C)
+			copy(elems, regs[in.B:in.B+in.C])
+			regs[in.A] = &object.Tuple{Elems: elems}
+		case OpSet:
+			regs[in.A], err = makeSet(regs[in.B : in.B+in.C])
+		case OpHash:
+			regs[in.A], err = makeHash(regs[in.B : in.B+2*in.C])
+
+		case OpClosure:
+			fn := vm.constants[in.B].(*Function)
+			free := make([]object.Object, fn.NumFree)
+			copy(free, regs[in.C:in.C+fn.NumFree])
+			regs[in.A] = &Closure{Fn: fn, FreeObjects: free}
+
+		case OpCall, OpTailCall:
+			switch callee := regs[in.B].(type) {
+			case *Closure:
+				if in.Op == OpTailCall {
+					err = vm.tailCall(callee, f.base+in.B+1, in.C)
+				} else {
+					err = vm.call(callee, f.base+in.B+1, in.C, f.base+in.A)
+				}
+				if err != nil {
+					return err
+				}
+				reload()
+
+			case *object.Builtin:
+				args := make([]object.Object, in.C)
+				copy(args, regs[in.B+1:in.B+1+in.C])
+				value := callee.Function(f.closure.Fn.Spans[f.ip-1], args...)
+				if errObj, ok := value.(*object.Error); ok {
+					return errors.New(errObj.Message)
+				}
+				if value == nil {
+					value = Null
+				}
+
+				if in.Op == OpCall {
+					regs[in.A] = value
+				} else if vm.ret(value) {
+					return nil
+				} else {
+					reload()
+				}
+
+			default:
+				return fmt.Errorf("Not a callable, cannot be invoked")
+			}
+
+		case OpReturn:
+			if vm.ret(regs[in.A]) {
+				return nil
+			}
+			reload()
+		case OpReturnNull:
+			if vm.ret(Null) {
+				return nil
+			}
+			reload()
+
+		default:
+			return fmt.Errorf("Unhandled operation: %v", in.Op)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ensureRegisters grows the register file to hold at least n registers.
+func (vm *VM) ensureRegisters(n int) {
+	if n <= len(vm.registers) {
+		return
+	}
+	size := 2 * len(vm.registers)
+	for size < n {
+		size *= 2
+	}
+	registers := make([]object.Object, size)
+	copy(registers, vm.registers)
+	vm.registers = registers
+}
+
+// collectArgs copies the arguments of a call to vm.args, expanding var args.
+func (vm *VM) collectArgs(first int, count int) {
+	vm.args = vm.args[:0]
+	for _, arg := range vm.registers[first : first+count] {
+		if varArgs, ok := arg.(*object.VarArgs); ok {
+			vm.args = append(vm.args, varArgs.Elems...)
+		} else {
+			vm.args = append(vm.args, arg)
+		}
+	}
+}
+
+// placeArgs stores the collected arguments in the first registers of a frame
+// for fn starting at base, packing the trailing ones of var arg functions.
+func (vm *VM) placeArgs(fn *Function, base int) error {
+	numArgs := len(vm.args)
+	if fn.VarArgs {
+		if numArgs < fn.NumArgs {
+			return fmt.Errorf("wrong number of arguments: want>=%d, got=%d", fn.NumArgs, numArgs)
+		}
+	} else if numArgs != fn.NumArgs {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.NumArgs, numArgs)
+	}
+
+	vm.ensureRegisters(base + fn.NumRegisters)
+	copy(vm.registers[base:], vm.args[:fn.NumArgs])
+	if fn.VarArgs {
+		elems := make([]object.Object, numArgs-fn.NumArgs)
+		copy(elems, vm.args[fn.NumArgs:])
+		vm.registers[base+fn.NumArgs] = &object.VarArgs{Elems: elems}
+	}
+	return nil
+}
+
+// call pushes a frame for a closure, whose count arguments start at register
+// first. Its result will be stored in register result.
+func (vm *VM) call(closure *Closure, first int, count int, result int) error {
+	if len(vm.frames) == MAX_FRAMES {
+		return fmt.Errorf("maximum recursion depth exceeded")
+	}
+
+	caller := &vm.frames[len(vm.frames)-1]
+	base := caller.base + caller.closure.Fn.NumRegisters
+
+	vm.collectArgs(first, count)
+	if err := vm.placeArgs(closure.Fn, base); err != nil {
+		return err
+	}
+
+	vm.frames = append(vm.frames, frame{closure: closure, base: base, result: result})
+	return nil
+}
+
+// tailCall replaces the current frame with one for a closure.
+func (vm *VM) tailCall(closure *Closure, first int, count int) error {
+	f := &vm.frames[len(vm.frames)-1]
+
+	vm.collectArgs(first, count)
+	if err := vm.placeArgs(closure.Fn, f.base); err != nil {
+		return err
+	}
+
+	f.closure = closure
+	f.ip = 0
+	return nil
+}
+
+// ret pops the current frame, storing its result for the caller. It returns
+// true when the main program returns.
+func (vm *VM) ret(value object.Object) bool {
+	returning := vm.frames[len(vm.frames)-1]
+	vm.frames = vm.frames[:len(vm.frames)-1]
+	if len(vm.frames) == 0 {
+		vm.result = value
+		return true
+	}
+
+	vm.registers[returning.result] = value
+	return false
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return obj.Value != 0
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	}
+	return true
+}
+
+func nativeBoolToBooleanObject(value bool) *object.Boolean {
+	if value {
+		return True
+	}
+	return False
+}
@@ -0,0 +1,47 @@
+package regvm
+
+import (
+	"os"
+	"testing"
+
+	"github.com/javier-varez/monkey_interpreter/compiler"
+	"github.com/javier-varez/monkey_interpreter/vm"
+)
+
+func readSample(b *testing.B, name string) string {
+	b.Helper()
+
+	contents, err := os.ReadFile("../samples/" + name)
+	if err != nil {
+		b.Fatalf("Unable to read sample: %s", err)
+	}
+	return string(contents)
+}
+
+func BenchmarkFibonacci(b *testing.B) {
+	program := parse(readSample(b, "fibonacci.monkey"))
+
+	b.Run("vm", func(b *testing.B) {
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			b.Fatalf("compiler error: %s", err)
+		}
+		for i := 0; i < b.N; i++ {
+			if err := vm.New(comp.Bytecode()).Run(); err != nil {
+				b.Fatalf("vm error: %s", err)
+			}
+		}
+	})
+
+	b.Run("regvm", func(b *testing.B) {
+		comp := NewCompiler()
+		if err := comp.Compile(program); err != nil {
+			b.Fatalf("compiler error: %s", err)
+		}
+		for i := 0; i < b.N; i++ {
+			if err := New(comp.Program()).Run(); err != nil {
+				b.Fatalf("vm error: %s", err)
+			}
+		}
+	})
+}
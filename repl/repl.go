@@ -6,11 +6,13 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/javier-varez/monkey_interpreter/ast"
 	"github.com/javier-varez/monkey_interpreter/compiler"
 	"github.com/javier-varez/monkey_interpreter/evaluator"
 	"github.com/javier-varez/monkey_interpreter/lexer"
 	"github.com/javier-varez/monkey_interpreter/object"
 	"github.com/javier-varez/monkey_interpreter/parser"
+	"github.com/javier-varez/monkey_interpreter/resolver"
 	"github.com/javier-varez/monkey_interpreter/vm"
 	"github.com/peterh/liner"
 )
@@ -88,6 +90,7 @@ func Start(useVm bool) {
 	defer linerState.SaveHistoryFile()
 
 	env := object.NewEnvironment()
+	res := resolver.New()
 	constants := []object.Object{}
 	symbolTable := compiler.NewSymbolTable()
 	for i, builtin := range object.Builtins {
@@ -113,6 +116,9 @@ func Start(useVm bool) {
 		p := parser.New(lex)
 
 		program := p.ParseProgram()
+		if len(program.Diagnostics) == 0 {
+			program.Diagnostics = res.Resolve(program)
+		}
 
 		if len(program.Diagnostics) != 0 {
 			fmt.Print("Diagnostics:\n\n")
@@ -134,8 +140,15 @@ func Start(useVm bool) {
 			constants = bytecode.Constants
 			vmInst := vm.NewWithGlobalKeyStore(bytecode, globals)
 			err = vmInst.Run()
-			if err != nil {
+			if astErr, ok := err.(ast.Error); ok {
+				fmt.Print(astErr.ContextualError())
+			} else if err != nil {
 				fmt.Printf("Error from vm: %s\n", err)
+			}
+			if tracer, ok := err.(object.StackTracer); ok {
+				fmt.Print(object.FormatStackTrace(tracer.StackTrace()))
+			}
+			if err != nil {
 				continue
 			}
 
@@ -146,7 +159,8 @@ func Start(useVm bool) {
 			if result != nil {
 				if result.Type() == object.ERROR_VALUE_OBJ {
 					err := result.(*object.Error)
-					fmt.Printf("%s\n", err.ContextualError())
+					fmt.Print(err.ContextualError())
+					fmt.Print(object.FormatStackTrace(err.Stack))
 				}
 			}
 		}
@@ -0,0 +1,202 @@
+// Package resolver implements a static pass that binds every identifier of a
+// program to its definition before running it. It reports the mistakes that
+// would otherwise only be found by the engines once the offending code runs:
+// undefined names, duplicate parameters, var args expanded outside of a
+// function that takes them and return statements outside of functions.
+//
+// Bindings follow the rules shared by the interpreter, the VM and the
+// transpiler: a let statement binds its name after evaluating its value, so
+// the value may not refer to the name being defined, and functions capture
+// the bindings defined before them.
+package resolver
+
+import (
+	"fmt"
+
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/object"
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+type resolveError struct {
+	span     token.Span
+	errorMsg string
+}
+
+func (e *resolveError) Error() string {
+	return e.errorMsg
+}
+
+func (e *resolveError) ContextualError() string {
+	return ast.FormatContextualError(e.span, e.errorMsg)
+}
+
+func (e *resolveError) Span() token.Span {
+	return e.span
+}
+
+type scope struct {
+	names map[string]bool
+	outer *scope
+	// varArgs is set for the scopes of functions declared with "...".
+	varArgs bool
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{names: map[string]bool{}, outer: outer}
+}
+
+func (s *scope) isDefined(name string) bool {
+	for current := s; current != nil; current = current.outer {
+		if current.names[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolver keeps the global names defined by the programs it resolved, so
+// that a REPL can resolve each line in the context of the previous ones.
+type Resolver struct {
+	globals *scope
+}
+
+func New() *Resolver {
+	globals := newScope(nil)
+	for _, builtin := range object.Builtins {
+		globals.names[builtin.Name] = true
+	}
+	return &Resolver{globals: globals}
+}
+
+// Resolve is a shorthand to resolve a standalone program.
+func Resolve(program *ast.Program) []ast.Error {
+	return New().Resolve(program)
+}
+
+// Resolve reports the resolution errors of a program that was parsed without
+// diagnostics. The global names it defines are only kept if there are none.
+func (r *Resolver) Resolve(program *ast.Program) []ast.Error {
+	globals := newScope(nil)
+	for name := range r.globals.names {
+		globals.names[name] = true
+	}
+
+	pass := &resolverPass{scope: globals}
+	for _, stmt := range program.Statements {
+		pass.resolveStatement(stmt)
+	}
+
+	if len(pass.errors) == 0 {
+		r.globals = globals
+	}
+	return pass.errors
+}
+
+type resolverPass struct {
+	errors []ast.Error
+	scope  *scope
+}
+
+func (r *resolverPass) mkError(span token.Span, msg string) {
+	r.errors = append(r.errors, &resolveError{span: span, errorMsg: msg})
+}
+
+func (r *resolverPass) inFunction() bool {
+	return r.scope.outer != nil
+}
+
+func (r *resolverPass) resolveStatement(stmt ast.Statment) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		r.resolveExpression(stmt.Expr)
+		if ident, ok := stmt.IdentExpr.(*ast.IdentifierExpr); ok {
+			r.scope.names[ident.IdentToken.Literal] = true
+		}
+	case *ast.ReturnStatement:
+		if !r.inFunction() {
+			r.mkError(stmt.ReturnToken.Span, "Return statement outside of a function")
+		}
+		r.resolveExpression(stmt.Expr)
+	case *ast.ExpressionStatement:
+		r.resolveExpression(stmt.Expr)
+	case *ast.BlockStatement:
+		r.resolveBlock(stmt)
+	}
+}
+
+// resolveBlock resolves the statements of a block. Blocks do not introduce a
+// scope, names defined in them are visible in the rest of the function.
+func (r *resolverPass) resolveBlock(block *ast.BlockStatement) {
+	for _, stmt := range block.Statements {
+		r.resolveStatement(stmt)
+	}
+}
+
+func (r *resolverPass) resolveExpressions(exprs []ast.Expression) {
+	for _, expr := range exprs {
+		r.resolveExpression(expr)
+	}
+}
+
+func (r *resolverPass) resolveExpression(expr ast.Expression) {
+	switch expr := expr.(type) {
+	case *ast.IdentifierExpr:
+		if !r.scope.isDefined(expr.IdentToken.Literal) {
+			r.mkError(expr.Span(), fmt.Sprintf("Unknown identifier %s", expr.IdentToken.Literal))
+		}
+	case *ast.VarArgsLiteralExpr:
+		if !r.scope.varArgs {
+			r.mkError(expr.Span(), "`...` used outside of a function with var args")
+		}
+	case *ast.PrefixExpr:
+		r.resolveExpression(expr.InnerExpr)
+	case *ast.InfixExpr:
+		r.resolveExpression(expr.LeftExpr)
+		r.resolveExpression(expr.RightExpr)
+	case *ast.IfExpr:
+		r.resolveExpression(expr.Condition)
+		r.resolveBlock(expr.Consequence)
+		if expr.Alternative != nil {
+			r.resolveBlock(expr.Alternative)
+		}
+	case *ast.FnLiteralExpr:
+		r.resolveFnLiteralExpr(expr)
+	case *ast.CallExpr:
+		r.resolveExpression(expr.CallableExpr)
+		r.resolveExpressions(expr.Args)
+	case *ast.ArrayLiteralExpr:
+		r.resolveExpressions(expr.Elems)
+	case *ast.SetLiteralExpr:
+		r.resolveExpressions(expr.Elems)
+	case *ast.TupleLiteralExpr:
+		r.resolveExpressions(expr.Elems)
+	case *ast.MapLiteralExpr:
+		for _, entry := range expr.Entries {
+			r.resolveExpression(entry.Key)
+			r.resolveExpression(entry.Value)
+		}
+	case *ast.IndexOperatorExpr:
+		r.resolveExpression(expr.ObjExpr)
+		r.resolveExpression(expr.IndexExpr)
+	case *ast.RangeExpr:
+		r.resolveExpression(expr.StartExpr)
+		r.resolveExpression(expr.EndExpr)
+	}
+}
+
+func (r *resolverPass) resolveFnLiteralExpr(expr *ast.FnLiteralExpr) {
+	r.scope = newScope(r.scope)
+	r.scope.varArgs = expr.VarArgs
+
+	for _, arg := range expr.Args {
+		name := arg.IdentToken.Literal
+		if r.scope.names[name] {
+			r.mkError(arg.Span(), fmt.Sprintf("Duplicate parameter %s", name))
+		}
+		r.scope.names[name] = true
+	}
+
+	r.resolveBlock(expr.Body)
+	r.scope = r.scope.outer
+}
@@ -0,0 +1,131 @@
+package resolver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/lexer"
+	"github.com/javier-varez/monkey_interpreter/parser"
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(program.Diagnostics) != 0 {
+		for _, err := range program.Diagnostics {
+			t.Errorf("%s", err.ContextualError())
+		}
+		t.Fatalf("Unrecoverable program diagnostics")
+	}
+	return program
+}
+
+func mkSpan(start, end int) token.Span {
+	return token.Span{
+		Start: token.Location{Line: 0, Column: start},
+		End:   token.Location{Line: 0, Column: end},
+	}
+}
+
+func TestResolvedPrograms(t *testing.T) {
+	tests := []string{
+		`let a = 1; let b = a + 1; puts(b)`,
+		`let add = fn(a, b) { a + b }; add(1, 2)`,
+		`let x = 1; let f = fn() { x }; f()`,
+		`let f = fn(a) { let b = a; fn(c) { a + b + c } }; f(1)(2)`,
+		`let f = fn() { if (true) { let y = 1; } y }`,
+		`let f = fn(a, ...) { puts(a, ...); len(toArray(...)) }`,
+		`let f = fn() { return 1; }`,
+		`let len = fn(x) { x }; len(1)`,
+		`let x = 1; let x = x + 1; x`,
+		`{"a": [1, (2, 3)], "b": {4}}["a"][1..2]`,
+	}
+
+	for _, input := range tests {
+		for _, err := range Resolve(parse(t, input)) {
+			t.Errorf("Unexpected resolution error in %q:\n%s", input, err.ContextualError())
+		}
+	}
+}
+
+func TestResolutionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		span     token.Span
+	}{
+		{`puts(a)`, "Unknown identifier a", mkSpan(5, 6)},
+		{`let a = a;`, "Unknown identifier a", mkSpan(8, 9)},
+		{`let f = fn() { g() }; let g = fn() { 1 };`, "Unknown identifier g", mkSpan(15, 16)},
+		{`let f = fn(n) { f(n) };`, "Unknown identifier f", mkSpan(16, 17)},
+		{`let f = fn(a) { 1 }; a`, "Unknown identifier a", mkSpan(21, 22)},
+		{`if (false) { missing } else { 1 }`, "Unknown identifier missing", mkSpan(13, 20)},
+		{`fn(a, b, a) { a }`, "Duplicate parameter a", mkSpan(9, 10)},
+		{`puts(...)`, "`...` used outside of a function with var args", mkSpan(5, 8)},
+		{`fn(a) { puts(...) }`, "`...` used outside of a function with var args", mkSpan(13, 16)},
+		{`fn(...) { fn() { puts(...) } }`, "`...` used outside of a function with var args", mkSpan(22, 25)},
+		{`return 1;`, "Return statement outside of a function", mkSpan(0, 6)},
+		{`if (true) { return 1; }`, "Return statement outside of a function", mkSpan(12, 18)},
+	}
+
+	for _, tt := range tests {
+		errors := Resolve(parse(t, tt.input))
+		if len(errors) != 1 {
+			t.Errorf("Expected a single resolution error in %q, got %d: %v", tt.input, len(errors), errors)
+			continue
+		}
+
+		if errors[0].Error() != tt.expected {
+			t.Errorf("Unexpected error in %q. Expected %q, got %q", tt.input, tt.expected, errors[0].Error())
+		}
+
+		span := errors[0].Span()
+		if span.Start != tt.span.Start || span.End != tt.span.End {
+			t.Errorf("Unexpected span in %q. Expected %v, got %v", tt.input, tt.span, span)
+		}
+	}
+}
+
+func TestIncrementalResolution(t *testing.T) {
+	r := New()
+
+	if errors := r.Resolve(parse(t, `let a = 1;`)); len(errors) != 0 {
+		t.Fatalf("Unexpected resolution errors: %v", errors)
+	}
+
+	// Names of programs with errors are discarded
+	if errors := r.Resolve(parse(t, `let b = 2; puts(c)`)); len(errors) != 1 {
+		t.Fatalf("Expected a single resolution error, got %v", errors)
+	}
+
+	if errors := r.Resolve(parse(t, `a`)); len(errors) != 0 {
+		t.Errorf("Unexpected resolution errors: %v", errors)
+	}
+
+	if errors := r.Resolve(parse(t, `b`)); len(errors) != 1 {
+		t.Errorf("Expected b to be undefined, got %v", errors)
+	}
+}
+
+func TestSamplesResolve(t *testing.T) {
+	samples, err := filepath.Glob("../samples/*.monkey")
+	if err != nil || len(samples) == 0 {
+		t.Fatalf("Unable to find samples: %v", err)
+	}
+
+	for _, sample := range samples {
+		txt, err := os.ReadFile(sample)
+		if err != nil {
+			t.Fatalf("Unable to read sample %s: %v", sample, err)
+		}
+
+		for _, err := range Resolve(parse(t, string(txt))) {
+			t.Errorf("Unexpected resolution error in %s:\n%s", sample, err.ContextualError())
+		}
+	}
+}
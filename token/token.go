@@ -3,7 +3,8 @@ package token
 type TokenType string
 
 type Location struct {
-	Line   int
+	Line int
+	// Column is counted in runes from the start of the line.
 	Column int
 }
 
@@ -78,23 +79,29 @@ const (
 	IDENT  = "IDENT"
 	INT    = "INT"
 	STRING = "STRING"
-
-	ASSIGN   = "="
-	PLUS     = "+"
-	MINUS    = "-"
-	BANG     = "!"
-	ASTERISK = "*"
-	SLASH    = "/"
-	LT       = "<"
-	GT       = ">"
-	EQ       = "=="
-	NOT_EQ   = "!="
+	// COMMENT tokens are never returned by the lexer, which skips them like
+	// whitespace and only records them for tools like the formatter.
+	COMMENT = "COMMENT"
+
+	ASSIGN    = "="
+	PLUS      = "+"
+	MINUS     = "-"
+	BANG      = "!"
+	ASTERISK  = "*"
+	SLASH     = "/"
+	LT        = "<"
+	GT        = ">"
+	EQ        = "=="
+	NOT_EQ    = "!="
+	PIPE      = "|"
+	AMPERSAND = "&"
 
 	COMMA      = ","
 	COLON      = ":"
 	SEMICOLON  = ";"
 	TWO_DOTS   = ".."
 	THREE_DOTS = "..."
+	ARROW      = "->"
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -1,6 +1,7 @@
 #pragma once
 
 #include <function_impl.h>
+#include <hash_map.h>
 #include <object.h>
 #include <object_impl.h>
 #include <var_args.h>
@@ -37,6 +38,12 @@ inline Object toArray(Object object) noexcept {
 
 inline Object len(Object object) noexcept {
   using std::literals::operator""sv;
+  if (object.is(Object::Index::TUPLE)) {
+    return Object::makeInt(object.getTuple().len());
+  } else if (object.is(Object::Index::SET)) {
+    return Object::makeInt(object.getSet().len());
+  }
+
   check(object.is(Object::Index::ARRAY), "Unsupported object passed to len: "sv,
         object.type());
 
@@ -91,12 +98,60 @@ inline Object push(Object object, Object newObj) noexcept {
   return Object::makeArray(newArray);
 }
 
+inline Object contains(Object object, Object key) noexcept {
+  using std::literals::operator""sv;
+  if (object.is(Object::Index::SET)) {
+    return Object::makeBool(object.getSet().contains(key));
+  }
+
+  check(object.is(Object::Index::HASH_MAP),
+        "Unsupported object passed to contains: "sv, object.type());
+  return Object::makeBool(object.getHashMap().contains(key));
+}
+
+inline Object keys(Object object) noexcept {
+  using std::literals::operator""sv;
+  check(object.is(Object::Index::HASH_MAP),
+        "Unsupported object passed to keys: "sv, object.type());
+
+  const HashMap map = object.getHashMap();
+  return Object::makeArray(
+      Array{[&map](LargeVec<Object>::Pusher pusher) noexcept -> void {
+        map.forEach([&](const Object &k, const Object &) { pusher.push(k); });
+      }});
+}
+
+inline Object values(Object object) noexcept {
+  using std::literals::operator""sv;
+  check(object.is(Object::Index::HASH_MAP),
+        "Unsupported object passed to values: "sv, object.type());
+
+  const HashMap map = object.getHashMap();
+  return Object::makeArray(
+      Array{[&map](LargeVec<Object>::Pusher pusher) noexcept -> void {
+        map.forEach([&](const Object &, const Object &v) { pusher.push(v); });
+      }});
+}
+
+// delete is a C++ keyword, the transpiler renames monkey identifiers that
+// clash with keywords by appending an underscore.
+inline Object delete_(Object object, Object key) noexcept {
+  using std::literals::operator""sv;
+  check(object.is(Object::Index::HASH_MAP),
+        "Unsupported object passed to delete: "sv, object.type());
+  return Object::makeHashMap(object.getHashMap().without(key));
+}
+
 }  // namespace runtime
 //
+using runtime::contains;
+using runtime::delete_;
 using runtime::first;
+using runtime::keys;
 using runtime::last;
 using runtime::len;
 using runtime::push;
 using runtime::puts;
 using runtime::rest;
 using runtime::toArray;
+using runtime::values;
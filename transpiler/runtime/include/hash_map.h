@@ -24,6 +24,11 @@ class HashMap final {
 
   const Object& operator[](const Object& key) const noexcept;
 
+  [[nodiscard]] bool contains(const Object& key) const noexcept;
+
+  // Returns a copy of the map without the given key.
+  [[nodiscard]] HashMap without(const Object& key) const noexcept;
+
   void forEach(const std::function<void(const Object&, const Object&)>&
                    callable) const noexcept;
 
@@ -36,4 +41,34 @@ class HashMap final {
   void pushKvPair(const KvPair& pair) noexcept;
 };
 
+class HashSet final {
+ public:
+  HashSet() noexcept;
+
+  template <typename... Args>
+    requires((std::same_as<Args, Object> && ...))
+  HashSet(const Args&... args) noexcept : HashSet() {
+    (insert(args), ...);
+  }
+
+  [[nodiscard]] bool contains(const Object& elem) const noexcept;
+
+  [[nodiscard]] size_t len() const noexcept;
+
+  [[nodiscard]] HashSet unionWith(const HashSet& other) const noexcept;
+  [[nodiscard]] HashSet intersect(const HashSet& other) const noexcept;
+  [[nodiscard]] HashSet difference(const HashSet& other) const noexcept;
+
+  void forEach(
+      const std::function<void(const Object&)>& callable) const noexcept;
+
+  ~HashSet() noexcept;
+
+ private:
+  class Impl;
+  Rc<Impl> mImpl;
+
+  void insert(const Object& elem) noexcept;
+};
+
 }  // namespace runtime
@@ -3,6 +3,7 @@
 #include <array.h>
 #include <fatal.h>
 #include <function.h>
+#include <tuple.h>
 
 #include <cstdint>
 #include <cstdlib>
@@ -18,6 +19,7 @@ namespace runtime {
 
 class VarArgs;
 class HashMap;
+class HashSet;
 
 struct Object final {
   // Marker type just to make sure nil is represented by the variant
@@ -26,8 +28,8 @@ struct Object final {
   constexpr static std::array OBJECT_TYPE_NAMES{[]() {
     using std::literals::operator""sv;
     return std::array{
-        "NIL"sv,      "INTEGER"sv, "BOOLEAN"sv, "STRING"sv,
-        "FUNCTION"sv, "ARRAY"sv,   "VARARGS"sv, "MAP"sv,
+        "NIL"sv,      "INTEGER"sv, "BOOLEAN"sv, "STRING"sv, "FUNCTION"sv,
+        "ARRAY"sv,    "VARARGS"sv, "MAP"sv,     "TUPLE"sv,  "SET"sv,
     };
   }()};
 
@@ -40,10 +42,12 @@ struct Object final {
     ARRAY,
     VARARGS,
     HASH_MAP,
+    TUPLE,
+    SET,
   };
 
   using Inner = std::variant<Nil, int64_t, bool, std::string, Function, Array,
-                             Rc<VarArgs>, Rc<HashMap>>;
+                             Rc<VarArgs>, Rc<HashMap>, Tuple, Rc<HashSet>>;
   Inner val{Nil{}};
 
   static inline Object makeInt(const int64_t val) noexcept {
@@ -63,6 +67,8 @@ struct Object final {
   static Object makeArray(const Array a) noexcept;
   static Object makeVarargs(const VarArgs &v) noexcept;
   static Object makeHashMap(const HashMap &h) noexcept;
+  static Object makeTuple(const Tuple &t) noexcept;
+  static Object makeSet(const HashSet &s) noexcept;
 
   constexpr inline bool is(const Index idx) const noexcept {
     return val.index() == static_cast<size_t>(idx);
@@ -80,6 +86,8 @@ struct Object final {
   Array getArray() const noexcept;
   VarArgs getVarArgs() const noexcept;
   HashMap getHashMap() const noexcept;
+  Tuple getTuple() const noexcept;
+  HashSet getSet() const noexcept;
 
   [[nodiscard]] std::string inspect() const noexcept;
 
@@ -103,5 +111,7 @@ Object operator==(const Object &lhs, const Object &rhs) noexcept;
 Object operator!=(const Object &lhs, const Object &rhs) noexcept;
 Object operator<(const Object &lhs, const Object &rhs) noexcept;
 Object operator>(const Object &lhs, const Object &rhs) noexcept;
+Object operator|(const Object &lhs, const Object &rhs) noexcept;
+Object operator&(const Object &lhs, const Object &rhs) noexcept;
 
 }  // namespace runtime
@@ -0,0 +1,26 @@
+#pragma once
+
+#include <array.h>
+
+namespace runtime {
+
+struct Object;
+
+class Tuple final {
+public:
+  using Iter = Array::Iter;
+
+  explicit Tuple(Array elems) noexcept;
+
+  Object operator[](size_t index) const noexcept;
+
+  size_t len() const noexcept;
+
+  Iter begin() const noexcept;
+  Iter end() const noexcept;
+
+private:
+  Array mElems;
+};
+
+} // namespace runtime
@@ -175,6 +175,8 @@ private:
 
 namespace detail {
 
+template <typename T> constexpr static size_t countElems() { return 0; }
+
 template <typename T, typename U, typename... Rest>
 constexpr static size_t countElems(U &&current, Rest &&...rest) {
   size_t count = 1;
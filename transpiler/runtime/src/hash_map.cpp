@@ -4,13 +4,18 @@
 
 #include <functional>
 #include <unordered_map>
+#include <unordered_set>
+#include <utility>
+#include <vector>
 
 namespace runtime {
 
 namespace {
 
+// Keys are owned by the wrapper, as the objects used to build a map or a set
+// are usually temporaries.
 struct ObjectWrapper {
-  const Object& obj;
+  Object obj;
 };
 
 [[nodiscard]] bool operator==(const ObjectWrapper& lhs,
@@ -61,6 +66,8 @@ struct hash<runtime::ObjectWrapper> final {
 
 namespace runtime {
 
+// Entries are kept in insertion order, the unordered map only indexes them by
+// key.
 class HashMap::Impl {
  public:
   Impl() noexcept;
@@ -69,32 +76,44 @@ class HashMap::Impl {
 
   const Object& operator[](const Object& key) const noexcept;
 
+  [[nodiscard]] bool contains(const Object& key) const noexcept;
+
   void forEach(const std::function<void(const Object&, const Object&)>&
                    callable) const noexcept;
 
  private:
-  std::unordered_map<ObjectWrapper, Object> mMap;
+  std::unordered_map<ObjectWrapper, size_t> mIndex;
+  std::vector<std::pair<Object, Object>> mEntries;
 };
 
 HashMap::Impl::Impl() noexcept {}
 
 void HashMap::Impl::pushKvPair(const KvPair& pair) noexcept {
-  mMap[ObjectWrapper{pair.k}] = pair.v;
+  const ObjectWrapper k{pair.k};
+  if (const auto it = mIndex.find(k); it != mIndex.end()) {
+    mEntries[it->second].second = pair.v;
+    return;
+  }
+  mIndex.emplace(k, mEntries.size());
+  mEntries.emplace_back(pair.k, pair.v);
 }
 
 const Object& HashMap::Impl::operator[](const Object& key) const noexcept {
-  const ObjectWrapper k{key};
-  if (mMap.contains(k)) {
-    return mMap.at(k);
+  if (const auto it = mIndex.find(ObjectWrapper{key}); it != mIndex.end()) {
+    return mEntries[it->second].second;
   }
   return Object::nil();
 }
 
+bool HashMap::Impl::contains(const Object& key) const noexcept {
+  return mIndex.contains(ObjectWrapper{key});
+}
+
 void HashMap::Impl::forEach(
     const std::function<void(const Object&, const Object&)>& callable)
     const noexcept {
-  for (const auto& [k, v] : mMap) {
-    callable(k.obj, v);
+  for (const auto& [k, v] : mEntries) {
+    callable(k, v);
   }
 }
 
@@ -104,6 +123,20 @@ const Object& HashMap::operator[](const Object& key) const noexcept {
   return (*mImpl)[key];
 }
 
+bool HashMap::contains(const Object& key) const noexcept {
+  return mImpl->contains(key);
+}
+
+HashMap HashMap::without(const Object& key) const noexcept {
+  HashMap result;
+  forEach([&](const Object& k, const Object& v) {
+    if (!k.equals(key)) {
+      result.pushKvPair(KvPair{k, v});
+    }
+  });
+  return result;
+}
+
 HashMap::~HashMap() noexcept {}
 
 void HashMap::pushKvPair(const KvPair& pair) noexcept {
@@ -115,4 +148,84 @@ void HashMap::forEach(const std::function<void(const Object&, const Object&)>&
   mImpl->forEach(callable);
 }
 
+class HashSet::Impl {
+ public:
+  Impl() noexcept;
+
+  void insert(const Object& elem) noexcept;
+
+  [[nodiscard]] bool contains(const Object& elem) const noexcept;
+
+  [[nodiscard]] size_t len() const noexcept;
+
+  void forEach(
+      const std::function<void(const Object&)>& callable) const noexcept;
+
+ private:
+  std::unordered_set<ObjectWrapper> mSet;
+};
+
+HashSet::Impl::Impl() noexcept {}
+
+void HashSet::Impl::insert(const Object& elem) noexcept {
+  mSet.insert(ObjectWrapper{elem});
+}
+
+bool HashSet::Impl::contains(const Object& elem) const noexcept {
+  return mSet.contains(ObjectWrapper{elem});
+}
+
+size_t HashSet::Impl::len() const noexcept { return mSet.size(); }
+
+void HashSet::Impl::forEach(
+    const std::function<void(const Object&)>& callable) const noexcept {
+  for (const auto& elem : mSet) {
+    callable(elem.obj);
+  }
+}
+
+HashSet::HashSet() noexcept : mImpl{} {}
+
+HashSet::~HashSet() noexcept {}
+
+void HashSet::insert(const Object& elem) noexcept { mImpl->insert(elem); }
+
+bool HashSet::contains(const Object& elem) const noexcept {
+  return mImpl->contains(elem);
+}
+
+size_t HashSet::len() const noexcept { return mImpl->len(); }
+
+HashSet HashSet::unionWith(const HashSet& other) const noexcept {
+  HashSet result;
+  forEach([&result](const Object& elem) { result.insert(elem); });
+  other.forEach([&result](const Object& elem) { result.insert(elem); });
+  return result;
+}
+
+HashSet HashSet::intersect(const HashSet& other) const noexcept {
+  HashSet result;
+  forEach([&result, &other](const Object& elem) {
+    if (other.contains(elem)) {
+      result.insert(elem);
+    }
+  });
+  return result;
+}
+
+HashSet HashSet::difference(const HashSet& other) const noexcept {
+  HashSet result;
+  forEach([&result, &other](const Object& elem) {
+    if (!other.contains(elem)) {
+      result.insert(elem);
+    }
+  });
+  return result;
+}
+
+void HashSet::forEach(
+    const std::function<void(const Object&)>& callable) const noexcept {
+  mImpl->forEach(callable);
+}
+
 }  // namespace runtime
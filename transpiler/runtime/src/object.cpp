@@ -1,3 +1,5 @@
+#include <limits>
+
 #include <hash_map.h>
 #include <object.h>
 #include <var_args.h>
@@ -87,6 +89,43 @@ struct Printer {
     stream << '}';
     return stream.str();
   }
+
+  [[nodiscard]] std::string operator()(const Tuple &val) noexcept {
+    using std::literals::operator""sv;
+    std::ostringstream stream;
+    stream << '(';
+    bool firstIter = true;
+    for (const Object &obj : val) {
+      if (!firstIter) {
+        stream << ", "sv;
+      } else {
+        firstIter = false;
+      }
+      stream << obj.inspect();
+    }
+    if (val.len() == 1) {
+      stream << ',';
+    }
+    stream << ')';
+    return stream.str();
+  }
+
+  [[nodiscard]] std::string operator()(const Rc<HashSet> &val) noexcept {
+    using std::literals::operator""sv;
+    std::ostringstream stream;
+    stream << "{"sv;
+    bool firstIter = true;
+    val->forEach([&firstIter, &stream](const Object &elem) {
+      if (!firstIter) {
+        stream << ", "sv;
+      } else {
+        firstIter = false;
+      }
+      stream << elem.inspect();
+    });
+    stream << '}';
+    return stream.str();
+  }
 };
 }  // namespace
 
@@ -124,6 +163,18 @@ Object Object::makeHashMap(const HashMap &v) noexcept {
   };
 }
 
+Object Object::makeTuple(const Tuple &t) noexcept {
+  return Object{
+      .val{t},
+  };
+}
+
+Object Object::makeSet(const HashSet &s) noexcept {
+  return Object{
+      .val{Rc<HashSet>{Marker<HashSet>{}, s}},
+  };
+}
+
 std::string Object::getString() const noexcept {
   using std::literals::operator""sv;
   check(is(Index::STRING), "Attempted to unwrap string but object type was `"sv,
@@ -152,11 +203,28 @@ HashMap Object::getHashMap() const noexcept {
   return *std::get<Rc<HashMap>>(val);
 }
 
+Tuple Object::getTuple() const noexcept {
+  using std::literals::operator""sv;
+  check(is(Index::TUPLE), "Attempted to unwrap tuple but object type was `"sv,
+        type(), '`');
+  return std::get<Tuple>(val);
+}
+
+HashSet Object::getSet() const noexcept {
+  using std::literals::operator""sv;
+  check(is(Index::SET), "Attempted to unwrap set but object type was `"sv,
+        type(), '`');
+  return *std::get<Rc<HashSet>>(val);
+}
+
 Object Object::operator-() const noexcept {
   using std::literals::operator""sv;
   check(is(Index::INTEGER), "Attempted to execute prefix operator '-' on a "sv,
         type());
-  return Object::makeInt(-getInteger());
+  int64_t result;
+  check(!__builtin_sub_overflow(int64_t{0}, getInteger(), &result),
+        "Integer overflow in prefix operator '-'"sv);
+  return Object::makeInt(result);
 }
 
 Object Object::operator!() const noexcept {
@@ -172,6 +240,10 @@ Object Object::operator[](Object index) const noexcept {
     check(index.is(Index::INTEGER), "Index to array is not an integer: "sv,
           type());
     return getArray()[index.getInteger()];
+  } else if (is(Index::TUPLE)) {
+    check(index.is(Index::INTEGER), "Index to tuple is not an integer: "sv,
+          type());
+    return getTuple()[index.getInteger()];
   } else if (is(Index::HASH_MAP)) {
     return getHashMap()[index];
   }
@@ -181,7 +253,10 @@ Object Object::operator[](Object index) const noexcept {
 Object operator+(const Object &lhs, const Object &rhs) noexcept {
   using std::literals::operator""sv;
   if (lhs.is(Object::Index::INTEGER) && rhs.is(Object::Index::INTEGER)) {
-    return Object::makeInt(int64_t{lhs.getInteger() + rhs.getInteger()});
+    int64_t result;
+    check(!__builtin_add_overflow(lhs.getInteger(), rhs.getInteger(), &result),
+          "Integer overflow in operator `+`"sv);
+    return Object::makeInt(result);
   } else if (lhs.is(Object::Index::STRING) && rhs.is(Object::Index::STRING)) {
     return Object::makeString(lhs.getString() + rhs.getString());
   }
@@ -193,7 +268,12 @@ Object operator+(const Object &lhs, const Object &rhs) noexcept {
 Object operator-(const Object &lhs, const Object &rhs) noexcept {
   using std::literals::operator""sv;
   if (lhs.is(Object::Index::INTEGER) && rhs.is(Object::Index::INTEGER)) {
-    return Object::makeInt(int64_t{lhs.getInteger() - rhs.getInteger()});
+    int64_t result;
+    check(!__builtin_sub_overflow(lhs.getInteger(), rhs.getInteger(), &result),
+          "Integer overflow in operator `-`"sv);
+    return Object::makeInt(result);
+  } else if (lhs.is(Object::Index::SET) && rhs.is(Object::Index::SET)) {
+    return Object::makeSet(lhs.getSet().difference(rhs.getSet()));
   }
 
   fatal("Operator `-` is undefined for operands `"sv, lhs.type(), "` and `"sv,
@@ -203,7 +283,10 @@ Object operator-(const Object &lhs, const Object &rhs) noexcept {
 Object operator*(const Object &lhs, const Object &rhs) noexcept {
   using std::literals::operator""sv;
   if (lhs.is(Object::Index::INTEGER) && rhs.is(Object::Index::INTEGER)) {
-    return Object::makeInt(int64_t{lhs.getInteger() * rhs.getInteger()});
+    int64_t result;
+    check(!__builtin_mul_overflow(lhs.getInteger(), rhs.getInteger(), &result),
+          "Integer overflow in operator `*`"sv);
+    return Object::makeInt(result);
   }
 
   fatal("Operator `*` is undefined for operands `"sv, lhs.type(), "` and `"sv,
@@ -213,7 +296,11 @@ Object operator*(const Object &lhs, const Object &rhs) noexcept {
 Object operator/(const Object &lhs, const Object &rhs) noexcept {
   using std::literals::operator""sv;
   if (lhs.is(Object::Index::INTEGER) && rhs.is(Object::Index::INTEGER)) {
-    return Object::makeInt(int64_t{lhs.getInteger() / rhs.getInteger()});
+    check(rhs.getInteger() != 0, "Division by zero"sv);
+    check(lhs.getInteger() != std::numeric_limits<int64_t>::min() ||
+              rhs.getInteger() != -1,
+          "Integer overflow in operator `/`"sv);
+    return Object::makeInt(lhs.getInteger() / rhs.getInteger());
   }
 
   fatal("Operator `/` is undefined for operands `"sv, lhs.type(), "` and `"sv,
@@ -264,6 +351,26 @@ Object operator>(const Object &lhs, const Object &rhs) noexcept {
         rhs.type(), '\n');
 }
 
+Object operator|(const Object &lhs, const Object &rhs) noexcept {
+  using std::literals::operator""sv;
+  if (lhs.is(Object::Index::SET) && rhs.is(Object::Index::SET)) {
+    return Object::makeSet(lhs.getSet().unionWith(rhs.getSet()));
+  }
+
+  fatal("Operator `|` is undefined for operands `"sv, lhs.type(), "` and `"sv,
+        rhs.type(), '\n');
+}
+
+Object operator&(const Object &lhs, const Object &rhs) noexcept {
+  using std::literals::operator""sv;
+  if (lhs.is(Object::Index::SET) && rhs.is(Object::Index::SET)) {
+    return Object::makeSet(lhs.getSet().intersect(rhs.getSet()));
+  }
+
+  fatal("Operator `&` is undefined for operands `"sv, lhs.type(), "` and `"sv,
+        rhs.type(), '\n');
+}
+
 const Object &Object::nil() noexcept {
   const static Object obj{};
   return obj;
@@ -285,8 +392,19 @@ bool Object::equals(const Object &other) const noexcept {
           return false;
         } else if constexpr (std::same_as<T, Rc<VarArgs>>) {
           return false;
-        } else if constexpr (std::same_as<T, Rc<HashMap>>) {
+        } else if constexpr (std::same_as<T, Rc<HashMap>> ||
+                             std::same_as<T, Rc<HashSet>>) {
           return false;
+        } else if constexpr (std::same_as<T, Tuple>) {
+          if (lhs.len() != rhs.len()) {
+            return false;
+          }
+          for (size_t i = 0; i < lhs.len(); i++) {
+            if (!lhs[i].equals(rhs[i])) {
+              return false;
+            }
+          }
+          return true;
         } else {
           return rhs == lhs;
         }
@@ -303,9 +421,16 @@ int64_t Object::hash() const noexcept {
                } else if constexpr (std::same_as<T, Function> ||
                                     std::same_as<T, Array> ||
                                     std::same_as<T, Rc<VarArgs>> ||
-                                    std::same_as<T, Rc<HashMap>>) {
+                                    std::same_as<T, Rc<HashMap>> ||
+                                    std::same_as<T, Rc<HashSet>>) {
                  using std::literals::operator""sv;
                  fatal("Cannot hash type: "sv, type());
+               } else if constexpr (std::same_as<T, Tuple>) {
+                 int64_t hash = 0;
+                 for (const Object &elem : val) {
+                   hash = hash * 31 + elem.hash();
+                 }
+                 return hash;
                } else {
                  return std::hash<T>{}(val);
                }
@@ -0,0 +1,21 @@
+#include <object.h>
+#include <tuple.h>
+
+#include <utility>
+
+namespace runtime {
+
+Tuple::Tuple(Array elems) noexcept : mElems{std::move(elems)} {}
+
+Object Tuple::operator[](size_t index) const noexcept {
+  check(index < len(), "Out of bounds access to tuple.");
+  return mElems[index];
+}
+
+size_t Tuple::len() const noexcept { return mElems.len(); }
+
+Tuple::Iter Tuple::begin() const noexcept { return mElems.begin(); }
+
+Tuple::Iter Tuple::end() const noexcept { return mElems.end(); }
+
+}  // namespace runtime
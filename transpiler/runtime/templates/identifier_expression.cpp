@@ -1 +1 @@
-{{ .IdentToken.Literal }}
+{{ CppIdentifier .IdentToken.Literal }}
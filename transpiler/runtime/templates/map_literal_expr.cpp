@@ -1,5 +1,5 @@
 runtime::Object::makeHashMap(runtime::HashMap{
-    {{range $k, $v := .Map}}
-      runtime::HashMap::KvPair{ {{Transpile $k}}, {{Transpile $v}} },
+    {{range $entry := .Entries}}
+      runtime::HashMap::KvPair{ {{Transpile $entry.Key}}, {{Transpile $entry.Value}} },
     {{end}}
 })
@@ -0,0 +1 @@
+runtime::Object::makeSet(runtime::HashSet{ {{range $i, $el := .Elems}}{{if $i}},{{end}}{{Transpile .}}{{end}} })
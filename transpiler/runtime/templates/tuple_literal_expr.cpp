@@ -0,0 +1 @@
+runtime::Object::makeTuple(runtime::Tuple{runtime::Array{ {{range $i, $el := .Elems}}{{if $i}},{{end}}{{Transpile .}}{{end}} }})
@@ -42,6 +42,8 @@ const (
 	VAR_ARGS_LITERAL_EXPRESSION = astNodeType("VAR_ARGS_LITERAL_EXPRESSION")
 	RANGE_EXPRESSION            = astNodeType("RANGE_EXPRESSION")
 	MAP_LITERAL_EXPRESSION      = astNodeType("MAP_LITERAL_EXPRESSION")
+	SET_LITERAL_EXPRESSION      = astNodeType("SET_LITERAL_EXPRESSION")
+	TUPLE_LITERAL_EXPRESSION    = astNodeType("TUPLE_LITERAL_EXPRESSION")
 )
 
 const runtimeIncludeDir = "runtime/include"
@@ -49,7 +51,31 @@ const runtimeSrcDir = "runtime/src"
 const runtimeCMakeListsTxt = "runtime/CMakeLists.txt"
 
 var funcs template.FuncMap = map[string]any{
-	"Transpile": Transpile,
+	"Transpile":     Transpile,
+	"CppIdentifier": cppIdentifier,
+}
+
+// cppKeywords are valid monkey identifiers that cannot be used as names in
+// the generated C++ code.
+var cppKeywords = map[string]bool{
+	"auto": true, "bool": true, "break": true, "case": true, "catch": true,
+	"char": true, "class": true, "const": true, "continue": true, "default": true,
+	"delete": true, "do": true, "double": true, "float": true, "for": true,
+	"goto": true, "int": true, "long": true, "namespace": true, "new": true,
+	"operator": true, "private": true, "public": true, "short": true,
+	"signed": true, "sizeof": true, "static": true, "struct": true,
+	"switch": true, "template": true, "this": true, "throw": true, "try": true,
+	"typedef": true, "union": true, "unsigned": true, "using": true,
+	"virtual": true, "void": true, "volatile": true, "while": true,
+}
+
+// cppIdentifier mangles identifiers that clash with C++ keywords by appending
+// an underscore. The runtime defines builtins such as delete accordingly.
+func cppIdentifier(name string) string {
+	if cppKeywords[name] {
+		return name + "_"
+	}
+	return name
 }
 
 func loadTemplate(nodeType astNodeType, filename string) {
@@ -80,6 +106,8 @@ func init() {
 	loadTemplate(VAR_ARGS_LITERAL_EXPRESSION, "runtime/templates/var_args_literal_expr.cpp")
 	loadTemplate(RANGE_EXPRESSION, "runtime/templates/range_expr.cpp")
 	loadTemplate(MAP_LITERAL_EXPRESSION, "runtime/templates/map_literal_expr.cpp")
+	loadTemplate(SET_LITERAL_EXPRESSION, "runtime/templates/set_literal_expr.cpp")
+	loadTemplate(TUPLE_LITERAL_EXPRESSION, "runtime/templates/tuple_literal_expr.cpp")
 }
 
 var indent int = 0
@@ -246,6 +274,10 @@ func Transpile(node ast.Node) string {
 		return execTemplate(RANGE_EXPRESSION, node)
 	case *ast.MapLiteralExpr:
 		return execTemplate(MAP_LITERAL_EXPRESSION, node)
+	case *ast.SetLiteralExpr:
+		return execTemplate(SET_LITERAL_EXPRESSION, node)
+	case *ast.TupleLiteralExpr:
+		return execTemplate(TUPLE_LITERAL_EXPRESSION, node)
 	default:
 		log.Fatalf("Unsupported node type: %T\n", node)
 	}
@@ -114,6 +114,7 @@ func TestFunctionCall(t *testing.T) {
 		expectedOutput string
 	}{
 		{`let add = fn(x, y) { x + y }; puts(add(10, 12))`, "22\n"},
+		{`let add = fn(x: int, y: int) -> int { x + y }; let z: int = add(10, 12); puts(z)`, "22\n"},
 	}
 	for i, tt := range test {
 		out := testTranspile(tt.input)
@@ -286,3 +287,42 @@ func TestMap(t *testing.T) {
 		}
 	}
 }
+
+func TestSetsAndTuples(t *testing.T) {
+	test := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{`puts(len({1, 2, 2, 1}), len({1, 2, 3} | {3, 4}), len({1, 2, 3} & {3, 4}), len({1, 2, 3} - {3, 4}))`, "2412\n"},
+		{`puts(contains({1, "two", (3, 4)}, (3, 4)), contains({1, 2, 3} - {3, 4}, 3), contains({"a": 1}, "a"))`, "truefalsetrue\n"},
+		{`puts((1, "two", [3]), " ", (1,), " ", (1, "two", [3])[1], " ", len(()))`, "(1, two, [3]) (1,) two 0\n"},
+		{`puts({(1, 2): "a", (2, 1): "b"}[(2, 1)])`, "b\n"},
+	}
+
+	for i, tt := range test {
+		out := testTranspile(tt.input)
+		if out != tt.expectedOutput {
+			t.Errorf("[%d] Test failed. expected %q, got %q", i, tt.expectedOutput, out)
+		}
+	}
+}
+
+func TestMapBuiltins(t *testing.T) {
+	test := []struct {
+		input          string
+		expectedOutput string
+	}{
+		{`puts(keys({"a": 1}), values({"a": 1}), len(keys({"a": 1, "b": 2})))`, "[a][1]2\n"},
+		{`let m = {"a": 1, "b": 2}; let n = delete(m, "a"); puts(contains(n, "a"), contains(m, "a"), n)`, "falsetrue{b: 2}\n"},
+		{`let new = fn(delete) { delete + 1 }; puts(new(1))`, "2\n"},
+		{`puts({"b": 1, "a": 2, "c": 3, "a": 4})`, "{b: 1, a: 4, c: 3}\n"},
+		{`puts(keys({"b": 1, "a": 2}), values(delete({"c": 1, "b": 2, "a": 3}, "b")))`, "[b, a][1, 3]\n"},
+	}
+
+	for i, tt := range test {
+		out := testTranspile(tt.input)
+		if out != tt.expectedOutput {
+			t.Errorf("[%d] Test failed. expected %q, got %q", i, tt.expectedOutput, out)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/javier-varez/monkey_interpreter/ast"
+	"github.com/javier-varez/monkey_interpreter/code"
+	"github.com/javier-varez/monkey_interpreter/compiler"
+	"github.com/javier-varez/monkey_interpreter/object"
+	"github.com/javier-varez/monkey_interpreter/token"
+)
+
+type StopReason string
+
+const (
+	StopStep       StopReason = "step"
+	StopBreakpoint StopReason = "breakpoint"
+	// StopExited is returned once the program runs to completion
+	StopExited StopReason = "exited"
+)
+
+// Variable is a named value visible from a frame.
+type Variable struct {
+	Name  string
+	Value object.Object
+}
+
+// StackFrame describes a frame of the call stack of a stopped program.
+type StackFrame struct {
+	Name string
+	// Span is the statement being executed by the frame
+	Span   token.Span
+	Locals []Variable
+	Free   []Variable
+}
+
+// Debugger runs a program one statement at a time. Programs stop before
+// executing the first instruction of a statement, either because a step
+// finished or because the statement is on a line with a breakpoint.
+type Debugger struct {
+	vm       *VM
+	bytecode *compiler.Bytecode
+
+	// breakpoints holds the lines with breakpoints, starting at 0
+	breakpoints map[int]bool
+	// stopped is set while the program is stopped at a statement, which must
+	// run before stopping again
+	stopped bool
+}
+
+func NewDebugger(bytecode *compiler.Bytecode) *Debugger {
+	return &Debugger{
+		vm:          New(bytecode),
+		bytecode:    bytecode,
+		breakpoints: map[int]bool{},
+	}
+}
+
+// functions returns the debug info of the main program and every function
+// defined in it.
+func (d *Debugger) functions() []*code.DebugInfo {
+	result := []*code.DebugInfo{d.bytecode.Debug}
+	for _, constant := range d.bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok && fn.Debug != nil {
+			result = append(result, fn.Debug)
+		}
+	}
+	return result
+}
+
+// SetBreakpoints replaces the breakpoints of the program, returning whether
+// each of the lines has a statement where the program can stop.
+func (d *Debugger) SetBreakpoints(lines []int) []bool {
+	hasStatement := map[int]bool{}
+	for _, debug := range d.functions() {
+		for _, stmt := range debug.Statements {
+			hasStatement[stmt.Span.Start.Line] = true
+		}
+	}
+
+	d.breakpoints = map[int]bool{}
+	verified := make([]bool, len(lines))
+	for i, line := range lines {
+		d.breakpoints[line] = true
+		verified[i] = hasStatement[line]
+	}
+	return verified
+}
+
+// Continue runs the program until it hits a breakpoint.
+func (d *Debugger) Continue() (StopReason, error) {
+	return d.run(func(depth int) bool { return false })
+}
+
+// StepIn runs the program until the next statement, entering called functions.
+func (d *Debugger) StepIn() (StopReason, error) {
+	return d.run(func(depth int) bool { return true })
+}
+
+// StepOver runs the program until the next statement of the current function
+// or of its callers.
+func (d *Debugger) StepOver() (StopReason, error) {
+	start := d.vm.frameIndex
+	return d.run(func(depth int) bool { return depth <= start })
+}
+
+// StepOut runs the program until the current function returns to its caller.
+func (d *Debugger) StepOut() (StopReason, error) {
+	start := d.vm.frameIndex
+	for !d.vm.Done() {
+		if err := d.vm.Step(); err != nil {
+			return "", err
+		}
+		if d.vm.frameIndex < start {
+			d.stopped = true
+			return StopStep, nil
+		}
+		if d.atBreakpoint() {
+			d.stopped = true
+			return StopBreakpoint, nil
+		}
+	}
+	return StopExited, nil
+}
+
+// run executes instructions until the program reaches a statement on a line
+// with a breakpoint, or a statement for which stepDone returns true given the
+// number of frames in the call stack.
+func (d *Debugger) run(stepDone func(depth int) bool) (StopReason, error) {
+	if d.stopped && !d.vm.Done() {
+		if err := d.vm.Step(); err != nil {
+			return "", err
+		}
+	}
+	d.stopped = false
+
+	for !d.vm.Done() {
+		if _, ok := d.nextStatement(); ok {
+			if d.atBreakpoint() {
+				d.stopped = true
+				return StopBreakpoint, nil
+			}
+			if stepDone(d.vm.frameIndex) {
+				d.stopped = true
+				return StopStep, nil
+			}
+		}
+
+		if err := d.vm.Step(); err != nil {
+			return "", err
+		}
+	}
+	return StopExited, nil
+}
+
+// nextStatement returns the statement that starts at the next instruction of
+// the current frame, if any.
+func (d *Debugger) nextStatement() (code.StatementInfo, bool) {
+	frame := d.vm.currentFrame()
+	if frame.closure.Fn.Debug == nil {
+		return code.StatementInfo{}, false
+	}
+	return frame.closure.Fn.Debug.StatementAt(frame.ip + 1)
+}
+
+func (d *Debugger) atBreakpoint() bool {
+	stmt, ok := d.nextStatement()
+	return ok && d.breakpoints[stmt.Span.Start.Line]
+}
+
+// StackFrames describes the call stack, starting from the innermost frame.
+func (d *Debugger) StackFrames() []StackFrame {
+	result := []StackFrame{}
+	for i := d.vm.frameIndex - 1; i >= 0; i-- {
+		frame := d.vm.frames[i]
+		fn := frame.closure.Fn
+
+		stackFrame := StackFrame{Name: fn.Name, Locals: []Variable{}, Free: []Variable{}}
+		if i == 0 {
+			stackFrame.Name = "main"
+		} else if fn.Name == "" {
+			stackFrame.Name = object.ANONYMOUS_FUNCTION
+		}
+
+		if fn.Debug != nil {
+			// The innermost frame is about to run the next instruction, while
+			// the others are in the middle of a call
+			offset := frame.ip
+			if i == d.vm.frameIndex-1 {
+				offset++
+			}
+			if stmt, ok := fn.Debug.StatementContaining(offset); ok {
+				stackFrame.Span = stmt.Span
+			}
+
+			locals := d.vm.stack[frame.LocalsBase : frame.LocalsBase+fn.NumLocals]
+			stackFrame.Locals = variables(fn.Debug.Locals, locals)
+			stackFrame.Free = variables(fn.Debug.Free, frame.closure.FreeObjects)
+		}
+
+		result = append(result, stackFrame)
+	}
+	return result
+}
+
+// Globals returns the global variables defined so far.
+func (d *Debugger) Globals() []Variable {
+	return variables(d.bytecode.Globals, d.vm.globals)
+}
+
+func variables(names []string, values []object.Object) []Variable {
+	result := []Variable{}
+	for i, name := range names {
+		if name == "" || i >= len(values) || values[i] == nil {
+			continue
+		}
+		result = append(result, Variable{Name: name, Value: values[i]})
+	}
+	return result
+}
+
+// Evaluate runs a program in the context of a frame, where frame 0 is the
+// innermost one, returning the value of its last expression statement. The
+// variables of the frame are copied, so the program cannot modify them.
+func (d *Debugger) Evaluate(frame int, program *ast.Program) (object.Object, error) {
+	frames := d.StackFrames()
+	if frame < 0 || frame >= len(frames) {
+		return nil, fmt.Errorf("Invalid frame %d", frame)
+	}
+
+	// Globals keep their index, so that functions of the program still find
+	// them. Free variables and locals are defined after them as new globals.
+	symbolTable := compiler.NewSymbolTable()
+	for i, builtin := range object.Builtins {
+		symbolTable.DefineBuiltin(i, builtin.Name)
+	}
+	for i, name := range d.bytecode.Globals {
+		if d.vm.globals[i] == nil {
+			// Not defined yet, the slot is taken without a name
+			name = ""
+		}
+		symbolTable.Define(name)
+	}
+
+	globals := make([]object.Object, GLOBALS_SIZE)
+	copy(globals, d.vm.globals)
+	for _, variable := range append(frames[frame].Free, frames[frame].Locals...) {
+		globals[symbolTable.Define(variable.Name).Index] = variable.Value
+	}
+
+	// Constants are kept too, as the functions of the program refer to them
+	constants := append([]object.Object{}, d.bytecode.Constants...)
+	c := compiler.NewWithState(constants, symbolTable)
+	if err := c.Compile(program); err != nil {
+		return nil, err
+	}
+
+	vm := NewWithGlobalKeyStore(c.Bytecode(), globals)
+	if err := vm.Run(); err != nil {
+		return nil, err
+	}
+
+	result := vm.LastPoppedStackElem()
+	if result == nil {
+		result = Null
+	}
+	return result, nil
+}
@@ -0,0 +1,200 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/javier-varez/monkey_interpreter/compiler"
+)
+
+func newTestDebugger(t *testing.T, input string) *Debugger {
+	program := parse(input)
+	if len(program.Diagnostics) != 0 {
+		t.Fatalf("Parse error: %s", program.Diagnostics[0])
+	}
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("Compilation error: %s", err)
+	}
+	return NewDebugger(c.Bytecode())
+}
+
+func variablesMap(variables []Variable) map[string]string {
+	result := map[string]string{}
+	for _, v := range variables {
+		result[v.Name] = v.Value.Inspect()
+	}
+	return result
+}
+
+func testVariables(t *testing.T, kind string, expected map[string]string, actual []Variable) {
+	vars := variablesMap(actual)
+	if len(vars) != len(expected) {
+		t.Errorf("Unexpected %s. Expected %v, got %v", kind, expected, vars)
+		return
+	}
+	for name, value := range expected {
+		if vars[name] != value {
+			t.Errorf("Unexpected %s. Expected %v, got %v", kind, expected, vars)
+			return
+		}
+	}
+}
+
+func TestDebuggerStepping(t *testing.T) {
+	input := `let add = fn(a, b) {
+	let sum = a + b;
+	sum
+};
+let x = add(1, 2);
+let y = x * 2;
+y`
+
+	d := newTestDebugger(t, input)
+
+	tests := []struct {
+		step           func() (StopReason, error)
+		expectedReason StopReason
+		expectedLine   int
+		expectedFrames int
+		expectedLocals map[string]string
+	}{
+		{d.StepIn, StopStep, 0, 1, map[string]string{}},
+		{d.StepOver, StopStep, 4, 1, map[string]string{}},
+		{d.StepIn, StopStep, 1, 2, map[string]string{"a": "1", "b": "2"}},
+		{d.StepOver, StopStep, 2, 2, map[string]string{"a": "1", "b": "2", "sum": "3"}},
+		{d.StepOut, StopStep, 4, 1, map[string]string{}},
+		{d.StepOver, StopStep, 5, 1, map[string]string{}},
+		{d.StepOver, StopStep, 6, 1, map[string]string{}},
+		{d.StepOver, StopExited, 0, 0, nil},
+	}
+
+	for i, tt := range tests {
+		reason, err := tt.step()
+		if err != nil {
+			t.Fatalf("Step %d failed: %s", i, err)
+		}
+		if reason != tt.expectedReason {
+			t.Fatalf("Step %d stopped for the wrong reason. Expected %s, got %s", i, tt.expectedReason, reason)
+		}
+		if reason == StopExited {
+			continue
+		}
+
+		frames := d.StackFrames()
+		if len(frames) != tt.expectedFrames {
+			t.Fatalf("Step %d has the wrong number of frames. Expected %d, got %d", i, tt.expectedFrames, len(frames))
+		}
+		if frames[0].Span.Start.Line != tt.expectedLine {
+			t.Errorf("Step %d stopped at the wrong line. Expected %d, got %d", i, tt.expectedLine, frames[0].Span.Start.Line)
+		}
+		testVariables(t, "locals", tt.expectedLocals, frames[0].Locals)
+	}
+
+	globals := variablesMap(d.Globals())
+	if _, ok := globals["add"]; !ok || len(globals) != 3 || globals["x"] != "3" || globals["y"] != "6" {
+		t.Errorf("Unexpected globals: %v", globals)
+	}
+}
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	input := `let adder = fn(a) {
+	fn(b) {
+		a + b
+	}
+};
+let addTwo = adder(2);
+addTwo(3);
+addTwo(4);`
+
+	d := newTestDebugger(t, input)
+
+	verified := d.SetBreakpoints([]int{2, 3, 6})
+	if len(verified) != 3 || !verified[0] || verified[1] || !verified[2] {
+		t.Fatalf("Unexpected verified breakpoints: %v", verified)
+	}
+
+	expectedLines := []int{6, 2, 2}
+	expectedB := []string{"", "3", "4"}
+	for i, line := range expectedLines {
+		reason, err := d.Continue()
+		if err != nil {
+			t.Fatalf("Continue failed: %s", err)
+		}
+		if reason != StopBreakpoint {
+			t.Fatalf("Expected a breakpoint, got %s", reason)
+		}
+
+		frames := d.StackFrames()
+		if frames[0].Span.Start.Line != line {
+			t.Fatalf("Stopped at the wrong line. Expected %d, got %d", line, frames[0].Span.Start.Line)
+		}
+		if expectedB[i] == "" {
+			continue
+		}
+
+		if len(frames) != 2 || frames[1].Name != "main" {
+			t.Fatalf("Unexpected frames: %+v", frames)
+		}
+		testVariables(t, "free variables", map[string]string{"a": "2"}, frames[0].Free)
+		testVariables(t, "locals", map[string]string{"b": expectedB[i]}, frames[0].Locals)
+	}
+
+	reason, err := d.Continue()
+	if err != nil || reason != StopExited {
+		t.Fatalf("Expected the program to exit, got %s (%v)", reason, err)
+	}
+}
+
+func TestDebuggerEvaluate(t *testing.T) {
+	input := `let double = fn(x) { x * 2 };
+let f = fn(a) {
+	let b = a + 1;
+	b
+};
+f(10);
+let late = 1;`
+
+	d := newTestDebugger(t, input)
+	d.SetBreakpoints([]int{3})
+	if reason, err := d.Continue(); err != nil || reason != StopBreakpoint {
+		t.Fatalf("Expected a breakpoint, got %s (%v)", reason, err)
+	}
+
+	tests := []struct {
+		frame    int
+		input    string
+		expected interface{}
+	}{
+		{0, "a + b", 21},
+		{0, "double(b)", 22},
+		{0, "len([a, b])", 2},
+		{1, "double(3)", 6},
+		{0, "late", "Unknown identifier late"},
+		{1, "a", "Unknown identifier a"},
+		{2, "a", "Invalid frame 2"},
+	}
+
+	for _, tt := range tests {
+		result, err := d.Evaluate(tt.frame, parse(tt.input))
+		if msg, ok := tt.expected.(string); ok {
+			if err == nil || err.Error() != msg {
+				t.Errorf("Expected error %q for %q, got %v", msg, tt.input, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %s", tt.input, err)
+			continue
+		}
+		if err := testIntegerObject(int64(tt.expected.(int)), result); err != nil {
+			t.Errorf("Wrong result for %q: %s", tt.input, err)
+		}
+	}
+
+	// Evaluating does not change the program
+	if reason, err := d.Continue(); err != nil || reason != StopExited {
+		t.Fatalf("Expected the program to exit, got %s (%v)", reason, err)
+	}
+}
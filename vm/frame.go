@@ -11,6 +11,10 @@ type Frame struct {
 
 	// points to the local vars on the stack
 	LocalsBase int
+
+	// callSite is the offset of the last call made by the frame, where it
+	// waits for the callee to return
+	callSite int
 }
 
 func NewFrame(closure *object.Closure, sp int) *Frame {
@@ -0,0 +1,268 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/javier-varez/monkey_interpreter/code"
+	"github.com/javier-varez/monkey_interpreter/compiler"
+	"github.com/javier-varez/monkey_interpreter/object"
+)
+
+// Verify checks that bytecode can run without corrupting the VM, which trusts
+// its input. Bytecode that was not produced by the compiler of this program,
+// like the one of files, should be verified before running it.
+//
+// Every function must be made of defined opcodes with all their operands,
+// jump to the start of instructions, refer to existing constants, locals,
+// free variables and builtins, and use the stack consistently: each
+// instruction must find the same number of values in the stack whatever path
+// leads to it, and never pop more values than were pushed.
+func Verify(bytecode *compiler.Bytecode) error {
+	// The number of free variables each function is closed with
+	free := map[int]int{}
+	for i, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			if err := collectFreeVariables(bytecode, fn, free); err != nil {
+				return fmt.Errorf("Invalid bytecode in constant %d: %w", i, err)
+			}
+		}
+	}
+	main := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	if err := collectFreeVariables(bytecode, main, free); err != nil {
+		return fmt.Errorf("Invalid bytecode in the main program: %w", err)
+	}
+
+	if err := verifyFunction(bytecode, main, true, 0); err != nil {
+		return fmt.Errorf("Invalid bytecode in the main program: %w", err)
+	}
+	for i, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			numFree, ok := free[i]
+			if !ok {
+				// Never closed over, so it cannot run
+				continue
+			}
+			if err := verifyFunction(bytecode, fn, false, numFree); err != nil {
+				return fmt.Errorf("Invalid bytecode in constant %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// collectFreeVariables records the number of free variables of the closures
+// created by fn, which must agree with the ones created elsewhere.
+func collectFreeVariables(bytecode *compiler.Bytecode, fn *object.CompiledFunction, free map[int]int) error {
+	instructions, err := fn.Instructions.Decode()
+	if err != nil {
+		return err
+	}
+
+	for _, ins := range instructions {
+		if ins.Opcode != code.OpClosure && ins.Opcode != code.OpClosureWide {
+			continue
+		}
+		idx, numFree := ins.Operands[0], ins.Operands[1]
+		if idx >= len(bytecode.Constants) {
+			return fmt.Errorf("Constant %d at offset %d does not exist", idx, ins.Offset)
+		}
+		if _, ok := bytecode.Constants[idx].(*object.CompiledFunction); !ok {
+			return fmt.Errorf("Closure at offset %d refers to constant %d, which is not a function", ins.Offset, idx)
+		}
+		if previous, ok := free[idx]; ok && previous != numFree {
+			return fmt.Errorf("Closure at offset %d captures %d free variables of constant %d, but others capture %d", ins.Offset, numFree, idx, previous)
+		}
+		free[idx] = numFree
+	}
+	return nil
+}
+
+// verifyFunction checks the instructions of a function that runs with
+// numFree free variables. Only the main program may run to the end of its
+// instructions, with an empty stack.
+func verifyFunction(bytecode *compiler.Bytecode, fn *object.CompiledFunction, isMain bool, numFree int) error {
+	numArgs := fn.NumArgs
+	if fn.VarArgs {
+		numArgs++
+	}
+	if fn.NumLocals < numArgs {
+		return fmt.Errorf("%d locals cannot hold %d arguments", fn.NumLocals, numArgs)
+	}
+
+	instructions, err := fn.Instructions.Decode()
+	if err != nil {
+		return err
+	}
+
+	// index maps the offset of each instruction to its position and offsets
+	// does the opposite. The end of the instructions is a valid target too.
+	index := map[int]int{len(fn.Instructions): len(instructions)}
+	offsets := make([]int, 0, len(instructions)+1)
+	for i, ins := range instructions {
+		index[ins.Offset] = i
+		offsets = append(offsets, ins.Offset)
+	}
+	offsets = append(offsets, len(fn.Instructions))
+
+	for _, ins := range instructions {
+		if err := verifyOperands(bytecode, fn, numFree, ins); err != nil {
+			return err
+		}
+		if code.IsJump(ins.Opcode) {
+			if _, ok := index[ins.Operands[0]]; !ok {
+				return fmt.Errorf("Jump target %d at offset %d is not the start of an instruction", ins.Operands[0], ins.Offset)
+			}
+		}
+	}
+
+	return verifyStack(instructions, index, offsets, isMain)
+}
+
+func verifyOperands(bytecode *compiler.Bytecode, fn *object.CompiledFunction, numFree int, ins code.Instruction) error {
+	switch ins.Opcode {
+	case code.OpConstant, code.OpConstantWide:
+		if ins.Operands[0] >= len(bytecode.Constants) {
+			return fmt.Errorf("Constant %d at offset %d does not exist", ins.Operands[0], ins.Offset)
+		}
+	case code.OpGetLocal, code.OpSetLocal, code.OpGetLocalWide, code.OpSetLocalWide:
+		if ins.Operands[0] >= fn.NumLocals {
+			return fmt.Errorf("Local %d at offset %d does not exist, the function has %d", ins.Operands[0], ins.Offset, fn.NumLocals)
+		}
+	case code.OpGetLocalAddConstant:
+		if ins.Operands[0] >= fn.NumLocals {
+			return fmt.Errorf("Local %d at offset %d does not exist, the function has %d", ins.Operands[0], ins.Offset, fn.NumLocals)
+		}
+		if ins.Operands[1] >= len(bytecode.Constants) {
+			return fmt.Errorf("Constant %d at offset %d does not exist", ins.Operands[1], ins.Offset)
+		}
+	case code.OpGetFree, code.OpGetFreeWide:
+		if ins.Operands[0] >= numFree {
+			return fmt.Errorf("Free variable %d at offset %d does not exist, the function has %d", ins.Operands[0], ins.Offset, numFree)
+		}
+	case code.OpGetBuiltin:
+		if ins.Operands[0] >= len(object.Builtins) {
+			return fmt.Errorf("Builtin %d at offset %d does not exist", ins.Operands[0], ins.Offset)
+		}
+	}
+	return nil
+}
+
+// verifyStack follows every path of the function, tracking the number of
+// values in the stack.
+func verifyStack(instructions []code.Instruction, index map[int]int, offsets []int, isMain bool) error {
+	// depths holds the size of the stack before each instruction reached so
+	// far, or -1 for the ones not reached yet
+	depths := make([]int, len(instructions)+1)
+	for i := range depths {
+		depths[i] = -1
+	}
+	depths[0] = 0
+	pending := []int{0}
+
+	for len(pending) != 0 {
+		i := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if i == len(instructions) {
+			if !isMain {
+				return fmt.Errorf("Execution runs past the end of the function")
+			}
+			if depths[i] != 0 {
+				return fmt.Errorf("The program ends with %d values in the stack", depths[i])
+			}
+			continue
+		}
+
+		ins := instructions[i]
+		depth := depths[i]
+		pop := func(n int) error {
+			if n > depth {
+				return fmt.Errorf("%s at offset %d pops %d values, but the stack has %d", definitionName(ins.Opcode), ins.Offset, n, depth)
+			}
+			depth -= n
+			return nil
+		}
+
+		var err error
+		next := []int{i + 1}
+		switch ins.Opcode {
+		case code.OpReturn, code.OpReturnValue, code.OpTailCall, code.OpTailCallWide:
+			if isMain {
+				return fmt.Errorf("%s at offset %d returns from the main program", definitionName(ins.Opcode), ins.Offset)
+			}
+		}
+
+		switch ins.Opcode {
+		case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetGlobal, code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree,
+			code.OpConstantWide, code.OpGetLocalWide, code.OpGetFreeWide, code.OpGetLocalAddConstant:
+			depth++
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpUnion, code.OpIntersect,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpIndex, code.OpRange:
+			err = pop(2)
+			depth++
+		case code.OpMinus, code.OpBang:
+			err = pop(1)
+			depth++
+		case code.OpPop, code.OpSetGlobal, code.OpSetLocal, code.OpSetLocalWide:
+			err = pop(1)
+		case code.OpJump:
+			next = []int{index[ins.Operands[0]]}
+		case code.OpJumpNotTruthy:
+			err = pop(1)
+			next = append(next, index[ins.Operands[0]])
+		case code.OpJumpNotGreaterThan, code.OpJumpNotEqual, code.OpJumpEqual:
+			err = pop(2)
+			next = append(next, index[ins.Operands[0]])
+		case code.OpArray, code.OpSet, code.OpTuple:
+			err = pop(ins.Operands[0])
+			depth++
+		case code.OpHash:
+			err = pop(2 * ins.Operands[0])
+			depth++
+		case code.OpClosure, code.OpClosureWide:
+			err = pop(ins.Operands[1])
+			depth++
+		case code.OpCall, code.OpTailCall, code.OpCallWide, code.OpTailCallWide:
+			// The function is on top of its arguments
+			err = pop(ins.Operands[0] + 1)
+			depth++
+			if ins.Opcode == code.OpTailCall || ins.Opcode == code.OpTailCallWide {
+				// The callee returns to the caller of the function
+				next = nil
+			}
+		case code.OpCallGlobal:
+			err = pop(ins.Operands[1])
+			depth++
+		case code.OpReturnValue:
+			err = pop(1)
+			next = nil
+		case code.OpReturn:
+			next = nil
+		default:
+			err = fmt.Errorf("%s at offset %d cannot be verified", definitionName(ins.Opcode), ins.Offset)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, n := range next {
+			switch depths[n] {
+			case -1:
+				depths[n] = depth
+				pending = append(pending, n)
+			case depth:
+			default:
+				return fmt.Errorf("Paths reach offset %d with %d and %d values in the stack", offsets[n], depths[n], depth)
+			}
+		}
+	}
+	return nil
+}
+
+func definitionName(op code.Opcode) string {
+	def, err := code.Lookup(byte(op))
+	if err != nil {
+		return fmt.Sprintf("opcode %d", op)
+	}
+	return def.Name
+}
@@ -0,0 +1,220 @@
+package vm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/javier-varez/monkey_interpreter/code"
+	"github.com/javier-varez/monkey_interpreter/compiler"
+	"github.com/javier-varez/monkey_interpreter/object"
+)
+
+func instructions(ins ...[]byte) code.Instructions {
+	result := code.Instructions{}
+	for _, i := range ins {
+		result = append(result, i...)
+	}
+	return result
+}
+
+func TestVerify(t *testing.T) {
+	// fn(a) { a } with no free variables
+	identity := &object.CompiledFunction{
+		Instructions: instructions(code.Make(code.OpGetLocal, 0), code.Make(code.OpReturnValue)),
+		NumLocals:    1,
+		NumArgs:      1,
+	}
+	one := &object.Integer{Value: 1}
+
+	tests := []struct {
+		name          string
+		main          code.Instructions
+		constants     []object.Object
+		expectedError string
+	}{
+		{
+			"call",
+			instructions(code.Make(code.OpConstant, 1), code.Make(code.OpClosure, 0, 0), code.Make(code.OpCall, 1), code.Make(code.OpPop)),
+			[]object.Object{identity, one},
+			"",
+		},
+		{
+			"truncated operand",
+			instructions(code.Make(code.OpTrue), code.Make(code.OpConstant, 0)[:2]),
+			[]object.Object{one},
+			"Invalid bytecode in the main program: Truncated operands of OpConstant at offset 1",
+		},
+		{
+			"unknown constant",
+			instructions(code.Make(code.OpConstant, 1), code.Make(code.OpPop)),
+			[]object.Object{one},
+			"Invalid bytecode in the main program: Constant 1 at offset 0 does not exist",
+		},
+		{
+			"closure of a non-function",
+			instructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+			[]object.Object{one},
+			"Invalid bytecode in the main program: Closure at offset 0 refers to constant 0, which is not a function",
+		},
+		{
+			"jump into an operand",
+			instructions(code.Make(code.OpTrue), code.Make(code.OpJumpNotTruthy, 2), code.Make(code.OpNull), code.Make(code.OpPop)),
+			nil,
+			"Invalid bytecode in the main program: Jump target 2 at offset 1 is not the start of an instruction",
+		},
+		{
+			"stack underflow",
+			instructions(code.Make(code.OpTrue), code.Make(code.OpAdd), code.Make(code.OpPop)),
+			nil,
+			"Invalid bytecode in the main program: OpAdd at offset 1 pops 2 values, but the stack has 1",
+		},
+		{
+			"unbalanced branches",
+			instructions(code.Make(code.OpTrue), code.Make(code.OpJumpNotTruthy, 5), code.Make(code.OpNull), code.Make(code.OpNull), code.Make(code.OpPop)),
+			nil,
+			"Invalid bytecode in the main program: Paths reach offset 5 with 0 and 1 values in the stack",
+		},
+		{
+			"values left in the stack",
+			instructions(code.Make(code.OpTrue)),
+			nil,
+			"Invalid bytecode in the main program: The program ends with 1 values in the stack",
+		},
+		{
+			"missing call arguments",
+			instructions(code.Make(code.OpTrue), code.Make(code.OpClosure, 0, 0), code.Make(code.OpCall, 2), code.Make(code.OpPop)),
+			[]object.Object{identity},
+			"Invalid bytecode in the main program: OpCall at offset 5 pops 3 values, but the stack has 2",
+		},
+		{
+			"function running past its end",
+			instructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+			[]object.Object{&object.CompiledFunction{Instructions: code.Make(code.OpNull)}},
+			"Invalid bytecode in constant 0: Execution runs past the end of the function",
+		},
+		{
+			"missing local",
+			instructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+			[]object.Object{&object.CompiledFunction{Instructions: instructions(code.Make(code.OpGetLocal, 1), code.Make(code.OpReturnValue)), NumLocals: 1}},
+			"Invalid bytecode in constant 0: Local 1 at offset 0 does not exist, the function has 1",
+		},
+		{
+			"compare and jump with one value",
+			instructions(code.Make(code.OpTrue), code.Make(code.OpJumpNotEqual, 4)),
+			nil,
+			"Invalid bytecode in the main program: OpJumpNotEqual at offset 1 pops 2 values, but the stack has 1",
+		},
+		{
+			"missing constant of a superinstruction",
+			instructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+			[]object.Object{&object.CompiledFunction{Instructions: instructions(code.Make(code.OpGetLocalAddConstant, 0, 1), code.Make(code.OpReturnValue)), NumLocals: 1}},
+			"Invalid bytecode in constant 0: Constant 1 at offset 0 does not exist",
+		},
+		{
+			"return from the main program",
+			instructions(code.Make(code.OpTrue), code.Make(code.OpReturnValue)),
+			nil,
+			"Invalid bytecode in the main program: OpReturnValue at offset 1 returns from the main program",
+		},
+		{
+			"tail call from the main program",
+			instructions(code.Make(code.OpConstant, 1), code.Make(code.OpClosure, 0, 0), code.Make(code.OpTailCall, 1)),
+			[]object.Object{identity, one},
+			"Invalid bytecode in the main program: OpTailCall at offset 7 returns from the main program",
+		},
+		{
+			"missing free variable",
+			instructions(code.Make(code.OpTrue), code.Make(code.OpClosure, 0, 1), code.Make(code.OpPop)),
+			[]object.Object{&object.CompiledFunction{Instructions: instructions(code.Make(code.OpGetFree, 1), code.Make(code.OpReturnValue))}},
+			"Invalid bytecode in constant 0: Free variable 1 at offset 0 does not exist, the function has 1",
+		},
+	}
+
+	for _, tt := range tests {
+		err := Verify(&compiler.Bytecode{Instructions: tt.main, Constants: tt.constants})
+		if tt.expectedError == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expectedError {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.expectedError, err)
+		}
+	}
+}
+
+func TestVerifyCompiledPrograms(t *testing.T) {
+	inputs := []string{
+		`let f = fn(a, ...) { if (a > 1) { return [a, len(...)]; } { "x": (a, 1) } }; f(1, 2, 3); f(3)`,
+		`let adder = fn(a) { fn(b) { a + b } }; puts(adder(1)(2), 0..3, {1, 2} | {3})`,
+		`let g = fn(x) { let y = -x; if (!(x == y)) { y } else { [x][0] } }; g(2)`,
+	}
+
+	for _, input := range inputs {
+		for _, optimize := range []bool{false, true} {
+			comp := compiler.New()
+			if optimize {
+				comp.EnableOptimizations()
+			}
+			if err := comp.Compile(parse(input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			if err := Verify(comp.Bytecode()); err != nil {
+				t.Errorf("Unexpected verification error for %q (optimized: %t): %v", input, optimize, err)
+			}
+		}
+	}
+}
+
+// Verified files may still read variables that are never set, which must
+// fail the run instead of crashing it
+func TestRunUnsetVariables(t *testing.T) {
+	readLocal := &object.CompiledFunction{
+		Instructions: instructions(code.Make(code.OpGetLocal, 0), code.Make(code.OpReturnValue)),
+		NumLocals:    1,
+	}
+
+	tests := []struct {
+		name          string
+		bytecode      *compiler.Bytecode
+		expectedError string
+	}{
+		{
+			"global",
+			&compiler.Bytecode{Instructions: instructions(code.Make(code.OpGetGlobal, 0), code.Make(code.OpPop)), Globals: []string{"a"}},
+			"Global 0 is read before it is set",
+		},
+		{
+			"called global",
+			&compiler.Bytecode{Instructions: instructions(code.Make(code.OpCallGlobal, 1, 0), code.Make(code.OpPop)), Globals: []string{"a", "f"}},
+			"Global 1 is read before it is set",
+		},
+		{
+			"local",
+			&compiler.Bytecode{
+				Instructions: instructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpCall, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{readLocal},
+			},
+			"Local 0 is read before it is set",
+		},
+	}
+
+	for _, tt := range tests {
+		var buffer bytes.Buffer
+		if err := tt.bytecode.Encode(&buffer, true); err != nil {
+			t.Fatalf("%s: encoding error: %v", tt.name, err)
+		}
+		bytecode, err := compiler.Decode(&buffer)
+		if err != nil {
+			t.Fatalf("%s: decoding error: %v", tt.name, err)
+		}
+		if err := Verify(bytecode); err != nil {
+			t.Fatalf("%s: unexpected verification error: %v", tt.name, err)
+		}
+
+		if err := New(bytecode).Run(); err == nil || err.Error() != tt.expectedError {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.expectedError, err)
+		}
+	}
+}
@@ -1,20 +1,44 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
 
+	"github.com/javier-varez/monkey_interpreter/ast"
 	"github.com/javier-varez/monkey_interpreter/code"
 	"github.com/javier-varez/monkey_interpreter/compiler"
 	"github.com/javier-varez/monkey_interpreter/object"
 	"github.com/javier-varez/monkey_interpreter/token"
 )
 
-const STACK_SIZE = 2048
 const GLOBALS_SIZE = 65536
-const MAX_FRAMES = 1024
+
+// The stack and the frames start with room for INITIAL_STACK_SIZE values and
+// INITIAL_FRAMES calls, and grow on demand up to the limits of the VM.
+const INITIAL_STACK_SIZE = 256
+const INITIAL_FRAMES = 64
+
+// MAX_STACK_SIZE and MAX_FRAMES are the default limits of the VM.
+const MAX_STACK_SIZE = 1 << 22
+const MAX_FRAMES = 1 << 16
+
+// Options configure the limits of a VM.
+type Options struct {
+	// MaxStackSize is the maximum number of values in the stack
+	MaxStackSize int
+	// MaxFrames is the maximum depth of nested calls, including the main
+	// program
+	MaxFrames int
+}
+
+// DefaultOptions returns the options used by New.
+func DefaultOptions() Options {
+	return Options{MaxStackSize: MAX_STACK_SIZE, MaxFrames: MAX_FRAMES}
+}
 
 type VM struct {
 	constants []object.Object
+	options   Options
 
 	stack   []object.Object
 	sp      int // Always points to the next value. Top of stack is stack[sp-1]
@@ -24,14 +48,38 @@ type VM struct {
 	frameIndex int
 }
 
-var Null = &object.Null{}
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
+// runtimeError is an error of the running program, located at the source of
+// the instruction that failed. It implements ast.Error.
+type runtimeError struct {
+	span     token.Span
+	errorMsg string
+	stack    []object.StackFrame
+}
 
-func assertNotNil(obj any) {
-	if obj == nil {
-		panic("Unexpected nil object")
-	}
+func (e *runtimeError) Error() string {
+	return e.errorMsg
+}
+
+func (e *runtimeError) ContextualError() string {
+	return ast.FormatContextualError(e.span, e.errorMsg)
+}
+
+func (e *runtimeError) Span() token.Span {
+	return e.span
+}
+
+func (e *runtimeError) StackTrace() []object.StackFrame {
+	return e.stack
+}
+
+var Null = object.NULL
+var True = object.TRUE
+var False = object.FALSE
+
+// unsetError is returned when a variable is read before it is set, which the
+// compiler never allows but corrupted bytecode files may do.
+func unsetError(kind string, idx int) error {
+	return fmt.Errorf("%s %d is read before it is set", kind, idx)
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
@@ -39,13 +87,24 @@ func New(bytecode *compiler.Bytecode) *VM {
 }
 
 func NewWithGlobalKeyStore(bytecode *compiler.Bytecode, keyStore []object.Object) *VM {
-	frames := make([]*Frame, MAX_FRAMES)
-	frames[0] = NewFrame(&object.Closure{Fn: &object.CompiledFunction{Instructions: bytecode.Instructions}}, 0)
+	return NewWithOptions(bytecode, keyStore, DefaultOptions())
+}
+
+// NewWithOptions returns a VM that runs bytecode with the given globals and
+// limits.
+func NewWithOptions(bytecode *compiler.Bytecode, keyStore []object.Object, options Options) *VM {
+	stackSize := INITIAL_STACK_SIZE
+	if stackSize > options.MaxStackSize {
+		stackSize = options.MaxStackSize
+	}
+	frames := make([]*Frame, 1, INITIAL_FRAMES)
+	frames[0] = NewFrame(&object.Closure{Fn: &object.CompiledFunction{Instructions: bytecode.Instructions, Debug: bytecode.Debug}}, 0)
 
 	return &VM{
 		constants: bytecode.Constants,
+		options:   options,
 
-		stack:   make([]object.Object, STACK_SIZE),
+		stack:   make([]object.Object, stackSize),
 		globals: keyStore,
 
 		frames:     frames,
@@ -54,63 +113,116 @@ func NewWithGlobalKeyStore(bytecode *compiler.Bytecode, keyStore []object.Object
 }
 
 func (vm *VM) Run() error {
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
-		vm.currentFrame().ip++
+	return vm.run(false)
+}
+
+// Done reports whether the program has run to completion.
+func (vm *VM) Done() bool {
+	return vm.currentFrame().ip >= len(vm.currentFrame().Instructions())-1
+}
+
+// Step executes the next instruction of the program. Errors are located at
+// the source of the instruction if the program was compiled with debug info.
+func (vm *VM) Step() error {
+	return vm.run(true)
+}
+
+// locate returns err located at the source of the instruction of a closure
+// at offset, along with the stack trace.
+func (vm *VM) locate(err error, closure *object.Closure, offset int) error {
+	span, ok := spanAt(closure, offset)
+	if !ok || span.Text == nil {
+		return err
+	}
+	return &runtimeError{span: span, errorMsg: err.Error(), stack: vm.stackTrace()}
+}
+
+// stackTrace returns the calls of the frames in the call stack, innermost
+// first. Each frame was called by the instruction at the call site of its
+// caller.
+func (vm *VM) stackTrace() []object.StackFrame {
+	stack := []object.StackFrame{}
+	for i := vm.frameIndex - 1; i > 0; i-- {
+		caller := vm.frames[i-1]
+		span, _ := spanAt(caller.closure, caller.callSite)
+		stack = append(stack, object.StackFrame{Function: vm.frames[i].closure.Fn.Name, CallSite: span})
+	}
+	return stack
+}
+
+// spanAt returns the span of the instruction of a closure at offset.
+func spanAt(closure *object.Closure, offset int) (token.Span, bool) {
+	if closure.Fn.Debug == nil {
+		return token.Span{}, false
+	}
+	return closure.Fn.Debug.SpanAt(offset)
+}
+
+// run executes instructions until the program ends, or just one of them if
+// single is set. The current frame, its instructions and its ip are kept in
+// locals, so the frame is only updated when something else may read it: on
+// calls, returns, errors and when run stops.
+func (vm *VM) run(single bool) error {
+	frame := vm.currentFrame()
+	ins := frame.Instructions()
+	ip := frame.ip
+
+	// reload picks up the frame on top after calls and returns
+	reload := func() {
+		frame = vm.currentFrame()
+		ins = frame.Instructions()
+		ip = frame.ip
+	}
 
-		ip := vm.currentFrame().ip
-		inst := vm.currentFrame().Instructions()
-		op := code.Opcode(inst[ip])
+	for ip < len(ins)-1 {
+		ip++
+		start := ip
+		op := code.Opcode(ins[ip])
 
+		// No instruction leaves more than one value in the stack, apart from
+		// calls, which make room for what they need themselves
+		if vm.sp >= len(vm.stack) {
+			if err := vm.growStack(vm.sp + 1); err != nil {
+				frame.ip = ip
+				return vm.locate(err, frame.closure, start)
+			}
+		}
+
+		var err error
 		switch op {
 		case code.OpConstant:
-			constIndex := code.ReadUint16(inst[ip+1:])
-			vm.currentFrame().ip += 2
-			err := vm.push(vm.constants[constIndex])
-			if err != nil {
-				return err
-			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
-			err := vm.runBinaryOp(op)
-			if err != nil {
-				return err
-			}
+			vm.push(vm.constants[code.ReadUint16(ins[ip+1:])])
+			ip += 2
+
+		case code.OpConstantWide:
+			vm.push(vm.constants[code.ReadUint32(ins[ip+1:])])
+			ip += 4
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpUnion, code.OpIntersect:
+			err = vm.runBinaryOp(op)
+
 		case code.OpPop:
-			_, err := vm.pop()
-			if err != nil {
-				return err
-			}
+			vm.pop()
+
 		case code.OpTrue:
-			err := vm.push(True)
-			if err != nil {
-				return err
-			}
+			vm.push(True)
+
 		case code.OpFalse:
-			err := vm.push(False)
-			if err != nil {
-				return err
-			}
+			vm.push(False)
+
 		case code.OpGreaterThan, code.OpEqual, code.OpNotEqual:
-			err := vm.runComparisonOp(op)
-			if err != nil {
-				return err
-			}
-		case code.OpMinus:
-			v, err := vm.pop()
-			if err != nil {
-				return err
-			}
+			err = vm.runComparisonOp(op)
 
-			if v.Type() != object.INTEGER_OBJ {
-				return fmt.Errorf("Cannot apply minus operator on type %T", v)
+		case code.OpMinus:
+			v := vm.pop()
+			if !object.IsInteger(v) {
+				err = fmt.Errorf("Cannot apply minus operator on type %T", v)
+				break
 			}
+			vm.push(object.NegateInteger(v))
 
-			asInt := v.(*object.Integer)
-			vm.push(&object.Integer{Value: -asInt.Value})
 		case code.OpBang:
-			v, err := vm.pop()
-			if err != nil {
-				return err
-			}
+			v := vm.pop()
 
 			var asBool bool
 			if v.Type() == object.BOOLEAN_OBJ {
@@ -123,323 +235,413 @@ func (vm *VM) Run() error {
 				asBool = true
 			}
 
-			vm.push(&object.Boolean{Value: !asBool})
+			vm.push(object.NativeBool(!asBool))
 
 		case code.OpJumpNotTruthy:
-			target := code.ReadUint16(inst[ip+1:])
-			vm.currentFrame().ip += 2
-
-			v, err := vm.pop()
-			if err != nil {
-				return err
-			}
+			target := int(code.ReadUint16(ins[ip+1:]))
+			ip += 2
 
-			if isTruthy := asBoolean(v); !isTruthy {
-				vm.currentFrame().ip = int(target) - 1
+			if isTruthy := asBoolean(vm.pop()); !isTruthy {
+				ip = target - 1
 			}
 
 		case code.OpJump:
-			target := code.ReadUint16(inst[ip+1:])
-			vm.currentFrame().ip = int(target) - 1
+			ip = int(code.ReadUint16(ins[ip+1:])) - 1
 
-		case code.OpNull:
-			err := vm.push(Null)
-			if err != nil {
-				return err
+		case code.OpJumpNotGreaterThan, code.OpJumpNotEqual, code.OpJumpEqual:
+			target := int(code.ReadUint16(ins[ip+1:]))
+			ip += 2
+
+			rhs := vm.pop()
+			lhs := vm.pop()
+			var result bool
+			result, err = compare(fusedComparisons[op], lhs, rhs)
+			if err == nil && !result {
+				ip = target - 1
 			}
 
-		case code.OpSetGlobal:
-			idx := code.ReadUint16(inst[ip+1:])
-			vm.currentFrame().ip += 2
+		case code.OpNull:
+			vm.push(Null)
 
-			obj, err := vm.pop()
-			if err != nil {
-				return err
-			}
-			vm.globals[idx] = obj
+		case code.OpSetGlobal:
+			vm.globals[code.ReadUint16(ins[ip+1:])] = vm.pop()
+			ip += 2
 
 		case code.OpGetGlobal:
-			idx := code.ReadUint16(inst[ip+1:])
-			vm.currentFrame().ip += 2
+			idx := int(code.ReadUint16(ins[ip+1:]))
+			ip += 2
 
 			obj := vm.globals[idx]
-			assertNotNil(obj)
-			err := vm.push(obj)
-			if err != nil {
-				return err
+			if obj == nil {
+				err = unsetError("Global", idx)
+				break
 			}
+			vm.push(obj)
 
 		case code.OpArray:
-			arrayLen := code.ReadUint16(inst[ip+1:])
-			vm.currentFrame().ip += 2
+			arrayLen := int(code.ReadUint16(ins[ip+1:]))
+			ip += 2
 
 			arr := &object.Array{Elems: make([]object.Object, arrayLen)}
-			for i := int(arrayLen) - 1; i >= 0; i-- {
-				val, err := vm.pop()
-				if err != nil {
-					return err
-				}
-				arr.Elems[i] = val
-			}
-
-			err := vm.push(arr)
-			if err != nil {
-				return err
+			for i := arrayLen - 1; i >= 0; i-- {
+				arr.Elems[i] = vm.pop()
 			}
+			vm.push(arr)
 
-		case code.OpHash:
-			mapLen := code.ReadUint16(inst[ip+1:])
-			vm.currentFrame().ip += 2
-
-			hashmap := &object.HashMap{Elems: make(map[object.HashKey]object.HashEntry, mapLen)}
-			for i := 0; i < int(mapLen); i++ {
-				val, err := vm.pop()
-				if err != nil {
-					return err
-				}
+		case code.OpSet:
+			setLen := int(code.ReadUint16(ins[ip+1:]))
+			ip += 2
 
-				key, err := vm.pop()
-				if err != nil {
-					return err
-				}
+			err = vm.buildSet(setLen)
 
-				hashable, ok := key.(object.Hashable)
-				if !ok {
-					return fmt.Errorf("Key object is not hashable")
-				}
+		case code.OpTuple:
+			tupleLen := int(code.ReadUint16(ins[ip+1:]))
+			ip += 2
 
-				hashmap.Elems[hashable.HashKey()] = object.HashEntry{Key: key, Value: val}
+			tuple := &object.Tuple{Elems: make([]object.Object, tupleLen)}
+			for i := tupleLen - 1; i >= 0; i-- {
+				tuple.Elems[i] = vm.pop()
 			}
+			vm.push(tuple)
 
-			err := vm.push(hashmap)
-			if err != nil {
-				return err
-			}
+		case code.OpHash:
+			mapLen := int(code.ReadUint16(ins[ip+1:]))
+			ip += 2
+			err = vm.buildHash(mapLen)
 
 		case code.OpIndex:
-			indexObj, err := vm.pop()
-			if err != nil {
-				return err
-			}
+			indexObj := vm.pop()
+			indexedObj := vm.pop()
+			err = vm.index(indexedObj, indexObj)
+
+		case code.OpCall, code.OpTailCall:
+			numArgsInCall := int(code.ReadUint8(ins[ip+1:]))
+			ip += 1
+
+			frame.ip = ip
+			frame.callSite = start
+			err = vm.call(vm.pop(), numArgsInCall, op == code.OpTailCall)
+			reload()
+
+		case code.OpCallWide, code.OpTailCallWide:
+			numArgsInCall := int(code.ReadUint16(ins[ip+1:]))
+			ip += 2
+
+			frame.ip = ip
+			frame.callSite = start
+			err = vm.call(vm.pop(), numArgsInCall, op == code.OpTailCallWide)
+			reload()
+
+		case code.OpCallGlobal:
+			idx := int(code.ReadUint16(ins[ip+1:]))
+			numArgsInCall := int(code.ReadUint8(ins[ip+3:]))
+			ip += 3
+
+			fn := vm.globals[idx]
+			if fn == nil {
+				err = unsetError("Global", idx)
+				break
+			}
+			frame.ip = ip
+			frame.callSite = start
+			err = vm.call(fn, numArgsInCall, false)
+			reload()
 
-			indexedObj, err := vm.pop()
-			if err != nil {
-				return err
-			}
+		case code.OpReturn:
+			vm.popFrame()
+			vm.push(Null)
+			reload()
 
-			switch inner := indexedObj.(type) {
-			case *object.Array:
-				if indexObj.Type() != object.INTEGER_OBJ {
-					return fmt.Errorf("Index to array must be an integral. Got=%T (%+v)", indexObj, indexObj)
-				}
+		case code.OpReturnValue:
+			val := vm.pop()
+			vm.popFrame()
+			vm.push(val)
+			reload()
 
-				var err error
-				i := indexObj.(*object.Integer).Value
-				if i < int64(len(inner.Elems)) {
-					err = vm.push(inner.Elems[i])
-				} else {
-					err = vm.push(Null)
-				}
-				if err != nil {
-					return err
-				}
+		case code.OpSetLocal:
+			vm.stack[frame.LocalsBase+int(code.ReadUint8(ins[ip+1:]))] = vm.pop()
+			ip += 1
 
-			case *object.HashMap:
-				hashable, ok := indexObj.(object.Hashable)
-				if !ok {
-					return fmt.Errorf("Index of type %T (%+v) is not hashable", indexObj, indexObj)
-				}
-				key := hashable.HashKey()
-
-				kv, ok := inner.Elems[key]
-				var err error
-				if ok {
-					err = vm.push(kv.Value)
-				} else {
-					err = vm.push(Null)
-				}
-				if err != nil {
-					return err
-				}
+		case code.OpSetLocalWide:
+			vm.stack[frame.LocalsBase+int(code.ReadUint16(ins[ip+1:]))] = vm.pop()
+			ip += 2
 
-			default:
-				return fmt.Errorf("Cannot index object of type: %T", indexedObj)
+		case code.OpGetLocal:
+			idx := int(code.ReadUint8(ins[ip+1:]))
+			ip += 1
+
+			obj := vm.stack[frame.LocalsBase+idx]
+			if obj == nil {
+				err = unsetError("Local", idx)
+				break
 			}
+			vm.push(obj)
+
+		case code.OpGetLocalWide:
+			idx := int(code.ReadUint16(ins[ip+1:]))
+			ip += 2
 
-		case code.OpCall:
-			fnObj, err := vm.pop()
-			if err != nil {
-				return err
+			obj := vm.stack[frame.LocalsBase+idx]
+			if obj == nil {
+				err = unsetError("Local", idx)
+				break
 			}
+			vm.push(obj)
 
-			numArgsObj, err := vm.pop()
-			if err != nil {
-				return err
+		case code.OpGetLocalAddConstant:
+			idx := int(code.ReadUint8(ins[ip+1:]))
+			rhs := vm.constants[code.ReadUint16(ins[ip+2:])]
+			ip += 3
+
+			lhs := vm.stack[frame.LocalsBase+idx]
+			if lhs == nil {
+				err = unsetError("Local", idx)
+				break
+			}
+			var result object.Object
+			if result, err = binaryOp(code.OpAdd, lhs, rhs); err == nil {
+				vm.push(result)
 			}
 
-			numArgs, ok := numArgsObj.(*object.Integer)
-			if !ok {
-				return fmt.Errorf("Could not get number of arguments to function in the stack")
+		case code.OpGetBuiltin:
+			idx := int(code.ReadUint8(ins[ip+1:]))
+			ip += 1
+
+			if idx >= len(object.Builtins) {
+				panic(fmt.Sprintf("Unknown builtin index %d", idx))
 			}
-			numArgsInCall := int(numArgs.Value)
+			vm.push(object.Builtins[idx].Builtin)
 
-			switch fn := fnObj.(type) {
-			case *object.Closure:
-				if err := vm.callCompiledFunction(fn, numArgsInCall); err != nil {
-					return err
-				}
+		case code.OpClosure:
+			constantIdx := int(code.ReadUint16(ins[ip+1:]))
+			numFreeVars := int(code.ReadUint8(ins[ip+3:]))
+			ip += 3
+			err = vm.pushClosure(constantIdx, numFreeVars)
 
-			case *object.Builtin:
-				if err := vm.executeBuiltin(fn, numArgsInCall); err != nil {
-					return err
-				}
+		case code.OpClosureWide:
+			constantIdx := int(code.ReadUint32(ins[ip+1:]))
+			numFreeVars := int(code.ReadUint16(ins[ip+5:]))
+			ip += 6
+			err = vm.pushClosure(constantIdx, numFreeVars)
 
-			default:
-				return fmt.Errorf("Not a callable, cannot be invoked")
-			}
+		case code.OpGetFree:
+			err = vm.getFree(frame, int(code.ReadUint8(ins[ip+1:])))
+			ip += 1
 
-		case code.OpReturn:
-			vm.popFrame()
-			err := vm.push(Null)
-			if err != nil {
-				return err
-			}
+		case code.OpGetFreeWide:
+			err = vm.getFree(frame, int(code.ReadUint16(ins[ip+1:])))
+			ip += 2
 
-		case code.OpReturnValue:
-			val, err := vm.pop()
-			if err != nil {
-				return err
-			}
+		case code.OpRange:
+			endObj := vm.pop()
+			startObj := vm.pop()
+			err = vm.makeRange(startObj, endObj)
 
-			vm.popFrame()
+		default:
+			err = fmt.Errorf("Unhandled operation: %v", op)
+		}
 
-			err = vm.push(val)
-			if err != nil {
-				return err
-			}
+		if err != nil {
+			frame.ip = ip
+			return vm.locate(err, frame.closure, start)
+		}
+		if single {
+			break
+		}
+	}
 
-		case code.OpSetLocal:
-			frame := vm.currentFrame()
-			idx := frame.LocalsBase + int(code.ReadUint8(inst[ip+1:]))
-			frame.ip += 1
+	frame.ip = ip
+	return nil
+}
 
-			obj, err := vm.pop()
-			if err != nil {
-				return err
-			}
+// fusedComparisons maps the superinstructions that compare and jump to the
+// comparison they make.
+var fusedComparisons = map[code.Opcode]code.Opcode{
+	code.OpJumpNotGreaterThan: code.OpGreaterThan,
+	code.OpJumpNotEqual:       code.OpEqual,
+	code.OpJumpEqual:          code.OpNotEqual,
+}
 
-			// TODO: This does not handle correctly accessing locals from a parent scope
-			vm.stack[idx] = obj
+// buildSet pushes a set of the setLen values on top of the stack.
+func (vm *VM) buildSet(setLen int) error {
+	set := object.NewSet()
+	for i := 0; i < setLen; i++ {
+		hashable, ok := vm.pop().(object.Hashable)
+		if !ok {
+			return fmt.Errorf("Set element is not hashable")
+		}
+		set.Add(hashable)
+	}
 
-		case code.OpGetLocal:
-			frame := vm.currentFrame()
-			idx := frame.LocalsBase + int(code.ReadUint8(inst[ip+1:]))
-			frame.ip += 1
-
-			// TODO: This does not handle correctly accessing locals from a parent scope
-			obj := vm.stack[idx]
-			assertNotNil(obj)
-			err := vm.push(obj)
-			if err != nil {
-				return err
-			}
+	vm.push(set)
+	return nil
+}
 
-		case code.OpGetBuiltin:
-			idx := int(code.ReadUint8(inst[ip+1:]))
-			vm.currentFrame().ip += 1
+// buildHash pushes a map made of the mapLen pairs of keys and values on top
+// of the stack.
+func (vm *VM) buildHash(mapLen int) error {
+	// Entries are popped in reverse, but must be inserted in source order
+	entries := make([]object.HashEntry, mapLen)
+	for i := mapLen - 1; i >= 0; i-- {
+		val := vm.pop()
+		key := vm.pop()
+		entries[i] = object.HashEntry{Key: key, Value: val}
+	}
 
-			if idx >= len(object.Builtins) {
-				panic(fmt.Sprintf("Unknown builtin index %d", idx))
-			}
+	hashmap := object.NewHashMap()
+	for _, entry := range entries {
+		hashable, ok := entry.Key.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("Key object is not hashable")
+		}
 
-			obj := object.Builtins[idx].Builtin
-			err := vm.push(obj)
-			if err != nil {
-				return err
-			}
+		hashmap.Set(hashable, entry.Value)
+	}
 
-		case code.OpClosure:
-			constantIdx := int(code.ReadUint16(inst[ip+1:]))
-			numFreeVars := int(code.ReadUint8(inst[ip+3:]))
-			vm.currentFrame().ip += 3
-
-			freeObjects := make([]object.Object, numFreeVars)
-			for i := 0; i < numFreeVars; i++ {
-				val, err := vm.pop()
-				if err != nil {
-					return err
-				}
-				freeObjects[numFreeVars-1-i] = val
-			}
+	vm.push(hashmap)
+	return nil
+}
 
-			fn, ok := vm.constants[constantIdx].(*object.CompiledFunction)
-			if !ok {
-				return fmt.Errorf("Argument to the OpClosure is not a compiled function")
-			}
+func (vm *VM) index(indexedObj, indexObj object.Object) error {
+	switch inner := indexedObj.(type) {
+	case *object.Array:
+		if indexObj.Type() != object.INTEGER_OBJ {
+			return fmt.Errorf("Index to array must be an integral. Got=%T (%+v)", indexObj, indexObj)
+		}
 
-			err := vm.push(&object.Closure{Fn: fn, FreeObjects: freeObjects})
-			if err != nil {
-				return err
-			}
+		i := indexObj.(*object.Integer).Value
+		if i < int64(len(inner.Elems)) {
+			vm.push(inner.Elems[i])
+		} else {
+			vm.push(Null)
+		}
 
-		case code.OpGetFree:
-			freeIdx := int(code.ReadUint8(inst[ip+1:]))
-			vm.currentFrame().ip += 1
-			freeObjects := vm.currentFrame().closure.FreeObjects
+	case *object.String:
+		if indexObj.Type() != object.INTEGER_OBJ {
+			return fmt.Errorf("Index to string must be an integral. Got=%T (%+v)", indexObj, indexObj)
+		}
 
-			if freeIdx >= len(freeObjects) {
-				return fmt.Errorf("Invalid free index: %d. Num free objects: %d", freeIdx, len(freeObjects))
-			}
+		ch, ok := inner.CharAt(indexObj.(*object.Integer).Value)
+		if ok {
+			vm.push(ch)
+		} else {
+			vm.push(Null)
+		}
 
-			if err := vm.push(freeObjects[freeIdx]); err != nil {
-				return err
-			}
+	case *object.Tuple:
+		if indexObj.Type() != object.INTEGER_OBJ {
+			return fmt.Errorf("Index to tuple must be an integral. Got=%T (%+v)", indexObj, indexObj)
+		}
 
-		case code.OpRange:
-			endObj, err := vm.pop()
-			if err != nil {
-				return err
-			}
+		i := indexObj.(*object.Integer).Value
+		if i >= 0 && i < int64(len(inner.Elems)) {
+			vm.push(inner.Elems[i])
+		} else {
+			vm.push(Null)
+		}
 
-			if endObj.Type() != object.INTEGER_OBJ {
-				return fmt.Errorf("Range start does not evaluate to an integer object: %T (%V)", endObj, endObj)
-			}
+	case *object.HashMap:
+		hashable, ok := indexObj.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("Index of type %T (%+v) is not hashable", indexObj, indexObj)
+		}
 
-			startObj, err := vm.pop()
-			if err != nil {
-				return err
-			}
+		value, ok := inner.Get(hashable)
+		if ok {
+			vm.push(value)
+		} else {
+			vm.push(Null)
+		}
 
-			if startObj.Type() != object.INTEGER_OBJ {
-				return fmt.Errorf("Range start does not evaluate to an integer object: %T (%V)", startObj, startObj)
-			}
+	default:
+		return fmt.Errorf("Cannot index object of type: %T", indexedObj)
+	}
+	return nil
+}
 
-			start := startObj.(*object.Integer).Value
-			end := endObj.(*object.Integer).Value
+func (vm *VM) makeRange(startObj, endObj object.Object) error {
+	if endObj.Type() != object.INTEGER_OBJ {
+		return fmt.Errorf("Range start does not evaluate to an integer object: %T (%V)", endObj, endObj)
+	}
 
-			incr := int64(1)
-			if start > end {
-				// Decreasing range
-				incr = -1
-			}
+	if startObj.Type() != object.INTEGER_OBJ {
+		return fmt.Errorf("Range start does not evaluate to an integer object: %T (%V)", startObj, startObj)
+	}
 
-			arrayObj := &object.Array{Elems: []object.Object{}}
+	start := startObj.(*object.Integer).Value
+	end := endObj.(*object.Integer).Value
 
-			curValue := start
-			for curValue != end {
-				arrayObj.Elems = append(arrayObj.Elems, &object.Integer{Value: curValue})
-				curValue = curValue + incr
-			}
+	incr := int64(1)
+	if start > end {
+		// Decreasing range
+		incr = -1
+	}
 
-			err = vm.push(arrayObj)
-			if err != nil {
-				return err
-			}
+	arrayObj := &object.Array{Elems: []object.Object{}}
 
-		default:
-			return fmt.Errorf("Unhandled operation: %v", op)
+	curValue := start
+	for curValue != end {
+		arrayObj.Elems = append(arrayObj.Elems, object.NewInt64(curValue))
+		curValue = curValue + incr
+	}
+
+	vm.push(arrayObj)
+	return nil
+}
+
+// call calls fn with the numArgsInCall values on top of the stack. Tail calls
+// replace the current frame.
+func (vm *VM) call(fnObj object.Object, numArgsInCall int, tail bool) error {
+	switch fn := fnObj.(type) {
+	case *object.Closure:
+		if tail {
+			return vm.tailCallCompiledFunction(fn, numArgsInCall)
+		}
+		return vm.callCompiledFunction(fn, numArgsInCall)
+
+	case *object.Builtin:
+		caller := vm.currentFrame()
+		span, _ := spanAt(caller.closure, caller.callSite)
+		if err := vm.executeBuiltin(fn, numArgsInCall, span); err != nil {
+			return err
 		}
+
+		if tail {
+			// There is no frame to reuse, just return the result right away
+			val := vm.pop()
+			vm.popFrame()
+			vm.push(val)
+		}
+		return nil
+	}
+	return fmt.Errorf("Not a callable, cannot be invoked")
+}
+
+// pushClosure closes the function at constantIdx over the numFreeVars values
+// on top of the stack.
+func (vm *VM) pushClosure(constantIdx int, numFreeVars int) error {
+	freeObjects := make([]object.Object, numFreeVars)
+	for i := 0; i < numFreeVars; i++ {
+		freeObjects[numFreeVars-1-i] = vm.pop()
+	}
+
+	fn, ok := vm.constants[constantIdx].(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("Argument to the OpClosure is not a compiled function")
 	}
+
+	vm.push(&object.Closure{Fn: fn, FreeObjects: freeObjects})
+	return nil
+}
+
+func (vm *VM) getFree(frame *Frame, freeIdx int) error {
+	freeObjects := frame.closure.FreeObjects
+
+	if freeIdx >= len(freeObjects) {
+		return fmt.Errorf("Invalid free index: %d. Num free objects: %d", freeIdx, len(freeObjects))
+	}
+
+	vm.push(freeObjects[freeIdx])
 	return nil
 }
 
@@ -456,125 +658,159 @@ func asBoolean(o object.Object) bool {
 }
 
 func (vm *VM) runBinaryOp(op code.Opcode) error {
-	rhs, err := vm.pop()
-	if err != nil {
-		return err
-	}
+	rhs := vm.pop()
+	lhs := vm.pop()
 
-	lhs, err := vm.pop()
+	result, err := binaryOp(op, lhs, rhs)
 	if err != nil {
 		return err
 	}
+	vm.push(result)
+	return nil
+}
 
-	if rhs.Type() == object.STRING_OBJ && lhs.Type() == object.STRING_OBJ {
-		return vm.runStringBinaryOp(op, lhs.(*object.String), rhs.(*object.String))
+func binaryOp(op code.Opcode, lhs, rhs object.Object) (object.Object, error) {
+	if object.IsInteger(rhs) && object.IsInteger(lhs) {
+		return intBinaryOp(op, lhs, rhs)
+	}
+	if rhs.Type() == object.SET_OBJ && lhs.Type() == object.SET_OBJ {
+		return setBinaryOp(op, lhs.(*object.Set), rhs.(*object.Set))
 	}
-	if rhs.Type() == object.INTEGER_OBJ && lhs.Type() == object.INTEGER_OBJ {
-		return vm.runIntBinaryOp(op, lhs.(*object.Integer), rhs.(*object.Integer))
+	if rhs.Type() == object.STRING_OBJ || lhs.Type() == object.STRING_OBJ {
+		return stringBinaryOp(op, lhs, rhs)
 	}
-	return fmt.Errorf("Invalid binary operation %d for types %T and %T", op, lhs, rhs)
+	return nil, fmt.Errorf("Invalid binary operation %d for types %T and %T", op, lhs, rhs)
 }
 
-func (vm *VM) runIntBinaryOp(op code.Opcode, lhs, rhs *object.Integer) error {
-	var result int64
+func intBinaryOp(op code.Opcode, lhs, rhs object.Object) (object.Object, error) {
 	switch op {
 	case code.OpAdd:
-		result = lhs.Value + rhs.Value
+		return object.AddIntegers(lhs, rhs), nil
 	case code.OpSub:
-		result = lhs.Value - rhs.Value
+		return object.SubIntegers(lhs, rhs), nil
 	case code.OpMul:
-		result = lhs.Value * rhs.Value
+		return object.MulIntegers(lhs, rhs), nil
 	case code.OpDiv:
-		result = lhs.Value / rhs.Value
-	default:
-		return fmt.Errorf("Invalid binary operation: %v", op)
+		return object.DivIntegers(lhs, rhs)
 	}
-	return vm.push(&object.Integer{Value: result})
+	return nil, fmt.Errorf("Invalid binary operation: %v", op)
 }
 
-func (vm *VM) runStringBinaryOp(op code.Opcode, lhs, rhs *object.String) error {
-	var result string
+func stringBinaryOp(op code.Opcode, lhs, rhs object.Object) (object.Object, error) {
+	if op != code.OpAdd {
+		return nil, fmt.Errorf("Invalid string binary operation: %v", op)
+	}
+
+	result, ok := object.Concat(lhs, rhs)
+	if !ok {
+		return nil, fmt.Errorf("Invalid binary operation %d for types %T and %T", op, lhs, rhs)
+	}
+	return result, nil
+}
+
+func setBinaryOp(op code.Opcode, lhs, rhs *object.Set) (object.Object, error) {
 	switch op {
-	case code.OpAdd:
-		result = lhs.Value + rhs.Value
-	default:
-		return fmt.Errorf("Invalid string binary operation: %v", op)
+	case code.OpUnion:
+		return lhs.Union(rhs), nil
+	case code.OpIntersect:
+		return lhs.Intersect(rhs), nil
+	case code.OpSub:
+		return lhs.Difference(rhs), nil
 	}
-	return vm.push(&object.String{Value: result})
+	return nil, fmt.Errorf("Invalid set binary operation: %v", op)
 }
 
 func (vm *VM) runComparisonOp(op code.Opcode) error {
-	rhs, err := vm.pop()
-	if err != nil {
-		return err
-	}
+	rhs := vm.pop()
+	lhs := vm.pop()
 
-	lhs, err := vm.pop()
+	result, err := compare(op, lhs, rhs)
 	if err != nil {
 		return err
 	}
+	vm.push(object.NativeBool(result))
+	return nil
+}
 
-	if rhs.Type() == object.INTEGER_OBJ && lhs.Type() == object.INTEGER_OBJ {
-		return vm.runIntComparisonOp(op, lhs.(*object.Integer), rhs.(*object.Integer))
+func compare(op code.Opcode, lhs, rhs object.Object) (bool, error) {
+	if object.IsInteger(rhs) && object.IsInteger(lhs) {
+		return intComparison(op, lhs, rhs)
 	}
 
-	if rhs.Type() != object.BOOLEAN_OBJ || lhs.Type() != object.BOOLEAN_OBJ {
-		return fmt.Errorf("Cannot apply comparison operator on types %T and %T", lhs, rhs)
+	if rhs.Type() == object.CHAR_OBJ && lhs.Type() == object.CHAR_OBJ {
+		return charComparison(op, lhs.(*object.Char), rhs.(*object.Char))
 	}
 
-	lhsVal := lhs.(*object.Boolean)
-	rhsVal := rhs.(*object.Boolean)
+	switch op {
+	case code.OpEqual:
+		return object.Equals(lhs, rhs), nil
+	case code.OpNotEqual:
+		return !object.Equals(lhs, rhs), nil
+	}
+	return false, fmt.Errorf("Cannot apply comparison operator on types %T and %T", lhs, rhs)
+}
 
-	var result bool
+func intComparison(op code.Opcode, lhs, rhs object.Object) (bool, error) {
 	switch op {
 	case code.OpEqual:
-		result = lhsVal.Value == rhsVal.Value
+		return object.CompareIntegers(lhs, rhs) == 0, nil
 	case code.OpNotEqual:
-		result = lhsVal.Value != rhsVal.Value
-	default:
-		return fmt.Errorf("Invalid comparison operation: %v", op)
+		return object.CompareIntegers(lhs, rhs) != 0, nil
+	case code.OpGreaterThan:
+		return object.CompareIntegers(lhs, rhs) > 0, nil
 	}
-	return vm.push(&object.Boolean{Value: result})
+	return false, fmt.Errorf("Invalid integer comparison operation: %v", op)
 }
 
-func (vm *VM) runIntComparisonOp(op code.Opcode, lhs, rhs *object.Integer) error {
-	var result bool
+func charComparison(op code.Opcode, lhs, rhs *object.Char) (bool, error) {
 	switch op {
 	case code.OpEqual:
-		result = lhs.Value == rhs.Value
+		return lhs.Value == rhs.Value, nil
 	case code.OpNotEqual:
-		result = lhs.Value != rhs.Value
+		return lhs.Value != rhs.Value, nil
 	case code.OpGreaterThan:
-		result = lhs.Value > rhs.Value
-	default:
-		return fmt.Errorf("Invalid integer comparison operation: %v", op)
+		return lhs.Value > rhs.Value, nil
 	}
-	return vm.push(&object.Boolean{Value: result})
+	return false, fmt.Errorf("Invalid char comparison operation: %v", op)
 }
 
-func (vm *VM) callCompiledFunction(closure *object.Closure, numArgsInCall int) error {
-	allArgs := make([]object.Object, numArgsInCall)
-	copy(allArgs, vm.stack[vm.sp-numArgsInCall:vm.sp])
-
-	// Free all the stack objects of the call, we will push them back now
-	vm.sp -= numArgsInCall
-
-	numArgsInCall = 0
-	for _, arg := range allArgs {
-		switch typedArg := arg.(type) {
-		case *object.VarArgs:
-			numArgsInCall += len(typedArg.Elems)
-			for _, inner := range typedArg.Elems {
-				if err := vm.push(inner); err != nil {
-					return err
+// prepareCallArgs expands var args in the arguments of the call and packs the
+// trailing arguments of var arg functions, leaving the stack ready for the
+// frame of the callee.
+func (vm *VM) prepareCallArgs(closure *object.Closure, numArgsInCall int) error {
+	numExpanded := 0
+	expand := false
+	for _, arg := range vm.stack[vm.sp-numArgsInCall : vm.sp] {
+		if varArgs, ok := arg.(*object.VarArgs); ok {
+			numExpanded += len(varArgs.Elems)
+			expand = true
+		} else {
+			numExpanded += 1
+		}
+	}
+
+	// Arguments are only moved if there are var args to expand
+	if expand {
+		allArgs := make([]object.Object, numArgsInCall)
+		copy(allArgs, vm.stack[vm.sp-numArgsInCall:vm.sp])
+
+		// Free all the stack objects of the call, we will push them back now
+		vm.sp -= numArgsInCall
+		if err := vm.growStack(vm.sp + numExpanded); err != nil {
+			return err
+		}
+
+		for _, arg := range allArgs {
+			switch typedArg := arg.(type) {
+			case *object.VarArgs:
+				for _, inner := range typedArg.Elems {
+					vm.push(inner)
 				}
-			}
-		default:
-			numArgsInCall += 1
-			if err := vm.push(arg); err != nil {
-				return err
+			default:
+				vm.push(arg)
 			}
 		}
+		numArgsInCall = numExpanded
 	}
 
 	fn := closure.Fn
@@ -586,60 +822,86 @@ func (vm *VM) callCompiledFunction(closure *object.Closure, numArgsInCall int) e
 		numVarArgs := numArgsInCall - fn.NumArgs
 		varArgs := &object.VarArgs{Elems: make([]object.Object, numVarArgs)}
 		for i := 0; i < numVarArgs; i++ {
-			v, err := vm.pop()
-			if err != nil {
-				return err
-			}
-			varArgs.Elems[numVarArgs-1-i] = v
+			varArgs.Elems[numVarArgs-1-i] = vm.pop()
 		}
-		err := vm.push(varArgs)
-		if err != nil {
+		if err := vm.growStack(vm.sp + 1); err != nil {
 			return err
 		}
+		vm.push(varArgs)
 	} else {
 		if numArgsInCall != fn.NumArgs {
 			return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.NumArgs, numArgsInCall)
 		}
 	}
 
-	vm.pushFrame(NewFrame(closure, vm.sp))
 	return nil
 }
 
-func (vm *VM) executeBuiltin(fn *object.Builtin, numArgsInCall int) error {
+func (vm *VM) callCompiledFunction(closure *object.Closure, numArgsInCall int) error {
+	if err := vm.prepareCallArgs(closure, numArgsInCall); err != nil {
+		return err
+	}
+
+	return vm.pushFrame(NewFrame(closure, vm.sp))
+}
+
+// tailCallCompiledFunction replaces the current frame with the one of the
+// callee, moving the arguments of the call over the locals of the caller.
+func (vm *VM) tailCallCompiledFunction(closure *object.Closure, numArgsInCall int) error {
+	if err := vm.prepareCallArgs(closure, numArgsInCall); err != nil {
+		return err
+	}
+
+	numArgs := closure.Fn.NumArgs
+	if closure.Fn.VarArgs {
+		numArgs += 1
+	}
+
+	frame := vm.currentFrame()
+	if err := vm.growStack(frame.LocalsBase + closure.Fn.NumLocals); err != nil {
+		return err
+	}
+	copy(vm.stack[frame.LocalsBase:], vm.stack[vm.sp-numArgs:vm.sp])
+	vm.clearLocals(frame.LocalsBase+numArgs, frame.LocalsBase+closure.Fn.NumLocals)
+	vm.sp = frame.LocalsBase + closure.Fn.NumLocals
+
+	frame.closure = closure
+	frame.ip = -1
+	return nil
+}
+
+// executeBuiltin calls a builtin, passing it the span of the call. The errors
+// it returns stop the program, like in the evaluator.
+func (vm *VM) executeBuiltin(fn *object.Builtin, numArgsInCall int, span token.Span) error {
 	args := make([]object.Object, numArgsInCall)
 	for i := 0; i < int(numArgsInCall); i++ {
-		v, err := vm.pop()
-		if err != nil {
-			return err
-		}
-		args[int(numArgsInCall)-1-i] = v
+		args[int(numArgsInCall)-1-i] = vm.pop()
 	}
 
-	val := fn.Function(token.Span{}, args...)
+	val := fn.Function(span, args...)
+	if errObj, ok := val.(*object.Error); ok {
+		return errors.New(errObj.Message)
+	}
 	if val == nil {
 		val = Null
 	}
 
-	return vm.push(val)
+	vm.push(val)
+	return nil
 }
 
-func (vm *VM) push(ob object.Object) error {
-	if vm.sp >= len(vm.stack) {
-		return fmt.Errorf("Stack overflown")
-	}
+// push stores a value on top of the stack. It does not check for room, which
+// is made sure of before every instruction and call.
+func (vm *VM) push(ob object.Object) {
 	vm.stack[vm.sp] = ob
 	vm.sp++
-	return nil
 }
 
-func (vm *VM) pop() (object.Object, error) {
-	if vm.sp == 0 {
-		return nil, fmt.Errorf("Stack underflown")
-	}
-	obj := vm.stack[vm.sp-1]
+// pop removes the value on top of the stack. Verified bytecode never pops
+// from an empty stack.
+func (vm *VM) pop() object.Object {
 	vm.sp--
-	return obj, nil
+	return vm.stack[vm.sp]
 }
 
 func (vm *VM) StackTop() object.Object {
@@ -657,15 +919,58 @@ func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.frameIndex-1]
 }
 
-func (vm *VM) pushFrame(frame *Frame) {
+func (vm *VM) pushFrame(frame *Frame) error {
 	numArgs := frame.closure.Fn.NumArgs
 	if frame.closure.Fn.VarArgs {
 		numArgs += 1
 	}
+	if vm.frameIndex >= vm.options.MaxFrames {
+		return fmt.Errorf("maximum recursion depth exceeded")
+	}
+	if err := vm.growStack(vm.sp + frame.closure.Fn.NumLocals - numArgs); err != nil {
+		return err
+	}
 	// Arg locals have already been pushed to the stack, therefore we don't need to move them
+	vm.clearLocals(vm.sp, vm.sp+frame.closure.Fn.NumLocals-numArgs)
 	vm.sp += frame.closure.Fn.NumLocals - numArgs
-	vm.frames[vm.frameIndex] = frame
+	if vm.frameIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, frame)
+	} else {
+		vm.frames[vm.frameIndex] = frame
+	}
 	vm.frameIndex++
+	return nil
+}
+
+// growStack makes room for at least size values in the stack, doubling its
+// size up to the maximum one.
+func (vm *VM) growStack(size int) error {
+	if size <= len(vm.stack) {
+		return nil
+	}
+	if size > vm.options.MaxStackSize {
+		return fmt.Errorf("maximum stack size exceeded")
+	}
+
+	newSize := 2 * len(vm.stack)
+	if newSize < size {
+		newSize = size
+	}
+	if newSize > vm.options.MaxStackSize {
+		newSize = vm.options.MaxStackSize
+	}
+	stack := make([]object.Object, newSize)
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
+}
+
+// clearLocals removes the values left in the stack slots [start, end) by
+// previous frames, so that debuggers do not show them as locals of a new frame.
+func (vm *VM) clearLocals(start, end int) {
+	for i := start; i < end && i < len(vm.stack); i++ {
+		vm.stack[i] = nil
+	}
 }
 
 func (vm *VM) popFrame() *Frame {
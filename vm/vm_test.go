@@ -1,14 +1,21 @@
 package vm
 
 import (
+	"bytes"
 	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/javier-varez/monkey_interpreter/ast"
 	"github.com/javier-varez/monkey_interpreter/compiler"
+	"github.com/javier-varez/monkey_interpreter/evaluator"
 	"github.com/javier-varez/monkey_interpreter/lexer"
 	"github.com/javier-varez/monkey_interpreter/object"
 	"github.com/javier-varez/monkey_interpreter/parser"
+	"github.com/javier-varez/monkey_interpreter/regvm"
 )
 
 func parse(input string) *ast.Program {
@@ -29,6 +36,30 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testBigIntObject(expected *big.Int, actual object.Object) error {
+	result, ok := actual.(*object.BigInt)
+	if !ok {
+		return fmt.Errorf("Object is not a big integer. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value.Cmp(expected) != 0 {
+		return fmt.Errorf("Object has wrong value. got=%s, want=%s", result.Value, expected)
+	}
+	return nil
+}
+
+func testCharObject(expected rune, actual object.Object) error {
+	result, ok := actual.(*object.Char)
+	if !ok {
+		return fmt.Errorf("Object is not a char. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("Object has wrong value. got=%q, want=%q", result.Value, expected)
+	}
+	return nil
+}
+
 func testBoolObject(expected bool, actual object.Object) error {
 	result, ok := actual.(*object.Boolean)
 	if !ok {
@@ -75,11 +106,11 @@ func testMapObject(t *testing.T, expected map[interface{}]interface{}, actual ob
 		return fmt.Errorf("Object is not a map. got=%T (%+v)", actual, actual)
 	}
 
-	if len(expected) != len(result.Elems) {
-		return fmt.Errorf("Unexpected number of elements: got=%+v, expected=%+v", result.Elems, expected)
+	if len(expected) != result.Len() {
+		return fmt.Errorf("Unexpected number of elements: got=%+v, expected=%+v", result.Inspect(), expected)
 	}
 
-	for _, v := range result.Elems {
+	for _, v := range result.Entries() {
 		var key interface{}
 		switch k := v.Key.(type) {
 		case *object.Boolean:
@@ -131,6 +162,11 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 				t.Fatalf("compiler error: %s", err)
 			}
 
+			// Everything the compiler produces must pass verification
+			if err := Verify(comp.Bytecode()); err != nil {
+				t.Fatalf("verification error: %s", err)
+			}
+
 			vm := New(comp.Bytecode())
 			err = vm.Run()
 			if err != nil {
@@ -139,10 +175,45 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 
 			stackElem := vm.LastPoppedStackElem()
 			testExpectedObject(t, tt.expected, stackElem)
+
+			// Optimizations must not change the result
+			comp = compiler.New()
+			comp.EnableOptimizations()
+			if err := comp.Compile(program); err != nil {
+				t.Fatalf("compiler error with optimizations: %s", err)
+			}
+			if err := Verify(comp.Bytecode()); err != nil {
+				t.Fatalf("verification error with optimizations: %s", err)
+			}
+
+			vm = New(comp.Bytecode())
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error with optimizations: %s", err)
+			}
+			testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+
+			// The register VM must agree with the stack VM
+			regVM, err := runRegisterVM(t, tt.input)
+			if err != nil {
+				t.Fatalf("register vm error: %s", err)
+			}
+			testExpectedObject(t, tt.expected, regVM.Result())
 		})
 	}
 }
 
+// runRegisterVM compiles and runs a program with the register VM.
+func runRegisterVM(t *testing.T, input string) (*regvm.VM, error) {
+	t.Helper()
+
+	comp := regvm.NewCompiler()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("register compiler error: %s", err)
+	}
+	vm := regvm.New(comp.Program())
+	return vm, vm.Run()
+}
+
 func testExpectedObject(t *testing.T, expected interface{}, actual object.Object) {
 	t.Helper()
 
@@ -152,6 +223,11 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 		if err != nil {
 			t.Fatalf("testIntegerObject failed: %s", err)
 		}
+	case *big.Int:
+		err := testBigIntObject(expected, actual)
+		if err != nil {
+			t.Fatalf("testBigIntObject failed: %s", err)
+		}
 	case bool:
 		err := testBoolObject(expected, actual)
 		if err != nil {
@@ -166,6 +242,11 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 		if err != nil {
 			t.Fatalf("testStringObject failed: %s", err)
 		}
+	case rune:
+		err := testCharObject(expected, actual)
+		if err != nil {
+			t.Fatalf("testCharObject failed: %s", err)
+		}
 	case []interface{}:
 		err := testArrayObject(t, expected, actual)
 		if err != nil {
@@ -197,6 +278,62 @@ func TestIntegerArithmetic(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func mustParseBigInt(s string) *big.Int {
+	value, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic(fmt.Sprintf("Invalid big integer %q", s))
+	}
+	return value
+}
+
+func TestBigIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"9223372036854775807 + 1", mustParseBigInt("9223372036854775808")},
+		{"9223372036854775807 + 1 - 1", 9223372036854775807},
+		{"-9223372036854775807 - 2", mustParseBigInt("-9223372036854775809")},
+		{"4611686018427387904 * 2", mustParseBigInt("9223372036854775808")},
+		{"4611686018427387904 * 4 / 4", 4611686018427387904},
+		{"(-9223372036854775807 - 1) / -1", mustParseBigInt("9223372036854775808")},
+		{"-(-9223372036854775807 - 1)", mustParseBigInt("9223372036854775808")},
+		{"9223372036854775807 * 9223372036854775807", mustParseBigInt("85070591730234615847396907784232501249")},
+		{"9223372036854775807 + 1 > 9223372036854775807", true},
+		{"9223372036854775807 < 9223372036854775807 + 1", true},
+		{"9223372036854775807 + 1 == 9223372036854775806 + 2", true},
+		{"9223372036854775807 + 1 != 9223372036854775807", true},
+		{"{9223372036854775807 + 1: 1}[9223372036854775806 + 2]", 1},
+		{
+			`let wrap = fn(self) { fn(...) { self(self, ...) } };
+			 let fib = wrap(fn(self, n, a, b) { if (n == 0) { a } else { self(self, n - 1, b, a + b) } });
+			 fib(100, 0, 1)`,
+			mustParseBigInt("354224848179261915075"),
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestDivisionByZero(t *testing.T) {
+	program := parse("1 / 0")
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+	if err.Error() != "Division by zero" {
+		t.Fatalf("wrong VM error: want=%q, got=%q", "Division by zero", err)
+	}
+
+	if _, err := runRegisterVM(t, "1 / 0"); err == nil || err.Error() != "Division by zero" {
+		t.Fatalf("wrong register VM error: want=%q, got=%v", "Division by zero", err)
+	}
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -239,6 +376,12 @@ func TestBooleanExpressions(t *testing.T) {
 		{"if (false) { 10 }", Null},
 		{"!(if (false) { 10 })", true},
 		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		// Blocks that do not end in an expression evaluate to null
+		{"let b = if (true) { let z = 1; }; puts(b);", Null},
+		{"let b = if (true) { let z = 1; }; b", Null},
+		{"if (true) { }", Null},
+		{"if (false) { 10 } else { let y = 2; }", Null},
+		{"let f = fn(x) { let r = if (x) { return 1; }; 2 }; [f(true), f(false)]", []interface{}{1, 2}},
 	}
 
 	runVmTests(t, tests)
@@ -259,6 +402,26 @@ func TestStringExpressions(t *testing.T) {
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
 		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`len("ñandú 🐒")`, 7},
+		{`"ñandú"[0]`, 'ñ'},
+		{`"ñandú"[4]`, 'ú'},
+		{`"ñandú"[5]`, Null},
+		{`first("🐒 monkey")`, '🐒'},
+		{`last("ñandú")`, 'ú'},
+		{`rest("ñandú")`, "andú"},
+		{`"ñandú"[0] + "andú"`, "ñandú"},
+		{`"ñand" + "ñandú"[4]`, "ñandú"},
+		{`"ñandú"[4] == last("ñandú")`, true},
+		{`"ñandú"[0] != "ñandú"[1]`, true},
+		{`"abc"[0] < "abc"[1]`, true},
+		{`"abc"[2] > "abc"[1]`, true},
+		{`{"ñandú"[0]: 1}[first("ñandú")]`, 1},
+		{
+			`let wrap = fn(self) { fn(...) { self(self, ...) } };
+			 let reverse = wrap(fn(self, s, acc) { if (len(s) == 0) { acc } else { self(self, rest(s), first(s) + acc) } });
+			 reverse("ñandú 🐒", "")`,
+			"🐒 údnañ",
+		},
 	}
 
 	runVmTests(t, tests)
@@ -279,6 +442,126 @@ func TestArrayExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestSetsAndTuples(t *testing.T) {
+	tests := []vmTestCase{
+		{`len({1, 2, 3})`, 3},
+		{`len({1, 2, 2, 1})`, 2},
+		{`len({1, 2, 3} | {3, 4})`, 4},
+		{`len({1, 2, 3} & {3, 4})`, 1},
+		{`len({1, 2, 3} - {3, 4})`, 2},
+		{`contains({1, "two", (3, 4)}, "two")`, true},
+		{`contains({1, "two", (3, 4)}, (3, 4))`, true},
+		{`contains({1, "two", (3, 4)}, (4, 3))`, false},
+		{`contains({1, 2, 3} & {3, 4}, 3)`, true},
+		{`contains({1, 2, 3} - {3, 4}, 3)`, false},
+		{`let a = {1, 2}; let b = {2, 3}; contains(a | b, 1) == contains(b | a, 1)`, true},
+		{`len((1, "two", [3]))`, 3},
+		{`(1, "two", [3])[1]`, "two"},
+		{`let t = (1,); t[0]`, 1},
+		{`len(())`, 0},
+		{`{(1, 2): "a", (2, 1): "b"}[(2, 1)]`, "b"},
+		{`let point = fn(x, y) { (x, y) }; {point(1, 2): "a"}[(1, 2)]`, "a"},
+		{`(1, 2)[2]`, Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStructuralEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{`[1, 2, [3, "four"]] == [1, 2, [3, "four"]]`, true},
+		{`[1, 2, [3, "four"]] == [1, 2, [3, "five"]]`, false},
+		{`[1, 2] != [1, 2, 3]`, true},
+		{`[] == []`, true},
+		{`{"a": [1, 2], "b": {1: 2}} == {"b": {1: 2}, "a": [1, 2]}`, true},
+		{`{"a": [1, 2]} == {"a": [2, 1]}`, false},
+		{`{"a": 1} != {"a": 1, "b": 2}`, true},
+		{`if (false) { 1 } == if (false) { 2 }`, true},
+		{`(1, [2]) == (1, [2])`, true},
+		{`{1, 2, 3} == {3, 2, 1}`, true},
+		{`{1, 2, 3} == {1, 2}`, false},
+		{`9223372036854775807 + 1 == 9223372036854775806 + 2`, true},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
+		{`{[1, 2]: "a", [2, 1]: "b"}[[2, 1]]`, "b"},
+		{`let key = [1, [2, 3]]; {key: "a"}[[1, [2, 3]]]`, "a"},
+		{`contains({[1, 2]: "a"}, [1, 2])`, true},
+		{`contains({[1, 2]: "a"}, [1, 3])`, false},
+		{`contains({[1], [1], [2]}, [1])`, true},
+		{`len({[1], [1], [2]})`, 2},
+		// Maps and sets hash the same whatever their order
+		{`let a = [{"a": 1, "b": 2}]; let b = [{"b": 2, "a": 1}]; a == b`, true},
+		{`let a = [{"a": 1, "b": 2}]; let b = [{"b": 2, "a": 1}]; contains({a}, b)`, true},
+		{`let a = [{"a": 1, "b": 2}]; let b = [{"b": 2, "a": 1}]; {a: 1}[b]`, 1},
+		{`let a = ({1, 2}, 3); let b = ({2, 1}, 3); {a: "x"}[b]`, "x"},
+		{`1 == "a"`, false},
+		{`let eq = fn(a, b) { a == b }; eq(1, "a")`, false},
+		{`[1] != (1)`, true},
+		{`"a"[0] == "a"`, false},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Equality must not depend on the engine running the program
+func TestEqualityAcrossEngines(t *testing.T) {
+	tests := []string{
+		`let eq = fn(a, b) { a == b }; [eq(1, "a"), eq("a", 1), eq([1], [1]), eq(true, 1), eq(puts, len)]`,
+		`let neq = fn(a, b) { a != b }; [neq(1, "a"), neq({1}, {1}), neq((1, "a"), (1, "a")), neq(if (false) { 1 }, false)]`,
+	}
+
+	for _, input := range tests {
+		expected := evaluator.Eval(parse(input), object.NewEnvironment())
+		if expected.Type() == object.ERROR_VALUE_OBJ {
+			t.Fatalf("interpreter error for %q: %s", input, expected.Inspect())
+		}
+
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		regVM, err := runRegisterVM(t, input)
+		if err != nil {
+			t.Fatalf("register vm error: %s", err)
+		}
+
+		for _, actual := range []object.Object{vm.LastPoppedStackElem(), regVM.Result()} {
+			if !object.Equals(expected, actual) {
+				t.Errorf("Engines disagree on %q. Interpreter: %s, VM: %s", input, expected.Inspect(), actual.Inspect())
+			}
+		}
+	}
+}
+
+func TestMapBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`len(keys({"a": 1, "b": 2, "c": 3}))`, 3},
+		{`keys({"a": 1})`, []interface{}{"a"}},
+		{`values({"a": 1})`, []interface{}{1}},
+		{`keys({})`, []interface{}{}},
+		{`let m = {"a": 1, "b": 2}; contains({1, 2}, values(m)[0])`, true},
+		{`let m = {"a": 1, "b": 2}; let n = delete(m, "a"); contains(n, "a")`, false},
+		{`let m = {"a": 1, "b": 2}; let n = delete(m, "a"); contains(m, "a")`, true},
+		{`len(keys(delete({"a": 1, "b": 2}, "c")))`, 2},
+		{`delete({"a": 1, "b": 2}, "a") == {"b": 2}`, true},
+		{`let f = fn() { 1 }; let g = fn() { 1 }; let m = {[f]: "f", [g]: "g"}; len(keys(m))`, 2},
+		{`let f = fn() { 1 }; let g = fn() { 1 }; let m = {[f]: "f", [g]: "g"}; m[[g]]`, "g"},
+		{`let f = fn() { 1 }; let g = fn() { 1 }; let m = {[f]: "f", [g]: "g"}; delete(m, [f])[[g]]`, "g"},
+		{`keys({"b": 1, "a": 2, "c": 3})`, []interface{}{"b", "a", "c"}},
+		{`values({"b": 1, "a": 2, "c": 3})`, []interface{}{1, 2, 3}},
+		{`keys(delete({"b": 1, "a": 2, "c": 3}, "a"))`, []interface{}{"b", "c"}},
+		{`{"a": 1, "a": 2}["a"]`, 2},
+		{`keys({"b": 1, "a": 2, "b": 3})`, []interface{}{"b", "a"}},
+		{`let m = {"a": 1, "b": 2}; m[keys(m)[1]] == values(m)[1]`, true},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestHashExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{`{}`, map[interface{}]interface{}{}},
@@ -294,6 +577,7 @@ func TestHashExpressions(t *testing.T) {
 func TestFunctionCalls(t *testing.T) {
 	tests := []vmTestCase{
 		{`let a = fn() { 5 + 10 }; a()`, 15},
+		{`let add = fn(a: int, b: [int]) -> int { a + b[0] }; let x: int = add(1, [2]); x`, 3},
 		{`let one = fn() { 1 }; let two = fn() { 2 }; one() + two()`, 3},
 		{`let a = fn() { 1 }; let b = fn() { a() + 1 }; let c = fn() { b() + 1 }; c()`, 3},
 		{`let a = fn() { return 10; 1; }; a()`, 10},
@@ -395,6 +679,10 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 			if err.Error() != tt.expected {
 				t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
 			}
+
+			if _, err := runRegisterVM(t, tt.input); err == nil || err.Error() != tt.expected {
+				t.Fatalf("wrong register VM error: want=%q, got=%v", tt.expected, err)
+			}
 		})
 	}
 }
@@ -404,60 +692,52 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
-		{
-			`len(1)`,
-			&object.Error{
-				Message: "\"len\" builtin takes a single string or array argument",
-			},
-		},
-		{`len("one", "two")`,
-			&object.Error{
-				Message: "\"len\" builtin takes a single string or array argument",
-			},
-		},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
 		{`puts("hello", "world!")`, Null},
 		{`first([1, 2, 3])`, 1},
-		{`first([])`,
-			&object.Error{
-				Message: "Array is empty",
-			},
-		},
-		{`first(1)`,
-			&object.Error{
-				Message: "\"first\" builtin takes a single array argument",
-			},
-		},
 		{`last([1, 2, 3])`, 3},
-		{`last([])`,
-			&object.Error{
-				Message: "Array is empty",
-			},
-		},
-		{`last(1)`,
-			&object.Error{
-				Message: "\"last\" builtin takes a single array argument",
-			},
-		},
 		{`rest([1, 2, 3])`, []int{2, 3}},
-		{`rest([])`,
-			&object.Error{
-				Message: "Array is empty",
-			},
-		},
 		{`push([], 1)`, []int{1}},
-		{`push(1, 1)`,
-			&object.Error{
-				Message: "\"push\" builtin takes an array argument and a new object to push",
-			},
-		},
 	}
 
 	runVmTests(t, tests)
 
 }
 
+func TestBuiltinErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{`len(1)`, "\"len\" builtin takes a single string, array, tuple or set argument"},
+		{`len("one", "two")`, "\"len\" builtin takes a single string, array, tuple or set argument"},
+		{`first([])`, "Array is empty"},
+		{`first(1)`, "\"first\" builtin takes a single string or array argument"},
+		{`last([])`, "Array is empty"},
+		{`last(1)`, "\"last\" builtin takes a single string or array argument"},
+		{`rest([])`, "Array is empty"},
+		{`push(1, 1)`, "\"push\" builtin takes an array argument and a new object to push"},
+		{`let f = fn(a) { let r = len(a); r }; f(1); puts("after")`, "\"len\" builtin takes a single string, array, tuple or set argument"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			comp := compiler.New()
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			// Errors returned by builtins stop the program
+			vm := New(comp.Bytecode())
+			if err := vm.Run(); err == nil || err.Error() != tt.expected {
+				t.Fatalf("wrong VM error: want=%q, got=%v", tt.expected, err)
+			}
+
+			if _, err := runRegisterVM(t, tt.input); err == nil || err.Error() != tt.expected {
+				t.Fatalf("wrong register VM error: want=%q, got=%v", tt.expected, err)
+			}
+		})
+	}
+}
+
 func TestClosures(t *testing.T) {
 	tests := []vmTestCase{
 		{`let a = fn(a) { let b = 10; fn(c) { 2 * b + 3 * a + c } }; a(40)(4)`, 144},
@@ -471,6 +751,107 @@ func TestClosures(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestTailCalls(t *testing.T) {
+	wrap := "let wrap = fn(self) { fn(...) { self(self, ...) } };"
+	tests := []vmTestCase{
+		{wrap + "let count = wrap(fn(self, n, acc) { if (n == 0) { return acc; } self(self, n - 1, acc + 1) }); count(100000, 0)", 100000},
+		{wrap + "let count = wrap(fn(self, n, acc) { if (n == 0) { acc } else { return self(self, n - 1, acc + 2); } }); count(100000, 0)", 200000},
+		{wrap + "let even = wrap(fn(self, n) { if (n == 0) { true } else { !self(self, n - 1) } }); even(10)", true},
+		{"let f = fn(a) { let b = 1; let c = 2; len(a) }; [f([1, 2, 3]), 4]", []interface{}{3, 4}},
+		{"let f = fn(a, b, c) { a + b + c }; let g = fn(a) { let x = 10; f(a, x, 1) }; g(5) + 1", 17},
+	}
+
+	runVmTests(t, tests)
+}
+
+// identifierName returns a distinct name for each i, as identifiers cannot
+// contain digits. The prefix keeps them apart from keywords.
+func identifierName(i int) string {
+	name := string(rune('a' + i%26))
+	for i /= 26; i > 0; i /= 26 {
+		name = string(rune('a'+i%26)) + name
+	}
+	return "v" + name
+}
+
+func TestWideOperands(t *testing.T) {
+	// More locals and free variables than a byte can address
+	lets := []string{}
+	names := []string{}
+	for i := 0; i < 300; i++ {
+		lets = append(lets, fmt.Sprintf("let %s = %d;", identifierName(i), i))
+		names = append(names, identifierName(i))
+	}
+	locals := fmt.Sprintf("let f = fn() { %s fn() { %s } }; f()()", strings.Join(lets, " "), strings.Join(names, " + "))
+
+	// More arguments than a byte can count, in calls and tail calls
+	args := []string{}
+	for i := 1; i <= 300; i++ {
+		args = append(args, fmt.Sprint(i))
+	}
+	sum := fmt.Sprintf("let f = fn(%s) { %s };", strings.Join(names, ", "), strings.Join(names, " + "))
+	calls := fmt.Sprintf("%s let g = fn() { f(%s) }; f(%s) + g()", sum, strings.Join(args, ", "), strings.Join(args, ", "))
+
+	// More constants than two bytes can address
+	statements := []string{}
+	for i := 0; i < 70000; i++ {
+		statements = append(statements, fmt.Sprint(i))
+	}
+	constants := strings.Join(statements, "; ")
+
+	tests := []struct {
+		name     string
+		input    string
+		expected int
+	}{
+		{"locals and free variables", locals, 299 * 300 / 2},
+		{"constants", constants, 69999},
+		{"arguments", calls, 300 * 301},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("%s: compiler error: %s", tt.name, err)
+		}
+		if err := Verify(comp.Bytecode()); err != nil {
+			t.Fatalf("%s: verification error: %s", tt.name, err)
+		}
+
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("%s: vm error: %s", tt.name, err)
+		}
+		if err := testIntegerObject(int64(tt.expected), vm.LastPoppedStackElem()); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		}
+
+		regVM, err := runRegisterVM(t, tt.input)
+		if err != nil {
+			t.Fatalf("%s: register vm error: %s", tt.name, err)
+		}
+		if err := testIntegerObject(int64(tt.expected), regVM.Result()); err != nil {
+			t.Errorf("%s: register vm: %s", tt.name, err)
+		}
+	}
+}
+
+func TestGlobalsLimit(t *testing.T) {
+	lets := []string{}
+	for i := 0; i <= GLOBALS_SIZE; i++ {
+		lets = append(lets, fmt.Sprintf("let %s = 1;", identifierName(i)))
+	}
+	input := strings.Join(lets, " ")
+	expected := "Programs cannot define more than 65536 globals"
+
+	if err := compiler.New().Compile(parse(input)); err == nil || err.Error() != expected {
+		t.Errorf("Expected compiler error %q, got %v", expected, err)
+	}
+	if err := regvm.NewCompiler().Compile(parse(input)); err == nil || err.Error() != expected {
+		t.Errorf("Expected register compiler error %q, got %v", expected, err)
+	}
+}
+
 func TestRangeExpression(t *testing.T) {
 	tests := []vmTestCase{
 		{`let a = fn() { 0 }; a()..10`, []interface{}{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
@@ -479,3 +860,235 @@ func TestRangeExpression(t *testing.T) {
 
 	runVmTests(t, tests)
 }
+
+func TestRuntimeErrorLocations(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedMsg  string
+		expectedText string
+	}{
+		{`let f = fn(x) { -x }; f("a")`, "Cannot apply minus operator on type *object.String", "-x"},
+		{`1 + (2 / 0)`, "Division by zero", "2 / 0"},
+		{`let a = [1]; a["x"]`, "Index to array must be an integral. Got=*object.String (&{Value:x})", `a["x"]`},
+		{`let f = fn(a, b) { a }; [f(1)]`, "wrong number of arguments: want=2, got=1", "f(1)"},
+		{`1 + {1, 2}`, "Invalid binary operation 1 for types *object.Integer and *object.Set", "1 + {1, 2}"},
+		{`let f = fn(x) { x + 1 }; f({1})`, "Invalid binary operation 1 for types *object.Set and *object.Integer", "x + 1"},
+		{`let f = fn(x) { if (x > 1) { 1 } }; f("a")`, "Cannot apply comparison operator on types *object.String and *object.Integer", "x > 1"},
+		{`let f = 1; [f(2)]`, "Not a callable, cannot be invoked", "f(2)"},
+		{`let a = 1; len(a)`, "\"len\" builtin takes a single string, array, tuple or set argument", "len(a)"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		optimized := compiler.New()
+		optimized.EnableOptimizations()
+		if err := optimized.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		_, regErr := runRegisterVM(t, tt.input)
+		for _, err := range []error{New(comp.Bytecode()).Run(), New(optimized.Bytecode()).Run(), regErr} {
+			astErr, ok := err.(ast.Error)
+			if !ok {
+				t.Errorf("Expected a located error for %q, got %v", tt.input, err)
+				continue
+			}
+
+			if astErr.Error() != tt.expectedMsg {
+				t.Errorf("Wrong error for %q. Expected %q, got %q", tt.input, tt.expectedMsg, astErr.Error())
+			}
+
+			span := astErr.Span()
+			text := []rune(*span.Text)[span.Start.Column:span.End.Column]
+			if string(text) != tt.expectedText {
+				t.Errorf("Wrong location for %q. Expected %q, got %q", tt.input, tt.expectedText, string(text))
+			}
+		}
+	}
+}
+
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedFunctions []string
+		expectedCallSites []string
+	}{
+		{
+			"let inner = fn(x) { -x };\nlet outer = fn(x) { let y = inner(x); y };\nlet apply = fn(f, x) { f(x) + 1 };\napply(outer, \"a\")",
+			[]string{"inner", "outer", "apply"},
+			[]string{"inner(x)", "f(x)", "apply(outer, \"a\")"},
+		},
+		{
+			"let call = fn(f) { f() + 1 };\ncall(fn() { 1 / 0 })",
+			[]string{"", "call"},
+			[]string{"f()", "call(fn() { 1 / 0 })"},
+		},
+		{"1 / 0", []string{}, []string{}},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		optimized := compiler.New()
+		optimized.EnableOptimizations()
+		if err := optimized.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		_, regErr := runRegisterVM(t, tt.input)
+		for _, err := range []error{New(comp.Bytecode()).Run(), New(optimized.Bytecode()).Run(), regErr} {
+			tracer, ok := err.(object.StackTracer)
+			if !ok {
+				t.Errorf("Expected an error with a stack trace for %q, got %v", tt.input, err)
+				continue
+			}
+
+			functions := []string{}
+			callSites := []string{}
+			for _, frame := range tracer.StackTrace() {
+				functions = append(functions, frame.Function)
+				line := []rune(strings.Split(*frame.CallSite.Text, "\n")[frame.CallSite.Start.Line])
+				callSites = append(callSites, string(line[frame.CallSite.Start.Column:frame.CallSite.End.Column]))
+			}
+
+			if fmt.Sprintf("%q", functions) != fmt.Sprintf("%q", tt.expectedFunctions) {
+				t.Errorf("Wrong functions for %q. Expected %q, got %q", tt.input, tt.expectedFunctions, functions)
+			}
+			if fmt.Sprintf("%q", callSites) != fmt.Sprintf("%q", tt.expectedCallSites) {
+				t.Errorf("Wrong call sites for %q. Expected %q, got %q", tt.input, tt.expectedCallSites, callSites)
+			}
+		}
+	}
+}
+
+func TestRecursionLimits(t *testing.T) {
+	depth := func(n int) string {
+		return fmt.Sprintf(`let wrap = fn(self) { fn(...) { self(self, ...) } };
+let depth = wrap(fn(self, x) { if (x == 0) { return 0; } 1 + self(self, x - 1) });
+depth(%d)`, n)
+	}
+
+	tests := []struct {
+		input         string
+		options       Options
+		expectedError string
+	}{
+		{depth(50000), DefaultOptions(), ""},
+		{depth(MAX_FRAMES), DefaultOptions(), "maximum recursion depth exceeded"},
+		{depth(20), Options{MaxStackSize: MAX_STACK_SIZE, MaxFrames: 10}, "maximum recursion depth exceeded"},
+		{depth(20), Options{MaxStackSize: 32, MaxFrames: MAX_FRAMES}, "maximum stack size exceeded"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := NewWithOptions(comp.Bytecode(), make([]object.Object, GLOBALS_SIZE), tt.options)
+		err := vm.Run()
+		if tt.expectedError == "" {
+			if err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			testExpectedObject(t, 50000, vm.LastPoppedStackElem())
+			continue
+		}
+
+		if err == nil || err.Error() != tt.expectedError {
+			t.Fatalf("Expected error %q, got %v", tt.expectedError, err)
+		}
+		tracer, ok := err.(object.StackTracer)
+		if !ok || len(tracer.StackTrace()) == 0 {
+			t.Errorf("Expected an error with a stack trace, got %v", err)
+		}
+		if tt.options.MaxFrames < MAX_FRAMES && len(tracer.StackTrace()) != tt.options.MaxFrames-1 {
+			t.Errorf("Expected %d calls in the stack trace, got %d", tt.options.MaxFrames-1, len(tracer.StackTrace()))
+		}
+	}
+
+	regVM, err := runRegisterVM(t, depth(50000))
+	if err != nil {
+		t.Fatalf("register vm error: %s", err)
+	}
+	testExpectedObject(t, 50000, regVM.Result())
+	if _, err := runRegisterVM(t, depth(regvm.MAX_FRAMES)); err == nil || err.Error() != "maximum recursion depth exceeded" {
+		t.Errorf("Expected the register vm to exceed the maximum recursion depth, got %v", err)
+	}
+}
+
+func TestDecodedBytecode(t *testing.T) {
+	input := `let adder = fn(a) { fn(b) { a + b } };
+let half = fn(x) { x / 0 };
+[adder(1)(2), "done"];
+half(adder(2)(3))`
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var buffer bytes.Buffer
+	if err := comp.Bytecode().Encode(&buffer, true); err != nil {
+		t.Fatalf("Unable to encode: %s", err)
+	}
+	bytecode, err := compiler.Decode(&buffer)
+	if err != nil {
+		t.Fatalf("Unable to decode: %s", err)
+	}
+
+	err = New(bytecode).Run()
+	astErr, ok := err.(ast.Error)
+	if !ok || astErr.Error() != "Division by zero" {
+		t.Fatalf("Expected a located division by zero, got %v", err)
+	}
+	span := astErr.Span()
+	line := []rune(strings.Split(*span.Text, "\n")[span.Start.Line])
+	if text := line[span.Start.Column:span.End.Column]; span.Start.Line != 1 || string(text) != "x / 0" {
+		t.Errorf("Wrong location %+v", span)
+	}
+	stack := err.(object.StackTracer).StackTrace()
+	if len(stack) != 1 || stack[0].Function != "half" {
+		t.Errorf("Unexpected stack trace %v", stack)
+	}
+}
+
+func BenchmarkSamples(b *testing.B) {
+	samples, err := filepath.Glob("../samples/*.monkey")
+	if err != nil || len(samples) == 0 {
+		b.Fatalf("Unable to find the samples: %v", err)
+	}
+
+	for _, sample := range samples {
+		contents, err := os.ReadFile(sample)
+		if err != nil {
+			b.Fatalf("Unable to read sample: %s", err)
+		}
+		program := parse(string(contents))
+
+		for _, optimize := range []bool{false, true} {
+			comp := compiler.New()
+			if optimize {
+				comp.EnableOptimizations()
+			}
+			if err := comp.Compile(program); err != nil {
+				b.Fatalf("compiler error: %s", err)
+			}
+
+			b.Run(fmt.Sprintf("%s/optimize=%t", filepath.Base(sample), optimize), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if err := New(comp.Bytecode()).Run(); err != nil {
+						b.Fatalf("vm error: %s", err)
+					}
+				}
+			})
+		}
+	}
+}